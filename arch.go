@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"github.com/droundy/go/types"
+	"github.com/droundy/go/x86"
+	"github.com/droundy/goopt"
+)
+
+// We can generate code for the 32-bit i386 (which is the default) or
+// the 64-bit amd64.  The two differ in how big a word is (and so how
+// big an int is), in how many registers there are, and in how the
+// kernel is called, but the code we generate is otherwise the same.
+
+var arch = goopt.String([]string{"--arch"}, "386",
+	"the machine to generate code for (386 or amd64)")
+
+// LongFlags lets the architecture be given as -arch=amd64 (and the
+// interpreter asked for with -interp), which goopt would otherwise
+// read as a string of one-letter flags.
+func LongFlags(args []string) []string {
+	for i,a := range args {
+		if strings.HasPrefix(a, "-arch") || a == "-interp" {
+			args[i] = "-" + a
+		}
+	}
+	return args
+}
+
+// SetArch sets everything up to generate code for *arch.
+func SetArch() {
+	die(x86.SetArch(*arch))
+	if *arch == "amd64" {
+		types.Target = types.AMD64
+		allocatable = append(allocatable, x86.R8, x86.R9, x86.R10, x86.R11,
+			x86.R12, x86.R13, x86.R14, x86.R15)
+		allRegisters = regs(allocatable...)
+	}
+}
+
+// DynamicLinker is the program that loads the C library for us.
+func DynamicLinker() string {
+	if *arch == "amd64" {
+		return "/lib64/ld-linux-x86-64.so.2"
+	}
+	return "/lib/ld-linux.so.2"
+}
+
+// ForArch tells whether the file called name is meant for the machine
+// we are compiling for: a file whose name ends in _386 or _amd64
+// (before its extension) is only for that machine, as with the go
+// tools.
+func ForArch(name string) bool {
+	if dot := strings.LastIndex(name, "."); dot >= 0 {
+		name = name[:dot]
+	}
+	for _,a := range []string{"386", "amd64"} {
+		if strings.HasSuffix(name, "_" + a) {
+			return a == *arch
+		}
+	}
+	return true
+}
+
+// syscallRegisters returns the registers holding the arguments of a
+// system call (whose number is always in %eax), and those the call
+// clobbers.  The amd64 uses different registers, and its syscall
+// instruction clobbers %rcx and %r11 too.
+func syscallRegisters() (args []x86.Register, clobbers regset) {
+	if x86.WordSize == 8 {
+		return []x86.Register{x86.EDI, x86.ESI, x86.EDX},
+			regs(x86.EAX, x86.EDI, x86.ESI, x86.EDX, x86.ECX, x86.R11)
+	}
+	return []x86.Register{x86.EBX, x86.ECX, x86.EDX},
+		regs(x86.EAX, x86.EBX, x86.ECX, x86.EDX)
+}
+
+// On the amd64, C takes its first six arguments in registers, and the
+// stack is only used for the rest, which we don't handle.
+var cArgRegisters = []x86.Register{x86.EDI, x86.ESI, x86.EDX, x86.ECX, x86.R8, x86.R9}
+
+// cClobbers returns the registers a C function may clobber, which are
+// all the rest of them on the amd64.
+func cClobbers() regset {
+	if x86.WordSize == 8 {
+		return regs(x86.EAX, x86.ECX, x86.EDX, x86.ESI, x86.EDI,
+			x86.R8, x86.R9, x86.R10, x86.R11)
+	}
+	return regs(x86.EAX, x86.ECX, x86.EDX)
+}
+
+// cSaved returns the registers that C expects us to leave as we found
+// them, apart from the frame pointer.
+func cSaved() []x86.Register {
+	if x86.WordSize == 8 {
+		return []x86.Register{x86.EBX, x86.R12, x86.R13, x86.R14, x86.R15}
+	}
+	return []x86.Register{x86.EBX, x86.ESI, x86.EDI}
+}
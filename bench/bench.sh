@@ -0,0 +1,19 @@
+#!/bin/bash
+# Times calls.go compiled with each calling convention, or with
+# --count, counts the instructions it runs in gogo's emulator, which
+# is slower, but the same every time.
+
+set -e
+
+cd `dirname $0`
+for abi in --stackabi --regabi; do
+    echo ======================
+    echo calls.go with $abi
+    echo ======================
+    if [ "$1" = "--count" ]; then
+        ../go --inline-budget 0 $abi --count-instructions run calls.go
+    else
+        ../go --inline-budget 0 $abi calls.go
+        time ./calls
+    fi
+done
@@ -0,0 +1,126 @@
+package main
+
+import "strconv"
+
+// This is a call-heavy benchmark, for comparing the calling
+// conventions.  It ought to be the usual recursive fibonacci, but that
+// needs an if statement that isn't constant, which gogo can't compile
+// yet, so this stands in for it until it can.  Each level calls the
+// one below twice, so l27 makes 2^28-1 calls in all (counting itself),
+// which is about 270 million.
+
+func l0(a, b int) int {
+	return a - b
+}
+
+func l1(a, b int) int {
+	return l0(a, b) + l0(b, a+1)
+}
+
+func l2(a, b int) int {
+	return l1(a, b) + l1(b, a+1)
+}
+
+func l3(a, b int) int {
+	return l2(a, b) + l2(b, a+1)
+}
+
+func l4(a, b int) int {
+	return l3(a, b) + l3(b, a+1)
+}
+
+func l5(a, b int) int {
+	return l4(a, b) + l4(b, a+1)
+}
+
+func l6(a, b int) int {
+	return l5(a, b) + l5(b, a+1)
+}
+
+func l7(a, b int) int {
+	return l6(a, b) + l6(b, a+1)
+}
+
+func l8(a, b int) int {
+	return l7(a, b) + l7(b, a+1)
+}
+
+func l9(a, b int) int {
+	return l8(a, b) + l8(b, a+1)
+}
+
+func l10(a, b int) int {
+	return l9(a, b) + l9(b, a+1)
+}
+
+func l11(a, b int) int {
+	return l10(a, b) + l10(b, a+1)
+}
+
+func l12(a, b int) int {
+	return l11(a, b) + l11(b, a+1)
+}
+
+func l13(a, b int) int {
+	return l12(a, b) + l12(b, a+1)
+}
+
+func l14(a, b int) int {
+	return l13(a, b) + l13(b, a+1)
+}
+
+func l15(a, b int) int {
+	return l14(a, b) + l14(b, a+1)
+}
+
+func l16(a, b int) int {
+	return l15(a, b) + l15(b, a+1)
+}
+
+func l17(a, b int) int {
+	return l16(a, b) + l16(b, a+1)
+}
+
+func l18(a, b int) int {
+	return l17(a, b) + l17(b, a+1)
+}
+
+func l19(a, b int) int {
+	return l18(a, b) + l18(b, a+1)
+}
+
+func l20(a, b int) int {
+	return l19(a, b) + l19(b, a+1)
+}
+
+func l21(a, b int) int {
+	return l20(a, b) + l20(b, a+1)
+}
+
+func l22(a, b int) int {
+	return l21(a, b) + l21(b, a+1)
+}
+
+func l23(a, b int) int {
+	return l22(a, b) + l22(b, a+1)
+}
+
+func l24(a, b int) int {
+	return l23(a, b) + l23(b, a+1)
+}
+
+func l25(a, b int) int {
+	return l24(a, b) + l24(b, a+1)
+}
+
+func l26(a, b int) int {
+	return l25(a, b) + l25(b, a+1)
+}
+
+func l27(a, b int) int {
+	return l26(a, b) + l26(b, a+1)
+}
+
+func main() {
+	println(strconv.Itoa(l27(1, 2)))
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"go/ast"
+	"github.com/droundy/go/ir"
+	"github.com/droundy/go/types"
+)
+
+// We can talk to C.  A function declared without a body and marked
+// with a //gogo:cdecl comment is written in C, so we call it the way
+// C expects (the System V i386 cdecl convention): the arguments are
+// pushed last first, the caller pops them again, and the result comes
+// back in %eax.  A go function marked with //gogo:export gets a
+// wrapper which C code can call.  Either comment can be followed by
+// the function's name in C, which is otherwise the same as its name
+// in go.  For now, only values that fit in a word can cross between go
+// and C, so there are no strings.  On the amd64 a go int is a C long.
+//
+//	//gogo:cdecl
+//	func abs(x int) int
+//
+//	//gogo:export gogo_twice
+//	func twice(x int) int { return x + x }
+
+type CFunc struct {
+	Name string // its name in C
+	Export bool // true if it's a go function that C can call
+}
+
+// CFuncs holds every function that has anything to do with C.
+var CFuncs = make(map[*types.Func]*CFunc)
+
+// FindCFuncs looks through pkgs for functions marked as cdecl or
+// export, which needs doing before we compile any calls to them.
+func FindCFuncs(pkgs []*ast.Package) {
+	for _,p := range pkgs {
+		for _,f := range p.Files {
+			for _,d := range f.Decls {
+				if fd,ok := d.(*ast.FuncDecl); ok && fd.Doc != nil {
+					for _,c := range fd.Doc.List {
+						findCFunc(fd, string(c.Text))
+					}
+				}
+			}
+		}
+	}
+}
+
+func findCFunc(fd *ast.FuncDecl, comment string) {
+	defer Catch(fd, func() {})
+	words := strings.Fields(comment)
+	if len(words) == 0 || (words[0] != "//gogo:cdecl" && words[0] != "//gogo:export") {
+		return
+	}
+	cf := &CFunc{fd.Name.Name, words[0] == "//gogo:export"}
+	if len(words) > 1 {
+		cf.Name = words[1]
+	}
+	if fd.Recv != nil {
+		Unsupported(fd, "I can't share methods such as %s with C", fd.Name.Name)
+	}
+	if cf.Export && fd.Body == nil {
+		Invalid(fd, "%s can't be exported to C, since it has no body", fd.Name.Name)
+	} else if !cf.Export && fd.Body != nil {
+		Invalid(fd, "%s is written in C, so it can't have a body", fd.Name.Name)
+	}
+	fn := Info.Defs[fd.Name].(*types.Func)
+	sig := fn.Signature()
+	if sig.Results.Len() > 1 {
+		Unsupported(fd, "C functions can't return more than one value")
+	}
+	if types.Target.WordSize == 8 && sig.Params.Len() > len(cArgRegisters) {
+		Unsupported(fd, "I can't pass more than %d arguments to or from C", len(cArgRegisters))
+	}
+	for _,vs := range [][]*types.Var{sig.Params.Vars, sig.Results.Vars} {
+		for _,v := range vs {
+			if ir.Words(v.Type()) != 1 {
+				Unsupported(fd, "I can only pass values that fit in a word to or from C, not %s", v.Type())
+			}
+		}
+	}
+	CFuncs[fn] = cf
+}
@@ -0,0 +1,669 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"github.com/droundy/go/ir"
+	"github.com/droundy/go/types"
+	"github.com/droundy/go/x86"
+)
+
+// X86Program generates the assembly for a whole program, apart from
+// the runtime and the assembly that comes with each package.
+func X86Program(p *ir.Program) []x86.X86 {
+	code := append([]x86.X86{}, x86.StartData...)
+	for _,g := range p.Globals {
+		// Each word starts out zero, and the frame's Lookup finds the
+		// variable here, since it isn't in any function's frame.
+		DefineGlobal(g.Name, g.Type)
+		code = append(code, x86.Commented(x86.Symbol(g.Name), "var " + g.Name + " " + g.Type.String()))
+		for w:=0; w<ir.Words(g.Type); w++ {
+			code = append(code, x86.GlobalInt(0))
+		}
+	}
+	for _,str := range p.Strings {
+		code = append(code,
+			x86.Symbol(p.StringLiteral(str)),
+			x86.Commented(x86.Ascii(str), "a non-null-terminated string"))
+	}
+	if *debugInfo {
+		code = append(code, DebugStart(p)...)
+	}
+	code = append(code, x86.StartText...)
+	for _,f := range p.Funcs {
+		if !f.External {
+			code = append(code, X86Function(f)...)
+			code = append(code, x86.Symbol(".Lend_" + f.Name))
+		}
+		if f.Export != "" {
+			code = append(code, CWrapper(f)...)
+		}
+	}
+	return append(code, Tables(p)...)
+}
+
+// wordType is what we tell the Stack about the words we push.
+var wordType = types.Typ[types.Uintptr]
+
+// A codegen turns a single function into assembly.  The register
+// allocator decides which temporaries live in registers, and the rest
+// get a word of their own on the stack.  Each function has a frame
+// pointer in %ebp, and we keep track of where everything is relative
+// to it with a Stack, just as the calling convention lays it out:
+//
+//	results (allocated by the caller)
+//	parameters, the last one first
+//	the return address
+//	the caller's %ebp, which is where our %ebp points
+//	spilled temporaries and locals
+//	anything we've pushed since
+//
+// Since %ebp stays put, it doesn't matter how much we've pushed.
+
+type codegen struct {
+	f *ir.Func
+	code []x86.X86
+	frame *Stack
+	regs map[int]x86.Register // which temporaries live in registers
+	used regset // every register that some temporary lives in
+}
+func (g *codegen) Append(xs... x86.X86) {
+	g.code = append(g.code, xs...)
+}
+
+// A location is somewhere we can put a word: a register or memory.
+
+type location interface {
+	x86.W32
+	x86.Ptr
+}
+
+func X86Function(f *ir.Func) []x86.X86 {
+	regparams, regresults := 0, 0
+	if RegABIFuncs[f.Name] {
+		regparams = inRegisters(slotWords(f.Params), len(argRegisters))
+		regresults = inRegisters(slotWords(f.Results), len(resultRegisters))
+	}
+	var top *Stack
+	s := top.New(f.Name)
+	for _,r := range f.Results[regresults:] {
+		s.DefineVariable(r.Name, r.Type)
+	}
+	s.ReturnSize = s.Size
+	for i:=len(f.Params)-1; i>=regparams; i-- {
+		s.DefineVariable(f.Params[i].Name, f.Params[i].Type)
+	}
+	s.DefineVariable("return", wordType)
+	g := &codegen{f, nil, s.New("_"), AllocateRegisters(f), 0}
+	// Whatever came in registers lives in our frame.
+	for _,p := range f.Params[:regparams] {
+		g.frame.DefineVariable(p.Name, p.Type)
+	}
+	for _,r := range f.Results[:regresults] {
+		g.frame.DefineVariable(r.Name, r.Type)
+	}
+	for i:=0; i<f.Temps; i++ {
+		if r,ok := g.regs[i]; ok {
+			g.used |= regs(r)
+		} else {
+			g.frame.DefineVariable(tempName(&ir.Temp{i}), wordType)
+		}
+	}
+	for _,l := range f.Locals {
+		g.frame.DefineVariable(l.Name, l.Type)
+	}
+
+	g.Append(x86.Commented(x86.GlobalSymbol(f.Name), f.Pos))
+	if *debugInfo {
+		g.Append(g.debugFunction()...)
+	}
+	g.Append(x86.Commented(x86.PushL(x86.EBP), "Save the caller's frame pointer"),
+		x86.Commented(x86.MovL(x86.ESP, x86.EBP), "and set up our own"))
+	if g.frame.Size > 0 {
+		g.Append(x86.Commented(x86.SubL(x86.Imm32(g.frame.Size), x86.ESP),
+			"Making room for temporaries and locals"))
+	}
+	reg := 0
+	for _,p := range f.Params[:regparams] {
+		for w:=0; w<ir.Words(p.Type); w++ {
+			g.Append(x86.Commented(x86.MovL(argRegisters[reg], g.slot(p, w)),
+				"Saving "+p.Name+" from a register"))
+			reg++
+		}
+	}
+	for _,r := range f.Results[:regresults] {
+		// The caller would have zeroed these on the stack.
+		for w:=0; w<ir.Words(r.Type); w++ {
+			g.Append(x86.MovL(x86.Imm32(0), g.slot(r, w)))
+		}
+	}
+	for _,b := range f.Blocks {
+		g.Append(x86.Symbol(g.label(b)))
+		for _,i := range b.Instrs {
+			g.Instruction(i)
+		}
+	}
+	g.Append(x86.GlobalSymbol("return_"+f.Name))
+	reg = 0
+	for _,r := range f.Results[:regresults] {
+		for w:=0; w<ir.Words(r.Type); w++ {
+			g.Append(x86.Commented(x86.MovL(g.slot(r, w), resultRegisters[reg]),
+				"Returning "+r.Name+" in a register"))
+			reg++
+		}
+	}
+	g.Append(x86.Commented(x86.MovL(x86.EBP, x86.ESP), "Popping our frame"),
+		x86.Commented(x86.PopL(x86.EBP), "Restore the caller's frame pointer"))
+	size := s.Size - x86.WordSize - s.ReturnSize
+	if RegABIFuncs[f.Name] && size == 0 {
+		// With nothing to pop, we can return the usual way, which the
+		// processor predicts far better than a jmp.
+		g.Append(x86.Return("from " + f.Name))
+		return g.code
+	}
+	// The return address can't go in %eax if that's where a result is.
+	retaddr := x86.EAX
+	if regresults > 0 {
+		retaddr = x86.EDX
+	}
+	g.Append(x86.Commented(x86.PopL(retaddr), "Pop the return address"))
+	if size > 0 {
+		g.Append(x86.Commented(x86.AddL(x86.Imm32(size), x86.ESP),
+			"Popping "+f.Name+" arguments."))
+	}
+	// Then we return!
+	g.Append(x86.RawAssembly("\tjmp *" + retaddr.W32()))
+	return g.code
+}
+
+func tempName(t *ir.Temp) string {
+	return "$" + t.String() // so it can't be confused with a go variable
+}
+
+func (g *codegen) label(b *ir.Block) string {
+	return ".L" + g.f.Name + "_" + b.Name
+}
+
+// Operand returns where to find the value v right now.
+func (g *codegen) Operand(v ir.Value) x86.W32 {
+	switch v := v.(type) {
+	case *ir.Temp:
+		return g.Dest(v)
+	case ir.Int:
+		return x86.Imm32(v)
+	case ir.Symbol:
+		return x86.Symbol(v)
+	}
+	panic("I don't know where to find " + v.String())
+}
+
+// Dest returns where the temporary t lives right now.
+func (g *codegen) Dest(t *ir.Temp) location {
+	if r,ok := g.regs[t.N]; ok {
+		return r
+	}
+	return g.frame.Lookup(tempName(t)).InMemory()
+}
+
+// Reg tells us which register v is in, if any.
+func (g *codegen) Reg(v ir.Value) (x86.Register, bool) {
+	if t,ok := v.(*ir.Temp); ok {
+		r,ok := g.regs[t.N]
+		return r, ok
+	}
+	return 0, false
+}
+
+func (g *codegen) slot(s *ir.Slot, word int) x86.Memory {
+	return g.frame.Lookup(s.Name).InMemory().Add(x86.WordSize*word)
+}
+
+// Move copies a word.  The x86 won't move from memory to memory, but
+// it will push from memory and pop to memory.
+func (g *codegen) Move(src x86.W32, dst location) {
+	if src == dst {
+		return
+	}
+	_,srcmem := src.(x86.Memory)
+	_,dstmem := dst.(x86.Memory)
+	if srcmem && dstmem {
+		g.Append(x86.PushL(src), x86.PopL(dst))
+	} else {
+		g.Append(x86.MovL(src, dst))
+	}
+}
+
+// WithScratch calls f with a register that isn't holding any of
+// avoid, saving whatever was in the register around it if need be.
+func (g *codegen) WithScratch(f func(r x86.Register), avoid... ir.Value) {
+	g.withScratch(0, f, avoid...)
+}
+
+// withScratch is WithScratch for when some registers (in inuse) are
+// busy with something other than the values to avoid.
+func (g *codegen) withScratch(inuse regset, f func(r x86.Register), avoid... ir.Value) {
+	for _,v := range avoid {
+		if r,ok := g.Reg(v); ok {
+			inuse |= regs(r)
+		}
+	}
+	// We'd rather have a register that no temporary lives in, since
+	// then there's nothing to save.
+	for _,r := range allocatable {
+		if !inuse.Has(r) && !g.used.Has(r) {
+			f(r)
+			return
+		}
+	}
+	for _,r := range allocatable {
+		if !inuse.Has(r) {
+			g.Append(x86.Commented(x86.PushL(r), "Saving a scratch register"))
+			f(r)
+			g.Append(x86.PopL(r))
+			return
+		}
+	}
+	panic("There is no scratch register to be had!")
+}
+
+func (g *codegen) Instruction(i ir.Instr) {
+	g.Append(x86.Comment(i.String()))
+	switch i := i.(type) {
+	case *ir.Load:
+		g.Move(g.slot(i.Src, i.Word), g.Dest(i.Dst))
+	case *ir.Store:
+		g.Move(g.Operand(i.Src), g.slot(i.Dst, i.Word))
+	case *ir.LoadAt:
+		word := x86.Imm32(x86.WordSize*i.Word)
+		if r,ok := g.Reg(i.Addr); ok {
+			g.Move(x86.Memory{word, r, nil, nil}, g.Dest(i.Dst))
+			return
+		}
+		g.WithScratch(func(r x86.Register) {
+			g.Move(g.Operand(i.Addr), r)
+			g.Move(x86.Memory{word, r, nil, nil}, g.Dest(i.Dst))
+		}, i.Dst)
+	case *ir.IndexCheck:
+		g.IndexCheck(i)
+	case *ir.BinOp:
+		g.BinOp(i)
+	case *ir.UnOp:
+		g.Move(g.Operand(i.X), g.Dest(i.Dst))
+		if i.Op == token.SUB {
+			g.Append(x86.NegL(g.Dest(i.Dst)))
+		} else {
+			g.Append(x86.NotL(g.Dest(i.Dst)))
+		}
+	case *ir.Syscall:
+		// The kernel wants the call number in %eax and the arguments in
+		// %ebx, %ecx and %edx (or %rdi, %rsi and %rdx on the amd64),
+		// which might be where some of them are right now, so we
+		// shuffle them through the stack.
+		for _,v := range []ir.Value{i.Trap, i.Args[0], i.Args[1], i.Args[2]} {
+			g.Append(x86.PushL(g.Operand(v)))
+		}
+		args,_ := syscallRegisters()
+		for a:=len(args)-1; a>=0; a-- {
+			g.Append(x86.PopL(args[a]))
+		}
+		g.Append(x86.PopL(x86.EAX))
+		if x86.WordSize == 8 {
+			g.Append(x86.Syscall())
+		} else {
+			g.Append(x86.Int(x86.Imm32(128)))
+		}
+		g.Move(x86.EAX, g.Dest(i.Dst))
+	case *ir.Call:
+		if i.CDecl {
+			g.CCall(i)
+			return
+		}
+		g.Call(i)
+	case *ir.Jump:
+		g.Append(x86.Jmp(x86.Symbol(g.label(i.Target))))
+	case *ir.Return:
+		g.Append(x86.Jmp(x86.Symbol("return_" + g.f.Name)))
+	case *ir.Line:
+		g.Append(lineLabel(i))
+		if *debugInfo {
+			g.Append(x86.RawAssembly(fmt.Sprint("\t.loc ", debugFile(i.File), " ", i.Line)))
+		}
+	default:
+		panic("I can't generate code for " + i.String())
+	}
+}
+
+// Call calls a go function.
+func (g *codegen) Call(i *ir.Call) {
+	regargs, regresults := 0, 0
+	if RegABIFuncs[i.Func] {
+		regargs = inRegisters(argWords(i.Args), len(argRegisters))
+		regresults = inRegisters(resultWords(i.Results), len(resultRegisters))
+	}
+	for _,r := range i.Results[regresults:] {
+		// Put zeros on the stack for the results, which the callee
+		// will fill in.
+		for w:=0; w<len(r); w++ {
+			g.Append(x86.PushL(x86.Imm32(0)))
+		}
+	}
+	// The arguments are pushed last word first, so that the first
+	// argument ends up next to the return address.  Any that go in
+	// registers are pushed too, since one of them could be sitting in
+	// another's register, and then popped into place.
+	for a:=len(i.Args)-1; a>=0; a-- {
+		for w:=len(i.Args[a])-1; w>=0; w-- {
+			g.Append(x86.PushL(g.Operand(i.Args[a][w])))
+		}
+	}
+	reg := 0
+	for _,a := range i.Args[:regargs] {
+		for w:=0; w<len(a); w++ {
+			g.Append(x86.PopL(argRegisters[reg]))
+			reg++
+		}
+	}
+	// The callee pops the arguments for us.
+	g.Append(x86.Call(x86.Symbol(i.Func)))
+	// The results in registers go through the stack too, for the same
+	// reason as the arguments.
+	reg = 0
+	for _,r := range i.Results[:regresults] {
+		reg += len(r)
+	}
+	for reg--; reg>=0; reg-- {
+		g.Append(x86.PushL(resultRegisters[reg]))
+	}
+	for _,r := range i.Results[:regresults] {
+		for _,t := range r {
+			g.Append(x86.PopL(g.Dest(t)))
+		}
+	}
+	// The last result is on top of the stack, lowest word first.
+	for r:=len(i.Results)-1; r>=regresults; r-- {
+		for _,t := range i.Results[r] {
+			g.Append(x86.PopL(g.Dest(t)))
+		}
+	}
+}
+
+// CCall calls a C function, which expects the stack to be aligned to
+// 16 bytes at the call, and which leaves the arguments on the stack
+// for us to pop.  It may also clobber %eax, %ecx and %edx, which the
+// register allocator knows about.  On the amd64, the arguments go in
+// registers instead, and C clobbers rather more of them.
+func (g *codegen) CCall(i *ir.Call) {
+	words := 0
+	for _,a := range i.Args {
+		words += len(a)
+	}
+	g.Append(x86.Commented(x86.AndL(x86.Imm32(-16), x86.ESP), "Aligning the stack for C"))
+	if pad := (16 - 4*words%16) % 16; pad > 0 && x86.WordSize == 4 {
+		g.Append(x86.SubL(x86.Imm32(pad), x86.ESP))
+	}
+	for a:=len(i.Args)-1; a>=0; a-- {
+		for w:=len(i.Args[a])-1; w>=0; w-- {
+			g.Append(x86.PushL(g.Operand(i.Args[a][w])))
+		}
+	}
+	if x86.WordSize == 8 {
+		// We pushed the arguments in case they're in each other's
+		// registers, and popping them leaves the stack aligned again.
+		for w:=0; w<words; w++ {
+			g.Append(x86.PopL(cArgRegisters[w]))
+		}
+	}
+	g.Append(x86.Call(x86.Symbol(i.Func)),
+		x86.Commented(x86.MovL(x86.EBP, x86.ESP), "Popping the arguments and padding"))
+	if g.frame.Size > 0 {
+		g.Append(x86.SubL(x86.Imm32(g.frame.Size), x86.ESP))
+	}
+	for _,r := range i.Results {
+		g.Move(x86.EAX, g.Dest(r[0]))
+	}
+}
+
+// CWrapper generates a function that C can call, which calls f the
+// way we call functions.  C expects us to leave %ebx, %esi, %edi and
+// %ebp (or on the amd64, %rbx, %r12 to %r15 and %rbp) as we found
+// them, which f may not do.
+func CWrapper(f *ir.Func) []x86.X86 {
+	code := []x86.X86{
+		x86.Commented(x86.GlobalSymbol(f.Export), "a C wrapper for "+f.Name),
+		x86.PushL(x86.EBP),
+		x86.MovL(x86.ESP, x86.EBP),
+	}
+	saved := cSaved()
+	for _,r := range saved {
+		code = append(code, x86.PushL(r))
+	}
+	if len(f.Results) > 0 {
+		code = append(code, x86.Commented(x86.PushL(x86.Imm32(0)), "room for the result"))
+	}
+	for a:=len(f.Params)-1; a>=0; a-- {
+		if x86.WordSize == 8 {
+			code = append(code, x86.PushL(cArgRegisters[a]))
+		} else {
+			code = append(code, x86.PushL(x86.Memory{x86.Imm32(8+4*a), x86.EBP, nil, nil}))
+		}
+	}
+	code = append(code, x86.Call(x86.Symbol(f.Name)))
+	if len(f.Results) > 0 {
+		code = append(code, x86.Commented(x86.PopL(x86.EAX), "C wants the result in %eax"))
+	}
+	for k:=len(saved)-1; k>=0; k-- {
+		code = append(code, x86.PopL(saved[k]))
+	}
+	return append(code, x86.PopL(x86.EBP), x86.Return("back to C"))
+}
+
+func (g *codegen) BinOp(i *ir.BinOp) {
+	switch i.Op {
+	case token.QUO, token.REM:
+		g.Divide(i)
+		return
+	case token.SHL, token.SHR:
+		g.Shift(i)
+		return
+	}
+	if r,ok := g.Dest(i.Dst).(x86.Register); ok {
+		g.Arithmetic(i.Op, r, i.X, i.Y)
+		return
+	}
+	// The x86 won't do arithmetic on two memory operands, and imull
+	// insists on a register, so we work in a scratch register.
+	g.WithScratch(func(r x86.Register) {
+		g.Arithmetic(i.Op, r, i.X, i.Y)
+		g.Move(r, g.Dest(i.Dst))
+	}, i.X, i.Y)
+}
+
+// Arithmetic computes x op y into the register d, which might be
+// where x or y already are.
+func (g *codegen) Arithmetic(op token.Token, d x86.Register, x, y ir.Value) {
+	rx,xind := g.Reg(x)
+	ry,yind := g.Reg(y)
+	xind = xind && rx == d
+	yind = yind && ry == d
+	if yind && !xind {
+		// We can't move x into d without losing y.
+		switch op {
+		case token.SUB:
+			g.Append(x86.NegL(d), x86.AddL(g.Operand(x), d))
+			return
+		case token.AND_NOT:
+			g.Append(x86.NotL(d), x86.AndL(g.Operand(x), d))
+			return
+		}
+		g.Append(arithmetic(op, g.Operand(x), d)) // the rest commute
+		return
+	}
+	g.Move(g.Operand(x), d)
+	if op == token.AND_NOT {
+		if c,ok := y.(ir.Int); ok {
+			g.Append(x86.AndL(x86.Imm32(^c), d))
+		} else if yind {
+			// y is in d too, so it's x, and notting d would lose it.
+			g.Append(x86.MovL(x86.Imm32(0), d))
+		} else {
+			// x &^ y == ^(^x | y)
+			g.Append(x86.NotL(d), x86.OrL(g.Operand(y), d), x86.NotL(d))
+		}
+		return
+	}
+	g.Append(arithmetic(op, g.Operand(y), d))
+}
+
+func arithmetic(op token.Token, src x86.W32, d x86.Register) x86.X86 {
+	switch op {
+	case token.ADD:
+		return x86.AddL(src, d)
+	case token.SUB:
+		return x86.SubL(src, d)
+	case token.MUL:
+		return x86.IMulL(src, d)
+	case token.AND:
+		return x86.AndL(src, d)
+	case token.OR:
+		return x86.OrL(src, d)
+	case token.XOR:
+		return x86.XorL(src, d)
+	}
+	panic("I don't know how to " + op.String())
+}
+
+// Divide uses idivl, which divides %edx:%eax by its operand, leaving
+// the quotient in %eax and the remainder in %edx.  The register
+// allocator has kept anything else (including the divisor) out of
+// those two registers.  idivl faults if the quotient doesn't fit,
+// which only happens when dividing the most negative int by -1, and
+// go says that gives the same int back (with a remainder of zero), so
+// we never let idivl see a -1:
+//
+//	cmpl $-1, divisor
+//	je 1f
+//	cltd
+//	idivl divisor
+//	jmp 2f
+//	1: negl %eax (or movl $0, %edx)
+//	2:
+func (g *codegen) Divide(i *ir.BinOp) {
+	g.Move(g.Operand(i.X), x86.EAX)
+	if c,ok := i.Y.(ir.Int); ok && c == -1 {
+		if i.Op == token.QUO {
+			g.Append(x86.NegL(x86.EAX))
+		} else {
+			g.Append(x86.MovL(x86.Imm32(0), x86.EDX))
+		}
+	} else if ok {
+		// idivl won't divide by a constant, so we put it on the stack.
+		g.Append(x86.Cltd(),
+			x86.PushL(x86.Imm32(c)),
+			x86.IDivL(x86.Memory{nil, x86.ESP, nil, nil}),
+			x86.AddL(x86.Imm32(x86.WordSize), x86.ESP))
+	} else {
+		y := g.Operand(i.Y)
+		g.Append(x86.CmpL(x86.Imm32(-1), y),
+			x86.Je(x86.Symbol("1f")),
+			x86.Cltd(),
+			x86.IDivL(y),
+			x86.Jmp(x86.Symbol("2f")),
+			x86.Symbol("1"))
+		if i.Op == token.QUO {
+			g.Append(x86.NegL(x86.EAX))
+		} else {
+			g.Append(x86.MovL(x86.Imm32(0), x86.EDX))
+		}
+		g.Append(x86.Symbol("2"))
+	}
+	if i.Op == token.QUO {
+		g.Move(x86.EAX, g.Dest(i.Dst))
+	} else {
+		g.Move(x86.EDX, g.Dest(i.Dst))
+	}
+}
+
+// IndexCheck panics (in goc.indexpanic) unless the index is within
+// the length.  Comparing them as unsigned numbers catches a negative
+// index too:
+//
+//	cmpl length, index
+//	jb 1f
+//	pushl length
+//	pushl index
+//	call goc.indexpanic
+//	1:
+func (g *codegen) IndexCheck(i *ir.IndexCheck) {
+	g.WithScratch(func(r x86.Register) {
+		g.Move(g.Operand(i.Index), r)
+		g.Append(x86.CmpL(g.Operand(i.Len), r),
+			x86.Jb(x86.Symbol("1f")),
+			x86.PushL(g.Operand(i.Len)),
+			x86.PushL(r),
+			x86.Call(x86.Symbol("goc.indexpanic")),
+			x86.Symbol("1"))
+	}, i.Len)
+}
+
+// Shift does a shift, which we can do straight into memory.  Since
+// our ints are signed, a right shift is arithmetic.
+func (g *codegen) Shift(i *ir.BinOp) {
+	shift := func(count x86.W8, d x86.Ptr) x86.X86 {
+		if i.Op == token.SHL {
+			return x86.ShiftLeftL(count, d)
+		}
+		return x86.ShiftRightArithL(count, d)
+	}
+	d := g.Dest(i.Dst)
+	if c,ok := i.Y.(ir.Int); ok && c < 0 {
+		// That's a panic, which inlining can leave us sure of.
+		g.Append(x86.Call(x86.Symbol("goc.shiftpanic")))
+		return
+	} else if ok {
+		// The x86 only looks at the bottom five (or six) bits of the
+		// count, but go shifts everything out when the count is a word
+		// or more.
+		bits := ir.Int(8*x86.WordSize)
+		if c >= bits && i.Op == token.SHL {
+			g.Append(x86.MovL(x86.Imm32(0), d))
+			return
+		} else if c >= bits {
+			c = bits - 1
+		}
+		g.Move(g.Operand(i.X), d)
+		g.Append(shift(x86.Imm8(c), d))
+		return
+	}
+	// A count that isn't constant has to be in %cl, which the
+	// allocator has kept clear of everything but the count itself.
+	// A negative count is a panic,
+	//
+	//	cmpl $0, %ecx
+	//	jge 1f
+	//	call goc.shiftpanic
+	//	1:
+	//
+	// and the x86 would only look at the bottom bits of any other, so
+	// to get go's answer for a count of a word or more we make a mask
+	// which is all ones if the count is smaller than that,
+	//
+	//	cmpl $32, %ecx
+	//	sbbl %eax, %eax
+	//
+	// and a left shift ands it with what it shifted, while a right
+	// shift ors its inverse into the count, so as to shift by 31.
+	g.Move(g.Operand(i.Y), x86.ECX)
+	g.Append(x86.CmpL(x86.Imm32(0), x86.ECX),
+		x86.Jge(x86.Symbol("1f")),
+		x86.Call(x86.Symbol("goc.shiftpanic")),
+		x86.Symbol("1"))
+	g.Move(g.Operand(i.X), d)
+	g.withScratch(regs(x86.ECX), func(r x86.Register) {
+		g.Append(x86.CmpL(x86.Imm32(8*x86.WordSize), x86.ECX), x86.SbbL(r, r))
+		if i.Op == token.SHL {
+			g.Append(shift(x86.ECX, d), x86.AndL(r, d))
+		} else {
+			g.Append(x86.NotL(r), x86.OrL(r, x86.ECX), shift(x86.ECX, d))
+		}
+	}, i.X, i.Dst)
+}
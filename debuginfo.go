@@ -0,0 +1,375 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"github.com/droundy/go/ir"
+	"github.com/droundy/go/types"
+	"github.com/droundy/go/x86"
+	"github.com/droundy/goopt"
+)
+
+var debugInfo = goopt.Flag([]string{"-g", "--debug-info"}, []string{},
+	"generate debugging information for gdb", "")
+
+// With -g we tell gdb about the program in DWARF (version 2, which is
+// all we need).  The line numbers are easy: each statement starts with
+// an ir.Line, which becomes a .loc directive, and the assembler makes
+// the .debug_line section from those.  Everything else we write out
+// ourselves: a compile unit covering all the text, and in it each
+// function, with its parameters, results and locals, which all live in
+// its frame at a fixed offset from the frame pointer.  That's all gdb
+// needs to set breakpoints by line, step through the source, and print
+// variables.  Our own encoder doesn't make any of this yet, so it's
+// only there when GNU as assembles the program.
+
+// A debugFunc is what we know about a function for the debugging
+// information.
+
+type debugFunc struct {
+	name, file string
+	line int
+	vars []debugVar
+}
+
+// A debugVar is a parameter, result or local, and where it is
+// relative to the frame pointer.
+
+type debugVar struct {
+	name string
+	t types.Type
+	offset int
+	param bool
+}
+
+var debugFuncs []*debugFunc
+
+// Each source file gets a number, by which .loc refers to it.
+var debugFiles = make(map[string]int)
+var debugFileNames []string
+
+func debugFile(name string) int {
+	if n,ok := debugFiles[name]; ok {
+		return n
+	}
+	debugFileNames = append(debugFileNames, name)
+	debugFiles[name] = len(debugFileNames)
+	return len(debugFileNames)
+}
+
+// DebugStart comes before any of the code, telling the assembler
+// about each source file.
+func DebugStart(p *ir.Program) []x86.X86 {
+	var out []string
+	for _,f := range p.Funcs {
+		for _,b := range f.Blocks {
+			for _,i := range b.Instrs {
+				if l,ok := i.(*ir.Line); ok {
+					if _,ok := debugFiles[l.File]; !ok {
+						out = append(out, fmt.Sprintf("\t.file %d %q", debugFile(l.File), l.File))
+					}
+				}
+			}
+		}
+	}
+	return []x86.X86{x86.Section("text"), x86.Symbol(".Ltext0"),
+		x86.RawAssembly(strings.Join(out, "\n"))}
+}
+
+// debugFunction records what gdb needs to know about f, once g has
+// decided where everything goes.  It returns a .loc for the prologue,
+// which gdb needs to see to know where the prologue ends: that's where
+// the line of the func itself comes up a second time.
+func (g *codegen) debugFunction() []x86.X86 {
+	d := &debugFunc{name: g.f.Name}
+	for _,b := range g.f.Blocks {
+		for _,i := range b.Instrs {
+			if l,ok := i.(*ir.Line); ok && d.file == "" {
+				d.file, d.line = l.File, l.Line
+			}
+		}
+	}
+	if d.file == "" {
+		return nil // we don't know where it came from
+	}
+	for k,ss := range [][]*ir.Slot{g.f.Params, g.f.Results, g.f.Locals} {
+		for _,s := range ss {
+			if s.Name == "_" || s.Name == "" {
+				continue
+			}
+			if v,ok := g.frame.Lookup(s.Name).(*StackVariable); ok {
+				d.vars = append(d.vars, debugVar{s.Name, s.Type, v.Offset, k == 0})
+			}
+		}
+	}
+	debugFuncs = append(debugFuncs, d)
+	return []x86.X86{x86.RawAssembly(fmt.Sprint("\t.loc ", debugFile(d.file), " ", d.line))}
+}
+
+// DWARF's constants, or those of them that we use.
+const (
+	dwTagCompileUnit = 0x11
+	dwTagSubprogram = 0x2e
+	dwTagFormalParameter = 0x05
+	dwTagVariable = 0x34
+	dwTagBaseType = 0x24
+	dwTagStructureType = 0x13
+	dwTagMember = 0x0d
+	dwTagPointerType = 0x0f
+
+	dwAtName = 0x03
+	dwAtByteSize = 0x0b
+	dwAtStmtList = 0x10
+	dwAtLowPc = 0x11
+	dwAtHighPc = 0x12
+	dwAtLanguage = 0x13
+	dwAtCompDir = 0x1b
+	dwAtProducer = 0x25
+	dwAtDataMemberLocation = 0x38
+	dwAtDeclFile = 0x3a
+	dwAtDeclLine = 0x3b
+	dwAtEncoding = 0x3e
+	dwAtExternal = 0x3f
+	dwAtFrameBase = 0x40
+	dwAtLocation = 0x02
+	dwAtType = 0x49
+
+	dwFormAddr = 0x01
+	dwFormBlock1 = 0x0a
+	dwFormData1 = 0x0b
+	dwFormData4 = 0x06
+	dwFormString = 0x08
+	dwFormFlag = 0x0c
+	dwFormRef4 = 0x13
+	dwFormUdata = 0x0f
+
+	dwAteBoolean = 0x02
+	dwAteSigned = 0x05
+	dwAteUnsigned = 0x07
+
+	dwOpFbreg = 0x91
+	dwOpBreg0 = 0x70
+	dwOpPlusUconst = 0x23
+
+	dwLangGo = 0x16
+)
+
+// These are the abbreviations, which say which attributes each kind of
+// entry has, and how they're written.
+const (
+	abbrevCompileUnit = 1 + iota
+	abbrevSubprogram
+	abbrevParameter
+	abbrevVariable
+	abbrevBaseType
+	abbrevStruct
+	abbrevMember
+	abbrevPointer
+)
+
+var abbrevs = [][]int{
+	abbrevCompileUnit: {dwTagCompileUnit, 1, dwAtProducer, dwFormString, dwAtLanguage, dwFormData1,
+		dwAtName, dwFormString, dwAtCompDir, dwFormString, dwAtLowPc, dwFormAddr,
+		dwAtHighPc, dwFormAddr, dwAtStmtList, dwFormData4},
+	abbrevSubprogram: {dwTagSubprogram, 1, dwAtName, dwFormString, dwAtDeclFile, dwFormUdata,
+		dwAtDeclLine, dwFormUdata, dwAtExternal, dwFormFlag, dwAtLowPc, dwFormAddr,
+		dwAtHighPc, dwFormAddr, dwAtFrameBase, dwFormBlock1},
+	abbrevParameter: {dwTagFormalParameter, 0, dwAtName, dwFormString, dwAtType, dwFormRef4,
+		dwAtLocation, dwFormBlock1},
+	abbrevVariable: {dwTagVariable, 0, dwAtName, dwFormString, dwAtType, dwFormRef4,
+		dwAtLocation, dwFormBlock1},
+	abbrevBaseType: {dwTagBaseType, 0, dwAtName, dwFormString, dwAtEncoding, dwFormData1,
+		dwAtByteSize, dwFormData1},
+	abbrevStruct: {dwTagStructureType, 1, dwAtName, dwFormString, dwAtByteSize, dwFormData1},
+	abbrevMember: {dwTagMember, 0, dwAtName, dwFormString, dwAtType, dwFormRef4,
+		dwAtDataMemberLocation, dwFormBlock1},
+	abbrevPointer: {dwTagPointerType, 0, dwAtByteSize, dwFormData1, dwAtType, dwFormRef4},
+}
+
+// A dwarf builds up the assembly for the debugging information.
+
+type dwarf struct {
+	out []string
+	types map[string]string // the label of the entry for each type
+	order []types.Type // the types, in the order we met them
+}
+
+func (d *dwarf) line(format string, args ...interface{}) {
+	d.out = append(d.out, fmt.Sprintf(format, args...))
+}
+func (d *dwarf) bytes(bs ...int) {
+	var s []string
+	for _,b := range bs {
+		s = append(s, fmt.Sprint(b))
+	}
+	d.line("\t.byte %s", strings.Join(s, ", "))
+}
+func (d *dwarf) str(s string) {
+	d.line("\t.ascii %q", s + "\x00")
+}
+func (d *dwarf) addr(sym string) {
+	if x86.WordSize == 8 {
+		d.line("\t.quad %s", sym)
+	} else {
+		d.line("\t.long %s", sym)
+	}
+}
+func (d *dwarf) ref(label string) {
+	d.line("\t.long %s - .Ldebug_info0", label)
+}
+
+// uleb128 and sleb128 encode numbers the way DWARF likes them, seven
+// bits to a byte.
+func uleb128(v uint) (out []int) {
+	for {
+		b := int(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(out, b)
+		}
+		out = append(out, b | 0x80)
+	}
+	panic("unreachable")
+}
+func sleb128(v int) (out []int) {
+	for {
+		b := v & 0x7f
+		v >>= 7
+		if (v == 0 && b & 0x40 == 0) || (v == -1 && b & 0x40 != 0) {
+			return append(out, b)
+		}
+		out = append(out, b | 0x80)
+	}
+	panic("unreachable")
+}
+
+// typeLabel returns the label of the entry describing t, which is
+// written out at the end.
+func (d *dwarf) typeLabel(t types.Type) string {
+	name := t.String()
+	if l,ok := d.types[name]; ok {
+		return l
+	}
+	l := fmt.Sprint(".Ldebug_type", len(d.types))
+	d.types[name] = l
+	d.order = append(d.order, t)
+	return l
+}
+
+// typeEntries writes out the entries for the types that we've
+// referred to, along with any types they refer to in turn, which go on
+// the end of d.order as we go.
+func (d *dwarf) typeEntries() {
+	for k:=0; k<len(d.order); k++ {
+		t := d.order[k]
+		name := t.String()
+		l := d.types[name]
+		d.line("%s:", l)
+		b,_ := t.Underlying().(*types.Basic)
+		switch {
+		case b != nil && b.Kind == types.String:
+			// A string is its length followed by a pointer to its
+			// bytes.
+			d.bytes(abbrevStruct)
+			d.str(name)
+			d.bytes(2*x86.WordSize)
+			d.bytes(abbrevMember)
+			d.str("len")
+			d.ref(d.typeLabel(types.Typ[types.Int]))
+			d.bytes(2, dwOpPlusUconst, 0)
+			d.bytes(abbrevMember)
+			d.str("str")
+			d.ref(l + "_ptr")
+			d.bytes(2, dwOpPlusUconst, x86.WordSize)
+			d.bytes(0)
+			d.line("%s_ptr:", l)
+			d.bytes(abbrevPointer, x86.WordSize)
+			d.ref(d.typeLabel(types.Typ[types.Uint8]))
+		default:
+			// Anything else that we can't describe yet looks to gdb
+			// like a number of the right size.
+			encoding := dwAteUnsigned
+			if b != nil && b.Kind == types.Bool {
+				encoding = dwAteBoolean
+			} else if b != nil && b.Kind >= types.Int && b.Kind <= types.Int64 {
+				encoding = dwAteSigned
+			}
+			size := types.Target.Sizeof(t)
+			if size > 255 || size <= 0 {
+				size = x86.WordSize
+			}
+			d.bytes(abbrevBaseType)
+			d.str(name)
+			d.bytes(encoding, size)
+		}
+	}
+}
+
+// DebugInfo writes out the debugging information for everything we
+// recorded with debugFunction.  It must come after all the code.
+func DebugInfo(source string) []x86.X86 {
+	d := &dwarf{types: make(map[string]string)}
+	d.line("\t.text")
+	d.line(".Letext0:")
+	d.line("\t.section .debug_line")
+	d.line(".Ldebug_line0:")
+
+	d.line("\t.section .debug_abbrev")
+	d.line(".Ldebug_abbrev0:")
+	for n,a := range abbrevs {
+		if n == 0 {
+			continue
+		}
+		d.bytes(append(append(uleb128(uint(n)), a[0], a[1]), a[2:]...)...)
+		d.bytes(0, 0)
+	}
+	d.bytes(0)
+
+	d.line("\t.section .debug_info")
+	d.line(".Ldebug_info0:")
+	d.line("\t.long .Ldebug_info_end - .Ldebug_info_start")
+	d.line(".Ldebug_info_start:")
+	d.line("\t.2byte 2") // the version of DWARF
+	d.line("\t.long .Ldebug_abbrev0")
+	d.bytes(x86.WordSize)
+	d.bytes(abbrevCompileUnit)
+	d.str("gogo")
+	d.bytes(dwLangGo)
+	d.str(source)
+	d.str(".")
+	d.addr(".Ltext0")
+	d.addr(".Letext0")
+	d.line("\t.long .Ldebug_line0")
+	// The frame base is wherever %ebp points.
+	fp := 5
+	if x86.WordSize == 8 {
+		fp = 6 // the amd64 numbers its registers differently
+	}
+	for _,f := range debugFuncs {
+		d.bytes(abbrevSubprogram)
+		d.str(f.name)
+		d.bytes(uleb128(uint(debugFile(f.file)))...)
+		d.bytes(uleb128(uint(f.line))...)
+		d.bytes(1)
+		d.addr(f.name)
+		d.addr(".Lend_" + f.name)
+		d.bytes(2, dwOpBreg0 + fp, 0)
+		for _,v := range f.vars {
+			if v.param {
+				d.bytes(abbrevParameter)
+			} else {
+				d.bytes(abbrevVariable)
+			}
+			d.str(v.name)
+			d.ref(d.typeLabel(v.t))
+			loc := sleb128(v.offset)
+			d.bytes(append([]int{1 + len(loc), dwOpFbreg}, loc...)...)
+		}
+		d.bytes(0)
+	}
+	d.typeEntries()
+	d.bytes(0)
+	d.line(".Ldebug_info_end:")
+	d.line("\t.text")
+	return []x86.X86{x86.RawAssembly(strings.Join(d.out, "\n"))}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"sort"
+	"go/ast"
+	"go/token"
+)
+
+// When we find something wrong with the program we are compiling, we
+// panic with a *CompileError, which is recovered (by Catch) at the
+// level of the enclosing statement or function.  That way we can
+// carry on and report as many errors as possible in one go.
+
+type ErrorKind int
+const (
+	// InvalidProgram means the program isn't legal go.
+	InvalidProgram ErrorKind = iota
+	// UnsupportedFeature means the program may well be legal go, but
+	// uses something this compiler can't yet handle.
+	UnsupportedFeature
+)
+
+type CompileError struct {
+	Pos token.Pos
+	Kind ErrorKind
+	Msg string
+}
+
+func (e *CompileError) String() string {
+	pos := myfiles.Position(e.Pos)
+	kind := ""
+	if e.Kind == UnsupportedFeature {
+		kind = "unsupported: "
+	}
+	return fmt.Sprintf("%s:%d:%d: %s%s", pos.Filename, pos.Line, pos.Column, kind, e.Msg)
+}
+
+// AddError records an error at n without interrupting what we are
+// doing.
+func AddError(kind ErrorKind, n ast.Node, format string, args ...interface{}) {
+	Errors = append(Errors, &CompileError{ n.Pos(), kind, fmt.Sprintf(format, args...) })
+}
+
+func raise(kind ErrorKind, n ast.Node, format string, args []interface{}) {
+	pos := token.NoPos
+	if n != nil {
+		pos = n.Pos()
+	}
+	panic(&CompileError{ pos, kind, fmt.Sprintf(format, args...) })
+}
+
+// Invalid reports that node n (which may be nil if we don't know
+// where we are) isn't legal go.
+func Invalid(n ast.Node, format string, args ...interface{}) {
+	raise(InvalidProgram, n, format, args)
+}
+
+// Unsupported reports that node n (which may be nil if we don't know
+// where we are) uses a feature we don't handle.
+func Unsupported(n ast.Node, format string, args ...interface{}) {
+	raise(UnsupportedFeature, n, format, args)
+}
+
+// Errors holds every error found so far.
+var Errors []*CompileError
+
+// Catch must be deferred.  It recovers from any CompileError raised
+// while compiling n, records it, and then calls cleanup so the caller
+// can get its bookkeeping back in order.  Any other panic is a bug in
+// the compiler, so we let it through.
+func Catch(n ast.Node, cleanup func()) {
+	x := recover()
+	if x == nil {
+		return
+	}
+	e,ok := x.(*CompileError)
+	if !ok {
+		panic(x)
+	}
+	if e.Pos == token.NoPos {
+		e.Pos = n.Pos() // the best guess we have
+	}
+	Errors = append(Errors, e)
+	cleanup()
+}
+
+type errorList []*CompileError
+func (l errorList) Len() int { return len(l) }
+func (l errorList) Less(i, j int) bool { return l[i].Pos < l[j].Pos }
+func (l errorList) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+
+// ReportErrors prints any errors we have found, in the order in which
+// they appear in the source, and exits if there were any.
+func ReportErrors() {
+	if len(Errors) == 0 {
+		return
+	}
+	sort.Sort(errorList(Errors))
+	for _,e := range Errors {
+		fmt.Fprintln(os.Stderr, e)
+	}
+	os.Exit(1)
+}
@@ -0,0 +1,175 @@
+package elf
+
+import (
+	"os"
+	"path"
+	"debug/dwarf"
+)
+
+// A program compiled with -g carries DWARF, which debug/dwarf can read
+// for us once we hand it the sections, all but the line numbers, which
+// it doesn't know about.  Those we decode ourselves, from the line
+// number program that GNU as writes into .debug_line (which is DWARF
+// version 2 or 3, or 4 at most, and never 64-bit DWARF).
+
+// DWARF returns the debugging information in f.
+func (f *File) DWARF() (*dwarf.Data, os.Error) {
+	data := func(name string) []byte {
+		if s := f.Section(name); s != nil {
+			return s.Data
+		}
+		return nil
+	}
+	if data(".debug_info") == nil {
+		return nil, os.NewError("there is no debugging information")
+	}
+	return dwarf.New(data(".debug_abbrev"), data(".debug_aranges"), data(".debug_frame"),
+		data(".debug_info"), data(".debug_line"), data(".debug_pubnames"),
+		data(".debug_ranges"), data(".debug_str"))
+}
+
+// A Line says that the code at Address is for the given line of File.
+
+type Line struct {
+	Address uint64
+	File string
+	Line int
+}
+
+func (r *reader) uleb() (v uint64) {
+	for shift:=uint(0); r.err == nil; shift += 7 {
+		b := r.byte()
+		v |= uint64(b & 127) << shift
+		if b < 128 {
+			break
+		}
+	}
+	return
+}
+func (r *reader) sleb() int64 {
+	var v int64
+	shift := uint(0)
+	for r.err == nil {
+		b := r.byte()
+		v |= int64(b & 127) << shift
+		shift += 7
+		if b < 128 {
+			if b & 64 != 0 && shift < 64 {
+				v |= -1 << shift
+			}
+			break
+		}
+	}
+	return v
+}
+func (r *reader) cstring() string {
+	s := cstring(r.b, r.at)
+	r.at += uint64(len(s)) + 1
+	return s
+}
+
+// Lines returns every row of the line number table in f, in the order
+// they come in .debug_line.
+func (f *File) Lines() (lines []Line, err os.Error) {
+	s := f.Section(".debug_line")
+	if s == nil {
+		return nil, os.NewError("there is no .debug_line")
+	}
+	r := &reader{b: s.Data, is64: f.Is64}
+	for r.at < uint64(len(s.Data)) && r.err == nil {
+		length := uint64(r.word())
+		end := r.at + length
+		if length == 0xffffffff || end > uint64(len(s.Data)) {
+			return nil, os.NewError("I can't read this .debug_line")
+		}
+		version := r.half()
+		r.word() // the length of the rest of the header
+		mininst := uint64(r.byte())
+		if version >= 4 {
+			r.byte() // the most operations in an instruction, which is 1 on the x86
+		}
+		r.byte() // whether a row is a statement to start with
+		linebase := int(int8(r.byte()))
+		linerange := int(r.byte())
+		opbase := int(r.byte())
+		if linerange == 0 {
+			return nil, os.NewError("the line range in .debug_line is zero")
+		}
+		oplengths := make([]int, opbase)
+		for i:=1; i<opbase; i++ {
+			oplengths[i] = int(r.byte())
+		}
+		dirs := []string{""}
+		for d := r.cstring(); d != "" && r.err == nil; d = r.cstring() {
+			dirs = append(dirs, d)
+		}
+		files := []string{""} // files are numbered from one
+		addfile := func(name string) {
+			dir := r.uleb()
+			r.uleb() // when it was modified
+			r.uleb() // and its size
+			if dir < uint64(len(dirs)) {
+				name = path.Join(dirs[dir], name)
+			}
+			files = append(files, name)
+		}
+		for name := r.cstring(); name != "" && r.err == nil; name = r.cstring() {
+			addfile(name)
+		}
+
+		var address uint64
+		file, line := 1, 1
+		row := func() {
+			name := ""
+			if file < len(files) {
+				name = files[file]
+			}
+			lines = append(lines, Line{address, name, line})
+		}
+		for r.at < end && r.err == nil {
+			op := int(r.byte())
+			if op >= opbase {
+				op -= opbase
+				address += uint64(op / linerange) * mininst
+				line += linebase + op % linerange
+				row()
+				continue
+			}
+			switch op {
+			case 0: // an extended opcode
+				size := r.uleb()
+				next := r.at + size
+				switch r.byte() {
+				case 1: // the end of a sequence
+					address, file, line = 0, 1, 1
+				case 2: // set the address
+					address = r.get(size - 1)
+				case 3: // define a file
+					addfile(r.cstring())
+				}
+				r.at = next
+			case 1: // copy
+				row()
+			case 2: // advance the address
+				address += r.uleb() * mininst
+			case 3: // advance the line
+				line += int(r.sleb())
+			case 4: // set the file
+				file = int(r.uleb())
+			case 8: // add the address a special opcode of 255 would
+				address += uint64((255 - opbase) / linerange) * mininst
+			case 9: // advance the address by a fixed amount
+				address += uint64(r.half())
+			default: // something we don't care about, such as the column
+				for i:=0; i<oplengths[op]; i++ {
+					r.uleb()
+				}
+			}
+		}
+		r.at = end
+	}
+	if r.err != nil {
+		return nil, os.NewError(".debug_line is cut short")
+	}
+	return
+}
@@ -6,7 +6,14 @@ import (
 	"exec"
 )
 
-func AssembleAndLink(fn string, code []byte) (err os.Error) {
+// AssembleAndLink turns code into an executable called fn, for the
+// machine arch, which is either "386" or "amd64".  Anything in
+// linkwith is handed to the linker, such as object files to link in.
+func AssembleAndLink(arch, fn string, code []byte, linkwith ...string) (err os.Error) {
+	asflag, emulation := "--32", "elf_i386"
+	if arch == "amd64" {
+		asflag, emulation = "--64", "elf_x86_64"
+	}
 	o,err := os.Open(fn+".S", os.O_WRONLY + os.O_CREAT + os.O_TRUNC, 0666)
 	if err != nil {
 		o.Close()
@@ -17,11 +24,11 @@ func AssembleAndLink(fn string, code []byte) (err os.Error) {
 	if err != nil {
 		return
 	}
-	err = justrun("as", "--32", "--fatal-warnings", "-o", fn+".o", fn+".S")
+	err = justrun("as", asflag, "--fatal-warnings", "-o", fn+".o", fn+".S")
 	if err != nil {
 		return
 	}
-	err = justrun("ld", "-o", fn, fn+".o") // "-s",
+	err = justrun("ld", append([]string{"-m", emulation, "-o", fn, fn+".o"}, linkwith...)...) // "-s",
 	return
 }
 
@@ -0,0 +1,228 @@
+package elf
+
+import (
+	"os"
+	"fmt"
+	"io/ioutil"
+	goelf "debug/elf"
+)
+
+// We can also write a relocatable object file, as as would, which the
+// system linker can then link with object files from gcc or anyone
+// else.  Each field that refers to a symbol gets a relocation, and any
+// symbol we refer to but don't define is left for the linker to find.
+// On the i386 the addend goes in the field itself (in a .rel section),
+// while on the amd64 it goes in the relocation (in a .rela section),
+// which is what each machine's linker expects.
+
+// An Object is everything that goes into an object file.
+
+type Object struct {
+	Arch string // "386" or "amd64"
+	Text, Data []byte
+	Symbols []Symbol
+	Relocs []Reloc
+}
+
+// A Reloc is a field in the text or data that holds the address of
+// Symbol plus Addend, less the address of the field itself if PCRel
+// is set.
+
+type Reloc struct {
+	Section int // TextSection or DataSection
+	Offset uint64
+	Size int // how many bytes the field takes up
+	Symbol string
+	Addend int64
+	PCRel bool
+}
+
+// Write writes the object into a file called fn.
+func (o *Object) Write(fn string) os.Error {
+	b,err := o.Bytes()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fn, b, 0666)
+}
+
+// A section is what we need to know to write out a section and its
+// header.
+
+type section struct {
+	name string
+	typ goelf.SectionType
+	flags goelf.SectionFlag
+	data []byte
+	link, info uint32
+	align, entsize uint64
+}
+
+// relocType returns the ELF relocation type for r.
+func (o *Object) relocType(r Reloc) (uint32, os.Error) {
+	switch {
+	case o.Arch == "amd64" && r.PCRel && r.Size == 4:
+		return uint32(goelf.R_X86_64_PC32), nil
+	case o.Arch == "amd64" && r.Size == 4:
+		// Our addresses are always in the first 2GB, so they fit.
+		return uint32(goelf.R_X86_64_32S), nil
+	case o.Arch == "amd64" && r.Size == 8 && !r.PCRel:
+		return uint32(goelf.R_X86_64_64), nil
+	case o.Arch != "amd64" && r.PCRel && r.Size == 4:
+		return uint32(goelf.R_386_PC32), nil
+	case o.Arch != "amd64" && r.Size == 4:
+		return uint32(goelf.R_386_32), nil
+	}
+	return 0, os.NewError(fmt.Sprintf("I can't relocate a field of %d bytes that refers to %s", r.Size, r.Symbol))
+}
+
+// Bytes returns the contents of the object file.
+func (o *Object) Bytes() ([]byte, os.Error) {
+	is64 := o.Arch == "amd64"
+	// These are the sections, in the order they go in the file.  The
+	// relocations and symbols go in whichever of them we need.
+	const (
+		text = 1 + iota
+		reltext
+		data
+		reldata
+		bss
+		symtab
+		strtab
+		shstrtab
+		note
+		numSections
+	)
+	relname, reltype, relsize, wordAlign := ".rel", goelf.SHT_REL, uint64(8), uint64(4)
+	if is64 {
+		relname, reltype, relsize, wordAlign = ".rela", goelf.SHT_RELA, 24, 8
+	}
+	// We copy the text and data, since on the i386 the addends go into
+	// them.
+	contents := [][]byte{append([]byte{}, o.Text...), append([]byte{}, o.Data...)}
+
+	// The symbols have to be sorted, so that the locals come before
+	// the globals, and those we don't define are global.
+	names := newStrtab()
+	syms := &writer{is64: is64}
+	syms.sym(0, 0, 0, 0, 0) // the null symbol
+	index := make(map[string]int)
+	defined := make(map[string]bool)
+	for _,s := range o.Symbols {
+		defined[s.Name] = true
+	}
+	var undefined []Symbol
+	for _,r := range o.Relocs {
+		if !defined[r.Symbol] {
+			undefined = append(undefined, Symbol{r.Symbol, 0, 0, true})
+			defined[r.Symbol] = true
+		}
+	}
+	numLocals := uint32(1)
+	for _,global := range []bool{false, true} {
+		ss := o.Symbols
+		if global {
+			ss = append(append([]Symbol{}, o.Symbols...), undefined...)
+		}
+		for k,s := range ss {
+			if s.Global != global {
+				continue
+			}
+			bind, shndx := goelf.STB_LOCAL, uint16(goelf.SHN_ABS)
+			if global {
+				bind = goelf.STB_GLOBAL
+			} else {
+				numLocals++
+			}
+			switch {
+			case k >= len(o.Symbols):
+				shndx = uint16(goelf.SHN_UNDEF)
+			case s.Section == TextSection:
+				shndx = text
+			case s.Section == DataSection:
+				shndx = data
+			case s.Section == BssSection:
+				shndx = bss
+			}
+			index[s.Name] = len(index) + 1
+			syms.sym(names.add(s.Name), s.Value, uint8(bind) << 4 | uint8(goelf.STT_NOTYPE), shndx, 0)
+		}
+	}
+
+	rels := []*writer{&writer{is64: is64}, &writer{is64: is64}}
+	for _,r := range o.Relocs {
+		typ,err := o.relocType(r)
+		if err != nil {
+			return nil, err
+		}
+		w := rels[r.Section]
+		w.addr(r.Offset)
+		if is64 {
+			w.addr(uint64(index[r.Symbol]) << 32 | uint64(typ))
+			w.addr(uint64(r.Addend))
+		} else {
+			w.addr(uint64(index[r.Symbol]) << 8 | uint64(typ))
+			field := contents[r.Section][r.Offset:r.Offset+uint64(r.Size)]
+			for i := range field {
+				field[i] = byte(r.Addend >> uint(8*i))
+			}
+		}
+	}
+
+	sections := []section{
+		section{},
+		section{".text", goelf.SHT_PROGBITS, goelf.SHF_ALLOC | goelf.SHF_EXECINSTR,
+			contents[TextSection], 0, 0, 16, 0},
+		section{relname + ".text", reltype, goelf.SHF_INFO_LINK, rels[TextSection].Bytes(),
+			symtab, text, wordAlign, relsize},
+		section{".data", goelf.SHT_PROGBITS, goelf.SHF_ALLOC | goelf.SHF_WRITE,
+			contents[DataSection], 0, 0, 4, 0},
+		section{relname + ".data", reltype, goelf.SHF_INFO_LINK, rels[DataSection].Bytes(),
+			symtab, data, wordAlign, relsize},
+		section{".bss", goelf.SHT_NOBITS, goelf.SHF_ALLOC | goelf.SHF_WRITE, nil, 0, 0, 4, 0},
+		section{".symtab", goelf.SHT_SYMTAB, 0, syms.Bytes(), strtab, numLocals,
+			wordAlign, syms.symSize()},
+		section{".strtab", goelf.SHT_STRTAB, 0, names.Bytes(), 0, 0, 1, 0},
+		section{".shstrtab", goelf.SHT_STRTAB, 0, nil, 0, 0, 1, 0},
+		// This says that we don't need an executable stack.
+		section{".note.GNU-stack", goelf.SHT_PROGBITS, 0, nil, 0, 0, 1, 0},
+	}
+	sectionNames := newStrtab()
+	var shnames []uint32
+	for _,s := range sections {
+		shnames = append(shnames, sectionNames.add(s.name))
+	}
+	sections[shstrtab].data = sectionNames.Bytes()
+
+	// Each section follows the last, after the ELF header, and then
+	// come the section headers.
+	ehsize := uint64(52)
+	if is64 {
+		ehsize = 64
+	}
+	offsets := make([]uint64, len(sections))
+	end := ehsize
+	for i,s := range sections {
+		if s.align > 0 {
+			end = align(end, s.align)
+		}
+		offsets[i] = end
+		end += uint64(len(s.data))
+	}
+	shoff := align(end, 8)
+	w := &writer{is64: is64}
+	w.header(goelf.ET_REL, 0, 0, shoff, numSections, shstrtab)
+	for i,s := range sections {
+		w.pad(offsets[i])
+		w.Write(s.data)
+	}
+	w.pad(shoff)
+	for i,s := range sections {
+		if i == 0 {
+			w.section(0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+			continue
+		}
+		w.section(shnames[i], s.typ, s.flags, 0, offsets[i], uint64(len(s.data)), s.link, s.info, s.align, s.entsize)
+	}
+	return w.Bytes(), nil
+}
@@ -0,0 +1,318 @@
+package elf
+
+import (
+	"os"
+	"io/ioutil"
+	goelf "debug/elf"
+)
+
+// We can read ELF files too, both executables and object files, which
+// lets us look at what we've produced without needing readelf.  Only
+// little-endian files are understood, which covers the i386 and the
+// amd64.
+
+// A File is an ELF file that we have read.
+
+type File struct {
+	Is64 bool
+	Type goelf.Type // such as ET_EXEC or ET_REL
+	Machine goelf.Machine
+	Entry uint64
+	Sections []*Section // including the null section, as in the file
+	Symbols []Sym // including the null symbol, as in the file
+}
+
+// A Section is a section of a File, with its relocations, if it has
+// any.
+
+type Section struct {
+	Name string
+	Type goelf.SectionType
+	Flags goelf.SectionFlag
+	Addr, Offset, Size uint64
+	Link, Info uint32
+	Data []byte // nil for a section such as .bss, which has no data in the file
+	Relocs []Rel
+}
+
+// A Sym is an entry in the symbol table.
+
+type Sym struct {
+	Name string
+	Value, Size uint64
+	Bind goelf.SymBind
+	Type goelf.SymType
+	Section int // the index of its section, or SHN_UNDEF, SHN_ABS and so on
+}
+
+// A Rel is a relocation, saying that the field at Offset in its
+// section must be filled in with the address of Symbols[Sym], in the
+// manner given by Type.  Addend is only meaningful in a RELA section.
+
+type Rel struct {
+	Offset uint64
+	Type uint32 // an R_386 or R_X86_64, depending on the machine
+	Sym int
+	Addend int64
+}
+
+// Open reads the ELF file called fn.
+func Open(fn string) (*File, os.Error) {
+	b,err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	f,err := Read(b)
+	if err != nil {
+		return nil, os.NewError(fn + ": " + err.String())
+	}
+	return f, nil
+}
+
+// A reader reads the fields of an ELF file, which (as for a writer)
+// depend on whether it's an ELF32 or ELF64 file.
+
+type reader struct {
+	b []byte
+	at uint64
+	is64 bool
+	err os.Error
+}
+
+func (r *reader) get(size uint64) (v uint64) {
+	if r.at + size > uint64(len(r.b)) {
+		r.err = os.NewError("the file is too short")
+		return 0
+	}
+	for i:=uint64(0); i<size; i++ {
+		v |= uint64(r.b[r.at+i]) << (8*i)
+	}
+	r.at += size
+	return
+}
+func (r *reader) byte() uint8 {
+	return uint8(r.get(1))
+}
+func (r *reader) half() uint16 {
+	return uint16(r.get(2))
+}
+func (r *reader) word() uint32 {
+	return uint32(r.get(4))
+}
+func (r *reader) addr() uint64 {
+	if r.is64 {
+		return r.get(8)
+	}
+	return r.get(4)
+}
+
+// data returns the size bytes at offset.
+func (r *reader) data(offset, size uint64) []byte {
+	if offset + size > uint64(len(r.b)) || offset + size < offset {
+		r.err = os.NewError("the file is too short")
+		return nil
+	}
+	return r.b[offset:offset+size]
+}
+
+// Read parses the contents of an ELF file.
+func Read(b []byte) (*File, os.Error) {
+	if len(b) < 16 || string(b[:4]) != goelf.ELFMAG {
+		return nil, os.NewError("this isn't an ELF file")
+	}
+	if goelf.Data(b[5]) != goelf.ELFDATA2LSB {
+		return nil, os.NewError("I can only read little-endian ELF files")
+	}
+	f := &File{}
+	switch goelf.Class(b[4]) {
+	case goelf.ELFCLASS32:
+	case goelf.ELFCLASS64:
+		f.Is64 = true
+	default:
+		return nil, os.NewError("this ELF file is neither 32 nor 64 bits")
+	}
+	r := &reader{b: b, at: 16, is64: f.Is64}
+	f.Type = goelf.Type(r.half())
+	f.Machine = goelf.Machine(r.half())
+	r.word() // the version
+	f.Entry = r.addr()
+	r.addr() // where the program headers are, which we don't need
+	shoff := r.addr()
+	r.word() // flags
+	r.half() // the size of the ELF header
+	r.half() // the size of a program header
+	r.half() // how many there are
+	shentsize := uint64(r.half())
+	shnum := int(r.half())
+	shstrndx := int(r.half())
+	var names []uint64 // we can only look these up once we have them all
+	for i:=0; i<shnum; i++ {
+		r.at = shoff + uint64(i)*shentsize
+		s := &Section{}
+		names = append(names, uint64(r.word()))
+		s.Type = goelf.SectionType(r.word())
+		s.Flags = goelf.SectionFlag(r.addr())
+		s.Addr = r.addr()
+		s.Offset = r.addr()
+		s.Size = r.addr()
+		s.Link = r.word()
+		s.Info = r.word()
+		if s.Type != goelf.SHT_NOBITS && s.Type != goelf.SHT_NULL {
+			s.Data = r.data(s.Offset, s.Size)
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		f.Sections = append(f.Sections, s)
+	}
+	if shstrndx >= len(f.Sections) {
+		return nil, os.NewError("there is no section holding the section names")
+	}
+	for i,s := range f.Sections {
+		s.Name = cstring(f.Sections[shstrndx].Data, names[i])
+	}
+	for _,s := range f.Sections {
+		if s.Type == goelf.SHT_SYMTAB && f.Symbols == nil {
+			if err := f.readSymbols(s); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _,s := range f.Sections {
+		if s.Type == goelf.SHT_REL || s.Type == goelf.SHT_RELA {
+			if int(s.Info) >= len(f.Sections) {
+				return nil, os.NewError(s.Name + " relocates a section that doesn't exist")
+			}
+			target := f.Sections[s.Info]
+			target.Relocs = append(target.Relocs, f.readRelocs(s)...)
+		}
+	}
+	return f, nil
+}
+
+func (f *File) readSymbols(s *Section) os.Error {
+	if int(s.Link) >= len(f.Sections) {
+		return os.NewError(s.Name + " has no string table")
+	}
+	names := f.Sections[s.Link].Data
+	r := &reader{b: s.Data, is64: f.Is64}
+	for r.at < uint64(len(s.Data)) {
+		var sym Sym
+		name := uint64(r.word())
+		if f.Is64 {
+			info := r.byte()
+			r.byte() // other
+			sym.Section = int(r.half())
+			sym.Value = r.addr()
+			sym.Size = r.addr()
+			sym.Bind, sym.Type = goelf.SymBind(info >> 4), goelf.SymType(info & 15)
+		} else {
+			sym.Value = r.addr()
+			sym.Size = r.addr()
+			info := r.byte()
+			r.byte() // other
+			sym.Section = int(r.half())
+			sym.Bind, sym.Type = goelf.SymBind(info >> 4), goelf.SymType(info & 15)
+		}
+		if r.err != nil {
+			return os.NewError(s.Name + " is cut short")
+		}
+		sym.Name = cstring(names, name)
+		f.Symbols = append(f.Symbols, sym)
+	}
+	return nil
+}
+
+func (f *File) readRelocs(s *Section) (rels []Rel) {
+	r := &reader{b: s.Data, is64: f.Is64}
+	for r.at < uint64(len(s.Data)) {
+		var rel Rel
+		rel.Offset = r.addr()
+		info := r.addr()
+		if f.Is64 {
+			rel.Type, rel.Sym = uint32(info), int(info >> 32)
+		} else {
+			rel.Type, rel.Sym = uint32(info & 255), int(info >> 8)
+		}
+		if s.Type == goelf.SHT_RELA {
+			rel.Addend = int64(r.addr())
+			if !f.Is64 {
+				rel.Addend = int64(int32(rel.Addend))
+			}
+		}
+		if r.err != nil {
+			break
+		}
+		rels = append(rels, rel)
+	}
+	return
+}
+
+// cstring returns the null-terminated string at offset in b.
+func cstring(b []byte, offset uint64) string {
+	if offset >= uint64(len(b)) {
+		return ""
+	}
+	end := offset
+	for end < uint64(len(b)) && b[end] != 0 {
+		end++
+	}
+	return string(b[offset:end])
+}
+
+// Section returns the section called name, or nil if there isn't one.
+func (f *File) Section(name string) *Section {
+	for _,s := range f.Sections {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// Lookup returns the symbol called name.
+func (f *File) Lookup(name string) (Sym, bool) {
+	for _,s := range f.Symbols {
+		if s.Name == name && name != "" {
+			return s, true
+		}
+	}
+	return Sym{}, false
+}
+
+// RelocType returns the name of a relocation's type, such as
+// R_386_PC32.
+func (f *File) RelocType(t uint32) string {
+	if f.Machine == goelf.EM_X86_64 {
+		return goelf.R_X86_64(t).String()
+	}
+	return goelf.R_386(t).String()
+}
+
+// Letter returns the letter nm would show for s: T for text, D for
+// data, B for bss, A for an absolute value and U for a symbol that is
+// undefined, in lower case if s is local.
+func (f *File) Letter(s Sym) (c int) {
+	switch {
+	case s.Section == int(goelf.SHN_UNDEF):
+		return 'U'
+	case s.Section == int(goelf.SHN_ABS):
+		c = 'A'
+	case s.Section >= len(f.Sections):
+		c = '?'
+	default:
+		sec := f.Sections[s.Section]
+		switch {
+		case sec.Flags & goelf.SHF_EXECINSTR != 0:
+			c = 'T'
+		case sec.Type == goelf.SHT_NOBITS:
+			c = 'B'
+		default:
+			c = 'D'
+		}
+	}
+	if s.Bind == goelf.STB_LOCAL {
+		c += 'a' - 'A'
+	}
+	return
+}
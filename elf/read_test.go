@@ -0,0 +1,132 @@
+package elf
+
+import (
+	"bytes"
+	"testing"
+	goelf "debug/elf"
+)
+
+// Whatever we write, we should read back just as it was.
+func TestReadExecutable(t *testing.T) {
+	for _,arch := range []string{"386", "amd64"} {
+		e := &Executable{
+			Arch: arch,
+			Entry: "_start",
+			Text: []byte{0x90, 0x90, 0xc3},
+			Data: []byte("hello"),
+			Bss: 8,
+			Symbols: []Symbol{
+				{"_start", TextSection, 1, true},
+				{"msg", DataSection, 0, false},
+				{"buffer", BssSection, 4, false},
+				{"size", AbsoluteSection, 5, false},
+			},
+		}
+		b,err := e.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		f,err := Read(b)
+		if err != nil {
+			t.Fatalf("%s: %s", arch, err)
+		}
+		text, data, bss := e.Addresses()
+		if f.Is64 != (arch == "amd64") || f.Type != goelf.ET_EXEC || f.Entry != text + 1 {
+			t.Errorf("%s: read an executable as %v", arch, f)
+		}
+		if s := f.Section(".text"); s == nil || !bytes.Equal(s.Data, e.Text) || s.Addr != text {
+			t.Errorf("%s: the text is %v", arch, s)
+		}
+		if s := f.Section(".data"); s == nil || string(s.Data) != "hello" || s.Addr != data {
+			t.Errorf("%s: the data is %v", arch, s)
+		}
+		if s := f.Section(".bss"); s == nil || s.Data != nil || s.Size != 8 || s.Addr != bss {
+			t.Errorf("%s: the bss is %v", arch, s)
+		}
+		for _,want := range []struct {
+			name string
+			value uint64
+			letter int
+		}{
+			{"_start", text + 1, 'T'},
+			{"msg", data, 'd'},
+			{"buffer", bss + 4, 'b'},
+			{"size", 5, 'a'},
+		} {
+			s,ok := f.Lookup(want.name)
+			if !ok {
+				t.Errorf("%s: there's no %s", arch, want.name)
+			} else if s.Value != want.value || f.Letter(s) != want.letter {
+				t.Errorf("%s: %s is %c %x rather than %c %x", arch, want.name,
+					f.Letter(s), s.Value, want.letter, want.value)
+			}
+		}
+	}
+}
+
+func TestReadObject(t *testing.T) {
+	for _,arch := range []string{"386", "amd64"} {
+		o := &Object{
+			Arch: arch,
+			Text: []byte{0xe8, 0, 0, 0, 0, 0xc3},
+			Data: []byte{0, 0, 0, 0},
+			Symbols: []Symbol{{"main", TextSection, 0, true}},
+			Relocs: []Reloc{
+				{TextSection, 1, 4, "elsewhere", -4, true},
+				{DataSection, 0, 4, "main", 0, false},
+			},
+		}
+		b,err := o.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		f,err := Read(b)
+		if err != nil {
+			t.Fatalf("%s: %s", arch, err)
+		}
+		if f.Type != goelf.ET_REL {
+			t.Errorf("%s: read an object as %v", arch, f.Type)
+		}
+		if s,ok := f.Lookup("main"); !ok || f.Letter(s) != 'T' {
+			t.Errorf("%s: main is %v", arch, s)
+		}
+		if s,ok := f.Lookup("elsewhere"); !ok || f.Letter(s) != 'U' {
+			t.Errorf("%s: elsewhere is %v", arch, s)
+		}
+		pcrel, abs := "R_386_PC32", "R_386_32"
+		if arch == "amd64" {
+			pcrel, abs = "R_X86_64_PC32", "R_X86_64_32S"
+		}
+		for _,want := range []struct {
+			section, reloc, symbol string
+		}{
+			{".text", pcrel, "elsewhere"},
+			{".data", abs, "main"},
+		} {
+			s := f.Section(want.section)
+			if s == nil || len(s.Relocs) != 1 {
+				t.Errorf("%s: %s has relocs %v", arch, want.section, s)
+				continue
+			}
+			r := s.Relocs[0]
+			if f.RelocType(r.Type) != want.reloc || f.Symbols[r.Sym].Name != want.symbol {
+				t.Errorf("%s: %s has a %s for %s rather than a %s for %s", arch, want.section,
+					f.RelocType(r.Type), f.Symbols[r.Sym].Name, want.reloc, want.symbol)
+			}
+		}
+	}
+}
+
+func TestReadGarbage(t *testing.T) {
+	if _,err := Read([]byte("#!/bin/sh\necho hello\n")); err == nil {
+		t.Error("read a shell script as ELF")
+	}
+	b,err := (&Executable{Arch: "386", Entry: "_start",
+		Symbols: []Symbol{{"_start", TextSection, 0, true}}}).Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _,err := Read(b[:len(b)/2]); err == nil {
+		t.Error("read an executable that was cut short")
+	}
+}
@@ -0,0 +1,348 @@
+package elf
+
+import (
+	"os"
+	"bytes"
+	"io/ioutil"
+	goelf "debug/elf"
+)
+
+// We can also write a static executable ourselves, given its machine
+// code, so we don't need ld.  The layout is much the same as ld's: the
+// ELF header and program headers are loaded along with the text, in
+// one read-only segment, followed by a writeable segment holding the
+// data and then the bss (which takes no room in the file).  After
+// them come the symbols and the section headers, which are only there
+// for the benefit of debuggers and objdump.
+
+// These are the sections a symbol can be in.
+const (
+	TextSection = iota
+	DataSection
+	BssSection
+	AbsoluteSection = -1 // for a symbol that is just a number
+)
+
+// An Executable is everything that goes into an executable.
+
+type Executable struct {
+	Arch string // "386" or "amd64"
+	Entry string // the symbol where execution starts
+	Text, Data []byte
+	Bss int // the size of the bss, which starts out as zeros
+	Symbols []Symbol
+}
+
+// A Symbol is a name for an address, as an offset into its section.
+
+type Symbol struct {
+	Name string
+	Section int
+	Value uint64
+	Global bool
+}
+
+const pageSize = 0x1000
+
+func (e *Executable) is64() bool {
+	return e.Arch == "amd64"
+}
+
+// base is the address at which the executable is loaded, which is the
+// same as ld's choice.
+func (e *Executable) base() uint64 {
+	if e.is64() {
+		return 0x400000
+	}
+	return 0x08048000
+}
+
+// headerSize is the size of the ELF header and the program headers.
+func (e *Executable) headerSize() uint64 {
+	if e.is64() {
+		return 64 + 2*56
+	}
+	return 52 + 2*32
+}
+
+// textOffset, dataOffset and so on give where each section goes in
+// the file.
+func (e *Executable) textOffset() uint64 {
+	return e.headerSize()
+}
+func (e *Executable) dataOffset() uint64 {
+	return align(e.textOffset() + uint64(len(e.Text)), 16)
+}
+
+// Addresses returns the addresses at which the text, data and bss
+// will be loaded, which depend only on how big the text and data are.
+// The data starts on a new page, but at the same offset into the page
+// as it is in the file, so that it can be mapped straight from the
+// file.
+func (e *Executable) Addresses() (text, data, bss uint64) {
+	text = e.base() + e.textOffset()
+	data = align(text + uint64(len(e.Text)), pageSize) + e.dataOffset() % pageSize
+	bss = align(data + uint64(len(e.Data)), 16)
+	return
+}
+
+func align(x, n uint64) uint64 {
+	return (x + n - 1) &^ (n - 1)
+}
+
+// Write writes the executable into a file called fn.
+func (e *Executable) Write(fn string) os.Error {
+	b,err := e.Bytes()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fn, b, 0777)
+}
+
+// A writer writes the fields of an ELF file, whose addresses and
+// offsets are twice as big in an ELF64 file as in an ELF32 one.
+
+type writer struct {
+	bytes.Buffer
+	is64 bool
+}
+
+func (w *writer) put(size int, v uint64) {
+	for i:=0; i<size; i++ {
+		w.WriteByte(byte(v >> uint(8*i)))
+	}
+}
+func (w *writer) byte(v uint8) {
+	w.put(1, uint64(v))
+}
+func (w *writer) half(v uint16) {
+	w.put(2, uint64(v))
+}
+func (w *writer) word(v uint32) {
+	w.put(4, uint64(v))
+}
+// addr writes an address, an offset or a size, which are all a word
+// on the machine.
+func (w *writer) addr(v uint64) {
+	if w.is64 {
+		w.put(8, v)
+	} else {
+		w.put(4, v)
+	}
+}
+func (w *writer) pad(to uint64) {
+	for uint64(w.Len()) < to {
+		w.WriteByte(0)
+	}
+}
+
+// A strtab is a string table, in which each name is stored once.
+
+type strtab struct {
+	bytes.Buffer
+	index map[string]uint32
+}
+
+func newStrtab() *strtab {
+	t := &strtab{index: make(map[string]uint32)}
+	t.WriteByte(0) // the empty name
+	t.index[""] = 0
+	return t
+}
+func (t *strtab) add(name string) uint32 {
+	if i,ok := t.index[name]; ok {
+		return i
+	}
+	i := uint32(t.Len())
+	t.WriteString(name)
+	t.WriteByte(0)
+	t.index[name] = i
+	return i
+}
+
+// Bytes returns the contents of the executable.
+func (e *Executable) Bytes() ([]byte, os.Error) {
+	textAddr, dataAddr, bssAddr := e.Addresses()
+	addrs := []uint64{textAddr, dataAddr, bssAddr}
+	entry := uint64(0)
+	found := false
+	for _,s := range e.Symbols {
+		if s.Name == e.Entry && s.Section != AbsoluteSection {
+			entry, found = addrs[s.Section] + s.Value, true
+		}
+	}
+	if !found {
+		return nil, os.NewError("there is no " + e.Entry + " to start at")
+	}
+
+	// The sections come in this order, after the null one.
+	const (
+		text = 1 + iota
+		data
+		bss
+		symtab
+		strtab
+		shstrtab
+		numSections
+	)
+	// The symbols have to be sorted, so that the locals come before
+	// the globals.
+	names := newStrtab()
+	syms := &writer{is64: e.is64()}
+	syms.sym(0, 0, 0, 0, 0) // the null symbol
+	numLocals := uint32(1)
+	for _,global := range []bool{false, true} {
+		for _,s := range e.Symbols {
+			if s.Global != global {
+				continue
+			}
+			bind, shndx, v := goelf.STB_LOCAL, uint16(goelf.SHN_ABS), s.Value
+			if global {
+				bind = goelf.STB_GLOBAL
+			} else {
+				numLocals++
+			}
+			if s.Section != AbsoluteSection {
+				shndx, v = uint16(text + s.Section), addrs[s.Section] + s.Value
+			}
+			syms.sym(names.add(s.Name), v, uint8(bind) << 4 | uint8(goelf.STT_NOTYPE), shndx, 0)
+		}
+	}
+	sectionNames := newStrtab()
+
+	w := &writer{is64: e.is64()}
+	dataEnd := e.dataOffset() + uint64(len(e.Data))
+	symtabOffset := align(dataEnd, 8)
+	strtabOffset := symtabOffset + uint64(syms.Len())
+	shstrtabOffset := strtabOffset + uint64(names.Len())
+	// We need the names of the sections before we know where the
+	// section headers go.
+	shnames := []uint32{0}
+	for _,n := range []string{".text", ".data", ".bss", ".symtab", ".strtab", ".shstrtab"} {
+		shnames = append(shnames, sectionNames.add(n))
+	}
+	shoff := align(shstrtabOffset + uint64(sectionNames.Len()), 8)
+	w.header(goelf.ET_EXEC, entry, 2, shoff, numSections, shstrtab)
+	// Now the program headers move things where we said they'd be.
+	// The ELF header is the start of the first page of text.
+	w.prog(goelf.PF_R | goelf.PF_X, 0, e.base(), e.textOffset() + uint64(len(e.Text)),
+		e.textOffset() + uint64(len(e.Text)))
+	w.prog(goelf.PF_R | goelf.PF_W, e.dataOffset(), dataAddr, uint64(len(e.Data)),
+		bssAddr + uint64(e.Bss) - dataAddr)
+	w.Write(e.Text)
+	w.pad(e.dataOffset())
+	w.Write(e.Data)
+	w.pad(symtabOffset)
+	w.Write(syms.Bytes())
+	w.Write(names.Bytes())
+	w.Write(sectionNames.Bytes())
+	w.pad(shoff)
+	// Last come the section headers.
+	w.section(0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	w.section(shnames[text], goelf.SHT_PROGBITS, goelf.SHF_ALLOC | goelf.SHF_EXECINSTR,
+		textAddr, e.textOffset(), uint64(len(e.Text)), 0, 0, 16, 0)
+	w.section(shnames[data], goelf.SHT_PROGBITS, goelf.SHF_ALLOC | goelf.SHF_WRITE,
+		dataAddr, e.dataOffset(), uint64(len(e.Data)), 0, 0, 4, 0)
+	w.section(shnames[bss], goelf.SHT_NOBITS, goelf.SHF_ALLOC | goelf.SHF_WRITE,
+		bssAddr, align(dataEnd, 16), uint64(e.Bss), 0, 0, 16, 0)
+	w.section(shnames[symtab], goelf.SHT_SYMTAB, 0, 0, symtabOffset, uint64(syms.Len()),
+		strtab, numLocals, 8, w.symSize())
+	w.section(shnames[strtab], goelf.SHT_STRTAB, 0, 0, strtabOffset, uint64(names.Len()),
+		0, 0, 1, 0)
+	w.section(shnames[shstrtab], goelf.SHT_STRTAB, 0, 0, shstrtabOffset,
+		uint64(sectionNames.Len()), 0, 0, 1, 0)
+	return w.Bytes(), nil
+}
+
+// header writes the ELF header, which is followed by phnum program
+// headers.
+func (w *writer) header(typ goelf.Type, entry uint64, phnum uint16, shoff uint64, shnum, shstrndx uint16) {
+	class, machine := goelf.ELFCLASS32, goelf.EM_386
+	ehsize, phentsize, shentsize := 52, 32, 40
+	if w.is64 {
+		class, machine = goelf.ELFCLASS64, goelf.EM_X86_64
+		ehsize, phentsize, shentsize = 64, 56, 64
+	}
+	w.WriteString(goelf.ELFMAG)
+	w.byte(uint8(class))
+	w.byte(uint8(goelf.ELFDATA2LSB))
+	w.byte(uint8(goelf.EV_CURRENT))
+	w.byte(uint8(goelf.ELFOSABI_NONE))
+	w.pad(16)
+	w.half(uint16(typ))
+	w.half(uint16(machine))
+	w.word(uint32(goelf.EV_CURRENT))
+	w.addr(entry)
+	if phnum > 0 {
+		w.addr(uint64(ehsize)) // the program headers follow the ELF header
+	} else {
+		w.addr(0)
+	}
+	w.addr(shoff)
+	w.word(0) // flags
+	w.half(uint16(ehsize))
+	w.half(uint16(phentsize))
+	w.half(phnum)
+	w.half(uint16(shentsize))
+	w.half(shnum)
+	w.half(shstrndx)
+}
+
+// prog writes a program header for a loadable segment.
+func (w *writer) prog(flags goelf.ProgFlag, off, addr, filesz, memsz uint64) {
+	w.word(uint32(goelf.PT_LOAD))
+	if w.is64 {
+		w.word(uint32(flags))
+	}
+	w.addr(off)
+	w.addr(addr)
+	w.addr(addr) // the physical address, which nobody cares about
+	w.addr(filesz)
+	w.addr(memsz)
+	if !w.is64 {
+		w.word(uint32(flags))
+	}
+	w.addr(pageSize)
+}
+
+// section writes a section header.
+func (w *writer) section(name uint32, typ goelf.SectionType, flags goelf.SectionFlag,
+		addr, off, size uint64, link, info uint32, align, entsize uint64) {
+	w.word(name)
+	w.word(uint32(typ))
+	w.addr(uint64(flags))
+	w.addr(addr)
+	w.addr(off)
+	w.addr(size)
+	w.word(link)
+	w.word(info)
+	w.addr(align)
+	w.addr(entsize)
+}
+
+// sym writes a symbol table entry, whose fields come in a different
+// order in an ELF64 file.
+func (w *writer) sym(name uint32, value uint64, info uint8, shndx uint16, size uint64) {
+	w.word(name)
+	if w.is64 {
+		w.byte(info)
+		w.byte(0) // other
+		w.half(shndx)
+		w.addr(value)
+		w.addr(size)
+		return
+	}
+	w.addr(value)
+	w.addr(size)
+	w.byte(info)
+	w.byte(0) // other
+	w.half(shndx)
+}
+
+// symSize is the size of a symbol table entry.
+func (w *writer) symSize() uint64 {
+	if w.is64 {
+		return 24
+	}
+	return 16
+}
@@ -0,0 +1,664 @@
+// Package emu emulates an i386 running Linux, well enough to run the
+// programs that gogo compiles, so that we can run them without an ELF
+// loader, GNU as or ld, and even where the kernel won't run 32-bit
+// code at all.  It runs the machine code that x86.Encode makes, and
+// understands the instructions that Encode knows how to encode, which
+// are all that gogo (and the assembly in its runtime and standard
+// library) uses.  The only way out is int $0x80, for which it does
+// the few system calls our programs make (see syscall.go).  It also
+// counts the instructions it runs, which is a steadier measure of how
+// fast some code is than a stopwatch.
+//
+// Memory is a single slice, holding the text, then the data, then the
+// heap that brk gives out, with what mmap gives out below the stack
+// at the top.  Anything outside it (such as the page at address zero)
+// faults, as does dividing by zero, and a fault is delivered as a
+// signal to whatever handler the program asked for, just as the
+// kernel would, which is how gogo's runtime turns faults into panics.
+package emu
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"github.com/droundy/go/x86"
+)
+
+const (
+	Base = 0x08048000 // the address of the start of memory, as ld would have it
+	MemorySize = 64 << 20
+	StackSize = 1 << 20
+	pageSize = 4096
+)
+
+// These are the registers, in the order of their hardware numbers.
+const (
+	EAX = iota
+	ECX
+	EDX
+	EBX
+	ESP
+	EBP
+	ESI
+	EDI
+)
+
+// A Machine is an i386 running a program.
+
+type Machine struct {
+	Regs [8]uint32
+	EIP uint32
+	cf, zf, sf, of, pf bool
+	Count int64 // how many instructions we've run
+	Stdin io.Reader
+	Stdout, Stderr io.Writer
+
+	mem []byte
+	object *x86.Object
+	text uint32 // the address of the text
+	brk, brkStart uint32 // the end of the heap, and where it started
+	mmapped uint32 // the lowest address that mmap has given out
+	files map[uint32]*os.File // the files the program has opened
+	handlers map[uint32]sigaction
+	exited bool
+	status int
+}
+
+// New loads o into a new Machine, ready to run from _start with the
+// command line argv.
+func New(o *x86.Object, argv []string) (*Machine, os.Error) {
+	m := &Machine{mem: make([]byte, MemorySize), object: o, Stdin: os.Stdin,
+		Stdout: os.Stdout, Stderr: os.Stderr,
+		files: make(map[uint32]*os.File), handlers: make(map[uint32]sigaction)}
+	text := o.Sections[x86.TextSection]
+	data := o.Sections[x86.DataSection]
+	m.text = Base
+	dataAddr := align(m.text + uint32(len(text)), pageSize)
+	m.brkStart = align(dataAddr + uint32(len(data)), pageSize)
+	m.brk = m.brkStart
+	m.mmapped = Base + MemorySize - StackSize
+	if m.brkStart > m.mmapped {
+		return nil, os.NewError("the program won't fit in memory")
+	}
+	addrs := [x86.NumSections]int64{int64(m.text), int64(dataAddr)}
+	err := o.Link(addrs, func(string) (int64, bool) { return 0, false })
+	if err != nil {
+		return nil, err
+	}
+	copy(m.mem[m.text-Base:], text)
+	copy(m.mem[dataAddr-Base:], data)
+	entry,ok := o.Address("_start", addrs)
+	if !ok {
+		return nil, os.NewError("there's no _start")
+	}
+	m.EIP = uint32(entry)
+
+	// The stack starts out as the kernel leaves it: argc, then the
+	// pointers to the arguments, then (empty) environment and
+	// auxiliary vector, with the strings themselves above.
+	sp := uint32(Base + MemorySize)
+	var ptrs []uint32
+	for _,a := range argv {
+		sp -= uint32(len(a) + 1)
+		copy(m.mem[sp-Base:], a)
+		ptrs = append(ptrs, sp)
+	}
+	sp &^= 15
+	words := []uint32{uint32(len(argv))}
+	words = append(words, ptrs...)
+	words = append(words, 0, 0, 0, 0) // no environment, and AT_NULL
+	sp -= uint32(4*len(words))
+	for i,w := range words {
+		m.store32(sp + uint32(4*i), w)
+	}
+	m.Regs[ESP] = sp
+	return m, nil
+}
+
+func align(x, n uint32) uint32 {
+	return (x + n - 1) &^ (n - 1)
+}
+
+// A fault is what happens when an instruction can't be carried out,
+// which becomes a signal, with the code that says why.
+
+type fault struct {
+	signal uint32
+	code uint32
+	addr uint32
+}
+
+// Run runs the program until it exits, and returns its exit status.
+// It fails if the program does something we don't understand, or
+// faults without a handler to deliver the signal to.
+func (m *Machine) Run() (int, os.Error) {
+	for !m.exited {
+		if err := m.run(); err != nil {
+			return 0, err
+		}
+	}
+	return m.status, nil
+}
+
+// run runs instructions until the program exits or faults, since
+// there's only the one recover for all of them.
+func (m *Machine) run() (err os.Error) {
+	defer func() {
+		switch r := recover().(type) {
+		case nil:
+		case *fault:
+			err = m.signal(r)
+		case os.Error:
+			err = os.NewError(fmt.Sprintf("%s at %x%s", r, m.EIP, m.where(m.EIP)))
+		default:
+			panic(r)
+		}
+	}()
+	for !m.exited {
+		m.step()
+	}
+	return nil
+}
+
+// where says which line of assembly the code at addr came from, if
+// it's code.
+func (m *Machine) where(addr uint32) string {
+	if addr < m.text || addr >= m.text + uint32(len(m.object.Sections[x86.TextSection])) {
+		return ""
+	}
+	return " in:" + m.object.Where(x86.TextSection, int(addr - m.text))
+}
+
+// at returns where in m.mem the n bytes at addr are, or faults.
+func (m *Machine) at(addr, n uint32) uint32 {
+	off := addr - Base
+	if addr < Base || uint64(off) + uint64(n) > uint64(len(m.mem)) {
+		panic(&fault{sigsegv, segvMaperr, addr})
+	}
+	return off
+}
+
+func (m *Machine) load8(addr uint32) uint32 {
+	return uint32(m.mem[m.at(addr, 1)])
+}
+
+func (m *Machine) load32(addr uint32) uint32 {
+	b := m.mem[m.at(addr, 4):]
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+func (m *Machine) store8(addr, v uint32) {
+	m.mem[m.at(addr, 1)] = byte(v)
+}
+
+func (m *Machine) store32(addr, v uint32) {
+	b := m.mem[m.at(addr, 4):]
+	b[0], b[1], b[2], b[3] = byte(v), byte(v>>8), byte(v>>16), byte(v>>24)
+}
+
+func (m *Machine) push(v uint32) {
+	m.store32(m.Regs[ESP] - 4, v)
+	m.Regs[ESP] -= 4
+}
+
+func (m *Machine) pop() uint32 {
+	v := m.load32(m.Regs[ESP])
+	m.Regs[ESP] += 4
+	return v
+}
+
+// A loc is where an operand is: either register reg, or memory at
+// addr.
+
+type loc struct {
+	mem bool
+	reg int
+	addr uint32
+}
+
+// get reads size bytes (1 or 4) from l.  The byte registers are the
+// bottom bytes of %eax to %ebx, and then the second bytes of the same.
+func (m *Machine) get(l loc, size int) uint32 {
+	switch {
+	case l.mem && size == 1:
+		return m.load8(l.addr)
+	case l.mem:
+		return m.load32(l.addr)
+	case size == 1 && l.reg >= 4:
+		return m.Regs[l.reg-4] >> 8 & 0xFF
+	case size == 1:
+		return m.Regs[l.reg] & 0xFF
+	}
+	return m.Regs[l.reg]
+}
+
+func (m *Machine) set(l loc, size int, v uint32) {
+	switch {
+	case l.mem && size == 1:
+		m.store8(l.addr, v)
+	case l.mem:
+		m.store32(l.addr, v)
+	case size == 1 && l.reg >= 4:
+		m.Regs[l.reg-4] = m.Regs[l.reg-4] &^ 0xFF00 | (v & 0xFF) << 8
+	case size == 1:
+		m.Regs[l.reg] = m.Regs[l.reg] &^ 0xFF | v & 0xFF
+	default:
+		m.Regs[l.reg] = v
+	}
+}
+
+// A decoder reads the bytes of one instruction.
+
+type decoder struct {
+	m *Machine
+	pc uint32
+}
+
+func (d *decoder) byte() uint32 {
+	v := d.m.load8(d.pc)
+	d.pc++
+	return v
+}
+
+func (d *decoder) word() uint32 {
+	v := d.m.load32(d.pc)
+	d.pc += 4
+	return v
+}
+
+// imm reads an immediate of size bytes, sign-extending a byte.
+func (d *decoder) imm(size int) uint32 {
+	if size == 1 {
+		return uint32(int32(int8(d.byte())))
+	}
+	return d.word()
+}
+
+// modRM reads a ModRM byte (and any SIB byte and displacement),
+// returning its reg field and where its r/m field refers to.
+func (d *decoder) modRM() (int, loc) {
+	b := d.byte()
+	mod, reg, rm := b >> 6, int(b >> 3 & 7), b & 7
+	if mod == 3 {
+		return reg, loc{reg: int(rm)}
+	}
+	var addr uint32
+	switch {
+	case rm == 4:
+		sib := d.byte()
+		scale, index, base := sib >> 6, sib >> 3 & 7, sib & 7
+		if index != 4 {
+			addr = d.m.Regs[index] << scale
+		}
+		if base == 5 && mod == 0 {
+			addr += d.word()
+		} else {
+			addr += d.m.Regs[base]
+		}
+	case rm == 5 && mod == 0:
+		addr = d.word()
+	default:
+		addr = d.m.Regs[rm]
+	}
+	switch mod {
+	case 1:
+		addr += d.imm(1)
+	case 2:
+		addr += d.word()
+	}
+	return reg, loc{mem: true, addr: addr}
+}
+
+// step runs a single instruction.  m.EIP only moves on once the
+// instruction is done, so that a fault leaves it pointing at the
+// instruction that faulted, as the kernel would tell a signal handler.
+func (m *Machine) step() {
+	d := &decoder{m, m.EIP}
+	op := d.byte()
+	rep := op == 0xF3
+	if rep {
+		op = d.byte()
+	}
+	// Most instructions come in a byte version, one less than the
+	// 32-bit one.
+	size := 4
+	if op & 1 == 0 {
+		size = 1
+	}
+	m.Count++
+	switch {
+	case op < 0x40 && op & 7 < 6:
+		// add, or, adc, sbb, and, sub, xor and cmp, which are numbered
+		// by bits 3 to 5.
+		digit := int(op >> 3)
+		switch op & 7 {
+		case 0, 1:
+			reg, rm := d.modRM()
+			m.arith(digit, rm, size, m.get(loc{reg: reg}, size))
+		case 2, 3:
+			reg, rm := d.modRM()
+			m.arith(digit, loc{reg: reg}, size, m.get(rm, size))
+		case 4, 5:
+			m.arith(digit, loc{reg: EAX}, size, d.imm(size))
+		}
+	case op == 0x0F:
+		m.twoByte(d)
+		return
+	case op >= 0x50 && op < 0x58:
+		m.push(m.Regs[op - 0x50])
+	case op >= 0x58 && op < 0x60:
+		m.Regs[op - 0x58] = m.pop()
+	case op == 0x68:
+		m.push(d.word())
+	case op == 0x6A:
+		m.push(d.imm(1))
+	case op == 0x69 || op == 0x6B:
+		reg, rm := d.modRM()
+		immSize := 4
+		if op == 0x6B {
+			immSize = 1
+		}
+		imm := d.imm(immSize)
+		m.Regs[reg] = m.imul(m.get(rm, 4), imm)
+	case op >= 0x70 && op < 0x80:
+		rel := d.imm(1)
+		if m.condition(op - 0x70) {
+			d.pc += rel
+		}
+	case op >= 0x80 && op <= 0x83 && op != 0x82:
+		digit, rm := d.modRM()
+		immSize := 4
+		if op != 0x81 {
+			immSize = 1
+		}
+		m.arith(digit, rm, size, d.imm(immSize))
+	case op >= 0x88 && op <= 0x8B:
+		reg, rm := d.modRM()
+		if op >= 0x8A {
+			m.set(loc{reg: reg}, size, m.get(rm, size))
+		} else {
+			m.set(rm, size, m.get(loc{reg: reg}, size))
+		}
+	case op == 0x8D:
+		reg, rm := d.modRM()
+		if !rm.mem {
+			panic(os.NewError("lea of a register"))
+		}
+		m.Regs[reg] = rm.addr
+	case op == 0x8F:
+		// popl works out its address after it has popped.
+		v := m.pop()
+		_, rm := d.modRM()
+		m.set(rm, 4, v)
+	case op == 0x90:
+		// nop
+	case op == 0x99:
+		m.Regs[EDX] = uint32(int32(m.Regs[EAX]) >> 31)
+	case op >= 0xA0 && op <= 0xA3:
+		addr := d.word()
+		if op >= 0xA2 {
+			m.set(loc{mem: true, addr: addr}, size, m.get(loc{reg: EAX}, size))
+		} else {
+			m.set(loc{reg: EAX}, size, m.get(loc{mem: true, addr: addr}, size))
+		}
+	case op == 0xA4:
+		n := uint32(1)
+		if rep {
+			n, m.Regs[ECX] = m.Regs[ECX], 0
+		}
+		for ; n > 0; n-- {
+			m.store8(m.Regs[EDI], m.load8(m.Regs[ESI]))
+			m.Regs[ESI]++
+			m.Regs[EDI]++
+		}
+	case op >= 0xB0 && op < 0xB8:
+		m.set(loc{reg: int(op - 0xB0)}, 1, d.byte())
+	case op >= 0xB8 && op < 0xC0:
+		m.Regs[op - 0xB8] = d.word()
+	case op == 0xC0 || op == 0xC1:
+		digit, rm := d.modRM()
+		m.shift(digit, rm, size, d.byte())
+	case op == 0xC3:
+		d.pc = m.pop()
+	case op == 0xC6 || op == 0xC7:
+		_, rm := d.modRM()
+		m.set(rm, size, d.imm(size))
+	case op == 0xCD:
+		if n := d.byte(); n != 0x80 {
+			panic(os.NewError(fmt.Sprint("int $", n)))
+		}
+		m.EIP = d.pc // for rt_sigreturn, which sets it again
+		m.syscall()
+		if m.EIP != d.pc {
+			return
+		}
+	case op >= 0xD0 && op <= 0xD3:
+		digit, rm := d.modRM()
+		count := uint32(1)
+		if op >= 0xD2 {
+			count = m.Regs[ECX] & 0xFF
+		}
+		m.shift(digit, rm, size, count)
+	case op == 0xE8:
+		rel := d.word()
+		m.push(d.pc)
+		d.pc += rel
+	case op == 0xE9:
+		d.pc += d.word()
+	case op == 0xEB:
+		d.pc += d.imm(1)
+	case op == 0xF6 || op == 0xF7:
+		digit, rm := d.modRM()
+		m.unary(digit, rm, size)
+	case op == 0xFC:
+		// cld: we only ever go forwards anyway
+	case op == 0xFF:
+		digit, rm := d.modRM()
+		v := m.get(rm, 4)
+		switch digit {
+		case 2:
+			m.push(d.pc)
+			d.pc = v
+		case 4:
+			d.pc = v
+		case 6:
+			m.push(v)
+		default:
+			m.unknown(op)
+		}
+	default:
+		m.unknown(op)
+	}
+	m.EIP = d.pc
+}
+
+// twoByte runs an instruction whose opcode starts with 0x0F.
+func (m *Machine) twoByte(d *decoder) {
+	op := d.byte()
+	switch {
+	case op >= 0x80 && op < 0x90:
+		rel := d.word()
+		if m.condition(op - 0x80) {
+			d.pc += rel
+		}
+	case op == 0xAF:
+		reg, rm := d.modRM()
+		m.Regs[reg] = m.imul(m.Regs[reg], m.get(rm, 4))
+	case op == 0xB6:
+		reg, rm := d.modRM()
+		m.Regs[reg] = m.get(rm, 1)
+	default:
+		m.unknown(0x0F00 | op)
+	}
+	m.EIP = d.pc
+}
+
+func (m *Machine) unknown(op uint32) {
+	panic(os.NewError(fmt.Sprintf("unknown instruction %x", op)))
+}
+
+// condition tells whether condition code cc (as in the jcc
+// instructions) holds.
+func (m *Machine) condition(cc uint32) bool {
+	var c bool
+	switch cc >> 1 {
+	case 0:
+		c = m.of
+	case 1:
+		c = m.cf
+	case 2:
+		c = m.zf
+	case 3:
+		c = m.cf || m.zf
+	case 4:
+		c = m.sf
+	case 5:
+		c = m.pf
+	case 6:
+		c = m.sf != m.of
+	case 7:
+		c = m.zf || m.sf != m.of
+	}
+	// The odd ones are the opposite of the even ones before them.
+	return c != (cc & 1 == 1)
+}
+
+// result sets the zero, sign and parity flags for r, a result of size
+// bytes, and returns it cut down to that size.
+func (m *Machine) result(r uint32, size int) uint32 {
+	if size == 1 {
+		r &= 0xFF
+	}
+	m.zf = r == 0
+	m.sf = r >> uint(8*size - 1) & 1 == 1
+	p := r & 0xFF
+	p ^= p >> 4
+	p ^= p >> 2
+	p ^= p >> 1
+	m.pf = p & 1 == 0
+	return r
+}
+
+// arith does arithmetic instruction number digit, on dst and src.
+func (m *Machine) arith(digit int, dst loc, size int, src uint32) {
+	a := m.get(dst, size)
+	b := src
+	bits := uint(8*size)
+	mask := uint64(1) << bits - 1
+	sign := uint32(1) << (bits - 1)
+	carry := uint64(0)
+	if m.cf {
+		carry = 1
+	}
+	var r uint32
+	switch digit {
+	case 0, 2: // add, adc
+		if digit == 0 {
+			carry = 0
+		}
+		sum := uint64(a) & mask + uint64(b) & mask + carry
+		r = uint32(sum)
+		m.cf = sum > mask
+		m.of = (a ^ r) & (b ^ r) & sign != 0
+	case 3, 5, 7: // sbb, sub, cmp
+		if digit != 3 {
+			carry = 0
+		}
+		r = a - b - uint32(carry)
+		m.cf = uint64(a) & mask < uint64(b) & mask + carry
+		m.of = (a ^ b) & (a ^ r) & sign != 0
+	case 1:
+		r = a | b
+		m.cf, m.of = false, false
+	case 4:
+		r = a & b
+		m.cf, m.of = false, false
+	case 6:
+		r = a ^ b
+		m.cf, m.of = false, false
+	}
+	r = m.result(r, size)
+	if digit != 7 {
+		m.set(dst, size, r)
+	}
+}
+
+func (m *Machine) imul(a, b uint32) uint32 {
+	r := int64(int32(a)) * int64(int32(b))
+	m.cf = r != int64(int32(r))
+	m.of = m.cf
+	return uint32(r)
+}
+
+// shift does shift number digit of dst by count.
+func (m *Machine) shift(digit int, dst loc, size int, count uint32) {
+	count &= 31
+	if count == 0 {
+		return
+	}
+	v := m.get(dst, size)
+	bits := uint32(8*size)
+	var r uint32
+	switch digit {
+	case 4: // shl
+		r = v << count
+		m.cf = count <= bits && v >> (bits - count) & 1 == 1
+		m.of = (r >> (bits - 1) & 1 == 1) != m.cf
+	case 5: // shr
+		r = v >> count
+		m.cf = v >> (count - 1) & 1 == 1
+		m.of = v >> (bits - 1) & 1 == 1
+	case 7: // sar
+		s := int32(v)
+		if size == 1 {
+			s = int32(int8(v))
+		}
+		r = uint32(s >> count)
+		m.cf = s >> (count - 1) & 1 == 1
+		m.of = false
+	default:
+		m.unknown(0xC1)
+	}
+	m.set(dst, size, m.result(r, size))
+}
+
+// unary does instruction number digit of the 0xF7 group (not, neg,
+// mul and the divides) on dst.
+func (m *Machine) unary(digit int, dst loc, size int) {
+	v := m.get(dst, size)
+	if size == 1 && digit > 3 {
+		m.unknown(0xF6)
+	}
+	switch digit {
+	case 2: // not
+		m.set(dst, size, ^v)
+	case 3: // neg
+		m.cf = v != 0
+		r := m.result(-v, size)
+		m.of = r == uint32(1) << uint(8*size - 1)
+		m.set(dst, size, r)
+	case 4: // mul
+		r := uint64(m.Regs[EAX]) * uint64(v)
+		m.Regs[EAX], m.Regs[EDX] = uint32(r), uint32(r >> 32)
+		m.cf = m.Regs[EDX] != 0
+		m.of = m.cf
+	case 6: // div
+		n := uint64(m.Regs[EDX]) << 32 | uint64(m.Regs[EAX])
+		if v == 0 || n / uint64(v) > 0xFFFFFFFF {
+			panic(&fault{sigfpe, fpeIntdiv, m.EIP})
+		}
+		m.Regs[EAX], m.Regs[EDX] = uint32(n / uint64(v)), uint32(n % uint64(v))
+	case 7: // idiv
+		n := int64(uint64(m.Regs[EDX]) << 32 | uint64(m.Regs[EAX]))
+		q := int64(0)
+		if v != 0 {
+			q = n / int64(int32(v))
+		}
+		if v == 0 || q != int64(int32(q)) {
+			panic(&fault{sigfpe, fpeIntdiv, m.EIP})
+		}
+		m.Regs[EAX], m.Regs[EDX] = uint32(q), uint32(n % int64(int32(v)))
+	default:
+		m.unknown(0xF7)
+	}
+}
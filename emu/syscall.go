@@ -0,0 +1,254 @@
+package emu
+
+import (
+	"os"
+)
+
+// These are the Linux system calls we do, by their i386 numbers.  The
+// number is in %eax, the arguments are in %ebx, %ecx, %edx, %esi, %edi
+// and %ebp, and the result (or minus an errno) goes back in %eax.
+const (
+	sysExit = 1
+	sysRead = 3
+	sysWrite = 4
+	sysOpen = 5
+	sysClose = 6
+	sysBrk = 45
+	sysMmap = 90
+	sysMunmap = 91
+	sysRtSigreturn = 173
+	sysRtSigaction = 174
+	sysRtSigprocmask = 175
+	sysMmap2 = 192
+	sysExitGroup = 252
+)
+
+const (
+	enoent = 2
+	eio = 5
+	ebadf = 9
+	enomem = 12
+	efault = 14
+	enosys = 38
+)
+
+const (
+	sigfpe = 8
+	sigsegv = 11
+)
+
+// These are the si_code a signal handler is told, which say why the
+// signal happened.
+const (
+	fpeIntdiv = 1 // an integer divide by zero
+	segvMaperr = 1 // an address that isn't mapped
+)
+
+// A sigaction is what the program asked to happen on a signal.
+
+type sigaction struct {
+	handler, flags, restorer uint32
+}
+
+func (m *Machine) syscall() {
+	r := &m.Regs
+	// A bad pointer makes the call fail, rather than faulting.
+	defer func() {
+		if f := recover(); f != nil {
+			if _,ok := f.(*fault); !ok {
+				panic(f)
+			}
+			r[EAX] = errno(efault)
+		}
+	}()
+	result := uint32(0)
+	switch r[EAX] {
+	case sysExit, sysExitGroup:
+		m.exited = true
+		m.status = int(r[EBX] & 0xFF)
+	case sysRead:
+		var n int
+		var err os.Error
+		buf := m.bytes(r[ECX], r[EDX])
+		if f,ok := m.files[r[EBX]]; ok {
+			n,err = f.Read(buf)
+		} else if r[EBX] == 0 {
+			n,err = m.Stdin.Read(buf)
+		} else {
+			result = errno(ebadf)
+			break
+		}
+		result = uint32(n)
+		if n == 0 && err != nil && err != os.EOF {
+			result = errno(eio)
+		}
+	case sysWrite:
+		var n int
+		var err os.Error
+		buf := m.bytes(r[ECX], r[EDX])
+		if f,ok := m.files[r[EBX]]; ok {
+			n,err = f.Write(buf)
+		} else if r[EBX] == 1 {
+			n,err = m.Stdout.Write(buf)
+		} else if r[EBX] == 2 {
+			n,err = m.Stderr.Write(buf)
+		} else {
+			result = errno(ebadf)
+			break
+		}
+		result = uint32(n)
+		if n == 0 && err != nil {
+			result = errno(eio)
+		}
+	case sysOpen:
+		f,err := os.Open(m.cstring(r[EBX]), int(r[ECX]), r[EDX])
+		if err != nil {
+			result = errno(enoent)
+			break
+		}
+		// The lowest free descriptor, as the kernel would give us.
+		fd := uint32(3)
+		for m.files[fd] != nil {
+			fd++
+		}
+		m.files[fd] = f
+		result = fd
+	case sysClose:
+		f,ok := m.files[r[EBX]]
+		if !ok {
+			result = errno(ebadf)
+			break
+		}
+		m.files[r[EBX]] = nil, false
+		if f.Close() != nil {
+			result = errno(eio)
+		}
+	case sysBrk:
+		// Like the kernel, we say where the heap ends even if we
+		// can't move it.
+		if r[EBX] >= m.brkStart && r[EBX] <= m.mmapped {
+			m.brk = r[EBX]
+		}
+		result = m.brk
+	case sysMmap, sysMmap2:
+		length := r[ECX]
+		if r[EAX] == sysMmap {
+			// The old mmap has its arguments in memory.
+			length = m.load32(r[EBX] + 4)
+		}
+		length = align(length, pageSize)
+		if length == 0 || m.mmapped - m.brk < length {
+			result = errno(enomem)
+			break
+		}
+		m.mmapped -= length
+		// It's all ours, so we only have to make sure it's zero.
+		b := m.bytes(m.mmapped, length)
+		for i := range b {
+			b[i] = 0
+		}
+		result = m.mmapped
+	case sysMunmap:
+		// We never give memory back.
+	case sysRtSigaction:
+		if old := r[EDX]; old != 0 {
+			h := m.handlers[r[EBX]]
+			m.store32(old, h.handler)
+			m.store32(old + 4, h.flags)
+			m.store32(old + 8, h.restorer)
+		}
+		if act := r[ECX]; act != 0 {
+			m.handlers[r[EBX]] = sigaction{m.load32(act), m.load32(act + 4), m.load32(act + 8)}
+		}
+	case sysRtSigreturn:
+		m.sigreturn()
+		return
+	case sysRtSigprocmask:
+		// We never block anything.
+	default:
+		result = errno(enosys)
+	}
+	r[EAX] = result
+}
+
+// errno returns what a system call returns to say it failed with e.
+func errno(e int) uint32 {
+	return uint32(-e)
+}
+
+// bytes returns the n bytes of memory at addr, which needn't be
+// anywhere if there aren't any.
+func (m *Machine) bytes(addr, n uint32) []byte {
+	if n == 0 {
+		return nil
+	}
+	off := m.at(addr, n)
+	return m.mem[off:off+n]
+}
+
+// cstring returns the nul-terminated string at addr.
+func (m *Machine) cstring(addr uint32) string {
+	end := addr
+	for m.load8(end) != 0 {
+		end++
+	}
+	return string(m.bytes(addr, end - addr))
+}
+
+// Where a signal handler finds things in the frame the kernel gives it.
+const (
+	frameSize = 4*4 + 128 + 20 + 88 // return address and arguments, siginfo, and ucontext
+	sigcontext = 20 // in the ucontext
+	savedRegs = sigcontext + 16 // %edi, %esi, %ebp, %esp, %ebx, %edx, %ecx, %eax
+	savedTrapno = sigcontext + 48
+	savedEIP = sigcontext + 56
+)
+
+// savedOrder is the order the registers are saved in a sigcontext.
+var savedOrder = []int{EDI, ESI, EBP, ESP, EBX, EDX, ECX, EAX}
+
+// signal delivers a fault to the program's handler, as the kernel
+// would, with a frame on the stack holding its siginfo and the context
+// it happened in, and returns to its restorer.  If there isn't a
+// handler, the program is dead.
+func (m *Machine) signal(f *fault) os.Error {
+	h,ok := m.handlers[f.signal]
+	if !ok || h.handler == 0 {
+		name := "SIGSEGV"
+		if f.signal == sigfpe {
+			name = "SIGFPE"
+		}
+		return os.NewError(name + m.where(m.EIP))
+	}
+	sp := (m.Regs[ESP] - frameSize) &^ 15
+	info := sp + 16
+	uc := info + 128
+	for i := uint32(0); i < frameSize - 16; i += 4 {
+		m.store32(info + i, 0)
+	}
+	m.store32(info, f.signal)
+	m.store32(info + 8, f.code)
+	m.store32(info + 12, f.addr)
+	for i,reg := range savedOrder {
+		m.store32(uc + savedRegs + uint32(4*i), m.Regs[reg])
+	}
+	m.store32(uc + savedTrapno, f.signal)
+	m.store32(uc + savedEIP, m.EIP)
+	m.store32(sp, h.restorer)
+	m.store32(sp + 4, f.signal)
+	m.store32(sp + 8, info)
+	m.store32(sp + 12, uc)
+	m.Regs[ESP] = sp
+	m.EIP = h.handler
+	return nil
+}
+
+// sigreturn puts back the context that a signal interrupted, once its
+// handler has returned to the restorer.
+func (m *Machine) sigreturn() {
+	uc := m.load32(m.Regs[ESP] + 8)
+	for i,reg := range savedOrder {
+		m.Regs[reg] = m.load32(uc + savedRegs + uint32(4*i))
+	}
+	m.EIP = m.load32(uc + savedEIP)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"strings"
+	"github.com/droundy/go/elf"
+	"github.com/droundy/go/x86"
+	"github.com/droundy/goopt"
+)
+
+var native = goopt.Flag([]string{"--native"}, []string{"--binutils"},
+	"encode and link the program ourselves", "use GNU as and ld to assemble and link")
+var compileOnly = goopt.Flag([]string{"-c", "--compile-only"}, []string{},
+	"write an object file for the linker, rather than an executable", "")
+var checkEncoding = goopt.Flag([]string{"--check-encoding"}, []string{},
+	"check that our own encoder agrees with the assembler", "")
+
+// CheckEncoding compares the machine code that GNU as made of code,
+// which has been linked into the executable exe, with what x86.Encode
+// makes of it, and complains about the first instruction on which
+// they disagree.  Since everything we link in goes after our own code,
+// ours comes first in each section.
+func CheckEncoding(exe string, code []x86.X86) os.Error {
+	o,err := x86.Encode(code)
+	if err != nil {
+		return err
+	}
+	f,err := elf.Open(exe)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]int64)
+	for _,s := range f.Symbols {
+		names[s.Name] = int64(s.Value)
+	}
+	var addrs [x86.NumSections]int64
+	var theirs [x86.NumSections][]byte
+	for i,name := range []string{".text", ".data"} {
+		if len(o.Sections[i]) == 0 {
+			continue
+		}
+		s := f.Section(name)
+		if s == nil {
+			return os.NewError(exe + " has no " + name + " section")
+		}
+		addrs[i], theirs[i] = int64(s.Addr), s.Data
+	}
+	lookup := func(name string) (int64, bool) {
+		v,ok := names[name]
+		return v, ok
+	}
+	if err := o.Link(addrs, lookup); err != nil {
+		return err
+	}
+	for i,ours := range o.Sections {
+		if len(ours) > len(theirs[i]) {
+			return os.NewError(fmt.Sprintf("our section %d is %d bytes, but the assembler's is only %d",
+				i, len(ours), len(theirs[i])))
+		}
+		for j := range ours {
+			if ours[j] != theirs[i][j] {
+				return os.NewError(fmt.Sprintf("the encoding differs at %x (%x rather than %x) in: %s",
+					addrs[i] + int64(j), ours[j], theirs[i][j], o.Where(i, j)))
+			}
+		}
+	}
+	return nil
+}
+
+// EncodeAndLink turns code into the executable exe all by itself, so
+// there's no need for binutils.  Since we don't read object files,
+// there's nothing else we can link with.
+func EncodeAndLink(exe string, code []x86.X86, linkwith []string) os.Error {
+	if len(linkwith) > 0 {
+		return os.NewError("I can't link with " + linkwith[0] + " without ld")
+	}
+	o,err := x86.Encode(code)
+	if err != nil {
+		return err
+	}
+	e := &elf.Executable{Arch: *arch, Entry: "_start",
+		Text: o.Sections[x86.TextSection], Data: o.Sections[x86.DataSection]}
+	text, data, _ := e.Addresses()
+	err = o.Link([x86.NumSections]int64{int64(text), int64(data)},
+		func(string) (int64, bool) { return 0, false })
+	if err != nil {
+		return err
+	}
+	e.Symbols = symbols(o, nil)
+	return e.Write(exe)
+}
+
+// EncodeObject turns code into the object file fn, which the system
+// linker can link with other object files.
+func EncodeObject(fn string, code []x86.X86) os.Error {
+	o,err := x86.Encode(code)
+	if err != nil {
+		return err
+	}
+	obj := &elf.Object{Arch: *arch,
+		Text: o.Sections[x86.TextSection], Data: o.Sections[x86.DataSection]}
+	needed := make(map[string]bool)
+	for _,r := range o.Relocs {
+		obj.Relocs = append(obj.Relocs, elf.Reloc{r.Section, uint64(r.Offset), r.Size,
+			r.Symbol, r.Addend, r.PCRel})
+		needed[r.Symbol] = true
+	}
+	obj.Symbols = symbols(o, needed)
+	return obj.Write(fn)
+}
+
+// symbols returns the symbols of o that belong in an ELF file, which
+// leaves out the local labels (which the assembler wouldn't keep)
+// unless they are needed.
+func symbols(o *x86.Object, needed map[string]bool) (out []elf.Symbol) {
+	for _,name := range o.SymbolOrder {
+		s := o.Symbols[name]
+		if strings.HasPrefix(name, ".L") && !needed[name] {
+			continue
+		}
+		out = append(out, elf.Symbol{name, s.Section, uint64(s.Value), s.Global})
+	}
+	return
+}
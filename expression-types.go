@@ -1,65 +1,39 @@
 package main
 
 import (
-	"fmt"
 	"go/ast"
-	"go/token"
+	"github.com/droundy/go/types"
 )
 
-func ExprType(e0 ast.Expr, s *Stack) (t *ast.Type) {
-	t = ast.NewType(ast.Basic)
-	switch e := e0.(type) {
-	case *ast.BasicLit:
-		switch e.Kind {
-		case token.STRING:
-			t.N = ast.String
-		default:
-			panic(fmt.Sprintf("I don't handle basic literals such as %s", e))
-		}
-	case *ast.CallExpr:
-		switch fn := e.Fun.(type) {
-		case *ast.Ident:
-			switch fn.Name {
-			case "println":
-				return ast.NewType(ast.Tuple)
-			case "print":
-				return ast.NewType(ast.Tuple)
-			default:
-				ftype := s.Lookup(fn.Name).Type()
-				switch ftype.N {
-				case 0:
-					// This is a function with no return value: easy!
-					return ast.NewType(ast.Tuple)
-				case 1:
-					return ftype.Params.Objects[0].Type
-				default:
-					panic("I don't yet do multiple return types...")
-				}
-			}
-		default:
-			panic(fmt.Sprintf("Can't handle function of weird type %T", e.Fun))
-		}
-	case *ast.Ident:
-		return s.Lookup(e.Name).Type()
-	default:
-		panic(fmt.Sprintf("I can't find type of expression %s of type %T\n", e0, e0))
+// ExprType returns the type of e, as worked out by the type checker.
+// An untyped constant gets its default type, which is what we'll
+// generate code for.
+func ExprType(e ast.Expr) types.Type {
+	tv,ok := Info.Types[e]
+	if !ok {
+		Unsupported(e, "I can't find type of expression of type %T", e)
 	}
-	return
+	return types.Default(tv.Type)
 }
 
-func TypeExpression(e ast.Expr) (t *ast.Type) {
-	switch e := e.(type) {
+// Callee returns the function (or builtin) that e calls, or nil if
+// it's something more complicated than a named function.
+func Callee(e *ast.CallExpr) types.Object {
+	switch fn := e.Fun.(type) {
 	case *ast.Ident:
-		switch e.Name {
-		case "string":
-			t = ast.NewType(ast.Basic)
-			t.N = ast.String
-			return
-		default:
-			panic("I don't understand type "+e.Name)
-		}
-	default:
-		panic(fmt.Sprintf("I can't understand type expression %s of type %T\n", e, e))
+		return Info.Uses[fn]
+	case *ast.SelectorExpr:
+		// This is either a function from an imported package or a
+		// method.
+		return Info.Uses[fn.Sel]
+	}
+	return nil
+}
+
+func TypeExpression(e ast.Expr) types.Type {
+	tv,ok := Info.Types[e]
+	if !ok || !tv.IsType {
+		Invalid(e, "I can't understand type expression of type %T", e)
 	}
-	panic(fmt.Sprintf("I don't understand the type expression %s", e))
+	return tv.Type
 }
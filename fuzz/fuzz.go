@@ -0,0 +1,109 @@
+// The fuzz command compiles random programs (written by
+// harness.Generate) with gogo, for both 386 and amd64, and in each of
+// the ways that gogo can compile them, and complains about any that
+// gogo can't compile, or that don't print what they should (when run
+// for real or, with --emulate, by gogo run), or, with --interp, that
+// do something different when interpreted.  Each program that fails
+// is kept, as fuzz-SEED-ARCH.go, which is ready to go in the tests
+// directory once you've found what's wrong.
+package main
+
+import (
+	"os"
+	"fmt"
+	"path"
+	"time"
+	"io/ioutil"
+	"github.com/droundy/goopt"
+	"github.com/droundy/go/harness"
+)
+
+var count = goopt.Int([]string{"-n", "--count"}, 100, "how many programs to try")
+var seed = goopt.Int([]string{"--seed"}, 0, "the seed of the first program (by default, one from the clock)")
+var gogo = goopt.String([]string{"--gogo"}, "../go", "the gogo to test")
+var jobs = goopt.Int([]string{"-j", "--jobs"}, 4, "how many programs to test at once")
+var compare = goopt.Flag([]string{"--compare"}, []string{},
+	"also compare the programs with what the go toolchain makes of them", "")
+var emulate = goopt.Flag([]string{"--emulate"}, []string{},
+	"also run the 386 programs in gogo's emulator", "")
+var interpret = goopt.Flag([]string{"--interp"}, []string{},
+	"also check that each program does the same when interpreted", "")
+var golib = goopt.String([]string{"--golib"}, "../harness/golib", "gogo's library, for the go toolchain")
+var workdir = goopt.String([]string{"--workdir"}, ".fuzzdir", "where to put the programs while testing them")
+
+func die(err os.Error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func main() {
+	goopt.Parse(func() []string { return nil })
+	first := int64(*seed)
+	if first == 0 {
+		first = time.Nanoseconds() / 1000 % 1000000000
+	}
+	src := path.Join(*workdir, "src")
+	os.RemoveAll(src)
+	die(os.MkdirAll(src, 0777))
+	var tests []*harness.Test
+	for n:=0; n<*count; n++ {
+		p := harness.Generate(first + int64(n))
+		for _,arch := range []string{"386", "amd64"} {
+			name := fmt.Sprint("fuzz-", p.Seed, "-", arch, ".go")
+			die(ioutil.WriteFile(path.Join(src, name), []byte(p.TestSource(arch)), 0666))
+			t,err := harness.Parse(src, name)
+			die(err)
+			tests = append(tests, t)
+		}
+	}
+	fmt.Printf("Trying seeds %d to %d\n", first, first + int64(*count) - 1)
+	errs := harness.RunAll(tests, *gogo, path.Join(*workdir, "run"), *jobs)
+	// We only keep the first thing that went wrong with each.
+	note := func(i int, err os.Error) {
+		if err != nil && errs[i] == nil {
+			errs[i] = err
+		}
+	}
+	if *interpret {
+		for i,err := range harness.InterpretAll(tests, *gogo, path.Join(*workdir, "interp"), *jobs) {
+			note(i, err)
+		}
+	}
+	if *compare {
+		gotool := harness.GoTool()
+		if gotool == "" {
+			die(os.NewError("there's no go toolchain to compare with"))
+		}
+		for i,err := range harness.CompareAll(tests, *gogo, gotool, *golib, path.Join(*workdir, "gc"), *jobs) {
+			note(i, err)
+		}
+	}
+	if *emulate {
+		// Only the 386 programs, since that's all we can emulate.
+		var ours []*harness.Test
+		for i:=0; i<len(tests); i+=2 {
+			ours = append(ours, tests[i])
+		}
+		for i,err := range harness.EmulateAll(ours, *gogo, path.Join(*workdir, "emu"), *jobs) {
+			note(2*i, err)
+		}
+	}
+	failed := 0
+	for i,err := range errs {
+		if err == nil {
+			continue
+		}
+		failed++
+		t := tests[i]
+		fmt.Printf("%s: %s\n", t.Name, err)
+		code,err := ioutil.ReadFile(path.Join(src, t.Name))
+		die(err)
+		die(ioutil.WriteFile(t.Name, code, 0666))
+	}
+	fmt.Printf("%d of %d programs failed\n", failed, len(tests))
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
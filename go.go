@@ -4,335 +4,619 @@ import (
 	"os"
 	"fmt"
 	"strings"
-	"strconv"
-	"unicode"
+	"io/ioutil"
 	"go/ast"
 	"go/token"
 	"go/parser"
-	"go/typechecker"
 	"github.com/droundy/go/elf"
+	"github.com/droundy/go/ir"
+	"github.com/droundy/go/types"
 	"github.com/droundy/go/x86"
 	"github.com/droundy/goopt"
 )
 
 var myfiles = token.NewFileSet()
 
-type StringVisitor CompileVisitor
-func (v StringVisitor) Visit(n0 ast.Node) (w ast.Visitor) {
-	//fmt.Printf("in StringVisitor, n0 is %s of type %T\n", n0, n0)
-	if n,ok := n0.(*ast.BasicLit); ok && n != nil && n.Kind == token.STRING {
-		str,err := strconv.Unquote(string(n.Value))
-		if err != nil {
-			panic(err)
-		}
-		sanitize := func(rune int) int {
-			if unicode.IsLetter(rune) {
-				return rune
+var dumpir = goopt.Flag([]string{"--dump-ir"}, []string{},
+	"print the intermediate representation instead of compiling", "")
+var inlineBudget = goopt.Int([]string{"--inline-budget"}, 20,
+	"inline functions of up to this many instructions (0 inlines nothing)")
+var libc = goopt.Flag([]string{"--libc"}, []string{},
+	"link dynamically against the C library", "")
+var verbose = goopt.Flag([]string{"-v", "--verbose"}, []string{},
+	"say what the optimizer is up to", "")
+
+// A CompileVisitor turns the syntax tree into ir, one function at a
+// time.
+
+type CompileVisitor struct {
+	Program *ir.Program
+	Func *ir.Func
+	Block *ir.Block // where the next instruction goes, or nil if we've just returned
+	Slots map[types.Object]*ir.Slot // the parameters and results of Func
+	Globals map[types.Object]*ir.Slot // the package-level variables
+	Inits []*ir.Func // the init function of each package that has one, in order
+}
+func NewCompileVisitor() *CompileVisitor {
+	return &CompileVisitor{Program: ir.NewProgram(), Globals: make(map[types.Object]*ir.Slot)}
+}
+func (v *CompileVisitor) Emit(i ir.Instr) {
+	v.Block.Add(i)
+}
+
+func (v *CompileVisitor) Visit(n0 ast.Node) (w ast.Visitor) {
+	switch n := n0.(type) {
+	case *ast.FuncDecl:
+		if n.Recv != nil {
+			AddError(UnsupportedFeature, n, "I don't handle methods such as %s", n.Name.Name)
+			return nil
+		}
+		v.CompileFunction(n)
+		return nil // No need to peek inside the func declaration!
+	case *ast.GenDecl:
+		if n.Tok == token.CONST || n.Tok == token.VAR {
+			// The type checker has worked out all our constants, and
+			// DefineGlobals has seen to the variables.
+			return nil
+		}
+		if n.Tok != token.IMPORT {
+			AddError(UnsupportedFeature, n, "I don't handle %s declarations", n.Tok)
+			return nil
+		}
+	}
+	return v
+}
+
+// DefineGlobals gives each package-level variable of p a slot, before
+// we compile any of its functions, which may come first.  They start
+// out zero, since there's no code to run before init to set them to
+// anything else.
+func (v *CompileVisitor) DefineGlobals(p *ast.Package) {
+	for _,f := range p.Files {
+		for _,d := range f.Decls {
+			d,ok := d.(*ast.GenDecl)
+			if !ok || d.Tok != token.VAR {
+				continue
 			}
-			return -1
-		}
-		fmt.Println("string literals are: ", v.string_literals)
-		if _,ok := v.string_literals[str]; !ok {
-			strname := "string_" + strings.Map(sanitize, str)
-			for {
-				// See if our strname is valid...
-				nameexists := false
-				for _,n := range v.string_literals {
-					if n == strname {
-						nameexists = true
+			for _,spec := range d.Specs {
+				spec := spec.(*ast.ValueSpec)
+				for _,n := range spec.Names {
+					if len(spec.Values) > 0 {
+						AddError(UnsupportedFeature, n,
+							"I can't yet initialize package-level variables such as %s", n.Name)
+						continue
 					}
+					obj := Info.Defs[n]
+					g := &ir.Slot{SymbolName(p.Name + "." + n.Name), obj.Type()}
+					v.Globals[obj] = g
+					v.Program.Globals = append(v.Program.Globals, g)
 				}
-				if !nameexists {
-					break // we've got a unique name already!
-				}
-				strname = strname + "X"
 			}
-			*v.assembly = append(*v.assembly,
-				x86.Symbol(strname),
-				x86.Commented(x86.Ascii(str), "a non-null-terminated string"))
-			v.string_literals[str] = strname
-			fmt.Println("Got new string literal: ", str)
 		}
 	}
-	return v
 }
 
-type CompileVisitor struct {
-	assembly *[]x86.X86
-	string_literals map[string]string
-	Stack *Stack
+// CallInits has main.main start by calling the init function of each
+// package, in the order in which the packages depend on each other,
+// as go does before it calls main.
+func (v *CompileVisitor) CallInits() {
+	for _,f := range v.Program.Funcs {
+		if f.Name != "main_main" {
+			continue
+		}
+		b := f.Blocks[0]
+		var calls []ir.Instr
+		for _,init := range v.Inits {
+			calls = append(calls, &ir.Call{init.Name, nil, nil, false})
+		}
+		// The first instruction is the line of main itself.
+		b.Instrs = append(b.Instrs[:1], append(calls, b.Instrs[1:]...)...)
+	}
 }
-func (v *CompileVisitor) Append(xs... x86.X86) {
-	*v.assembly = append(*v.assembly, xs...)
+
+// SlotName gives the name of a parameter or result slot, making one
+// up if it hasn't got one.
+func SlotName(r *types.Var, prefix string, i int) string {
+	if r.Name() == "" || r.Name() == "_" {
+		return fmt.Sprint(prefix, i)
+	}
+	return r.Name()
 }
-func (v *CompileVisitor) FunctionPrologue(fn *ast.FuncDecl) {
-	v.Stack = v.Stack.New(fn.Name.Name)
-	ftype := ast.NewType(ast.Function)
-	ftype.N = uint(fn.Type.Results.NumFields())
-	ftype.Params = ast.NewScope(nil)
-	fmt.Println("Working on function", fn.Name.Name)
-	if fn.Type.Results != nil {
-		resultnum := 0
-		for _,resultfield := range fn.Type.Results.List {
-			names := []string{"_"}
-			if resultfield.Names != nil {
-				names = []string{}
-				for _,i := range resultfield.Names {
-					names = append(names, i.Name)
-				}
-			}
-			t := TypeExpression(resultfield.Type)
-			for _,n := range names {
-				ftype.Params.Insert(&ast.Object{ ast.Fun, n, t, resultfield, 0 })
-				resultnum++
-				v.Stack.DefineVariable(n, t, fmt.Sprintf("return_value_%d", resultnum))
 
-				// The return values are actually allocated elsewhere... here
-				// we just need to define the function type properly so it
-				// gets called properly.
-			}
-		}
+func (v *CompileVisitor) CompileFunction(n *ast.FuncDecl) {
+	// If this function is broken, we skip the rest of it and move on to
+	// the next one.
+	defer Catch(n, func() {})
+	fn := Info.Defs[n.Name].(*types.Func)
+	sig := fn.Signature()
+	v.Func = ir.NewFunc(SymbolName(fn.FullName()))
+	if cf,ok := CFuncs[fn]; ok && cf.Export {
+		v.Func.Export = cf.Name
+	} else if ok {
+		v.Func.Name = cf.Name
+		v.Func.CDecl = true
+	}
+	pos := myfiles.Position(n.Pos())
+	v.Func.Pos = fmt.Sprint(pos.Filename, ": line ", pos.Line)
+	v.Slots = make(map[types.Object]*ir.Slot)
+	for i,r := range sig.Results.Vars {
+		s := &ir.Slot{SlotName(r, "r", i), r.Type()}
+		v.Func.Results = append(v.Func.Results, s)
+		v.Slots[r] = s
 	}
-	fmt.Println("Stack size after results is", v.Stack.Size)
-	v.Stack.ReturnSize = v.Stack.Size
-	// The arguments are pushed last argument first, so that eventually
-	// the types of the "later" arguments can depend on the first
-	// arguments, which seems nice to me.
-	for pi:=len(fn.Type.Params.List)-1; pi>=0; pi-- {
-		paramfield := fn.Type.Params.List[pi]
-		names := []string{"_"}
-		if paramfield.Names != nil {
-			names = []string{}
-			for _,i := range paramfield.Names {
-				names = append(names, i.Name)
+	for i,p := range sig.Params.Vars {
+		s := &ir.Slot{SlotName(p, "p", i), p.Type()}
+		v.Func.Params = append(v.Func.Params, s)
+		v.Slots[p] = s
+	}
+	v.Program.Funcs = append(v.Program.Funcs, v.Func)
+	if fn.Name() == "init" {
+		for _,init := range v.Inits {
+			if init.Name == v.Func.Name {
+				Unsupported(n.Name, "I can only handle one init function in each package")
 			}
 		}
-		t := TypeExpression(paramfield.Type)
-		for i:=len(names)-1; i>=0; i-- {
-			n := names[i]
-			ftype.Params.Insert(&ast.Object{ ast.Fun, n, t, paramfield, 0 })
-			v.Stack.DefineVariable(n, t)
-
-			// The function parameters are actually allocated
-			// elsewhere... here we just need to define the function type
-			// properly so it gets called properly.
-		}
+		v.Inits = append(v.Inits, v.Func)
 	}
-	fmt.Println("Stack size after params is", v.Stack.Size)
-	v.Stack.DefineVariable("return", IntType)
-	fmt.Println("Stack size after return is", v.Stack.Size)
-	v.Stack = v.Stack.New("_")
-	DefineGlobal(fn.Name.Name, ftype)
-	// symbol for the start name
-	pos := myfiles.Position(fn.Pos())
-	v.Append(x86.Commented(x86.GlobalSymbol("main_"+fn.Name.Name),
-		fmt.Sprint(pos.Filename, ": line ", pos.Line)))
-	// If we had arguments, we'd want to swap them with the return
-	// address here...
-}
-func (v *CompileVisitor) FunctionPostlogue() {
-	// First we roll back the stack from where we started...
-	for v.Stack.Name == "_" {
-		// We need to pop off any extra layers of stack we've added...
-		if v.Stack.Size > 0 {
-			v.Append(x86.Commented(x86.AddL(x86.Imm32(v.Stack.Size), x86.ESP),
-				"We stored this much on the stack so far."))
-		}
-		v.Stack = v.Stack.Parent // We've popped off the arguments...
+	if n.Body == nil {
+		// A function declared without a body is implemented in the
+		// assembly that accompanies its package, so all we needed was
+		// its type.
+		v.Func.External = true
+		return
+	}
+	v.Block = v.Func.NewBlock()
+	v.Line(n)
+	v.CompileStatements(n.Body.List)
+	if v.Block != nil && !v.Block.Terminated() {
+		v.Block.Return()
 	}
-	// Now jump to the "real" postlogue.  This is a little stupid, but I
-	// expect it'll come in handy when I implement defer (not to mention
-	// panic/recover).
-	v.Append(x86.Jmp(x86.Symbol("return_" + v.Stack.Name)))
 }
-
-func (v *CompileVisitor) Visit(n0 ast.Node) (w ast.Visitor) {
-	// The following only handles functions (not methods)
-	if n,ok := n0.(*ast.FuncDecl); ok && n.Recv == nil {
-		v.FunctionPrologue(n)
-		for _,statement := range n.Body.List {
-			v.CompileStatement(statement)
-		}
-		v.FunctionPostlogue()
-		v.Append(x86.GlobalSymbol("return_"+n.Name.Name))
-		v.Append(x86.Commented(x86.PopL(x86.EAX), "Pop the return address"))
-		// Pop off function arguments...
-		fmt.Println("Function", v.Stack.Name, "has stack size", v.Stack.Size)
-		fmt.Println("Function", v.Stack.Name, "has return values size", v.Stack.ReturnSize)
-		v.Append(x86.Commented(x86.AddL(x86.Imm32(v.Stack.Size - 4 - v.Stack.ReturnSize), x86.ESP),
-			"Popping "+v.Stack.Name+" arguments."))
-		// Then we return!
-		v.Append(x86.RawAssembly("\tjmp *%eax"))
-		v.Stack = v.Stack.Parent
-		return nil // No need to peek inside the func declaration!
+// Line notes where the code for n starts, for tracebacks and the
+// debugging information.
+func (v *CompileVisitor) Line(n ast.Node) {
+	if v.Block != nil {
+		pos := myfiles.Position(n.Pos())
+		v.Block.Add(&ir.Line{pos.Filename, pos.Line})
 	}
-	return v
 }
-func (v *CompileVisitor) PopType(t ast.Type) {
-	switch t.Form {
-	case ast.Tuple:
-		for _,o := range t.Params.Objects {
-			v.PopType(*o.Type)
-		}
-	case ast.Basic:
-		switch t.N {
-		case ast.String:
-			v.Append(x86.AddL(x86.Imm32(8), x86.ESP))
-		default:
-			panic(fmt.Sprintf("I don't know how to pop basic type %s", t))
+// CompileStatements compiles a list of statements, stopping once we
+// have returned or panicked, since nothing after that can ever run.
+func (v *CompileVisitor) CompileStatements(list []ast.Stmt) {
+	for _,statement := range list {
+		if v.Block == nil {
+			return
 		}
-	default:
-		panic(fmt.Sprintf("I don't know how to pop type %s", t.Form))
+		v.CompileStatement(statement)
 	}
 }
 func (v *CompileVisitor) CompileStatement(statement ast.Stmt) {
+	// If we can't compile this statement, we note the error and carry
+	// on with the next one.
+	defer Catch(statement, func() {})
+	v.Line(statement)
 	switch s := statement.(type) {
 	case *ast.EmptyStmt:
 		// It is empty, I can handle that!
 	case *ast.ExprStmt:
-		v.CompileExpression(s.X)
-		t := ExprType(s.X, v.Stack)
-		switch t.Form {
-		case ast.Tuple:
-			
+		if call,ok := s.X.(*ast.CallExpr); ok {
+			v.CompileCall(call) // we don't care about the results
+			if b,ok := Callee(call).(*types.Builtin); ok && b.Name() == "panic" {
+				v.Block.Return() // we never get this far
+				v.Block = nil
+			}
+		} else {
+			v.CompileExpression(s.X)
 		}
 	case *ast.ReturnStmt:
-		for resultnum,e := range s.Results {
-			vname := fmt.Sprintf("return_value_%d", resultnum+1)
-			v.CompileExpression(e)
-			v.PopTo(vname)
+		var results [][]ir.Value
+		if len(s.Results) == 1 && len(v.Func.Results) > 1 {
+			// As in return f(), where f returns several values.
+			results = v.CompileCall(s.Results[0].(*ast.CallExpr))
+		} else {
+			for _,e := range s.Results {
+				results = append(results, v.CompileExpression(e))
+			}
+		}
+		for i,r := range results {
+			for w,val := range r {
+				v.Emit(&ir.Store{v.Func.Results[i], w, val})
+			}
+		}
+		v.Emit(&ir.Return{})
+		v.Block = nil
+	case *ast.AssignStmt:
+		if s.Tok != token.ASSIGN || len(s.Lhs) != 1 || len(s.Rhs) != 1 {
+			Unsupported(s, "I can only handle assignments of a single value with =")
+		}
+		id,ok := s.Lhs[0].(*ast.Ident)
+		if !ok {
+			Unsupported(s.Lhs[0], "I can only assign to variables")
+		}
+		val := v.CompileExpression(s.Rhs[0])
+		if id.Name == "_" {
+			return
+		}
+		dst := v.Variable(id, Info.Uses[id])
+		for w,x := range val {
+			v.Emit(&ir.Store{dst, w, x})
+		}
+	case *ast.BlockStmt:
+		v.CompileStatements(s.List)
+	case *ast.IfStmt:
+		if s.Init != nil {
+			v.CompileStatement(s.Init)
+		}
+		// We only handle conditions we can work out right now, in which
+		// case there's no need for a branch at all.
+		cond,ok := Info.Types[s.Cond].Value.(bool)
+		if !ok {
+			Unsupported(s.Cond, "I can only handle if statements with constant conditions")
+		}
+		if cond {
+			v.CompileStatement(s.Body)
+		} else if s.Else != nil {
+			v.CompileStatement(s.Else)
+		}
+	case *ast.DeclStmt:
+		if d,ok := s.Decl.(*ast.GenDecl); !ok || d.Tok != token.CONST {
+			Unsupported(s, "I can only handle const declarations inside functions")
 		}
-		v.FunctionPostlogue()
 	default:
-		panic(fmt.Sprintf("I can't handle statements such as: %T", statement))
+		Unsupported(statement, "I can't handle statements such as: %T", statement)
 	}
 }
-func (v *CompileVisitor) CompileExpression(exp ast.Expr) {
+
+// CompileExpression returns the words that make up the value of exp.
+func (v *CompileVisitor) CompileExpression(exp ast.Expr) []ir.Value {
+	if val := Info.Types[exp].Value; val != nil {
+		// The type checker has already worked this one out for us.
+		return v.CompileConstant(exp, val)
+	}
 	switch e := exp.(type) {
-	case *ast.BasicLit:
-		switch e.Kind {
-		case token.STRING:
-			str,err := strconv.Unquote(string(e.Value))
-			if err != nil {
-				panic(err)
-			}
-			n,ok := v.string_literals[str]
-			if !ok {
-				panic(fmt.Sprintf("I don't recognize the string: %s", string(e.Value)))
-			}
-			v.Append(
-				x86.Commented(x86.PushL(x86.Symbol(n)), "Pushing string literal "+string(e.Value)),
-				x86.PushL(x86.Imm32(len(str))))
-			v.Stack.Push(StringType) // let the stack know we've pushed a literal
-		default:
-			panic(fmt.Sprintf("I don't know how to deal with literal: %s", e))
-		}
+	case *ast.ParenExpr:
+		return v.CompileExpression(e.X)
 	case *ast.CallExpr:
-		if fn,ok := e.Fun.(*ast.Ident); ok {
-			pos := myfiles.Position(fn.Pos())
-			switch fn.Name {
-			case "println", "print":
-				if len(e.Args) != 1 {
-					panic(fmt.Sprintf("%s expects just one argument, not %d", fn.Name, len(e.Args)))
-				}
-				argtype := ExprType(e.Args[0], v.Stack)
-				if argtype.N != ast.String || argtype.Form != ast.Basic {
-					panic(fmt.Sprintf("Argument to %s has type %s but should have type string!",
-						fn.Name, argtype))
-				}
-				v.Stack = v.Stack.New("arguments")
-				v.CompileExpression(e.Args[0])
-				v.Append(x86.Commented(x86.Call(x86.Symbol(fn.Name)),
-					fmt.Sprint(pos.Filename, ": line ", pos.Line)))
-				v.Stack = v.Stack.Parent // A hack to let the callee clean up arguments
-			default:
-				// This must not be a built-in function...
-				functype := v.Stack.Lookup(fn.Name)
-				if functype.Type().Form != ast.Function {
-					panic("Function "+ fn.Name + " is not actually a function!")
-				}
-				for i:=0; i<int(functype.Type().N); i++ {
-					// Put zeros on the stack for the return values (and define these things)
-					v.Declare(functype.Type().Params.Objects[i].Name,
-						functype.Type().Params.Objects[i].Type)
-				}
-				v.Stack = v.Stack.New("arguments")
-				for i:=len(e.Args)-1; i>=0; i-- {
-					v.CompileExpression(e.Args[i])
-				}
-				// FIXME: I assume here that there is no return value!
-				v.Append(x86.Commented(x86.Call(x86.Symbol("main_"+fn.Name)),
-					fmt.Sprint(pos.Filename, ": line ", pos.Line)))
-				v.Stack = v.Stack.Parent // A hack to let the callee clean up arguments
-			}
-		} else {
-			panic(fmt.Sprintf("I don't know how to deal with complicated function: %s", e.Fun))
+		results := v.CompileCall(e)
+		if len(results) != 1 {
+			Invalid(e, "This call doesn't have a single value")
 		}
+		return results[0]
 	case *ast.Ident:
-		evar := v.Stack.Lookup(e.Name)
-		switch SizeOnStack(evar.Type()) {
-		case 4:
-			v.Append(x86.Commented(x86.MovL(evar.InMemory(), x86.EAX), "Reading variable "+e.Name))
-			v.Append(x86.PushL(x86.EAX))
-			v.Stack.DefineVariable("_copy_of_"+e.Name, evar.Type())
-		case 8:
-			v.Append(x86.Comment(fmt.Sprintf("The offset of %s is %s", e.Name, evar.InMemory())))
-			v.Append(x86.Commented(x86.MovL(evar.InMemory().Add(4), x86.EAX),
-				"Reading variable "+e.Name))
-			v.Append(x86.MovL(evar.InMemory(), x86.EBX))
-			v.Append(x86.PushL(x86.EAX))
-			v.Append(x86.PushL(x86.EBX))
-			v.Stack.DefineVariable("_copy_of_"+e.Name, evar.Type())
-		default:
-			panic(fmt.Sprintf("I don't handle variables with length %s", SizeOnStack(evar.Type())))
+		return v.Load(v.Variable(e, Info.Uses[e]))
+	case *ast.SelectorExpr:
+		// This can only be a variable from another package, such as
+		// os.Args, since we don't have structs.
+		return v.Load(v.Variable(e, Info.Uses[e.Sel]))
+	case *ast.IndexExpr:
+		t,ok := ExprType(e.X).Underlying().(*types.Slice)
+		if !ok {
+			Unsupported(e, "I can only index slices, not %s", ExprType(e.X))
 		}
-	default:
-		panic(fmt.Sprintf("I can't handle expressions such as: %T value %s", exp, exp))
+		x := v.CompileExpression(e.X) // a pointer, a length and a capacity
+		i := v.CompileInteger(e.Index)
+		v.Emit(&ir.IndexCheck{i, x[1]})
+		off := v.Func.NewTemp()
+		v.Emit(&ir.BinOp{token.MUL, off, i, ir.Int(types.Target.Sizeof(t.Elem))})
+		addr := v.Func.NewTemp()
+		v.Emit(&ir.BinOp{token.ADD, addr, x[0], off})
+		var out []ir.Value
+		for w:=0; w<ir.Words(t.Elem); w++ {
+			t := v.Func.NewTemp()
+			v.Emit(&ir.LoadAt{t, addr, w})
+			out = append(out, t)
+		}
+		return out
+	case *ast.UnaryExpr:
+		x := v.CompileInteger(e.X)
+		switch e.Op {
+		case token.ADD:
+			return []ir.Value{x}
+		case token.SUB, token.XOR:
+			t := v.Func.NewTemp()
+			v.Emit(&ir.UnOp{e.Op, t, x})
+			return []ir.Value{t}
+		}
+		Unsupported(e, "I don't handle the unary %s operator", e.Op)
+	case *ast.BinaryExpr:
+		switch e.Op {
+		case token.ADD, token.SUB, token.MUL, token.QUO, token.REM,
+			token.AND, token.OR, token.XOR, token.AND_NOT, token.SHL, token.SHR:
+			x := v.CompileInteger(e.X)
+			y := v.CompileInteger(e.Y)
+			t := v.Func.NewTemp()
+			v.Emit(&ir.BinOp{e.Op, t, x, y})
+			return []ir.Value{t}
+		}
+		Unsupported(e, "I don't handle the %s operator", e.Op)
 	}
+	Unsupported(exp, "I can't handle expressions such as: %T", exp)
+	return nil
 }
 
-func (v *CompileVisitor) PopTo(vname string) {
-	v.Append(v.Stack.PopTo(vname))
+// Variable returns the slot of the variable obj, which e refers to.
+func (v *CompileVisitor) Variable(e ast.Expr, obj types.Object) *ir.Slot {
+	if s,ok := v.Slots[obj]; ok {
+		return s
+	}
+	if s,ok := v.Globals[obj]; ok {
+		return s
+	}
+	Unsupported(e, "I don't handle variables such as %s", obj.Name())
+	return nil
+}
+
+// Load returns the words of the variable in s.
+func (v *CompileVisitor) Load(s *ir.Slot) (out []ir.Value) {
+	for w:=0; w<ir.Words(s.Type); w++ {
+		t := v.Func.NewTemp()
+		v.Emit(&ir.Load{t, s, w})
+		out = append(out, t)
+	}
+	return
+}
+
+// CompileConstant returns the words of the constant val, which is
+// the value of e.
+func (v *CompileVisitor) CompileConstant(e ast.Expr, val interface{}) []ir.Value {
+	switch val := val.(type) {
+	case int64:
+		if t := ExprType(e); ir.Words(t) != 1 {
+			Unsupported(e, "I can't handle constants of type %s", t)
+		}
+		if types.Target.WordSize == 8 && int64(int32(val)) != val {
+			// The amd64 only has 32-bit immediates, mostly.
+			Unsupported(e, "I can't handle constants as big as %d", val)
+		}
+		return []ir.Value{ir.Int(val)}
+	case string:
+		return []ir.Value{ir.Int(len(val)), v.Program.StringLiteral(val)}
+	case bool:
+		if val {
+			return []ir.Value{ir.Int(1)}
+		}
+		return []ir.Value{ir.Int(0)}
+	}
+	Unsupported(e, "I can't handle constants such as %v", val)
+	return nil
 }
 
-func (v *CompileVisitor) Declare(vname string, t *ast.Type) {
-	switch SizeOnStack(t) {
-	case 4:
-		v.Append(x86.Commented(x86.PushL(x86.Imm32(0)), "This is variable "+vname))
-	case 8:
-		v.Append(x86.Commented(x86.PushL(x86.Imm32(0)), "This is variable "+vname))
-		v.Append(x86.Commented(x86.PushL(x86.Imm32(0)), "This is variable "+vname))
+// CompileInteger compiles an expression which must be a signed
+// integer that fits in a word, since that's the only sort of
+// arithmetic we know how to do.
+func (v *CompileVisitor) CompileInteger(e ast.Expr) ir.Value {
+	t := ExprType(e)
+	if !types.IsInteger(t) || types.IsUnsigned(t) || TypeToSize(t) != types.Target.WordSize {
+		Unsupported(e, "I can only do arithmetic on int, not %s", t)
+	}
+	return v.CompileExpression(e)[0]
+}
+
+// CompileCall returns the words of each of the results of the call.
+func (v *CompileVisitor) CompileCall(e *ast.CallExpr) (results [][]ir.Value) {
+	switch fn := Callee(e).(type) {
+	case *types.Builtin:
+		switch fn.Name() {
+		case "println", "print", "panic":
+			if len(e.Args) != 1 {
+				Unsupported(e, "%s expects just one argument, not %d", fn.Name(), len(e.Args))
+			}
+			argtype := ExprType(e.Args[0])
+			if !types.IsString(argtype) {
+				Unsupported(e.Args[0], "Argument to %s has type %s but should have type string!",
+					fn.Name(), argtype)
+			}
+			arg := v.CompileExpression(e.Args[0])
+			v.Emit(&ir.Call{fn.Name(), [][]ir.Value{arg}, nil, false})
+		case "len":
+			// A constant length, such as that of a string literal, never
+			// gets this far.
+			t := ExprType(e.Args[0])
+			x := v.CompileExpression(e.Args[0])
+			if _,ok := t.Underlying().(*types.Slice); ok {
+				return [][]ir.Value{[]ir.Value{x[1]}} // after the pointer
+			} else if types.IsString(t) {
+				return [][]ir.Value{[]ir.Value{x[0]}}
+			}
+			Unsupported(e.Args[0], "I can't take the length of %s", t)
+		default:
+			Unsupported(e, "I don't handle the builtin %s", fn.Name())
+		}
+	case *types.Func:
+		sig := fn.Signature()
+		if sig.Recv != nil {
+			Unsupported(e.Fun, "I don't handle methods such as %s", fn.Name())
+		}
+		if sig.Variadic {
+			Unsupported(e.Fun, "I don't handle variadic functions such as %s", fn.Name())
+		}
+		if fn.FullName() == "syscall.Syscall" {
+			// We make system calls ourselves, rather than calling
+			// syscall.S, so that the arguments can go straight into the
+			// registers where the kernel wants them.
+			var args [4]ir.Value
+			for i,a := range e.Args {
+				args[i] = v.CompileInteger(a)
+			}
+			t := v.Func.NewTemp()
+			v.Emit(&ir.Syscall{t, args[0], [3]ir.Value{args[1], args[2], args[3]}})
+			return [][]ir.Value{[]ir.Value{t}}
+		}
+		var args [][]ir.Value
+		if len(e.Args) == 1 && sig.Params.Len() > 1 {
+			// As in f(g()), where g returns several values.
+			args = v.CompileCall(e.Args[0].(*ast.CallExpr))
+		} else {
+			for _,a := range e.Args {
+				args = append(args, v.CompileExpression(a))
+			}
+		}
+		call := &ir.Call{SymbolName(fn.FullName()), args, nil, false}
+		if cf,ok := CFuncs[fn]; ok && !cf.Export {
+			call.Func = cf.Name
+			call.CDecl = true
+		}
+		for _,r := range sig.Results.Vars {
+			var temps []*ir.Temp
+			var vals []ir.Value
+			for w:=0; w<ir.Words(r.Type()); w++ {
+				t := v.Func.NewTemp()
+				temps = append(temps, t)
+				vals = append(vals, t)
+			}
+			call.Results = append(call.Results, temps)
+			results = append(results, vals)
+		}
+		v.Emit(call)
 	default:
-		panic(fmt.Sprintf("I don't handle variables with length %s", SizeOnStack(t)))
+		Unsupported(e.Fun, "I don't know how to deal with complicated function: %T", e.Fun)
 	}
-	v.Stack.DefineVariable(vname, t)
+	return
 }
 
 func main() {
+	// gogo run takes a single go file, and everything after it is for
+	// the program, so goopt mustn't see it.
+	var runargs []string
+	run := false
+	for i,a := range os.Args {
+		if a == "run" {
+			run = true
+		} else if run && strings.HasSuffix(a, ".go") {
+			os.Args, runargs = os.Args[:i+1], os.Args[i+1:]
+			break
+		}
+	}
+	os.Args = LongFlags(os.Args)
 	goopt.Parse(func() []string { return nil })
-	if len(goopt.Args) > 0 {
-		x,err := parser.ParseFiles(myfiles, goopt.Args, 0)
+	SetArch()
+	if len(goopt.Args) > 0 && goopt.Args[0] == "objdump" {
+		die(Objdump(goopt.Args[1:]))
+		return
+	}
+	args := goopt.Args
+	running := len(args) > 0 && args[0] == "run"
+	if running {
+		if len(args) != 2 || !strings.HasSuffix(args[1], ".go") {
+			die(os.NewError("gogo run needs a go file to run"))
+		}
+		args = args[1:]
+	}
+	// Anything that isn't go is for the linker, such as object files
+	// holding C functions.
+	var gofiles, linkwith []string
+	for _,a := range args {
+		if strings.HasSuffix(a, ".go") {
+			gofiles = append(gofiles, a)
+		} else {
+			linkwith = append(linkwith, a)
+		}
+	}
+	if *libc {
+		linkwith = append(linkwith, "-dynamic-linker", DynamicLinker(), "-lc")
+	}
+	if len(gofiles) > 0 {
+		x,err := parser.ParseFiles(myfiles, gofiles, parser.ParseComments)
 		die(err)
-		fmt.Fprintln(os.Stderr, "Parsed: ", *x["main"])
-		die(typechecker.CheckPackage(myfiles, x["main"], nil))
-		fmt.Fprintln(os.Stderr, "Checked: ", *x["main"])
+		if *verbose {
+			fmt.Fprintln(os.Stderr, "Parsed: ", *x["main"])
+		}
 		//for _,a := range x["main"].Files {
 		//	die(printer.Fprint(os.Stdout, a))
 		//}
 
-		aaa := x86.StartData
-		var bbb *Stack
-		var cv = CompileVisitor{ &aaa, make(map[string]string), bbb.New("global")}
-		ast.Walk(StringVisitor(cv), x["main"])
+		pkgs,err := ImportedPackages(x["main"])
+		die(err)
+		CheckPackages(pkgs)
+		FindCFuncs(pkgs)
+		// There's no point generating code for a program we know to be
+		// broken.
+		ReportErrors()
 
-		cv.Append(x86.StartText...)
-		ast.Walk(&cv, x["main"])
+		cv := NewCompileVisitor()
+		// The packages are in dependency order, so every function is
+		// defined before anyone tries to call it.
+		for _,p := range pkgs {
+			cv.DefineGlobals(p)
+			ast.Walk(cv, p)
+		}
+		cv.CallInits()
+		ReportErrors()
+		if running && *interp {
+			os.Exit(Interpret(pkgs, RunName(gofiles[0]), runargs))
+		}
+		if *optimize > 0 {
+			cv.Program.Inline(*inlineBudget, func(callee, caller *ir.Func) {
+				if *verbose {
+					fmt.Fprintln(os.Stderr, "Inlined", callee.Name, "into", caller.Name)
+				}
+			})
+		}
+
+		var asm []x86.X86
+		for _,p := range pkgs {
+			a,err := PackageAssembly(p)
+			die(err)
+			asm = append(asm, a...)
+		}
+		// Anything that main doesn't call (and that neither the assembly
+		// nor C can call) needn't be compiled at all.
+		roots := []string{"main_main"}
+		asmobj,err := x86.Encode(asm)
+		die(err)
+		refs := asmobj.References()
+		for _,f := range cv.Program.Funcs {
+			if refs[f.Name] || f.Export != "" {
+				roots = append(roots, f.Name)
+			}
+		}
+		for _,f := range cv.Program.Prune(roots) {
+			if *verbose {
+				fmt.Fprintln(os.Stderr, "Removed unused function", f.Name)
+			}
+		}
+		// Whatever gets called from outside go has to stick to the
+		// stack convention.
+		UseRegABI(cv.Program, roots)
+		if *dumpir {
+			fmt.Print(cv.Program)
+			return
+		}
+
+		code := append(X86Program(cv.Program), asm...)
 
 		// Here we just add a crude debug library
-		cv.Append(x86.Debugging...)
-		ass := x86.Assembly(*cv.assembly)
+		code = append(code, x86.Debugging...)
+		code = append(code, x86.Runtime...)
+		if *debugInfo {
+			code = append(code, DebugInfo(gofiles[0])...)
+		}
+		if *optimize > 0 {
+			code = x86.Peephole(code)
+		}
+		ass := x86.Assembly(code)
 		//fmt.Println(ass)
-		die(elf.AssembleAndLink(goopt.Args[0][:len(goopt.Args[0])-3], []byte(ass)))
+		exe := gofiles[0][:len(gofiles[0])-3]
+		if running {
+			os.Exit(Run(RunName(gofiles[0]), code, runargs))
+		}
+		if *native || *compileOnly {
+			// We still write out the assembly, for anyone who wants to
+			// read it.
+			die(ioutil.WriteFile(exe+".S", []byte(ass), 0666))
+		}
+		if *compileOnly {
+			die(EncodeObject(exe+".o", code))
+			return
+		}
+		if *native {
+			die(EncodeAndLink(exe, code, linkwith))
+			return
+		}
+		die(elf.AssembleAndLink(*arch, exe, []byte(ass), linkwith...))
+		if *checkEncoding {
+			die(CheckEncoding(exe, code))
+		}
 	}
 }
 
@@ -0,0 +1,159 @@
+package harness
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"debug/dwarf"
+	"github.com/droundy/go/elf"
+)
+
+// Some things about a program can only be seen in the file gogo makes
+// of it, which we read with our own ELF reader, so that we don't need
+// binutils to check them.
+
+// compile compiles the test program called name with flags, in a
+// directory of its own, and reads the file gogo makes, which is called
+// out.
+func compile(t *testing.T, name, out string, flags ...string) *elf.File {
+	test,err := Parse("../tests", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	workdir := path.Join("../.testdir/elf", strings.Join(append([]string{name}, flags...), ""))
+	var f *elf.File
+	errs := each([]*Test{test}, gogoPath(), workdir, 1, func(test *Test, dir string) os.Error {
+		err := copyFile(path.Join(dir, test.Name), path.Join(test.Dir, test.Name))
+		if err != nil {
+			return err
+		}
+		argv := append(append([]string{"../go"}, flags...), test.Name)
+		_,stderr,status,err := run(dir, nil, argv...)
+		if err == nil && status != 0 {
+			err = os.NewError("compiling failed:\n" + stderr)
+		}
+		if err != nil {
+			return err
+		}
+		f,err = elf.Open(path.Join(dir, out))
+		return err
+	})
+	if errs[0] != nil {
+		t.Fatalf("%s with %s: %s", name, strings.Join(flags, " "), errs[0])
+	}
+	return f
+}
+
+// hasSymbol checks that f has a symbol called name, that nm would show
+// with letter.
+func hasSymbol(t *testing.T, f *elf.File, name string, letter int) {
+	s,ok := f.Lookup(name)
+	if !ok {
+		t.Errorf("there's no %s", name)
+	} else if f.Letter(s) != letter {
+		t.Errorf("%s is a %c rather than a %c", name, f.Letter(s), letter)
+	}
+}
+
+// TestDeadcode checks that nothing we can't reach makes it into the
+// binary, whether or not ld made it.
+func TestDeadcode(t *testing.T) {
+	for _,linker := range []string{"--binutils", "--native"} {
+		f := compile(t, "deadcode.go", "deadcode", linker)
+		hasSymbol(t, f, "main_main", 'T')
+		hasSymbol(t, f, "string_Helloworld", 'd')
+		for _,s := range f.Symbols {
+			for _,gone := range []string{"main_unused", "string_Nobodycallsme", "string_Thisneverhappens"} {
+				if strings.Contains(s.Name, gone) {
+					t.Errorf("with %s there's still a %s", linker, s.Name)
+				}
+			}
+		}
+	}
+}
+
+// TestCdecl checks that the object file gogo -c writes exports what C
+// calls, and leaves what it calls in C for the linker.
+func TestCdecl(t *testing.T) {
+	f := compile(t, "cdecl.go", "cdecl.o", "-c")
+	hasSymbol(t, f, "gogo_twice", 'T')
+	hasSymbol(t, f, "add3", 'U')
+	found := false
+	for _,r := range f.Section(".text").Relocs {
+		if f.Symbols[r.Sym].Name == "c_twice_plus_one" && f.RelocType(r.Type) == "R_386_PC32" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("nothing calls c_twice_plus_one")
+	}
+}
+
+// The location of a variable that is at an offset from the frame
+// pointer.
+const opFbreg = 0x91
+
+// TestDebuginfo checks that with -g gdb can find each statement, and
+// describe and its variables, and knows their types.
+func TestDebuginfo(t *testing.T) {
+	for _,arch := range []string{"386", "amd64"} {
+		f := compile(t, "debuginfo.go", "debuginfo", "-arch="+arch, "-O0", "-g")
+		lines,err := f.Lines()
+		if err != nil {
+			t.Fatalf("%s: %s", arch, err)
+		}
+		found := false
+		for _,l := range lines {
+			if l.File == "debuginfo.go" && l.Line == 13 {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: there's no debuginfo.go:13 in %v", arch, lines)
+		}
+
+		d,err := f.DWARF()
+		if err != nil {
+			t.Fatalf("%s: %s", arch, err)
+		}
+		entries := make(map[string]*dwarf.Entry)
+		r := d.Reader()
+		for {
+			e,err := r.Next()
+			if err != nil {
+				t.Fatalf("%s: %s", arch, err)
+			}
+			if e == nil {
+				break
+			}
+			if name,ok := e.Val(dwarf.AttrName).(string); ok {
+				entries[name] = e
+			}
+		}
+		if e := entries["main_describe"]; e == nil || e.Tag != dwarf.TagSubprogram {
+			t.Errorf("%s: main_describe is %v", arch, e)
+		}
+		for _,name := range []string{"name", "size"} {
+			e := entries[name]
+			if e == nil {
+				t.Errorf("%s: there's no %s", arch, name)
+				continue
+			}
+			if loc,_ := e.Val(dwarf.AttrLocation).([]byte); len(loc) == 0 || loc[0] != opFbreg {
+				t.Errorf("%s: %s isn't in the frame, but at %v", arch, name, loc)
+			}
+		}
+		for _,name := range []string{"string", "bool"} {
+			if entries[name] == nil {
+				t.Errorf("%s: there's no type %s", arch, name)
+			}
+		}
+	}
+
+	// Without -g there's none of it.
+	f := compile(t, "debuginfo.go", "debuginfo")
+	if f.Section(".debug_info") != nil || f.Section(".debug_line") != nil {
+		t.Error("there's debugging information without -g")
+	}
+}
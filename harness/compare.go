@@ -0,0 +1,175 @@
+package harness
+
+import (
+	"os"
+	"exec"
+	"path"
+	"strings"
+	"io/ioutil"
+)
+
+// As well as checking each program against what it says it should do,
+// we can check it against what the go toolchain makes of it, if there
+// is one installed.  gogo's library isn't go's, so golib holds a copy
+// of it written for the go toolchain, which each program is built
+// with in place of the real one.  A program that can't be compiled by
+// both (because it's meant not to compile, or it needs C) is left out.
+// We try every way a program says to compile it, with go building it
+// for whichever machine that is, and run each with every one of its
+// cases.
+//
+// The only differences we expect are in what comes after a panic: both
+// say "panic: " and why, but then go prints its goroutines where we
+// print our traceback, so we stop comparing there.
+
+// GoTool returns the go command, or "" if there isn't one.
+func GoTool() string {
+	gotool,err := exec.LookPath("go")
+	if err != nil {
+		return ""
+	}
+	return gotool
+}
+
+// CompareAll compiles and runs tests with both gogo and gotool, much
+// as RunAll does, and returns how the two differed for each test, or
+// nil for a test where they agreed.
+func CompareAll(tests []*Test, gogo, gotool, golib, workdir string, parallel int) []os.Error {
+	golib = abs(golib)
+	return each(tests, gogo, workdir, parallel, func(t *Test, dir string) os.Error {
+		return t.Compare(dir, gotool, golib)
+	})
+}
+
+// Compare compiles t in dir with both gogo (at ../go), with each of
+// its flags, and gotool, for whichever machine the flags ask for, runs
+// each with every case, and returns how they differed.
+func (t *Test) Compare(dir, gotool, golib string) os.Error {
+	if t.Errors != nil || t.C != "" {
+		return nil
+	}
+	base := t.Name[:len(t.Name)-len(".go")]
+	if err := copyFile(path.Join(dir, t.Name), path.Join(t.Dir, t.Name)); err != nil {
+		return err
+	}
+	gcdir := path.Join(dir, "gc")
+	if err := gcPrepare(gcdir, path.Join(t.Dir, t.Name), golib); err != nil {
+		return err
+	}
+	built := make(map[string]bool)
+	var ps problems
+	for _,c := range t.Compiles {
+		if !c.program() {
+			continue
+		}
+		how := c.how()
+		_,stderr,status,err := t.compile(dir, c.Flags)
+		if err == nil && status != 0 {
+			err = os.NewError("gogo" + how + " failed:\n" + stderr)
+		}
+		if err != nil {
+			return err
+		}
+		// Each machine's go binary gets a directory of its own, so
+		// that it has the same name as ours, along with the program
+		// in case it reads itself.
+		arch := goarch(c.Flags)
+		if !built[arch] {
+			env := []string{"GOARCH=" + arch, "CGO_ENABLED=0", "GOTOOLCHAIN=local"}
+			_,stderr,status,err = run(gcdir, env, gotool, "build", "-o", path.Join(arch, base), t.Name)
+			if err == nil && status != 0 {
+				err = os.NewError("go build for " + arch + " failed:\n" + stderr)
+			}
+			if err == nil {
+				err = copyFile(path.Join(gcdir, arch, t.Name), path.Join(t.Dir, t.Name))
+			}
+			if err != nil {
+				return err
+			}
+			built[arch] = true
+		}
+
+		for _,k := range t.Cases {
+			what := how + k.how()
+			argv := append([]string{"./" + base}, k.Args...)
+			stdout,stderr,status,err := run(dir, nil, argv...)
+			if err != nil {
+				return err
+			}
+			gcout,gcerr,gcstatus,err := run(path.Join(gcdir, arch), nil, argv...)
+			if err != nil {
+				return err
+			}
+			if status != gcstatus {
+				ps.add("%s%s exited with status %d, but %d with go", base, what, status, gcstatus)
+			}
+			ps.check("stdout"+what+" (- go, + gogo)", gcout, stdout)
+			ps.check("stderr"+what+" (- go, + gogo)", untilPanic(gcerr), untilPanic(stderr))
+		}
+	}
+	return ps.error()
+}
+
+// gcPrepare makes gcdir a module in which the go toolchain can build
+// the program src against golib.
+func gcPrepare(gcdir, src, golib string) os.Error {
+	if err := os.MkdirAll(gcdir, 0777); err != nil {
+		return err
+	}
+	err := ioutil.WriteFile(path.Join(gcdir, "go.mod"), []byte("module gogo\n\ngo 1.16\n"), 0666)
+	if err != nil {
+		return err
+	}
+	pkgs,err := ioutil.ReadDir(golib)
+	if err != nil {
+		return err
+	}
+	ours := make(map[string]bool)
+	for _,pkg := range pkgs {
+		if !pkg.IsDirectory() {
+			continue
+		}
+		ours[pkg.Name] = true
+		if err := os.MkdirAll(path.Join(gcdir, pkg.Name), 0777); err != nil {
+			return err
+		}
+		fis,err := ioutil.ReadDir(path.Join(golib, pkg.Name))
+		if err != nil {
+			return err
+		}
+		for _,fi := range fis {
+			err := copyFile(path.Join(gcdir, pkg.Name, fi.Name), path.Join(golib, pkg.Name, fi.Name))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	// The program imports our packages by the module's name.
+	code,err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(code), "\n", -1)
+	for i,l := range lines {
+		for pkg := range ours {
+			quoted := "\"" + pkg + "\""
+			if strings.TrimSpace(l) == quoted || l == "import " + quoted {
+				lines[i] = strings.Replace(l, quoted, "\"gogo/" + pkg + "\"", 1)
+			}
+		}
+	}
+	_,name := path.Split(src)
+	return ioutil.WriteFile(path.Join(gcdir, name), []byte(strings.Join(lines, "\n")), 0666)
+}
+
+// untilPanic returns stderr up to and including the line saying why a
+// program panicked, if it did.
+func untilPanic(stderr string) string {
+	lines := strings.Split(stderr, "\n", -1)
+	for i,l := range lines {
+		if strings.HasPrefix(l, "panic: ") {
+			return strings.Join(lines[:i+1], "\n") + "\n"
+		}
+	}
+	return stderr
+}
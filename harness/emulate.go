@@ -0,0 +1,53 @@
+package harness
+
+import (
+	"os"
+	"path"
+)
+
+// We can also run each program in gogo's i386 emulator, with gogo run,
+// which needs neither binutils nor a kernel that runs 32-bit code.
+// That leaves out anything we can't emulate: programs for the amd64,
+// and programs that need C, which we can't link with.
+
+// EmulateAll runs tests in the emulator, as RunAll does with the real
+// thing, and returns the problems with each, or nil for a test that
+// passed.
+func EmulateAll(tests []*Test, gogo, workdir string, parallel int) []os.Error {
+	return each(tests, gogo, workdir, parallel, func(t *Test, dir string) os.Error {
+		return t.Emulate(dir)
+	})
+}
+
+// Emulate runs t with gogo run (using the gogo at ../go), with each of
+// its flags that are for the i386, and returns everything that went
+// wrong with it.
+func (t *Test) Emulate(dir string) os.Error {
+	if t.Errors != nil || t.C != "" {
+		return nil
+	}
+	if err := copyFile(path.Join(dir, t.Name), path.Join(t.Dir, t.Name)); err != nil {
+		return err
+	}
+	base := t.Name[:len(t.Name)-len(".go")]
+	var ps problems
+	for _,c := range t.Compiles {
+		if goarch(c.Flags) != "386" || !c.program() {
+			continue
+		}
+		for _,k := range t.Cases {
+			how := c.how() + k.how()
+			argv := append(append([]string{"../go"}, quiet(c.Flags)...), "run", t.Name)
+			stdout,stderr,status,err := run(dir, nil, append(argv, k.Args...)...)
+			if err != nil {
+				return err
+			}
+			if status != k.Exit {
+				ps.add("%s%s exited with status %d rather than %d", base, how, status, k.Exit)
+			}
+			ps.check("stdout"+how, k.Stdout, stdout)
+			ps.check("stderr"+how, k.stderr(), k.untilPanic(stderr))
+		}
+	}
+	return ps.error()
+}
@@ -0,0 +1,682 @@
+package harness
+
+import (
+	"fmt"
+	"rand"
+	"strings"
+)
+
+// Generate writes random programs in the part of go that gogo can
+// compile: functions of ints and strings with no variables of their
+// own, whose bodies are a return (perhaps in an if with a constant
+// condition) or some printing; arithmetic of every sort; calls,
+// including passing on all the results of another call; and
+// strconv.Itoa, strconv.Atoi, strings.Index and strings.Repeat.  As
+// gogo learns more of go, so should this.
+//
+// We work out the value of everything as we generate it, so we know
+// what the program should print: main shows the values of a few
+// expressions, and then a checksum of them all, which makes for some
+// deeply nested calls.  We divide and shift by anything at all, so a
+// program can panic, dividing by zero or shifting by a negative count,
+// in which case it should print what it would have up to then, and
+// then say why it panicked.  (Since that's the end of it, most of our
+// divisors are made negative, and most of our counts positive, by
+// masking them.)  What go leaves undefined is which of two
+// panics in the same statement comes first, so we don't write such a
+// statement.  And we never combine two constants (or divide or shift
+// by one), which go would do at compile time, and complain if it
+// overflowed or divided by zero.
+
+// A gtype is the type of a generated expression.
+
+type gtype int
+
+const (
+	gInt gtype = iota
+	gString
+)
+
+func (t gtype) String() string {
+	if t == gString {
+		return "string"
+	}
+	return "int"
+}
+
+// A gexpr is a generated expression, which knows its own value, given
+// the arguments of the function it is in.
+
+type gexpr interface {
+	String() string
+	value(args []interface{}, ev *geval) interface{}
+	constant() bool
+}
+
+// A geval is what working out the value of a gexpr needs: the number
+// of bits in an int, and somewhere to note why it panicked.  Rather
+// than stopping at a panic, we carry on with a zero, so as to find
+// every panic a statement could run into.
+
+type geval struct {
+	bits uint
+	panics []string
+}
+
+func (ev *geval) panic(why string) int64 {
+	for _,p := range ev.panics {
+		if p == why {
+			return 0
+		}
+	}
+	ev.panics = append(ev.panics, why)
+	return 0
+}
+
+const (
+	divideByZero = "runtime error: integer divide by zero"
+	negativeShift = "runtime error: negative shift amount"
+)
+
+// wrap makes x into an int of bits bits.
+func wrap(x int64, bits uint) int64 {
+	return x << (64 - bits) >> (64 - bits)
+}
+
+type gconst int64
+
+func (c gconst) String() string {
+	if c < 0 {
+		return fmt.Sprint("(", int64(c), ")")
+	}
+	return fmt.Sprint(int64(c))
+}
+func (c gconst) value(args []interface{}, ev *geval) interface{} { return int64(c) }
+func (c gconst) constant() bool { return true }
+
+type gliteral string
+
+func (s gliteral) String() string { return fmt.Sprintf("%q", string(s)) }
+func (s gliteral) value(args []interface{}, ev *geval) interface{} { return string(s) }
+func (s gliteral) constant() bool { return true }
+
+// A gparam is parameter number n of the function it is in.
+
+type gparam int
+
+func (p gparam) String() string { return fmt.Sprint("p", int(p)) }
+func (p gparam) value(args []interface{}, ev *geval) interface{} { return args[p] }
+func (p gparam) constant() bool { return false }
+
+type gunary struct {
+	op string
+	x gexpr
+}
+
+func (u *gunary) String() string { return "(" + u.op + u.x.String() + ")" }
+func (u *gunary) value(args []interface{}, ev *geval) interface{} {
+	x := u.x.value(args, ev).(int64)
+	if u.op == "-" {
+		return wrap(-x, ev.bits)
+	}
+	return ^x
+}
+func (u *gunary) constant() bool { return u.x.constant() }
+
+type gbinary struct {
+	op string
+	x, y gexpr
+}
+
+func (b *gbinary) String() string { return "(" + b.x.String() + " " + b.op + " " + b.y.String() + ")" }
+func (b *gbinary) value(args []interface{}, ev *geval) interface{} {
+	x := b.x.value(args, ev).(int64)
+	y := b.y.value(args, ev).(int64)
+	switch {
+	case (b.op == "/" || b.op == "%") && y == 0:
+		return ev.panic(divideByZero)
+	case (b.op == "<<" || b.op == ">>") && y < 0:
+		return ev.panic(negativeShift)
+	}
+	switch b.op {
+	case "+": return wrap(x + y, ev.bits)
+	case "-": return wrap(x - y, ev.bits)
+	case "*": return wrap(x * y, ev.bits)
+	case "/": return wrap(x / y, ev.bits)
+	case "%": return x % y
+	case "&": return x & y
+	case "|": return x | y
+	case "^": return x ^ y
+	case "&^": return x &^ y
+	case "<<":
+		if y >= int64(ev.bits) {
+			return int64(0)
+		}
+		return wrap(x << uint(y), ev.bits)
+	case ">>":
+		if y >= int64(ev.bits) {
+			y = int64(ev.bits - 1)
+		}
+		return x >> uint(y)
+	}
+	panic("unknown operator " + b.op)
+}
+func (b *gbinary) constant() bool { return b.x.constant() && b.y.constant() }
+
+// A gcall is a call to one of the functions of the program, or to one
+// of the library's.  If spread isn't nil, it's a call whose results
+// are the arguments.
+
+type gcall struct {
+	f *gfunc
+	args []gexpr
+	spread *gcall
+}
+
+func (c *gcall) String() string {
+	if c.spread != nil {
+		return c.f.name + "(" + c.spread.String() + ")"
+	}
+	var args []string
+	for _,a := range c.args {
+		args = append(args, a.String())
+	}
+	return c.f.name + "(" + strings.Join(args, ", ") + ")"
+}
+func (c *gcall) values(args []interface{}, ev *geval) []interface{} {
+	var vs []interface{}
+	if c.spread != nil {
+		vs = c.spread.values(args, ev)
+	} else {
+		for _,a := range c.args {
+			vs = append(vs, a.value(args, ev))
+		}
+	}
+	return c.f.call(vs, ev)
+}
+func (c *gcall) value(args []interface{}, ev *geval) interface{} { return c.values(args, ev)[0] }
+func (c *gcall) constant() bool { return false }
+
+// A gfunc is a function of the program, or of the library, in which
+// case it has no body, but a builtin to work out what it returns.
+
+type gfunc struct {
+	name string
+	params, results []gtype
+	// If cond isn't "", the body is an if, which returns then if taken
+	// is true, and body otherwise.
+	cond string
+	taken bool
+	then, body []gexpr
+	builtin func(args []interface{}, ev *geval) interface{}
+}
+
+func (f *gfunc) call(args []interface{}, ev *geval) []interface{} {
+	if f.builtin != nil {
+		return []interface{}{f.builtin(args, ev)}
+	}
+	rs := f.body
+	if f.cond != "" && f.taken {
+		rs = f.then
+	}
+	var vs []interface{}
+	for _,r := range rs {
+		vs = append(vs, r.value(args, ev))
+	}
+	return vs
+}
+
+func (f *gfunc) String() string {
+	var params []string
+	for i,t := range f.params {
+		params = append(params, fmt.Sprint("p", i, " ", t))
+	}
+	var results []string
+	for _,t := range f.results {
+		results = append(results, t.String())
+	}
+	rs := strings.Join(results, ", ")
+	if len(results) > 1 {
+		rs = "(" + rs + ")"
+	}
+	ret := func(es []gexpr) string {
+		var s []string
+		for _,e := range es {
+			s = append(s, e.String())
+		}
+		return "return " + strings.Join(s, ", ")
+	}
+	out := "func " + f.name + "(" + strings.Join(params, ", ") + ") " + rs + " {\n"
+	if f.cond != "" {
+		out += "\tif " + f.cond + " {\n\t\t" + ret(f.then) + "\n\t}\n"
+	}
+	return out + "\t" + ret(f.body) + "\n}\n"
+}
+
+// A gstmt is a statement of main: showing an int, printing a string,
+// or calling a function that does some printing.
+
+type gstmt struct {
+	show, print gexpr
+	call *gprinter
+	args []gexpr
+}
+
+// A gprinter is a function that prints some strings.
+
+type gprinter struct {
+	name string
+	params []gtype
+	prints []gexpr
+}
+
+// A Program is a randomly generated program.
+
+type Program struct {
+	Seed int64
+	funcs []*gfunc
+	printers []*gprinter
+	main []gstmt
+}
+
+// Generate generates a program from seed, which will always give the
+// same program.
+func Generate(seed int64) *Program {
+	g := &generator{r: rand.New(rand.NewSource(seed)), p: &Program{Seed: seed}}
+	return g.program()
+}
+
+type generator struct {
+	r *rand.Rand
+	p *Program
+	funcs []*gfunc // those that can be called (so far)
+	params []gtype // those of the function being generated
+	calls int // how many more calls it may make
+}
+
+var words = []string{"", "a", "ab", "xy", "gopher", "12", "-7", "panic"}
+
+var library = []*gfunc{
+	&gfunc{name: "strconv.Itoa", params: []gtype{gInt}, results: []gtype{gString},
+		builtin: func(args []interface{}, ev *geval) interface{} {
+			return fmt.Sprint(args[0].(int64))
+		}},
+	&gfunc{name: "strings.Index", params: []gtype{gString, gString}, results: []gtype{gInt},
+		builtin: func(args []interface{}, ev *geval) interface{} {
+			return int64(strings.Index(args[0].(string), args[1].(string)))
+		}},
+}
+
+func (g *generator) program() *Program {
+	id := &gfunc{name: "id", params: []gtype{gInt}, results: []gtype{gInt}, body: []gexpr{gparam(0)}}
+	g.p.funcs = append(g.p.funcs, id)
+	g.funcs = append(g.funcs, id)
+	for i:=0; i<2+g.r.Intn(8); i++ {
+		f := g.function(fmt.Sprint("f", i))
+		g.p.funcs = append(g.p.funcs, f)
+		g.funcs = append(g.funcs, f)
+	}
+	for i:=0; i<g.r.Intn(3); i++ {
+		pr := &gprinter{name: fmt.Sprint("say", i)}
+		g.params, g.calls = g.types(1+g.r.Intn(3)), 2
+		for j:=0; j<1+g.r.Intn(3); j++ {
+			pr.prints = append(pr.prints, g.expr(gString, 3))
+		}
+		pr.params = g.params
+		g.p.printers = append(g.p.printers, pr)
+	}
+	g.params = nil
+	for i:=0; i<3+g.r.Intn(6); i++ {
+		s := g.statement()
+		for tries:=0; s.ambiguous() && tries < 10; tries++ {
+			s = g.statement()
+		}
+		if s.ambiguous() {
+			s = gstmt{print: gliteral(words[g.r.Intn(len(words))])}
+		}
+		g.p.main = append(g.p.main, s)
+	}
+	return g.p
+}
+
+// statement generates a statement of main.
+func (g *generator) statement() (s gstmt) {
+	g.calls = 3
+	switch n := g.r.Intn(6); {
+	case n < 3:
+		s.show = g.expr(gInt, 4)
+	case n < 5 || len(g.p.printers) == 0:
+		s.print = g.expr(gString, 3)
+	default:
+		s.call = g.p.printers[g.r.Intn(len(g.p.printers))]
+		for _,t := range s.call.params {
+			s.args = append(s.args, g.expr(t, 2))
+		}
+	}
+	return
+}
+
+// run works out what s prints, and adds anything it shows to sum,
+// stopping if it panics.
+func (s gstmt) run(ev *geval, sum *int64) string {
+	switch {
+	case s.show != nil:
+		v := s.show.value(nil, ev).(int64)
+		if len(ev.panics) > 0 {
+			return ""
+		}
+		*sum = wrap(*sum*31 + v, ev.bits)
+		return fmt.Sprint(v, "\n")
+	case s.print != nil:
+		v := s.print.value(nil, ev).(string)
+		if len(ev.panics) > 0 {
+			return ""
+		}
+		return v + "\n"
+	}
+	var args []interface{}
+	for _,a := range s.args {
+		args = append(args, a.value(nil, ev))
+	}
+	out := ""
+	for _,e := range s.call.prints {
+		if len(ev.panics) > 0 {
+			break
+		}
+		v := e.value(args, ev).(string)
+		if len(ev.panics) == 0 {
+			out += v + "\n"
+		}
+	}
+	return out
+}
+
+// ambiguous tells whether s could panic in two different ways at
+// once, when go doesn't say which would come first.
+func (s gstmt) ambiguous() bool {
+	for _,bits := range []uint{32, 64} {
+		ev := &geval{bits: bits}
+		s.run(ev, new(int64))
+		if len(ev.panics) > 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *generator) types(n int) (ts []gtype) {
+	for i:=0; i<n; i++ {
+		ts = append(ts, gtype(g.r.Intn(3)/2))
+	}
+	return
+}
+
+// function generates a function, which may call any of those before
+// it.
+func (g *generator) function(name string) *gfunc {
+	f := &gfunc{name: name}
+	// Every function has an int parameter, so that it has something
+	// that isn't constant to work with.
+	f.params = append([]gtype{gInt}, g.types(g.r.Intn(4))...)
+	f.results = g.types(1)
+	if g.r.Intn(4) == 0 {
+		f.results = []gtype{gInt, gInt}
+	}
+	g.params, g.calls = f.params, 2
+	for _,t := range f.results {
+		f.body = append(f.body, g.expr(t, 3))
+	}
+	if g.r.Intn(4) == 0 {
+		ops := []string{"<", "<=", "==", "!=", ">", ">="}
+		a, b, op := g.r.Intn(5), g.r.Intn(5), ops[g.r.Intn(len(ops))]
+		f.cond = fmt.Sprint(a, " ", op, " ", b)
+		f.taken = map[string]bool{"<": a < b, "<=": a <= b, "==": a == b,
+			"!=": a != b, ">": a > b, ">=": a >= b}[op]
+		for _,t := range f.results {
+			f.then = append(f.then, g.expr(t, 3))
+		}
+	}
+	return f
+}
+
+// expr generates an expression of type t, of at most depth levels.
+func (g *generator) expr(t gtype, depth int) gexpr {
+	if t == gString {
+		return g.str(depth)
+	}
+	return g.integer(depth, false)
+}
+
+// param returns one of the parameters of type t, or nil if there are
+// none.
+func (g *generator) param(t gtype) gexpr {
+	var ps []gexpr
+	for i,pt := range g.params {
+		if pt == t {
+			ps = append(ps, gparam(i))
+		}
+	}
+	if len(ps) == 0 {
+		return nil
+	}
+	return ps[g.r.Intn(len(ps))]
+}
+
+// call returns a call to one of the functions that return a t, or nil
+// if we've made enough calls already.
+func (g *generator) call(t gtype, depth int) gexpr {
+	var fs []*gfunc
+	for _,f := range append(g.funcs, library...) {
+		if len(f.results) == 1 && f.results[0] == t {
+			fs = append(fs, f)
+		}
+	}
+	if g.calls == 0 || len(fs) == 0 {
+		return nil
+	}
+	g.calls--
+	f := fs[g.r.Intn(len(fs))]
+	c := &gcall{f: f}
+	if len(f.params) == 2 && f.params[0] == gInt && f.params[1] == gInt {
+		for _,s := range g.funcs {
+			if len(s.results) == 2 && g.calls > 0 && g.r.Intn(2) == 0 {
+				g.calls--
+				c.spread = &gcall{f: s}
+				for _,pt := range s.params {
+					c.spread.args = append(c.spread.args, g.expr(pt, depth-1))
+				}
+				return c
+			}
+		}
+	}
+	for _,pt := range f.params {
+		c.args = append(c.args, g.expr(pt, depth-1))
+	}
+	return c
+}
+
+// integer generates an int expression, which isn't constant if
+// nonconst is true.
+func (g *generator) integer(depth int, nonconst bool) gexpr {
+	if depth > 0 {
+		switch g.r.Intn(8) {
+		case 0, 1, 2:
+			ops := []string{"+", "-", "*", "/", "%", "&", "|", "^", "&^", "<<", ">>"}
+			op := ops[g.r.Intn(len(ops))]
+			x := g.integer(depth-1, true)
+			var y gexpr
+			switch op {
+			case "/", "%", "<<", ">>":
+				// Anything will do, but a program that panics
+				// stops there, so mostly we make sure it won't.
+				y = g.integer(depth-1, true)
+				switch {
+				case g.r.Intn(4) == 0:
+				case op == "/" || op == "%":
+					y = &gbinary{"|", &gbinary{"&", y, gconst(15)}, gconst(-16)}
+				default:
+					y = &gbinary{"&", y, gconst(127)}
+				}
+			default:
+				y = g.integer(depth-1, false)
+				if g.r.Intn(2) == 0 {
+					x, y = y, x
+				}
+			}
+			return &gbinary{op, x, y}
+		case 3:
+			ops := []string{"-", "^"}
+			return &gunary{ops[g.r.Intn(2)], g.integer(depth-1, true)}
+		case 4, 5:
+			if c := g.call(gInt, depth); c != nil {
+				return c
+			}
+		case 6:
+			if c := g.call(gString, depth); c != nil && c.(*gcall).f.name == "strconv.Itoa" {
+				return &gcall{f: atoi, args: []gexpr{c}}
+			}
+		}
+	}
+	if p := g.param(gInt); p != nil && (nonconst || g.r.Intn(3) > 0) {
+		return p
+	}
+	if nonconst {
+		return &gcall{f: g.funcs[0], args: []gexpr{g.integer(0, false)}}
+	}
+	switch g.r.Intn(10) {
+	case 0:
+		edges := []int64{2147483647, -2147483648, 65535, 1 << 20}
+		return gconst(edges[g.r.Intn(len(edges))])
+	}
+	return gconst(g.r.Intn(41) - 20)
+}
+
+// atoi is only ever given what strconv.Itoa gave back, so that go's
+// idea of what isn't a number doesn't matter.
+var atoi = &gfunc{name: "strconv.Atoi", params: []gtype{gString}, results: []gtype{gInt},
+	builtin: func(args []interface{}, ev *geval) interface{} {
+		var n int64
+		fmt.Sscan(args[0].(string), &n)
+		return n
+	}}
+
+var repeat = &gfunc{name: "strings.Repeat", params: []gtype{gString, gInt}, results: []gtype{gString},
+	builtin: func(args []interface{}, ev *geval) interface{} {
+		return strings.Repeat(args[0].(string), int(args[1].(int64)))
+	}}
+
+// str generates a string expression.
+func (g *generator) str(depth int) gexpr {
+	if depth > 0 {
+		switch g.r.Intn(4) {
+		case 0:
+			if c := g.call(gString, depth); c != nil {
+				return c
+			}
+		case 1:
+			count := gexpr(gconst(g.r.Intn(4)))
+			if g.r.Intn(2) == 0 {
+				count = &gbinary{"&", g.integer(depth-1, true), gconst(3)}
+			}
+			return &gcall{f: repeat, args: []gexpr{g.str(depth-1), count}}
+		}
+	}
+	if p := g.param(gString); p != nil && g.r.Intn(3) > 0 {
+		return p
+	}
+	return gliteral(words[g.r.Intn(len(words))])
+}
+
+// Source returns the program itself.
+func (p *Program) Source() string {
+	code := ""
+	for _,f := range p.funcs {
+		code += "\n" + f.String()
+	}
+	for _,pr := range p.printers {
+		var params []string
+		for i,t := range pr.params {
+			params = append(params, fmt.Sprint("p", i, " ", t))
+		}
+		code += "\nfunc " + pr.name + "(" + strings.Join(params, ", ") + ") {\n"
+		for _,e := range pr.prints {
+			code += "\tprintln(" + e.String() + ")\n"
+		}
+		code += "}\n"
+	}
+	code += "\nfunc main() {\n"
+	sum := "0"
+	for _,s := range p.main {
+		switch {
+		case s.show != nil:
+			code += "\tshow(" + s.show.String() + ")\n"
+			sum = "check(" + sum + ", " + s.show.String() + ")"
+		case s.print != nil:
+			code += "\tprintln(" + s.print.String() + ")\n"
+		default:
+			var args []string
+			for _,a := range s.args {
+				args = append(args, a.String())
+			}
+			code += "\t" + s.call.name + "(" + strings.Join(args, ", ") + ")\n"
+		}
+	}
+	code += "\tshow(" + sum + ")\n}\n"
+
+	out := fmt.Sprint("// This program was written by harness.Generate(", p.Seed, ").\n\n")
+	out += "package main\n\nimport (\n\t\"strconv\"\n"
+	if strings.Contains(code, "strings.") {
+		// go won't have an import we don't use.
+		out += "\t\"strings\"\n"
+	}
+	out += ")\n\nfunc show(x int) {\n\tprintln(strconv.Itoa(x))\n}\n\n"
+	out += "func check(sum, x int) int {\n\treturn sum*31 + x\n}\n"
+	return out + code
+}
+
+// Output returns what the program should print, when an int has bits
+// bits, and why it panics, if it does.
+func (p *Program) Output(bits uint) (out, panicked string) {
+	sum := int64(0)
+	for _,s := range p.main {
+		ev := &geval{bits: bits}
+		out += s.run(ev, &sum)
+		if len(ev.panics) > 0 {
+			return out, ev.panics[0]
+		}
+	}
+	return out + fmt.Sprint(sum, "\n"), ""
+}
+
+// TestSource returns the program with the comments that say what it should
+// do, for the harness, when compiled for arch, which is "386" or
+// "amd64".
+func (p *Program) TestSource(arch string) string {
+	bits, flags := uint(32), []string{"-O1", "-O0", "--regabi", "--native"}
+	if arch == "amd64" {
+		bits = 64
+		for i := range flags {
+			flags[i] = "-arch=amd64 " + flags[i]
+		}
+	}
+	out := p.Source() + "\n"
+	for _,f := range flags {
+		out += "// Flags: " + f + "\n"
+	}
+	stderr, panicked := p.Output(bits)
+	if panicked != "" {
+		out += "// Panic: " + panicked + "\n"
+	}
+	if stderr == "" {
+		return out
+	}
+	out += "// Stderr:\n"
+	for _,l := range strings.Split(stderr[:len(stderr)-1], "\n", -1) {
+		if l == "" {
+			out += "//\n"
+		} else {
+			out += "// " + l + "\n"
+		}
+	}
+	return out
+}
@@ -0,0 +1,6 @@
+// Package errors is gogo's errors, for the go toolchain.
+package errors
+
+func New(text string) string {
+	return text
+}
@@ -0,0 +1,42 @@
+// Package os is gogo's os, for the go toolchain, so that a test
+// program can be compiled by both and the results compared.
+package os
+
+import (
+	realos "os"
+	"gogo/syscall"
+)
+
+func Exit(code int) {
+	realos.Exit(code)
+}
+
+func Stdin() int {
+	return 0
+}
+
+func Stdout() int {
+	return 1
+}
+
+func Stderr() int {
+	return 2
+}
+
+var Args = realos.Args
+
+func Open(name string, flag int, perm int) int {
+	return syscall.Open(name, flag, perm)
+}
+
+func Read(fd int, n int) string {
+	return syscall.Read(fd, n)
+}
+
+func Write(fd int, s string) int {
+	return syscall.Write(fd, s)
+}
+
+func Close(fd int) int {
+	return syscall.Close(fd)
+}
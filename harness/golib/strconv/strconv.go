@@ -0,0 +1,20 @@
+// Package strconv is gogo's strconv, for the go toolchain.
+package strconv
+
+import "strconv"
+
+func Itoa(i int) string {
+	return strconv.Itoa(i)
+}
+
+// Atoi returns the value of the decimal number s, or zero if s isn't
+// a number, just as gogo's does.
+func Atoi(s string) int {
+	for i,c := range s {
+		if (c < '0' || c > '9') && !(i == 0 && c == '-') {
+			return 0
+		}
+	}
+	n,_ := strconv.Atoi(s)
+	return n
+}
@@ -0,0 +1,17 @@
+// Package strings is gogo's strings, for the go toolchain.
+package strings
+
+import "strings"
+
+func Index(s, sep string) int {
+	return strings.Index(s, sep)
+}
+
+// Repeat returns "" for a negative count, as gogo's does, where go's
+// would panic.
+func Repeat(s string, count int) string {
+	if count <= 0 {
+		return ""
+	}
+	return strings.Repeat(s, count)
+}
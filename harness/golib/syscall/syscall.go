@@ -0,0 +1,63 @@
+// Package syscall is gogo's syscall, for the go toolchain.  The
+// programs are built for 386, so the system call numbers are the same
+// as gogo's.
+package syscall
+
+import (
+	realos "os"
+	"syscall"
+)
+
+const (
+	SYS_EXIT = 1
+	SYS_READ = 3
+	SYS_WRITE = 4
+	SYS_OPEN = 5
+	SYS_CLOSE = 6
+)
+
+func Syscall(trap, a1, a2, a3 int) int {
+	if trap == SYS_EXIT {
+		// A gogo program has only the one thread, so exit ends it,
+		// but a go program has several.
+		realos.Exit(a1)
+	}
+	r,_,e := syscall.Syscall(uintptr(trap), uintptr(a1), uintptr(a2), uintptr(a3))
+	if e != 0 {
+		return -int(e)
+	}
+	return int(r)
+}
+
+func Read(fd int, n int) string {
+	buf := make([]byte, n)
+	n,_ = syscall.Read(fd, buf)
+	if n < 0 {
+		n = 0
+	}
+	return string(buf[:n])
+}
+
+func Write(fd int, s string) int {
+	n,err := syscall.Write(fd, []byte(s))
+	if err != nil {
+		return -int(err.(syscall.Errno))
+	}
+	return n
+}
+
+func Open(path string, mode int, perm int) int {
+	fd,err := syscall.Open(path, mode, uint32(perm))
+	if err != nil {
+		return -int(err.(syscall.Errno))
+	}
+	return fd
+}
+
+func Close(fd int) int {
+	return Syscall(SYS_CLOSE, fd, 0, 0)
+}
+
+func Exit(code int) {
+	Syscall(SYS_EXIT, code, 0, 0)
+}
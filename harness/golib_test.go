@@ -0,0 +1,87 @@
+package harness
+
+import (
+	"path"
+	"testing"
+	"go/ast"
+	"go/token"
+	"go/parser"
+	"io/ioutil"
+)
+
+// golib is a copy of gogo's library written by hand for the go
+// toolchain, so it had better have the same API as the real one, or
+// CompareAll will be comparing two different programs.
+
+// exported returns what the package in dir exports, saying what each
+// name is: a func, var, const or type.
+func exported(t *testing.T, dir string) map[string]string {
+	pkgs,err := parser.ParseDir(token.NewFileSet(), dir, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]string)
+	for _,p := range pkgs {
+		for _,f := range p.Files {
+			for _,d := range f.Decls {
+				switch d := d.(type) {
+				case *ast.FuncDecl:
+					if d.Recv == nil && ast.IsExported(d.Name.Name) {
+						names[d.Name.Name] = "func"
+					}
+				case *ast.GenDecl:
+					for _,s := range d.Specs {
+						switch s := s.(type) {
+						case *ast.TypeSpec:
+							if ast.IsExported(s.Name.Name) {
+								names[s.Name.Name] = "type"
+							}
+						case *ast.ValueSpec:
+							for _,n := range s.Names {
+								if ast.IsExported(n.Name) {
+									names[n.Name] = d.Tok.String()
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return names
+}
+
+// TestGolib checks that each package in golib exports just what the
+// same package in lib does, and that there's one for each.
+func TestGolib(t *testing.T) {
+	pkgs,err := ioutil.ReadDir("../lib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _,pkg := range pkgs {
+		if !pkg.IsDirectory() {
+			continue
+		}
+		ours := exported(t, path.Join("../lib", pkg.Name))
+		theirs := exported(t, path.Join("golib", pkg.Name))
+		for name,what := range ours {
+			if theirs[name] != what {
+				t.Errorf("lib/%s has a %s %s, but golib/%s doesn't", pkg.Name, what, name, pkg.Name)
+			}
+		}
+		for name,what := range theirs {
+			if _,ok := ours[name]; !ok {
+				t.Errorf("golib/%s has a %s %s, but lib/%s doesn't", pkg.Name, what, name, pkg.Name)
+			}
+		}
+	}
+	golib,err := ioutil.ReadDir("golib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _,pkg := range golib {
+		if _,err := ioutil.ReadDir(path.Join("../lib", pkg.Name)); err != nil {
+			t.Errorf("golib/%s isn't in lib", pkg.Name)
+		}
+	}
+}
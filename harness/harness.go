@@ -0,0 +1,674 @@
+// Package harness runs gogo's test programs, and checks that each one
+// compiles (or fails to compile) and runs as it says it should.  What
+// a program should do is written in comments in the program itself,
+// usually at the end, so that they don't move any line numbers:
+//
+//	// Flags: -O0
+//	// Flags: -arch=amd64
+//	// Args: hello
+//	// Exit: 3
+//	// Output:
+//	// what it should write to stdout
+//	// Stderr:
+//	// what it should write to stderr
+//	// Args: hello world
+//	// Exit: 4
+//
+// Each Flags line is one way of compiling it (and with no Flags line
+// we compile it just the once, with no flags at all), and each Args
+// line starts a case, one way of running it, which should behave just
+// the same however it was compiled.  A block such as Output runs until
+// the next directive or the next line that isn't a comment.  Whatever
+// output a case doesn't give should be empty, and Exit defaults to
+// zero.  A program with no
+// Args line has just the one case, with no arguments.  A case that
+// should panic, but whose traceback we don't care about, can say so
+// instead,
+//
+//	// Panic: runtime error: integer divide by zero
+//
+// which means that it should exit with 2, once it has written its
+// Stderr and then "panic: " and why.
+//
+// We can also check what gogo makes of the program, as well as what
+// the program does:
+//
+//	// Flags: -v --inline-budget 0
+//	// Asm: call main_echo
+//	// NoAsm in main_main: \(%esp\)
+//	// Log: Removed unused function main_unused
+//	// NoFile: inline.o
+//	// Dump:
+//	// what gogo should write to stdout, such as with --dump-ir
+//
+// Asm says that some line of the assembly should match a regular
+// expression, and NoAsm that none should, looking at just one function
+// if it says which.  Log is a line gogo should write to stderr, and
+// NoFile a file it shouldn't write.  Each of these belongs to the
+// Flags line before it, or to every way of compiling the program if
+// it comes before any Flags line.  A program compiled with --dump-ir
+// isn't run, since gogo doesn't write one, and one compiled with -c is
+// linked by us.  A program that shouldn't compile instead lists the
+// errors it should get, with their positions:
+//
+//	// Errors:
+//	// typecheck.go:19:20: constant 256 overflows uint8
+//
+// A test can also come with some C, X.c, which we compile and link
+// with it, for whichever machine it's compiled for.  What can only be
+// seen in the binary, such as its symbols, binary_test.go checks with
+// our own ELF reader.
+//
+// The compiler keeps its state in globals, so each compilation runs
+// the gogo executable in a process of its own, which is also what lets
+// us run the tests in parallel.
+package harness
+
+import (
+	"os"
+	"fmt"
+	"exec"
+	"path"
+	"sort"
+	"regexp"
+	"strings"
+	"strconv"
+	"io/ioutil"
+)
+
+// A Test is one of the test programs, and what it should do.
+
+type Test struct {
+	Name string // the name of the program, such as println.go
+	Dir string // the directory it is in
+	Compiles []*Compile // each way of compiling it
+	Cases []*Case // each way of running it, however it was compiled
+	Errors []string // the errors it should fail to compile with
+	C string // the C that goes with it, if there is any
+}
+
+// A Compile is one way of compiling a Test, and what gogo should make
+// of it.
+
+type Compile struct {
+	Flags []string
+	Asm []Asm // what the assembly should and shouldn't have in it
+	Log []string // the lines gogo should write to stderr
+	Dump string // what gogo should write to stdout
+	NoFile []string // the files gogo shouldn't write
+}
+
+// An Asm says that some line of the assembly should match Pattern, or
+// that none should if Not is set, looking only at the function Func
+// if there is one.
+
+type Asm struct {
+	Pattern *regexp.Regexp
+	Func string
+	Not bool
+}
+
+// A Case is one way of running a Test, and what it should do.
+
+type Case struct {
+	Args []string
+	Stdout, Stderr string
+	Exit int
+	Panic string // why it should panic, if it should but we don't care where
+}
+
+// Parse reads the test program called name in dir.
+func Parse(dir, name string) (*Test, os.Error) {
+	src,err := ioutil.ReadFile(path.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+	t := &Test{Name: name, Dir: dir}
+	base := name[:len(name)-len(".go")]
+	if exists(path.Join(dir, base+".c")) {
+		t.C = path.Join(dir, base+".c")
+	}
+	every := &Compile{} // what's said before any Flags line
+	c := every
+	var k *Case
+	thecase := func() *Case {
+		if k == nil {
+			k = &Case{}
+			t.Cases = append(t.Cases, k)
+		}
+		return k
+	}
+	var block *string // the Output, Stderr, Dump or Errors we are in the middle of
+	var errors string
+	for n,line := range strings.Split(string(src), "\n", -1) {
+		if !strings.HasPrefix(line, "//") {
+			block = nil
+			continue
+		}
+		text := line[2:]
+		if strings.HasPrefix(text, " ") {
+			text = text[1:]
+		}
+		colon := strings.Index(text, ":")
+		directive, rest, in := "", "", ""
+		if colon > 0 {
+			directive, rest = text[:colon], strings.TrimSpace(text[colon+1:])
+		}
+		if f := strings.Fields(directive); len(f) == 3 && (f[0] == "Asm" || f[0] == "NoAsm") && f[1] == "in" {
+			directive, in = f[0], f[2]
+		}
+		fail := func(why string) (*Test, os.Error) {
+			return nil, os.NewError(fmt.Sprint(name, ":", n+1, ": ", why))
+		}
+		switch directive {
+		case "Flags":
+			c = &Compile{Flags: strings.Fields(rest)}
+			t.Compiles = append(t.Compiles, c)
+		case "Asm", "NoAsm":
+			re,err := regexp.Compile(rest)
+			if err != nil {
+				return fail("bad regular expression " + rest + ": " + err.String())
+			}
+			c.Asm = append(c.Asm, Asm{re, in, directive == "NoAsm"})
+		case "Log":
+			c.Log = append(c.Log, rest)
+		case "NoFile":
+			c.NoFile = append(c.NoFile, rest)
+		case "Args":
+			k = nil
+			thecase().Args = strings.Fields(rest)
+		case "Exit":
+			thecase().Exit,err = strconv.Atoi(rest)
+			if err != nil {
+				return fail("bad exit status " + rest)
+			}
+		case "Panic":
+			thecase()
+			k.Panic, k.Exit = rest, 2
+		case "Output", "Stderr", "Dump", "Errors":
+			if rest != "" {
+				return fail(directive + " should be on a line of its own")
+			}
+			switch directive {
+			case "Output":
+				block = &thecase().Stdout
+			case "Stderr":
+				block = &thecase().Stderr
+			case "Dump":
+				block = &c.Dump
+			default:
+				block = &errors
+			}
+			continue
+		default:
+			if block != nil {
+				*block += text + "\n"
+			}
+			continue
+		}
+		block = nil
+	}
+	if errors != "" {
+		t.Errors = strings.Split(strings.TrimRight(errors, "\n"), "\n", -1)
+		if t.Cases != nil {
+			return nil, os.NewError(name + " can't both fail to compile and run")
+		}
+	} else if t.Cases == nil {
+		t.Cases = []*Case{&Case{}}
+	}
+	if len(t.Compiles) == 0 {
+		t.Compiles = []*Compile{every}
+	} else {
+		for _,c := range t.Compiles {
+			c.Asm = append(append([]Asm{}, every.Asm...), c.Asm...)
+			c.Log = append(append([]string{}, every.Log...), c.Log...)
+			c.NoFile = append(append([]string{}, every.NoFile...), c.NoFile...)
+			c.Dump = every.Dump + c.Dump
+		}
+	}
+	return t, nil
+}
+
+func exists(fn string) bool {
+	_,err := os.Stat(fn)
+	return err == nil
+}
+
+// Find finds every test program in dir, in alphabetical order.
+func Find(dir string) (tests []*Test, err os.Error) {
+	fis,err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _,fi := range fis {
+		if fi.IsRegular() && strings.HasSuffix(fi.Name, ".go") {
+			names = append(names, fi.Name)
+		}
+	}
+	sort.SortStrings(names)
+	for _,name := range names {
+		t,err := Parse(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		tests = append(tests, t)
+	}
+	return
+}
+
+// run runs argv in dir, with our environment and then env, and
+// returns what it wrote to stdout and stderr, and its exit status.
+func run(dir string, env []string, argv ...string) (stdout, stderr string, status int, err os.Error) {
+	cmd := argv[0]
+	if !strings.Contains(cmd, "/") {
+		cmd,err = exec.LookPath(cmd)
+		if err != nil {
+			return
+		}
+	}
+	env = append(os.Environ(), env...)
+	p,err := exec.Run(cmd, argv, env, dir, exec.DevNull, exec.Pipe, exec.Pipe)
+	if err != nil {
+		return
+	}
+	// We read stderr while we're reading stdout, in case whatever
+	// we're running fills up the pipe.
+	errc := make(chan []byte)
+	go func() {
+		b,_ := ioutil.ReadAll(p.Stderr)
+		errc <- b
+	}()
+	out,err := ioutil.ReadAll(p.Stdout)
+	stderr = string(<-errc)
+	if err != nil {
+		return
+	}
+	w,err := p.Wait(0)
+	if err != nil {
+		return
+	}
+	p.Close()
+	return string(out), stderr, w.ExitStatus(), nil
+}
+
+func copyFile(to, from string) os.Error {
+	src,err := ioutil.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(to, src, 0666)
+}
+
+// A problems collects everything that went wrong with a Test.
+
+type problems []string
+
+func (ps *problems) add(format string, args ...interface{}) {
+	*ps = append(*ps, fmt.Sprintf(format, args...))
+}
+func (ps *problems) check(what, want, got string) {
+	if want != got {
+		ps.add("%s differs:\n%s", what, Diff(want, got))
+	}
+}
+func (ps problems) error() os.Error {
+	if len(ps) == 0 {
+		return nil
+	}
+	return os.NewError(strings.Join(ps, "\n"))
+}
+
+// Run copies t into dir, and compiles and runs it there, using the gogo at ../go, and
+// returns everything that went wrong with it.
+func (t *Test) Run(dir string) os.Error {
+	var ps problems
+	base := t.Name[:len(t.Name)-len(".go")]
+	if err := t.copy(dir); err != nil {
+		return err
+	}
+	for _,c := range t.Compiles {
+		how := c.how()
+		stdout,stderr,status,err := t.compile(dir, c.Flags)
+		if err != nil {
+			return err
+		}
+		if t.Errors != nil {
+			var errors []string
+			for _,l := range strings.Split(stderr, "\n", -1) {
+				if strings.HasPrefix(l, t.Name + ":") {
+					errors = append(errors, l)
+				}
+			}
+			if status == 0 {
+				ps.add("compiling%s should have failed", how)
+			}
+			ps.check("compile errors"+how, strings.Join(t.Errors, "\n"), strings.Join(errors, "\n"))
+			continue
+		}
+		if status != 0 {
+			ps.add("compiling%s failed:\n%s", how, stderr)
+			continue
+		}
+		ps.check("gogo's output"+how, c.Dump, stdout)
+		logged := make(map[string]bool)
+		for _,l := range strings.Split(stderr, "\n", -1) {
+			logged[l] = true
+		}
+		for _,l := range c.Log {
+			if !logged[l] {
+				ps.add("compiling%s didn't say %q", how, l)
+			}
+		}
+		for _,fn := range c.NoFile {
+			if exists(path.Join(dir, fn)) {
+				ps.add("compiling%s wrote %s", how, fn)
+			}
+		}
+		if len(c.Asm) > 0 {
+			asm,err := ioutil.ReadFile(path.Join(dir, base + ".S"))
+			if err != nil {
+				return err
+			}
+			c.checkAsm(&ps, string(asm))
+		}
+		if !c.program() {
+			continue
+		}
+		for _,k := range t.Cases {
+			stdout,stderr,status,err := run(dir, nil, append([]string{"./" + base}, k.Args...)...)
+			if err != nil {
+				return err
+			}
+			what := how + k.how()
+			if status != k.Exit {
+				ps.add("%s%s exited with status %d rather than %d", base, what, status, k.Exit)
+			}
+			ps.check("stdout"+what, k.Stdout, stdout)
+			ps.check("stderr"+what, k.stderr(), k.untilPanic(stderr))
+		}
+	}
+	return ps.error()
+}
+
+// copy copies t, and its C if it has any, into dir.
+func (t *Test) copy(dir string) os.Error {
+	files := []string{t.Name}
+	if t.C != "" {
+		_,c := path.Split(t.C)
+		files = append(files, c)
+	}
+	for _,fn := range files {
+		if err := copyFile(path.Join(dir, fn), path.Join(t.Dir, fn)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// how says how c compiles a program, for saying what went wrong.
+func (c *Compile) how() string {
+	if len(c.Flags) == 0 {
+		return ""
+	}
+	return " with " + strings.Join(c.Flags, " ")
+}
+
+// program says whether compiling with c makes a program to run.
+func (c *Compile) program() bool {
+	return !hasFlag(c.Flags, "--dump-ir")
+}
+
+// checkAsm checks the assembly gogo wrote against c.Asm.
+func (c *Compile) checkAsm(ps *problems, asm string) {
+	lines := strings.Split(asm, "\n", -1)
+	for _,a := range c.Asm {
+		where, found := "", false
+		in := a.Func == "" // whether we are in the function we're looking at
+		for _,l := range lines {
+			switch {
+			case a.Func == "":
+			case strings.HasPrefix(l, a.Func + ":"):
+				in = true
+			case strings.HasPrefix(l, ".Lend_" + a.Func + ":"):
+				in = false
+			}
+			if in && a.Pattern.MatchString(l) {
+				found = true
+				break
+			}
+		}
+		if a.Func != "" {
+			where = " in " + a.Func
+		}
+		switch {
+		case found && a.Not:
+			ps.add("the assembly%s%s has %s in it", c.how(), where, a.Pattern)
+		case !found && !a.Not:
+			ps.add("the assembly%s%s has no %s in it", c.how(), where, a.Pattern)
+		}
+	}
+}
+
+// how says how k runs a program, for saying what went wrong.
+func (k *Case) how() string {
+	if len(k.Args) == 0 {
+		return ""
+	}
+	return " given " + strings.Join(k.Args, " ")
+}
+
+// stderr returns what k should write to stderr, up to and including
+// why it panics, if that's all it says.
+func (k *Case) stderr() string {
+	if k.Panic != "" {
+		return k.Stderr + "panic: " + k.Panic + "\n"
+	}
+	return k.Stderr
+}
+
+// untilPanic returns what of stderr to compare with k.stderr().
+func (k *Case) untilPanic(stderr string) string {
+	if k.Panic != "" {
+		return untilPanic(stderr)
+	}
+	return stderr
+}
+
+// hasFlag says whether flags include any of names.
+func hasFlag(flags []string, names ...string) bool {
+	for _,f := range flags {
+		for _,n := range names {
+			if f == n {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// quiet returns flags without -v, which with gogo run would mix what
+// gogo says it's doing into what the program writes to stderr.
+func quiet(flags []string) (q []string) {
+	for _,f := range flags {
+		if f != "-v" && f != "--verbose" {
+			q = append(q, f)
+		}
+	}
+	return
+}
+
+// goarch returns the machine that gogo compiles for with flags.
+func goarch(flags []string) string {
+	arch := "386"
+	for i,f := range flags {
+		switch {
+		case strings.HasPrefix(f, "-arch=") || strings.HasPrefix(f, "--arch="):
+			arch = f[strings.Index(f, "=")+1:]
+		case (f == "-arch" || f == "--arch") && i+1 < len(flags):
+			arch = flags[i+1]
+		}
+	}
+	return arch
+}
+
+// compile compiles t in dir with flags, using the gogo at ../go, along
+// with its C if it has any, and returns what gogo wrote and how it
+// exited.  With -c gogo only writes an object file, so we link that
+// ourselves, as anyone using -c would.
+func (t *Test) compile(dir string, flags []string) (stdout, stderr string, status int, err os.Error) {
+	base := t.Name[:len(t.Name)-len(".go")]
+	// Nothing a compile before this one left behind should be taken
+	// for what this one made.
+	for _,fn := range []string{base, base + ".o", base + ".S"} {
+		os.Remove(path.Join(dir, fn))
+	}
+	argv := append(append([]string{"../go"}, flags...), t.Name)
+	obj := ""
+	if t.C != "" {
+		obj,err = t.compileC(dir, flags)
+		if err != nil {
+			return
+		}
+	}
+	objectOnly := hasFlag(flags, "-c", "--compile-only")
+	if obj != "" && !objectOnly {
+		argv = append(argv, obj)
+	}
+	stdout,stderr,status,err = run(dir, nil, argv...)
+	if err != nil || status != 0 || !objectOnly {
+		return
+	}
+	emulation := "elf_i386"
+	if goarch(flags) == "amd64" {
+		emulation = "elf_x86_64"
+	}
+	ld := []string{"ld", "-m", emulation, "-o", base, base + ".o"}
+	if obj != "" {
+		ld = append(ld, obj)
+	}
+	_,lderr,ldstatus,err := run(dir, nil, ld...)
+	if err == nil && ldstatus != 0 {
+		err = os.NewError("ld failed:\n" + lderr)
+	}
+	return
+}
+
+// compileC compiles the C that goes with t, for the machine that flags
+// ask for.
+func (t *Test) compileC(dir string, flags []string) (string, os.Error) {
+	base := t.Name[:len(t.Name)-len(".go")]
+	obj, m := base + "-c.o", "-m32"
+	if goarch(flags) == "amd64" {
+		obj, m = base + "-c64.o", "-m64"
+	}
+	_,stderr,status,err := run(dir, nil, "gcc", m, "-fno-pic", "-fno-stack-protector",
+		"-c", "-o", obj, base + ".c")
+	if err == nil && status != 0 {
+		err = os.NewError("gcc failed:\n" + stderr)
+	}
+	return obj, err
+}
+
+// RunAll runs tests, as many as parallel at a time, each in a
+// directory of its own in workdir, using the gogo executable.  It
+// returns the problems with each, or nil for a test that passed.
+func RunAll(tests []*Test, gogo, workdir string, parallel int) []os.Error {
+	return each(tests, gogo, workdir, parallel, func(t *Test, dir string) os.Error {
+		return t.Run(dir)
+	})
+}
+
+func abs(fn string) string {
+	if strings.HasPrefix(fn, "/") {
+		return fn
+	}
+	wd,_ := os.Getwd()
+	return path.Join(wd, fn)
+}
+
+// each calls f for each of tests, as many as parallel at a time, with
+// a directory of its own in workdir, next to the gogo executable.
+func each(tests []*Test, gogo, workdir string, parallel int, f func(t *Test, dir string) os.Error) []os.Error {
+	errs := make([]os.Error, len(tests))
+	gogo = abs(gogo)
+	os.RemoveAll(workdir)
+	if err := os.MkdirAll(workdir, 0777); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	// Each test runs gogo as ../go, which finds its library beside
+	// it.
+	gogodir,_ := path.Split(gogo)
+	os.Symlink(gogo, path.Join(workdir, "go"))
+	os.Symlink(path.Join(gogodir, "lib"), path.Join(workdir, "lib"))
+
+	todo := make(chan int)
+	done := make(chan bool)
+	for w:=0; w<parallel; w++ {
+		go func() {
+			for i := range todo {
+				t := tests[i]
+				t.Dir = abs(t.Dir)
+				dir := path.Join(workdir, t.Name[:len(t.Name)-len(".go")])
+				errs[i] = os.MkdirAll(dir, 0777)
+				if errs[i] == nil {
+					errs[i] = f(t, dir)
+				}
+			}
+			done <- true
+		}()
+	}
+	for i := range tests {
+		todo <- i
+	}
+	close(todo)
+	for w:=0; w<parallel; w++ {
+		<-done
+	}
+	return errs
+}
+
+// Diff returns the lines that differ between want and got, as diff -u
+// would show them, with those only in want marked with - and those
+// only in got marked with +.
+func Diff(want, got string) (out string) {
+	a := strings.Split(want, "\n", -1)
+	b := strings.Split(got, "\n", -1)
+	// common[i][j] is the length of the longest common subsequence of
+	// a[i:] and b[j:].
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i:=len(a)-1; i>=0; i-- {
+		for j:=len(b)-1; j>=0; j-- {
+			switch {
+			case a[i] == b[j]:
+				common[i][j] = common[i+1][j+1] + 1
+			case common[i+1][j] > common[i][j+1]:
+				common[i][j] = common[i+1][j]
+			default:
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			out += " " + a[i] + "\n"
+			i++
+			j++
+		case j == len(b) || (i < len(a) && common[i+1][j] >= common[i][j+1]):
+			out += "-" + a[i] + "\n"
+			i++
+		default:
+			out += "+" + b[j] + "\n"
+			j++
+		}
+	}
+	return
+}
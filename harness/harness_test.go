@@ -0,0 +1,161 @@
+package harness
+
+import (
+	"os"
+	"fmt"
+	"path"
+	"testing"
+	"io/ioutil"
+)
+
+// gogoPath returns the gogo to test: the one in the directory above
+// us, unless $GOGO says to use another.
+func gogoPath() string {
+	if gogo := os.Getenv("GOGO"); gogo != "" {
+		return gogo
+	}
+	return "../go"
+}
+
+// TestPrograms runs every program in ../tests.
+func TestPrograms(t *testing.T) {
+	tests,err := Find("../tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i,err := range RunAll(tests, gogoPath(), "../.testdir", 4) {
+		if err != nil {
+			t.Errorf("%s: %s", tests[i].Name, err)
+		}
+	}
+}
+
+// TestEmulate runs every program that it can in gogo's emulator.
+func TestEmulate(t *testing.T) {
+	tests,err := Find("../tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i,err := range EmulateAll(tests, gogoPath(), "../.testdir/emu", 4) {
+		if err != nil {
+			t.Errorf("%s: %s", tests[i].Name, err)
+		}
+	}
+}
+
+// TestInterpret checks that every program does the same when it's
+// interpreted as when it's compiled.
+func TestInterpret(t *testing.T) {
+	tests,err := Find("../tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i,err := range InterpretAll(tests, gogoPath(), "../.testdir/interp", 4) {
+		if err != nil {
+			t.Errorf("%s: %s", tests[i].Name, err)
+		}
+	}
+}
+
+// TestCompare checks that every program does the same with gogo as
+// it does with the go toolchain, if there is one.
+func TestCompare(t *testing.T) {
+	gotool := GoTool()
+	if gotool == "" {
+		t.Log("there's no go toolchain to compare with")
+		return
+	}
+	tests,err := Find("../tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i,err := range CompareAll(tests, gogoPath(), gotool, "golib", "../.testdir/gc", 4) {
+		if err != nil {
+			t.Errorf("%s: %s", tests[i].Name, err)
+		}
+	}
+}
+
+// TestGenerate runs a few random programs, for both machines, and
+// checks they do what Generate says they will.
+func TestGenerate(t *testing.T) {
+	src := "../.testdir/generated"
+	if err := os.MkdirAll(src, 0777); err != nil {
+		t.Fatal(err)
+	}
+	var tests []*Test
+	for seed:=int64(1); seed<=10; seed++ {
+		p := Generate(seed)
+		for _,arch := range []string{"386", "amd64"} {
+			name := fmt.Sprint("gen-", seed, "-", arch, ".go")
+			err := ioutil.WriteFile(path.Join(src, name), []byte(p.TestSource(arch)), 0666)
+			if err != nil {
+				t.Fatal(err)
+			}
+			test,err := Parse(src, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tests = append(tests, test)
+		}
+	}
+	for i,err := range RunAll(tests, gogoPath(), path.Join(src, "run"), 4) {
+		if err != nil {
+			t.Errorf("%s: %s\n%s", tests[i].Name, err, Generate(int64(i/2+1)).Source())
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests,err := Find("../tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _,test := range tests {
+		switch test.Name {
+		case "library.go":
+			if len(test.Cases) != 1 || len(test.Cases[0].Args) != 1 || test.Cases[0].Args[0] != "hello" ||
+				test.Cases[0].Exit != 3 || test.Cases[0].Stdout != "Goodbye world!\n" {
+				t.Errorf("library.go parsed as %v", test.Cases)
+			}
+		case "typecheck.go":
+			if len(test.Errors) != 4 || test.Cases != nil {
+				t.Errorf("typecheck.go parsed as %v", test)
+			}
+		case "fault.go":
+			if test.C == "" || len(test.Compiles) != 4 || len(test.Cases) != 2 ||
+				test.Cases[1].Args[0] != "5" || test.Cases[1].Exit != 2 {
+				t.Errorf("fault.go parsed as %v", test)
+			}
+		case "inline.go":
+			asm := make([]int, len(test.Compiles))
+			for i,c := range test.Compiles {
+				asm[i] = len(c.Asm)
+			}
+			if fmt.Sprint(asm) != "[2 0 2]" || len(test.Compiles[1].Log) != 1 {
+				t.Errorf("inline.go parsed as %v", test.Compiles)
+			}
+		case "native.go":
+			// What comes before any Flags line is for every way of
+			// compiling it.
+			for _,c := range test.Compiles {
+				if len(c.NoFile) != 1 || c.NoFile[0] != "native.o" {
+					t.Errorf("native.go parsed as %v", c)
+				}
+			}
+		case "regabi.go":
+			c := test.Compiles[len(test.Compiles)-1]
+			if len(c.Asm) != 3 || c.Asm[0].Func != "main_sub" || c.Asm[0].Not || !c.Asm[1].Not {
+				t.Errorf("regabi.go parsed as %v", c)
+			}
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	got := Diff("a\nb\nc\n", "a\nc\nd\n")
+	want := " a\n-b\n c\n+d\n \n"
+	if got != want {
+		t.Errorf("Diff gave\n%s\nrather than\n%s", got, want)
+	}
+}
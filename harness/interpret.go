@@ -0,0 +1,69 @@
+package harness
+
+import (
+	"os"
+	"path"
+)
+
+// gogo's interpreter (gogo run --interp) shares nothing with the
+// compiler but the front end, so a program that does something
+// different when it's compiled points at a bug in the back end.  Like
+// CompareAll, this needs no expectations written down, so we check
+// every program that compiles, with every one of its flags (which can
+// ask for either machine, since the interpreter does ints of either
+// size), apart from those that need C, which we can't interpret.  A
+// program that panics without saying where needn't have the same
+// traceback either way, since a function that was inlined is missing
+// from the compiled program's.
+
+// InterpretAll runs tests both compiled and interpreted, much as
+// RunAll does, and returns how the two differed for each test, or nil
+// for a test where they agreed.
+func InterpretAll(tests []*Test, gogo, workdir string, parallel int) []os.Error {
+	return each(tests, gogo, workdir, parallel, func(t *Test, dir string) os.Error {
+		return t.Interpret(dir)
+	})
+}
+
+// Interpret compiles and runs t in dir, and interprets it there, with
+// the gogo at ../go, and returns how the two differed.
+func (t *Test) Interpret(dir string) os.Error {
+	if t.Errors != nil || t.C != "" {
+		return nil
+	}
+	if err := copyFile(path.Join(dir, t.Name), path.Join(t.Dir, t.Name)); err != nil {
+		return err
+	}
+	base := t.Name[:len(t.Name)-len(".go")]
+	var ps problems
+	for _,c := range t.Compiles {
+		if !c.program() {
+			continue
+		}
+		_,stderr,status,err := t.compile(dir, c.Flags)
+		if err == nil && status != 0 {
+			err = os.NewError("compiling" + c.how() + " failed:\n" + stderr)
+		}
+		if err != nil {
+			return err
+		}
+		for _,k := range t.Cases {
+			how := c.how() + k.how()
+			stdout,stderr,status,err := run(dir, nil, append([]string{"./" + base}, k.Args...)...)
+			if err != nil {
+				return err
+			}
+			argv := append(append(append([]string{"../go"}, quiet(c.Flags)...), "run", "--interp", t.Name), k.Args...)
+			istdout,istderr,istatus,err := run(dir, nil, argv...)
+			if err != nil {
+				return err
+			}
+			if status != istatus {
+				ps.add("%s%s exited with status %d, but %d when interpreted", base, how, status, istatus)
+			}
+			ps.check("stdout"+how+" (- interpreted, + compiled)", istdout, stdout)
+			ps.check("stderr"+how+" (- interpreted, + compiled)", k.untilPanic(istderr), k.untilPanic(stderr))
+		}
+	}
+	return ps.error()
+}
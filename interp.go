@@ -0,0 +1,540 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"strings"
+	"strconv"
+	"go/ast"
+	"go/token"
+	"github.com/droundy/go/types"
+	"github.com/droundy/goopt"
+)
+
+// gogo run --interp foo.go (or -interp) runs foo.go without compiling
+// it at all, by walking its syntax tree, with the types and constants
+// that the type checker worked out for the compiler.  Since the two
+// share nothing but the front end, a program that behaves differently
+// when interpreted points at a bug in the back end, and the harness
+// checks every test program both ways.  The interpreter does what go
+// would, which isn't always what we do yet, and it only runs programs
+// that the compiler would accept.
+//
+// Functions that are written in assembly are done by the interpreter
+// itself, as are the system calls that the library makes.
+
+var interp = goopt.Flag([]string{"--interp"}, []string{},
+	"have gogo run interpret the program rather than compile it", "")
+
+// A value is an int (kept as an int64, whatever the size of a word),
+// a string, a bool or a slice (a []value).
+
+type value interface{}
+
+// An interpreter is a program being interpreted.
+
+type interpreter struct {
+	funcs map[*types.Func]*ast.FuncDecl
+	globals map[types.Object]value // the package-level variables
+	args []string
+	files map[int64]*os.File
+	stack []*frame
+}
+
+// A frame is a call that hasn't returned yet.
+
+type frame struct {
+	fn *types.Func
+	vars map[types.Object]value // the parameters and results
+	results []value
+	pos token.Position // of the statement we're running, for tracebacks
+}
+
+// An exit is how an interpreted program ends, unwinding everything.
+
+type exit int
+
+// Interpret runs pkgs (which have been checked and compiled as usual)
+// as the program exe, and returns its exit status.
+func Interpret(pkgs []*ast.Package, exe string, args []string) (status int) {
+	in := &interpreter{funcs: make(map[*types.Func]*ast.FuncDecl),
+		globals: make(map[types.Object]value),
+		args: append([]string{exe}, args...),
+		files: map[int64]*os.File{0: os.Stdin, 1: os.Stdout, 2: os.Stderr}}
+	var main *types.Func
+	var inits []*types.Func // in the order the packages depend on each other
+	for _,p := range pkgs {
+		for _,f := range p.Files {
+			for _,d := range f.Decls {
+				switch d := d.(type) {
+				case *ast.FuncDecl:
+					fn := Info.Defs[d.Name].(*types.Func)
+					in.funcs[fn] = d
+					if fn.FullName() == "main.main" {
+						main = fn
+					} else if fn.Name() == "init" {
+						inits = append(inits, fn)
+					}
+				case *ast.GenDecl:
+					for _,spec := range d.Specs {
+						if spec,ok := spec.(*ast.ValueSpec); ok && d.Tok == token.VAR {
+							for _,n := range spec.Names {
+								in.globals[Info.Defs[n]] = zero(Info.Defs[n].Type())
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	defer func() {
+		switch x := recover().(type) {
+		case nil:
+		case exit:
+			status = int(x) & 0xFF
+		case *CompileError:
+			fmt.Fprintln(os.Stderr, x)
+			status = 1
+		default:
+			panic(x)
+		}
+	}()
+	for _,fn := range inits {
+		in.call(fn, nil)
+	}
+	in.call(main, nil)
+	return 0
+}
+
+// call calls fn with args, and returns its results.
+func (in *interpreter) call(fn *types.Func, args []value) []value {
+	d := in.funcs[fn]
+	if _,ok := CFuncs[fn]; ok {
+		Unsupported(d, "I can't interpret %s, which is written in C", fn.FullName())
+	}
+	if d.Body == nil {
+		return in.external(fn, args)
+	}
+	sig := fn.Signature()
+	f := &frame{fn: fn, vars: make(map[types.Object]value)}
+	for i,p := range sig.Params.Vars {
+		f.vars[p] = args[i]
+	}
+	for _,r := range sig.Results.Vars {
+		z := zero(r.Type())
+		f.vars[r] = z
+		f.results = append(f.results, z)
+	}
+	in.stack = append(in.stack, f)
+	if !in.statements(d.Body.List) {
+		in.namedResults(f)
+	}
+	in.stack = in.stack[:len(in.stack)-1]
+	return f.results
+}
+
+// namedResults returns whatever the named results of f hold, as a bare
+// return (or falling off the end) does.
+func (in *interpreter) namedResults(f *frame) {
+	for i,r := range f.fn.Signature().Results.Vars {
+		f.results[i] = f.vars[r]
+	}
+}
+
+func zero(t types.Type) value {
+	switch {
+	case types.IsString(t):
+		return ""
+	case types.IsInteger(t):
+		return int64(0)
+	}
+	if _,ok := t.Underlying().(*types.Slice); ok {
+		return []value(nil)
+	}
+	return false
+}
+
+// variable returns where the variable obj lives: in the current frame
+// if it's a parameter or result, and otherwise at the package level.
+func (in *interpreter) variable(e ast.Expr, obj types.Object) map[types.Object]value {
+	if _,ok := in.top().vars[obj]; ok {
+		return in.top().vars
+	}
+	if _,ok := in.globals[obj]; ok {
+		return in.globals
+	}
+	Unsupported(e, "I don't handle variables such as %s", obj.Name())
+	return nil
+}
+
+func (in *interpreter) top() *frame {
+	return in.stack[len(in.stack)-1]
+}
+
+// statements runs list, and tells whether it returned.
+func (in *interpreter) statements(list []ast.Stmt) bool {
+	for _,s := range list {
+		if in.statement(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// statement runs s, and tells whether it returned.
+func (in *interpreter) statement(statement ast.Stmt) bool {
+	f := in.top()
+	f.pos = myfiles.Position(statement.Pos())
+	switch s := statement.(type) {
+	case *ast.EmptyStmt:
+	case *ast.ExprStmt:
+		if call,ok := s.X.(*ast.CallExpr); ok {
+			in.call1(call)
+		} else {
+			in.expression(s.X)
+		}
+	case *ast.ReturnStmt:
+		var results []value
+		if len(s.Results) == 1 && len(f.results) > 1 {
+			results = in.call1(s.Results[0].(*ast.CallExpr))
+		} else {
+			for _,e := range s.Results {
+				results = append(results, in.expression(e))
+			}
+		}
+		if len(results) == 0 {
+			in.namedResults(f)
+		}
+		copy(f.results, results)
+		return true
+	case *ast.AssignStmt:
+		x := in.expression(s.Rhs[0])
+		if id := s.Lhs[0].(*ast.Ident); id.Name != "_" {
+			in.variable(id, Info.Uses[id])[Info.Uses[id]] = x
+		}
+	case *ast.BlockStmt:
+		return in.statements(s.List)
+	case *ast.IfStmt:
+		if s.Init != nil && in.statement(s.Init) {
+			return true
+		}
+		if in.expression(s.Cond).(bool) {
+			return in.statement(s.Body)
+		} else if s.Else != nil {
+			return in.statement(s.Else)
+		}
+	case *ast.DeclStmt:
+		// The type checker has worked out our constants.
+	default:
+		Unsupported(statement, "I can't interpret statements such as: %T", statement)
+	}
+	return false
+}
+
+// expression returns the value of e.
+func (in *interpreter) expression(exp ast.Expr) value {
+	if val := Info.Types[exp].Value; val != nil {
+		return val
+	}
+	switch e := exp.(type) {
+	case *ast.ParenExpr:
+		return in.expression(e.X)
+	case *ast.CallExpr:
+		results := in.call1(e)
+		if len(results) != 1 {
+			Invalid(e, "This call doesn't have a single value")
+		}
+		return results[0]
+	case *ast.Ident:
+		obj := Info.Uses[e]
+		return in.variable(e, obj)[obj]
+	case *ast.SelectorExpr:
+		obj := Info.Uses[e.Sel]
+		return in.variable(e, obj)[obj]
+	case *ast.IndexExpr:
+		x := in.expression(e.X).([]value)
+		i := in.expression(e.Index).(int64)
+		if i < 0 {
+			in.panic(fmt.Sprintf("runtime error: index out of range [%d]", i))
+		} else if i >= int64(len(x)) {
+			in.panic(fmt.Sprintf("runtime error: index out of range [%d] with length %d", i, len(x)))
+		}
+		return x[i]
+	case *ast.UnaryExpr:
+		x := in.expression(e.X)
+		switch e.Op {
+		case token.ADD:
+			return x
+		case token.SUB:
+			return in.word(-x.(int64))
+		case token.XOR:
+			return ^x.(int64)
+		case token.NOT:
+			return !x.(bool)
+		}
+		Unsupported(e, "I don't handle the unary %s operator", e.Op)
+	case *ast.BinaryExpr:
+		return in.binary(e)
+	}
+	Unsupported(exp, "I can't interpret expressions such as: %T", exp)
+	return nil
+}
+
+// word wraps x around as an int would.
+func (in *interpreter) word(x int64) int64 {
+	if types.Target.WordSize == 4 {
+		return int64(int32(x))
+	}
+	return x
+}
+
+func (in *interpreter) binary(e *ast.BinaryExpr) value {
+	switch e.Op {
+	case token.LAND:
+		return in.expression(e.X).(bool) && in.expression(e.Y).(bool)
+	case token.LOR:
+		return in.expression(e.X).(bool) || in.expression(e.Y).(bool)
+	}
+	x, y := in.expression(e.X), in.expression(e.Y)
+	switch x := x.(type) {
+	case string:
+		y := y.(string)
+		switch e.Op {
+		case token.ADD:
+			return x + y
+		case token.EQL:
+			return x == y
+		case token.NEQ:
+			return x != y
+		case token.LSS:
+			return x < y
+		case token.LEQ:
+			return x <= y
+		case token.GTR:
+			return x > y
+		case token.GEQ:
+			return x >= y
+		}
+	case bool:
+		switch e.Op {
+		case token.EQL:
+			return x == y.(bool)
+		case token.NEQ:
+			return x != y.(bool)
+		}
+	case int64:
+		y := y.(int64)
+		switch e.Op {
+		case token.ADD:
+			return in.word(x + y)
+		case token.SUB:
+			return in.word(x - y)
+		case token.MUL:
+			return in.word(x * y)
+		case token.QUO, token.REM:
+			if y == 0 {
+				in.panic("runtime error: integer divide by zero")
+			}
+			if y == -1 {
+				// The most negative int divided by -1 is itself.
+				if e.Op == token.QUO {
+					return in.word(-x)
+				}
+				return int64(0)
+			}
+			if e.Op == token.QUO {
+				return x / y
+			}
+			return x % y
+		case token.AND:
+			return x & y
+		case token.OR:
+			return x | y
+		case token.XOR:
+			return x ^ y
+		case token.AND_NOT:
+			return x &^ y
+		case token.SHL, token.SHR:
+			if y < 0 {
+				in.panic("runtime error: negative shift amount")
+			}
+			if e.Op == token.SHR {
+				return x >> uint(y) // which is all sign for a word or more
+			}
+			if y >= int64(8*types.Target.WordSize) {
+				return int64(0)
+			}
+			return in.word(x << uint(y))
+		case token.EQL:
+			return x == y
+		case token.NEQ:
+			return x != y
+		case token.LSS:
+			return x < y
+		case token.LEQ:
+			return x <= y
+		case token.GTR:
+			return x > y
+		case token.GEQ:
+			return x >= y
+		}
+	}
+	Unsupported(e, "I don't handle the %s operator", e.Op)
+	return nil
+}
+
+// call1 makes the call e, and returns its results.
+func (in *interpreter) call1(e *ast.CallExpr) []value {
+	switch fn := Callee(e).(type) {
+	case *types.Builtin:
+		if fn.Name() == "len" {
+			switch x := in.expression(e.Args[0]).(type) {
+			case string:
+				return []value{int64(len(x))}
+			case []value:
+				return []value{int64(len(x))}
+			}
+		}
+		var s []string
+		for _,a := range e.Args {
+			s = append(s, fmt.Sprint(in.expression(a)))
+		}
+		switch fn.Name() {
+		case "print":
+			in.write(2, strings.Join(s, ""))
+			return nil
+		case "println":
+			in.write(2, strings.Join(s, " ") + "\n")
+			return nil
+		case "panic":
+			in.panic(strings.Join(s, ""))
+		}
+		Unsupported(e, "I don't handle the builtin %s", fn.Name())
+	case *types.Func:
+		var args []value
+		if len(e.Args) == 1 && fn.Signature().Params.Len() > 1 {
+			// As in f(g()), where g returns several values.
+			args = in.call1(e.Args[0].(*ast.CallExpr))
+		} else {
+			for _,a := range e.Args {
+				args = append(args, in.expression(a))
+			}
+		}
+		return in.call(fn, args)
+	}
+	Unsupported(e.Fun, "I don't know how to deal with complicated function: %T", e.Fun)
+	return nil
+}
+
+// panic prints "panic: " and why, and a traceback like the one the
+// runtime prints, and then ends the program.
+func (in *interpreter) panic(why string) {
+	out := "panic: " + why + "\n\n"
+	for i:=len(in.stack)-1; i>=0; i-- {
+		f := in.stack[i]
+		out += fmt.Sprintf("%s()\n\t%s:%d\n", f.fn.FullName(), f.pos.Filename, f.pos.Line)
+	}
+	in.write(2, out)
+	panic(exit(2))
+}
+
+func (in *interpreter) write(fd int64, s string) int64 {
+	f,ok := in.files[fd]
+	if !ok {
+		return -9 // EBADF
+	}
+	n,_ := f.Write([]byte(s))
+	return int64(n)
+}
+
+// These are the system calls that syscall.Syscall can make, for each
+// machine (see lib/syscall/sysnum_*.go).
+var sysExit = map[string]int64{"386": 1, "amd64": 60}
+var sysClose = map[string]int64{"386": 6, "amd64": 3}
+
+// external does what fn, which is written in assembly, would do.
+func (in *interpreter) external(fn *types.Func, args []value) []value {
+	integer := func(i int) int64 {
+		return args[i].(int64)
+	}
+	str := func(i int) string {
+		return args[i].(string)
+	}
+	switch fn.FullName() {
+	case "os.runtime_args":
+		var args []value
+		for _,a := range in.args {
+			args = append(args, a)
+		}
+		return []value{args}
+	case "strconv.Itoa":
+		return []value{strconv.Itoa64(integer(0))}
+	case "strconv.Atoi":
+		// Ours gives zero for anything that isn't a number, and
+		// wraps around a number that's too big.
+		s := str(0)
+		neg := strings.HasPrefix(s, "-")
+		if neg {
+			s = s[1:]
+		}
+		n := int64(0)
+		for _,c := range s {
+			if c < '0' || c > '9' {
+				return []value{int64(0)}
+			}
+			n = in.word(n*10 + int64(c - '0'))
+		}
+		if neg {
+			n = in.word(-n)
+		}
+		return []value{n}
+	case "strings.Index":
+		return []value{int64(strings.Index(str(0), str(1)))}
+	case "strings.Repeat":
+		if integer(1) <= 0 {
+			return []value{""}
+		}
+		return []value{strings.Repeat(str(0), int(integer(1)))}
+	case "syscall.Syscall":
+		switch integer(0) {
+		case sysExit[*arch]:
+			panic(exit(integer(1)))
+		case sysClose[*arch]:
+			f,ok := in.files[integer(1)]
+			if !ok {
+				return []value{int64(-9)} // EBADF
+			}
+			in.files[integer(1)] = nil, false
+			if f.Close() != nil {
+				return []value{int64(-5)} // EIO
+			}
+			return []value{int64(0)}
+		}
+		// We can't do anything that takes a pointer, so it's as though
+		// the kernel didn't have any other calls.
+		return []value{int64(-38)} // ENOSYS
+	case "syscall.Write":
+		return []value{in.write(integer(0), str(1))}
+	case "syscall.Read":
+		f,ok := in.files[integer(0)]
+		if !ok {
+			return []value{""}
+		}
+		buf := make([]byte, integer(1))
+		n,_ := f.Read(buf)
+		return []value{string(buf[:n])}
+	case "syscall.Open":
+		f,err := os.Open(str(0), int(integer(1)), uint32(integer(2)))
+		if err != nil {
+			return []value{int64(-2)} // ENOENT
+		}
+		fd := int64(3)
+		for in.files[fd] != nil {
+			fd++
+		}
+		in.files[fd] = f
+		return []value{fd}
+	}
+	Unsupported(in.funcs[fn], "I can't interpret %s, which is written in assembly", fn.FullName())
+	return nil
+}
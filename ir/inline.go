@@ -0,0 +1,343 @@
+package ir
+
+import (
+	"fmt"
+)
+
+// Inline copies the bodies of small functions into the places that
+// call them, which saves pushing the arguments, the call and the
+// return.  We only inline leaf functions, which call nothing but the
+// print builtins.  A function that defers or recovers must never be
+// inlined (its deferred calls run when it returns, and recover only
+// works when called from a deferred function), and since both of
+// those involve calls, being a leaf rules them out too.
+//
+// budget is the most instructions a function may have and still be
+// inlined, and report is told about each call we get rid of.
+func (p *Program) Inline(budget int, report func(callee, caller *Func)) {
+	inlinable := make(map[string]*Func)
+	for _,f := range p.Funcs {
+		if f.leaf() && f.size() <= budget {
+			inlinable[f.Name] = f
+		}
+	}
+	for _,f := range p.Funcs {
+		changed := false
+		for {
+			b, k, callee := f.inlinableCall(inlinable)
+			if b == nil {
+				break
+			}
+			f.inline(b, k, callee)
+			report(callee, f)
+			changed = true
+		}
+		if changed {
+			f.mergeBlocks()
+			f.forwardLocals()
+		}
+	}
+}
+
+// leaf tells whether f is a function we could inline.
+func (f *Func) leaf() bool {
+	if f.External {
+		return false
+	}
+	for _,b := range f.Blocks {
+		for _,i := range b.Instrs {
+			if c,ok := i.(*Call); ok && c.Func != "print" && c.Func != "println" {
+				return false
+			}
+		}
+	}
+	return true
+}
+func (f *Func) size() (n int) {
+	for _,b := range f.Blocks {
+		for _,i := range b.Instrs {
+			if _,ok := i.(*Line); !ok {
+				n++
+			}
+		}
+	}
+	return
+}
+
+// inlinableCall finds a call in f which we can inline, returning the
+// block it's in, where it is in the block, and what it calls.
+func (f *Func) inlinableCall(inlinable map[string]*Func) (*Block, int, *Func) {
+	for _,b := range f.Blocks {
+		for k,i := range b.Instrs {
+			if c,ok := i.(*Call); ok {
+				if callee,ok := inlinable[c.Func]; ok && callee != f {
+					return b, k, callee
+				}
+			}
+		}
+	}
+	return nil, 0, nil
+}
+
+// inline replaces the call at b.Instrs[k] with a copy of the body of
+// callee.  The callee's parameters and results become locals of f,
+// and each return becomes a jump to a new block holding whatever came
+// after the call, which starts by loading the results.
+func (f *Func) inline(b *Block, k int, callee *Func) {
+	c := b.Instrs[k].(*Call)
+	after := f.NewBlock()
+	after.Instrs = append(after.Instrs, b.Instrs[k+1:]...)
+	after.Succs = b.Succs
+	for _,s := range after.Succs {
+		for j := range s.Preds {
+			if s.Preds[j] == b {
+				s.Preds[j] = after
+			}
+		}
+	}
+	b.Instrs = b.Instrs[:k]
+	b.Succs = nil
+
+	slots := make(map[*Slot]*Slot)
+	for _,s := range callee.Params {
+		slots[s] = f.NewLocal(callee.Name + "." + s.Name, s.Type)
+	}
+	for _,s := range callee.Results {
+		slots[s] = f.NewLocal(callee.Name + "." + s.Name, s.Type)
+	}
+	for _,s := range callee.Locals {
+		slots[s] = f.NewLocal(s.Name, s.Type)
+	}
+	slot := func(s *Slot) *Slot {
+		if l,ok := slots[s]; ok {
+			return l
+		}
+		return s // a package-level variable, which is the same for us
+	}
+	for a,arg := range c.Args {
+		for w,v := range arg {
+			b.Add(&Store{slots[callee.Params[a]], w, v})
+		}
+	}
+	for _,r := range callee.Results {
+		// The caller always zeroes the results, so we do too.
+		for w:=0; w<Words(r.Type); w++ {
+			b.Add(&Store{slots[r], w, Int(0)})
+		}
+	}
+	var loads []Instr
+	for r,ts := range c.Results {
+		for w,t := range ts {
+			loads = append(loads, &Load{t, slots[callee.Results[r]], w})
+		}
+	}
+	after.Instrs = append(loads, after.Instrs...)
+
+	temps := make(map[*Temp]*Temp)
+	temp := func(t *Temp) *Temp {
+		if _,ok := temps[t]; !ok {
+			temps[t] = f.NewTemp()
+		}
+		return temps[t]
+	}
+	value := func(v Value) Value {
+		if t,ok := v.(*Temp); ok {
+			return temp(t)
+		}
+		return v
+	}
+	blocks := make(map[*Block]*Block)
+	var body []*Block
+	for _,cb := range callee.Blocks {
+		blocks[cb] = f.NewBlock()
+		body = append(body, blocks[cb])
+	}
+	for _,cb := range callee.Blocks {
+		nb := blocks[cb]
+		for _,i := range cb.Instrs {
+			switch i := i.(type) {
+			case *Load:
+				nb.Add(&Load{temp(i.Dst), slot(i.Src), i.Word})
+			case *Store:
+				nb.Add(&Store{slot(i.Dst), i.Word, value(i.Src)})
+			case *LoadAt:
+				nb.Add(&LoadAt{temp(i.Dst), value(i.Addr), i.Word})
+			case *IndexCheck:
+				nb.Add(&IndexCheck{value(i.Index), value(i.Len)})
+			case *Call:
+				nc := &Call{i.Func, nil, nil, i.CDecl}
+				for _,a := range i.Args {
+					var na []Value
+					for _,v := range a {
+						na = append(na, value(v))
+					}
+					nc.Args = append(nc.Args, na)
+				}
+				for _,r := range i.Results {
+					var nr []*Temp
+					for _,t := range r {
+						nr = append(nr, temp(t))
+					}
+					nc.Results = append(nc.Results, nr)
+				}
+				nb.Add(nc)
+			case *BinOp:
+				nb.Add(&BinOp{i.Op, temp(i.Dst), value(i.X), value(i.Y)})
+			case *UnOp:
+				nb.Add(&UnOp{i.Op, temp(i.Dst), value(i.X)})
+			case *Syscall:
+				nb.Add(&Syscall{temp(i.Dst), value(i.Trap),
+					[3]Value{value(i.Args[0]), value(i.Args[1]), value(i.Args[2])}})
+			case *Jump:
+				nb.Jump(blocks[i.Target])
+			case *Return:
+				nb.Jump(after)
+			case *Line:
+				// The inlined code counts as part of the line that
+				// called it.
+			default:
+				panic(fmt.Sprint("I don't know how to inline ", i))
+			}
+		}
+	}
+	b.Jump(blocks[callee.Blocks[0]])
+
+	// We keep the blocks in the order they'll run, which saves some
+	// jumps.
+	var order []*Block
+	for _,x := range f.Blocks[:len(f.Blocks)-len(body)-1] {
+		order = append(order, x)
+		if x == b {
+			order = append(order, body...)
+			order = append(order, after)
+		}
+	}
+	f.Blocks = order
+}
+
+// mergeBlocks joins each block that ends by jumping to a block that
+// nothing else jumps to onto that block.
+func (f *Func) mergeBlocks() {
+	for merged := true; merged; {
+		merged = false
+		for _,x := range f.Blocks {
+			j,ok := x.Instrs[len(x.Instrs)-1].(*Jump)
+			if !ok || j.Target == x || j.Target == f.Blocks[0] || len(j.Target.Preds) != 1 {
+				continue
+			}
+			y := j.Target
+			x.Instrs = append(x.Instrs[:len(x.Instrs)-1], y.Instrs...)
+			x.Succs = y.Succs
+			for _,s := range y.Succs {
+				for k := range s.Preds {
+					if s.Preds[k] == y {
+						s.Preds[k] = x
+					}
+				}
+			}
+			var rest []*Block
+			for _,z := range f.Blocks {
+				if z != y {
+					rest = append(rest, z)
+				}
+			}
+			f.Blocks = rest
+			merged = true
+			break
+		}
+	}
+}
+
+// forwardLocals gets rid of what we can of the loads and stores of
+// locals that inlining leaves behind.  Within a block, a load from a
+// local that we've just stored to is just the value we stored, and a
+// store that's overwritten before anyone loads it is pointless, as is
+// any store to a local that nobody ever loads.  Along the way we fold
+// any arithmetic that has become constant.
+func (f *Func) forwardLocals() {
+	local := make(map[*Slot]bool)
+	for _,l := range f.Locals {
+		local[l] = true
+	}
+	same := make(map[*Temp]Value)
+	resolve := func(v Value) Value {
+		for {
+			t,ok := v.(*Temp)
+			if !ok {
+				return v
+			}
+			if _,ok := same[t]; !ok {
+				return v
+			}
+			v = same[t]
+		}
+		panic("unreachable")
+	}
+	for _,b := range f.Blocks {
+		known := make(map[string]Value) // what each word of a local holds
+		stored := make(map[string]int) // where we last stored it, if nobody's loaded it since
+		var out []Instr
+		for _,i := range b.Instrs {
+			Rewrite(i, resolve)
+			if v,ok := Fold(i); ok {
+				same[Defs(i)[0]] = v
+				continue
+			}
+			switch i := i.(type) {
+			case *Store:
+				if local[i.Dst] {
+					w := fmt.Sprint(i.Dst.Name, "[", i.Word, "]")
+					if k,ok := stored[w]; ok && k >= 0 {
+						out[k] = nil
+					}
+					known[w] = i.Src
+					stored[w] = len(out)
+				}
+			case *Load:
+				if local[i.Src] {
+					w := fmt.Sprint(i.Src.Name, "[", i.Word, "]")
+					if v,ok := known[w]; ok {
+						same[i.Dst] = v
+						continue
+					}
+					known[w] = i.Dst
+					stored[w] = -1
+				}
+			}
+			out = append(out, i)
+		}
+		b.Instrs = nil
+		for _,i := range out {
+			if i != nil {
+				b.Instrs = append(b.Instrs, i)
+			}
+		}
+	}
+	// Now we know which locals are ever loaded, we can drop the rest.
+	loaded := make(map[*Slot]bool)
+	for _,b := range f.Blocks {
+		for _,i := range b.Instrs {
+			Rewrite(i, resolve) // in case a use came before we learned of it
+			if l,ok := i.(*Load); ok {
+				loaded[l.Src] = true
+			}
+		}
+	}
+	for _,b := range f.Blocks {
+		var keep []Instr
+		for _,i := range b.Instrs {
+			if s,ok := i.(*Store); ok && local[s.Dst] && !loaded[s.Dst] {
+				continue
+			}
+			keep = append(keep, i)
+		}
+		b.Instrs = keep
+	}
+	var locals []*Slot
+	for _,l := range f.Locals {
+		if loaded[l] {
+			locals = append(locals, l)
+		}
+	}
+	f.Locals = locals
+}
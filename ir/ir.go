@@ -0,0 +1,600 @@
+// Package ir holds gogo's intermediate representation, which sits
+// between the syntax tree and the assembly.  A function is a list of
+// basic blocks, each of which is a list of three-address instructions
+// ending with a jump or a return.  Every value is a single machine
+// word, so a string is a pair of values: its length and a pointer to
+// its bytes.
+package ir
+
+import (
+	"fmt"
+	"go/token"
+	"strings"
+	"unicode"
+	"github.com/droundy/go/types"
+)
+
+// A Program is everything we are compiling: all the functions of all
+// the packages, along with the package-level variables and the string
+// literals they use.
+
+type Program struct {
+	Funcs []*Func
+	Globals []*Slot // the package-level variables, named by their symbols
+	Strings []string // the string literals, in the order we found them
+	symbols map[string]Symbol
+}
+func NewProgram() *Program {
+	return &Program{nil, nil, nil, make(map[string]Symbol)}
+}
+
+// StringLiteral returns the symbol which will hold the bytes of the
+// string literal str.
+func (p *Program) StringLiteral(str string) Symbol {
+	if s,ok := p.symbols[str]; ok {
+		return s
+	}
+	sanitize := func(rune int) int {
+		if unicode.IsLetter(rune) {
+			return rune
+		}
+		return -1
+	}
+	strname := "string_" + strings.Map(sanitize, str)
+	for {
+		// See if our strname is valid...
+		nameexists := false
+		for _,n := range p.symbols {
+			if string(n) == strname {
+				nameexists = true
+			}
+		}
+		if !nameexists {
+			break // we've got a unique name already!
+		}
+		strname = strname + "X"
+	}
+	p.symbols[str] = Symbol(strname)
+	p.Strings = append(p.Strings, str)
+	return Symbol(strname)
+}
+
+// Prune throws away the functions that can't be reached from roots,
+// along with any string literals that only they used, and returns the
+// functions it threw away.  External functions are always kept, since
+// their code comes with their package whether we like it or not.
+func (p *Program) Prune(roots []string) (removed []*Func) {
+	byname := make(map[string]*Func)
+	for _,f := range p.Funcs {
+		byname[f.Name] = f
+	}
+	reached := make(map[string]bool)
+	var reach func(name string)
+	reach = func(name string) {
+		f,ok := byname[name]
+		if !ok || reached[name] {
+			return
+		}
+		reached[name] = true
+		for _,b := range f.Blocks {
+			for _,i := range b.Instrs {
+				if c,ok := i.(*Call); ok {
+					reach(c.Func)
+				}
+			}
+		}
+	}
+	for _,r := range roots {
+		reach(r)
+	}
+	var funcs []*Func
+	used := make(map[Symbol]bool)
+	for _,f := range p.Funcs {
+		if !reached[f.Name] && !f.External {
+			removed = append(removed, f)
+			continue
+		}
+		funcs = append(funcs, f)
+		for _,b := range f.Blocks {
+			for _,i := range b.Instrs {
+				for _,v := range Uses(i) {
+					if s,ok := v.(Symbol); ok {
+						used[s] = true
+					}
+				}
+			}
+		}
+	}
+	p.Funcs = funcs
+	strs := p.Strings
+	symbols := p.symbols
+	p.Strings = nil
+	p.symbols = make(map[string]Symbol)
+	for _,str := range strs {
+		if used[symbols[str]] {
+			p.Strings = append(p.Strings, str)
+			p.symbols[str] = symbols[str]
+		}
+	}
+	return
+}
+
+func (p *Program) String() (out string) {
+	for _,g := range p.Globals {
+		out += "var " + g.Name + " " + g.Type.String() + "\n"
+	}
+	if len(p.Globals) > 0 {
+		out += "\n"
+	}
+	for _,f := range p.Funcs {
+		out += f.String() + "\n"
+	}
+	return
+}
+
+// A Func is a single function, whose parameters and results live on
+// the stack, where the calling convention puts them.
+
+type Func struct {
+	Name string // the symbol for the function, such as main_main
+	Pos string // where the function came from, for the comments
+	Params, Results []*Slot
+	Locals []*Slot // slots of our own, which live in our frame
+	Blocks []*Block // the first block is where we start
+	Temps int // how many temporaries we have used
+	External bool // true if the function is implemented in assembly (or C)
+	CDecl bool // true if the function is written in C, and must be called as C expects
+	Export string // the name by which C code can call us, if it can
+}
+func NewFunc(name string) *Func {
+	return &Func{Name: name}
+}
+func (f *Func) NewTemp() *Temp {
+	t := &Temp{f.Temps}
+	f.Temps++
+	return t
+}
+// NewLocal makes a slot for f's own use, whose name is based on name
+// but won't clash with anything else.
+func (f *Func) NewLocal(name string, t types.Type) *Slot {
+	try := name
+	for n:=2; f.hasSlot(try); n++ {
+		try = fmt.Sprint(name, ".", n)
+	}
+	s := &Slot{try, t}
+	f.Locals = append(f.Locals, s)
+	return s
+}
+func (f *Func) hasSlot(name string) bool {
+	for _,ss := range [][]*Slot{f.Params, f.Results, f.Locals} {
+		for _,s := range ss {
+			if s.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+func (f *Func) NewBlock() *Block {
+	b := &Block{Name: fmt.Sprint("b", len(f.Blocks))}
+	f.Blocks = append(f.Blocks, b)
+	return b
+}
+func (f *Func) String() string {
+	out := "func " + f.Name + "(" + slotList(f.Params) + ")"
+	if len(f.Results) > 0 {
+		out += " (" + slotList(f.Results) + ")"
+	}
+	if f.CDecl {
+		return out + " (external C)\n"
+	}
+	if f.External {
+		return out + " (external)\n"
+	}
+	if f.Export != "" {
+		out += " export " + f.Export
+	}
+	out += ":\n"
+	for _,l := range f.Locals {
+		out += "\tvar " + l.Name + " " + l.Type.String() + "\n"
+	}
+	for _,b := range f.Blocks {
+		out += b.String()
+	}
+	return out
+}
+
+func slotList(slots []*Slot) (out string) {
+	for i,s := range slots {
+		if i > 0 {
+			out += ", "
+		}
+		out += s.Name + " " + s.Type.String()
+	}
+	return
+}
+
+// A Slot is a parameter, result or local of a function, or a
+// package-level variable, any of which lives in memory rather than in
+// a temporary.
+
+type Slot struct {
+	Name string
+	Type types.Type
+}
+
+// Words returns the number of machine words a value of type t takes
+// up.
+func Words(t types.Type) int {
+	return (types.Target.Sizeof(t) + types.Target.WordSize - 1) / types.Target.WordSize
+}
+
+// A Block is a basic block: a list of instructions that are always
+// executed together, the last of which is a jump or return.  We keep
+// track of which blocks it can be reached from (Preds) and which it
+// can lead to (Succs).
+
+type Block struct {
+	Name string
+	Instrs []Instr
+	Preds, Succs []*Block
+}
+func (b *Block) Add(i Instr) {
+	b.Instrs = append(b.Instrs, i)
+}
+func (b *Block) Jump(to *Block) {
+	b.Add(&Jump{to})
+	b.Succs = append(b.Succs, to)
+	to.Preds = append(to.Preds, b)
+}
+func (b *Block) Return() {
+	b.Add(&Return{})
+}
+
+// Terminated tells whether b already ends in a jump or return.
+func (b *Block) Terminated() bool {
+	if len(b.Instrs) == 0 {
+		return false
+	}
+	switch b.Instrs[len(b.Instrs)-1].(type) {
+	case *Jump, *Return:
+		return true
+	}
+	return false
+}
+func (b *Block) String() string {
+	out := b.Name + ":\n"
+	for _,i := range b.Instrs {
+		if _,ok := i.(*Line); ok {
+			continue // there's one for every statement, which is just clutter
+		}
+		out += "\t" + i.String() + "\n"
+	}
+	return out
+}
+
+// A Value is something an instruction can read: a temporary or a
+// constant.
+
+type Value interface {
+	String() string
+}
+
+// A Temp is a temporary, which holds a single word.
+
+type Temp struct {
+	N int
+}
+func (t *Temp) String() string {
+	return fmt.Sprint("t", t.N)
+}
+
+// An Int is a constant word.
+
+type Int int64
+func (i Int) String() string {
+	return fmt.Sprint(int64(i))
+}
+
+// A Symbol is the address of something with a name, such as the
+// bytes of a string literal.
+
+type Symbol string
+func (s Symbol) String() string {
+	return "&" + string(s)
+}
+
+// And now the instructions.
+
+type Instr interface {
+	String() string
+}
+
+// Load reads one word of a slot into a temporary.
+
+type Load struct {
+	Dst *Temp
+	Src *Slot
+	Word int
+}
+func (l *Load) String() string {
+	return fmt.Sprint(l.Dst, " = ", l.Src.Name, "[", l.Word, "]")
+}
+
+// Store writes a value into one word of a slot.
+
+type Store struct {
+	Dst *Slot
+	Word int
+	Src Value
+}
+func (s *Store) String() string {
+	return fmt.Sprint(s.Dst.Name, "[", s.Word, "] = ", s.Src)
+}
+
+// LoadAt reads one word of what Addr points to into a temporary, as
+// when indexing a slice.
+
+type LoadAt struct {
+	Dst *Temp
+	Addr Value
+	Word int
+}
+func (l *LoadAt) String() string {
+	return fmt.Sprint(l.Dst, " = *", l.Addr, "[", l.Word, "]")
+}
+
+// IndexCheck panics unless 0 <= Index < Len, which is what every
+// index into a slice has to be.
+
+type IndexCheck struct {
+	Index, Len Value
+}
+func (c *IndexCheck) String() string {
+	return fmt.Sprint("check 0 <= ", c.Index, " < ", c.Len)
+}
+
+// Call calls a function.  Its arguments and results are grouped by
+// go value, since that's how they are laid out on the stack.  CDecl
+// calls are to C functions, and show up as ccall.
+
+type Call struct {
+	Func string
+	Args [][]Value
+	Results [][]*Temp
+	CDecl bool
+}
+func (c *Call) String() (out string) {
+	for _,r := range c.Results {
+		for _,t := range r {
+			out += t.String() + " "
+		}
+	}
+	if out != "" {
+		out += "= "
+	}
+	if c.CDecl {
+		out += "c"
+	}
+	out += "call " + c.Func + "("
+	for i,a := range c.Args {
+		if i > 0 {
+			out += ", "
+		}
+		for j,w := range a {
+			if j > 0 {
+				out += " "
+			}
+			out += w.String()
+		}
+	}
+	return out + ")"
+}
+
+// BinOp does arithmetic on two words, such as t2 = t0 + t1.  The
+// integers are all signed for now.
+
+type BinOp struct {
+	Op token.Token
+	Dst *Temp
+	X, Y Value
+}
+func (b *BinOp) String() string {
+	return fmt.Sprint(b.Dst, " = ", b.X, " ", b.Op, " ", b.Y)
+}
+
+// UnOp is a negation (-) or complement (^) of a single word.
+
+type UnOp struct {
+	Op token.Token
+	Dst *Temp
+	X Value
+}
+func (u *UnOp) String() string {
+	return u.Dst.String() + " = " + u.Op.String() + u.X.String()
+}
+
+// Syscall traps into the kernel, which wants its arguments in
+// particular registers.  Dst is the kernel's return value.
+
+type Syscall struct {
+	Dst *Temp
+	Trap Value
+	Args [3]Value
+}
+func (s *Syscall) String() string {
+	return fmt.Sprint(s.Dst, " = syscall ", s.Trap, "(", s.Args[0], ", ", s.Args[1], ", ", s.Args[2], ")")
+}
+
+type Jump struct {
+	Target *Block
+}
+func (j *Jump) String() string {
+	return "jump " + j.Target.Name
+}
+
+// Return returns from the function, whose results must already have
+// been stored in their slots.
+
+type Return struct {}
+func (r *Return) String() string {
+	return "return"
+}
+
+// Line marks where the code for a line of source starts, for
+// tracebacks and the debugging information.  It does nothing at all.
+
+type Line struct {
+	File string
+	Line int
+}
+func (l *Line) String() string {
+	return fmt.Sprint("line ", l.File, ":", l.Line)
+}
+
+// Uses returns the values that i reads.
+func Uses(i Instr) (out []Value) {
+	switch i := i.(type) {
+	case *Store:
+		out = append(out, i.Src)
+	case *LoadAt:
+		out = append(out, i.Addr)
+	case *IndexCheck:
+		out = append(out, i.Index, i.Len)
+	case *Call:
+		for _,a := range i.Args {
+			out = append(out, a...)
+		}
+	case *BinOp:
+		out = append(out, i.X, i.Y)
+	case *UnOp:
+		out = append(out, i.X)
+	case *Syscall:
+		out = append(out, i.Trap, i.Args[0], i.Args[1], i.Args[2])
+	}
+	return
+}
+
+// Rewrite replaces each value that i reads with what f makes of it.
+func Rewrite(i Instr, f func(Value) Value) {
+	switch i := i.(type) {
+	case *Store:
+		i.Src = f(i.Src)
+	case *LoadAt:
+		i.Addr = f(i.Addr)
+	case *IndexCheck:
+		i.Index, i.Len = f(i.Index), f(i.Len)
+	case *Call:
+		for _,a := range i.Args {
+			for w := range a {
+				a[w] = f(a[w])
+			}
+		}
+	case *BinOp:
+		i.X, i.Y = f(i.X), f(i.Y)
+	case *UnOp:
+		i.X = f(i.X)
+	case *Syscall:
+		i.Trap = f(i.Trap)
+		for a := range i.Args {
+			i.Args[a] = f(i.Args[a])
+		}
+	}
+}
+
+// wrap makes v overflow the way an int does on the machine we are
+// compiling for.
+func wrap(v Int) Int {
+	if types.Target.WordSize == 4 {
+		return Int(int32(v))
+	}
+	return v
+}
+
+// Fold works out the value of an arithmetic instruction whose
+// operands are all constants, which inlining can leave behind.  Our
+// ints are a word long, so that's how they wrap.
+func Fold(i Instr) (Value, bool) {
+	v,ok := fold(i)
+	// The amd64 mostly only has 32-bit immediates, so we leave
+	// anything bigger to be worked out at run time.
+	if !ok || Int(int32(v)) != v {
+		return nil, false
+	}
+	return v, true
+}
+
+func fold(i Instr) (Int, bool) {
+	switch i := i.(type) {
+	case *UnOp:
+		if x,ok := i.X.(Int); ok {
+			switch i.Op {
+			case token.SUB:
+				return wrap(-x), true
+			case token.XOR:
+				return wrap(^x), true
+			}
+		}
+	case *BinOp:
+		x,xok := i.X.(Int)
+		y,yok := i.Y.(Int)
+		if !xok || !yok {
+			break
+		}
+		var v Int
+		switch i.Op {
+		case token.ADD: v = x + y
+		case token.SUB: v = x - y
+		case token.MUL: v = x * y
+		case token.AND: v = x & y
+		case token.OR: v = x | y
+		case token.XOR: v = x ^ y
+		case token.AND_NOT: v = x &^ y
+		case token.SHL, token.SHR:
+			if y < 0 {
+				return 0, false // that's a run-time panic too
+			}
+			if i.Op == token.SHR {
+				v = wrap(x) >> uint(y)
+			} else if y >= Int(8*types.Target.WordSize) {
+				v = 0
+			} else {
+				v = x << uint(y)
+			}
+		case token.QUO, token.REM:
+			if y == 0 {
+				return 0, false // that's a run-time panic
+			}
+			if i.Op == token.QUO {
+				v = wrap(x) / wrap(y)
+			} else {
+				v = wrap(x) % wrap(y)
+			}
+		default:
+			return 0, false
+		}
+		return wrap(v), true
+	}
+	return 0, false
+}
+
+// Defs returns the temporaries that i writes.
+func Defs(i Instr) (out []*Temp) {
+	switch i := i.(type) {
+	case *Load:
+		out = append(out, i.Dst)
+	case *LoadAt:
+		out = append(out, i.Dst)
+	case *Call:
+		for _,r := range i.Results {
+			out = append(out, r...)
+		}
+	case *BinOp:
+		out = append(out, i.Dst)
+	case *UnOp:
+		out = append(out, i.Dst)
+	case *Syscall:
+		out = append(out, i.Dst)
+	}
+	return
+}
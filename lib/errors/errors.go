@@ -0,0 +1,8 @@
+// Package errors will provide error values once gogo understands
+// interfaces.  Until then, an error is simply its message, and the
+// empty string means there was no error.
+package errors
+
+func New(text string) string {
+	return text
+}
@@ -0,0 +1,52 @@
+// Package os provides access to the command line, to files and to
+// the exit status.  Files are plain file descriptors, which Open,
+// Read, Write and Close work on, and Stdout and friends are functions
+// returning them.
+package os
+
+import "syscall"
+
+func Exit(code int) {
+	syscall.Exit(code)
+}
+
+func Stdin() int {
+	return 0
+}
+
+func Stdout() int {
+	return 1
+}
+
+func Stderr() int {
+	return 2
+}
+
+// Args holds the command-line arguments, starting with the program
+// name.
+var Args []string
+
+func init() {
+	Args = runtime_args()
+}
+
+// runtime_args makes a slice of the arguments that _start found.
+func runtime_args() []string
+
+// Open opens a file, with flag and perm as in open(2), returning a
+// file descriptor (which is negative on error).
+func Open(name string, flag int, perm int) int {
+	return syscall.Open(name, flag, perm)
+}
+
+func Read(fd int, n int) string {
+	return syscall.Read(fd, n)
+}
+
+func Write(fd int, s string) int {
+	return syscall.Write(fd, s)
+}
+
+func Close(fd int) int {
+	return syscall.Close(fd)
+}
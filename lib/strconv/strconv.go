@@ -0,0 +1,10 @@
+// Package strconv converts between ints and their decimal string
+// representation.  The work is done in assembly (strconv_386.S and
+// strconv_amd64.S), since gogo can't yet compile loops.
+package strconv
+
+func Itoa(i int) string
+
+// Atoi returns the value of the decimal number s, or zero if s isn't
+// a number.
+func Atoi(s string) int
@@ -0,0 +1,10 @@
+// Package strings provides simple functions to manipulate strings.
+// They are implemented in strings_386.S and strings_amd64.S.
+package strings
+
+// Index returns the index of the first instance of sep in s, or -1 if
+// sep is not present in s.
+func Index(s, sep string) int
+
+// Repeat returns a new string consisting of count copies of s.
+func Repeat(s string, count int) string
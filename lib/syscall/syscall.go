@@ -0,0 +1,25 @@
+// Package syscall provides the raw Linux system calls upon which the
+// rest of the standard library is built.  The functions declared
+// without a body are implemented in syscall_386.S and syscall_amd64.S,
+// and the system call numbers, which differ between the two, are in
+// sysnum_386.go and sysnum_amd64.go.
+package syscall
+
+// Syscall makes system call number trap, returning whatever the
+// kernel gives back in %eax (which is negative on error).
+func Syscall(trap, a1, a2, a3 int) int
+
+// Read reads up to n bytes from fd into a freshly allocated string.
+func Read(fd int, n int) string
+
+func Write(fd int, s string) int
+
+func Open(path string, mode int, perm int) int
+
+func Close(fd int) int {
+	return Syscall(SYS_CLOSE, fd, 0, 0)
+}
+
+func Exit(code int) {
+	Syscall(SYS_EXIT, code, 0, 0)
+}
@@ -0,0 +1,9 @@
+package syscall
+
+const (
+	SYS_EXIT = 1
+	SYS_READ = 3
+	SYS_WRITE = 4
+	SYS_OPEN = 5
+	SYS_CLOSE = 6
+)
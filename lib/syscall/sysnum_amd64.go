@@ -0,0 +1,9 @@
+package syscall
+
+const (
+	SYS_READ = 0
+	SYS_WRITE = 1
+	SYS_OPEN = 2
+	SYS_CLOSE = 3
+	SYS_EXIT = 60
+)
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"strings"
+	goelf "debug/elf"
+	"github.com/droundy/go/elf"
+)
+
+// "gogo objdump foo" lists the sections and symbols of foo (and its
+// relocations, if it's an object file), which is handy for checking
+// what made it into a binary without needing binutils.  The symbols
+// are listed as nm does, with a letter saying where each one is.
+
+// Objdump describes each of the files called fns.
+func Objdump(fns []string) os.Error {
+	for _,fn := range fns {
+		f,err := elf.Open(fn)
+		if err != nil {
+			return err
+		}
+		class := "ELF32"
+		if f.Is64 {
+			class = "ELF64"
+		}
+		fmt.Printf("%s: %s %s %s, entry %x\n", fn, class, f.Type, f.Machine, f.Entry)
+		fmt.Println("Sections:")
+		for i,s := range f.Sections {
+			if i == 0 {
+				continue // the null section
+			}
+			fmt.Printf("  %2d %-14s %-12s %8x %8x %s\n", i, s.Name,
+				strings.Replace(s.Type.String(), "SHT_", "", 1), s.Addr, s.Size, sectionFlags(s.Flags))
+		}
+		fmt.Println("Symbols:")
+		for i,s := range f.Symbols {
+			if i == 0 || s.Type == goelf.STT_SECTION || s.Type == goelf.STT_FILE {
+				continue
+			}
+			fmt.Printf("  %8x %c %s\n", s.Value, f.Letter(s), s.Name)
+		}
+		for _,s := range f.Sections {
+			if len(s.Relocs) == 0 {
+				continue
+			}
+			fmt.Printf("Relocations for %s:\n", s.Name)
+			for _,r := range s.Relocs {
+				name := ""
+				if r.Sym < len(f.Symbols) {
+					name = f.Symbols[r.Sym].Name
+					if name == "" && f.Symbols[r.Sym].Section < len(f.Sections) {
+						name = f.Sections[f.Symbols[r.Sym].Section].Name
+					}
+				}
+				fmt.Printf("  %8x %-16s %s%+d\n", r.Offset, f.RelocType(r.Type), name, r.Addend)
+			}
+		}
+	}
+	return nil
+}
+
+func sectionFlags(flags goelf.SectionFlag) (out string) {
+	if flags & goelf.SHF_ALLOC != 0 {
+		out += "A"
+	}
+	if flags & goelf.SHF_WRITE != 0 {
+		out += "W"
+	}
+	if flags & goelf.SHF_EXECINSTR != 0 {
+		out += "X"
+	}
+	return
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+	"io/ioutil"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"github.com/droundy/go/x86"
+	"github.com/droundy/goopt"
+)
+
+// The standard library lives in a directory of its own, which by
+// default sits right next to the compiler executable.
+var libdir = goopt.String([]string{"--lib"}, "", "directory holding the standard library")
+
+func LibraryDir() string {
+	if *libdir != "" {
+		return *libdir
+	}
+	dir,_ := path.Split(os.Args[0])
+	return path.Join(dir, "lib")
+}
+
+// SymbolName converts a package-qualified name such as os.Exit into
+// the name of its symbol in the assembly, which is os_Exit.
+func SymbolName(name string) string {
+	return strings.Map(func(c int) int {
+		if c == '.' {
+			return '_'
+		}
+		return c
+	}, name)
+}
+
+// ImportPaths returns the paths of every package that pkg imports.
+func ImportPaths(pkg *ast.Package) (out []string) {
+	for _,f := range pkg.Files {
+		for _,d := range f.Decls {
+			if g,ok := d.(*ast.GenDecl); ok && g.Tok == token.IMPORT {
+				for _,s := range g.Specs {
+					p := string(s.(*ast.ImportSpec).Path.Value)
+					out = append(out, p[1:len(p)-1])
+				}
+			}
+		}
+	}
+	return
+}
+
+// ImportedPackages returns pkg along with every package it imports
+// directly or indirectly.  The packages are ordered so that each one
+// comes after the packages it depends on.
+func ImportedPackages(pkg *ast.Package) (out []*ast.Package, err os.Error) {
+	done := make(map[string]bool)
+	var visit func(p *ast.Package) os.Error
+	visit = func(p *ast.Package) os.Error {
+		for _,ip := range ImportPaths(p) {
+			if done[ip] {
+				continue
+			}
+			done[ip] = true
+			isgo := func(fi *os.FileInfo) bool {
+				return strings.HasSuffix(fi.Name, ".go") && ForArch(fi.Name)
+			}
+			ps,err := parser.ParseDir(myfiles, path.Join(LibraryDir(), ip), isgo, parser.ParseComments)
+			if err != nil {
+				return err
+			}
+			_,name := path.Split(ip)
+			imported,ok := ps[name]
+			if !ok {
+				return os.NewError("Couldn't find package " + ip)
+			}
+			if err := visit(imported); err != nil {
+				return err
+			}
+		}
+		out = append(out, p)
+		return nil
+	}
+	err = visit(pkg)
+	return
+}
+
+// PackageAssembly returns the contents of the assembly files (ending
+// in .S) which implement the functions of pkg that are declared
+// without a body.  Since assembly is only good for one machine, these
+// are usually called something like foo_386.S.
+func PackageAssembly(pkg *ast.Package) (out []x86.X86, err os.Error) {
+	if pkg.Name == "main" {
+		return
+	}
+	dir := ""
+	for fn := range pkg.Files {
+		dir,_ = path.Split(fn)
+		break
+	}
+	fis,err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _,fi := range fis {
+		if strings.HasSuffix(fi.Name, ".S") && ForArch(fi.Name) {
+			code,err := ioutil.ReadFile(path.Join(dir, fi.Name))
+			if err != nil {
+				return nil,err
+			}
+			out = append(out, x86.Comment("From "+path.Join(dir, fi.Name)),
+				x86.RawAssembly(code))
+		}
+	}
+	return
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"github.com/droundy/go/ir"
+	"github.com/droundy/go/x86"
+	"github.com/droundy/goopt"
+)
+
+var regabi = goopt.Flag([]string{"--regabi"}, []string{"--stackabi"},
+	"pass the first few arguments and results in registers",
+	"pass every argument and result on the stack")
+
+// With --regabi, functions pass their first few arguments and results
+// in registers rather than on the stack, much as gc's ABIInternal
+// does.  Each argument (or result) that fits in the registers that are
+// left goes in them, a word per register, and as soon as one doesn't
+// fit, it and everything after it goes on the stack just as usual.
+// The callee gives each register argument a home in its frame as soon
+// as it starts, and since the caller no longer pushes it, there's
+// nothing to pop at the end.
+//
+// Anything that gets called from assembly or C, or that we can't see
+// all the calls to, sticks to the stack convention, since that's what
+// its callers expect.
+
+// These are the registers, in order, that the arguments go in.
+var argRegisters = []x86.Register{x86.EAX, x86.EBX, x86.ECX, x86.EDX}
+
+// These are the registers, in order, that the results come back in.
+// They mustn't include %edx, which holds the return address while we
+// return.
+var resultRegisters = []x86.Register{x86.EAX, x86.EBX}
+
+// RegABIFuncs holds the names of the functions using registers.
+var RegABIFuncs = make(map[string]bool)
+
+// UseRegABI decides which of the functions in p can use registers,
+// which is any that we compile ourselves and that aren't in
+// stackonly.
+func UseRegABI(p *ir.Program, stackonly []string) {
+	if !*regabi {
+		return
+	}
+	for _,f := range p.Funcs {
+		RegABIFuncs[f.Name] = !f.External
+	}
+	for _,n := range stackonly {
+		RegABIFuncs[n] = false
+	}
+}
+
+// inRegisters tells how many of the leading values, whose sizes in
+// words are given, go in the available registers.
+func inRegisters(words []int, available int) (n int) {
+	for _,w := range words {
+		if w > available {
+			break
+		}
+		available -= w
+		n++
+	}
+	return
+}
+
+func slotWords(ss []*ir.Slot) (ws []int) {
+	for _,s := range ss {
+		ws = append(ws, ir.Words(s.Type))
+	}
+	return
+}
+func argWords(args [][]ir.Value) (ws []int) {
+	for _,a := range args {
+		ws = append(ws, len(a))
+	}
+	return
+}
+func resultWords(results [][]*ir.Temp) (ws []int) {
+	for _,r := range results {
+		ws = append(ws, len(r))
+	}
+	return
+}
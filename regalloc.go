@@ -0,0 +1,267 @@
+package main
+
+import (
+	"sort"
+	"go/token"
+	"github.com/droundy/go/ir"
+	"github.com/droundy/go/x86"
+	"github.com/droundy/goopt"
+)
+
+var optimize = goopt.Int([]string{"-O", "--optimize"}, 1,
+	"how hard to optimize (-O0 keeps every temporary on the stack)")
+
+// These are the registers we hand out to temporaries.  %esp is the
+// stack pointer, and %ebp is the frame pointer.
+var allocatable = []x86.Register{x86.EAX, x86.EBX, x86.ECX, x86.EDX, x86.ESI, x86.EDI}
+
+// A regset is a set of registers, one bit each.
+
+type regset uint
+func (s regset) Has(r x86.Register) bool {
+	return s & (1 << uint(r)) != 0
+}
+func regs(rs... x86.Register) (s regset) {
+	for _,r := range rs {
+		s |= 1 << uint(r)
+	}
+	return
+}
+var allRegisters = regs(allocatable...)
+
+// An interval is the stretch of instructions over which a temporary
+// is live.  Instruction k reads its operands at position 2k and
+// writes its results at 2k+1, so a temporary that dies in an
+// instruction can share a register with one that is born there.
+
+type interval struct {
+	t int // the number of the temporary
+	start, end int
+	forbidden regset // registers it mustn't live in
+	reg x86.Register
+	inreg bool // false if it's spilled
+}
+
+type byStart []*interval
+func (s byStart) Len() int { return len(s) }
+func (s byStart) Less(i, j int) bool { return s[i].start < s[j].start }
+func (s byStart) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+// Clobbers returns the registers that instruction i destroys, which
+// nothing can be kept in across it.
+func Clobbers(i ir.Instr) regset {
+	switch i := i.(type) {
+	case *ir.Call:
+		if i.CDecl {
+			return cClobbers() // C saves the rest
+		}
+		return allRegisters // the callee could do anything
+	case *ir.Syscall:
+		_,c := syscallRegisters()
+		return c
+	case *ir.BinOp:
+		switch i.Op {
+		case token.QUO, token.REM:
+			return regs(x86.EAX, x86.EDX)
+		case token.SHL, token.SHR:
+			if _,ok := i.Y.(ir.Int); !ok {
+				return regs(x86.ECX)
+			}
+		}
+	}
+	return 0
+}
+
+// AllocateRegisters decides which temporaries of f live in which
+// registers, using a linear scan.  Any temporary that isn't in the
+// map gets spilled to the stack.
+func AllocateRegisters(f *ir.Func) map[int]x86.Register {
+	out := make(map[int]x86.Register)
+	if *optimize == 0 {
+		return out // everything on the stack, as in the good old days
+	}
+	intervals := liveIntervals(f)
+	var active []*interval
+	for _,cur := range intervals {
+		// First throw out anything that has died by now.
+		stillalive := active[:0]
+		for _,a := range active {
+			if a.end >= cur.start {
+				stillalive = append(stillalive, a)
+			}
+		}
+		active = stillalive
+		taken := cur.forbidden
+		for _,a := range active {
+			taken |= regs(a.reg)
+		}
+		found := false
+		for _,r := range allocatable {
+			if !taken.Has(r) {
+				cur.reg, found = r, true
+				break
+			}
+		}
+		if !found {
+			// We have to spill something, so we pick whichever lives
+			// longest, since that frees up the most registers.
+			var victim *interval
+			vi := -1
+			for i,a := range active {
+				if !cur.forbidden.Has(a.reg) && (victim == nil || a.end > victim.end) {
+					victim, vi = a, i
+				}
+			}
+			if victim == nil || victim.end <= cur.end {
+				continue // spill cur itself
+			}
+			cur.reg = victim.reg
+			victim.inreg = false
+			active = append(active[:vi], active[vi+1:]...)
+		}
+		cur.inreg = true
+		active = append(active, cur)
+	}
+	for _,iv := range intervals {
+		if iv.inreg {
+			out[iv.t] = iv.reg
+		}
+	}
+	return out
+}
+
+// liveIntervals works out when each temporary is live, sorted by
+// where they start.
+func liveIntervals(f *ir.Func) []*interval {
+	// First we number the instructions, and find which temporaries
+	// each block uses before defining them.
+	first := make(map[*ir.Block]int)
+	last := make(map[*ir.Block]int)
+	uses := make(map[*ir.Block]map[int]bool)
+	defs := make(map[*ir.Block]map[int]bool)
+	n := 0
+	for _,b := range f.Blocks {
+		first[b] = n
+		uses[b] = make(map[int]bool)
+		defs[b] = make(map[int]bool)
+		for _,i := range b.Instrs {
+			for _,v := range ir.Uses(i) {
+				if t,ok := v.(*ir.Temp); ok && !defs[b][t.N] {
+					uses[b][t.N] = true
+				}
+			}
+			for _,t := range ir.Defs(i) {
+				defs[b][t.N] = true
+			}
+			n++
+		}
+		last[b] = n-1
+	}
+	// Then the usual backwards dataflow to find what is live on the
+	// way into and out of each block.
+	livein := make(map[*ir.Block]map[int]bool)
+	liveout := make(map[*ir.Block]map[int]bool)
+	for _,b := range f.Blocks {
+		livein[b] = make(map[int]bool)
+		liveout[b] = make(map[int]bool)
+	}
+	for changed := true; changed; {
+		changed = false
+		for j:=len(f.Blocks)-1; j>=0; j-- {
+			b := f.Blocks[j]
+			for _,s := range b.Succs {
+				for t := range livein[s] {
+					if !liveout[b][t] {
+						liveout[b][t] = true
+						changed = true
+					}
+				}
+			}
+			for t := range uses[b] {
+				livein[b][t] = true
+			}
+			for t := range liveout[b] {
+				if !defs[b][t] && !livein[b][t] {
+					livein[b][t] = true
+					changed = true
+				}
+			}
+		}
+	}
+	// Now we can find the intervals, which are from the first
+	// position to the last at which each temporary is live.
+	ivs := make([]*interval, f.Temps)
+	extend := func(t, pos int) {
+		if ivs[t] == nil {
+			ivs[t] = &interval{t: t, start: pos, end: pos}
+		}
+		if pos < ivs[t].start {
+			ivs[t].start = pos
+		}
+		if pos > ivs[t].end {
+			ivs[t].end = pos
+		}
+	}
+	k := 0
+	for _,b := range f.Blocks {
+		for t := range livein[b] {
+			extend(t, 2*first[b])
+		}
+		for t := range liveout[b] {
+			extend(t, 2*last[b]+1)
+		}
+		for _,i := range b.Instrs {
+			for _,v := range ir.Uses(i) {
+				if t,ok := v.(*ir.Temp); ok {
+					extend(t.N, 2*k)
+				}
+			}
+			for _,t := range ir.Defs(i) {
+				extend(t.N, 2*k+1)
+			}
+			k++
+		}
+	}
+	// Finally, the registers each temporary can't use: those clobbered
+	// by anything it lives across, and those that get in the way of
+	// the instructions it is used in.
+	k = 0
+	for _,b := range f.Blocks {
+		for _,i := range b.Instrs {
+			if c := Clobbers(i); c != 0 {
+				for _,iv := range ivs {
+					if iv != nil && iv.start <= 2*k && iv.end > 2*k+1 {
+						iv.forbidden |= c
+					}
+				}
+			}
+			if op,ok := i.(*ir.BinOp); ok {
+				forbid := func(v ir.Value, c regset) {
+					if t,ok := v.(*ir.Temp); ok {
+						ivs[t.N].forbidden |= c
+					}
+				}
+				switch c := Clobbers(op); op.Op {
+				case token.QUO, token.REM:
+					// idivl overwrites %eax and %edx before it is done with
+					// the divisor.
+					forbid(op.Y, c)
+				case token.SHL, token.SHR:
+					// The count goes into %cl, so the shifted value
+					// mustn't be there.
+					forbid(op.X, c)
+					forbid(op.Dst, c)
+				}
+			}
+			k++
+		}
+	}
+	var out []*interval
+	for _,iv := range ivs {
+		if iv != nil {
+			out = append(out, iv)
+		}
+	}
+	sort.Sort(byStart(out))
+	return out
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"strings"
+	"github.com/droundy/go/emu"
+	"github.com/droundy/go/x86"
+	"github.com/droundy/goopt"
+)
+
+// gogo run foo.go args... compiles foo.go as usual, but rather than
+// writing an executable, runs it in our i386 emulator with the rest of
+// the command line as its arguments.  That needs nothing from the
+// machine we're on, not even that it can run 32-bit code.
+
+var countInstructions = goopt.Flag([]string{"--count-instructions"}, []string{},
+	"have gogo run say how many instructions the program ran", "")
+
+// RunName is the name gogo run gives the program in gofile: that of
+// the executable it would have compiled, as you'd run it.
+func RunName(gofile string) string {
+	exe := gofile[:len(gofile)-len(".go")]
+	if !strings.Contains(exe, "/") {
+		exe = "./" + exe
+	}
+	return exe
+}
+
+// Run runs code in the emulator as the program exe, and returns its
+// exit status.
+func Run(exe string, code []x86.X86, args []string) int {
+	if *arch != "386" {
+		die(os.NewError("I can only run 386 code, not " + *arch))
+	}
+	o,err := x86.Encode(code)
+	die(err)
+	m,err := emu.New(o, append([]string{exe}, args...))
+	die(err)
+	status,err := m.Run()
+	die(err)
+	if *countInstructions {
+		fmt.Fprintln(os.Stderr, m.Count, "instructions")
+	}
+	return status
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+func show(x int) {
+	println(strconv.Itoa(x))
+}
+
+func id(x int) int {
+	return x
+}
+
+// deep needs more registers than the i386 has.
+func deep(a, b, c int) int {
+	return a*(b+(c*(a+(b*(c+(a-(b*(c+(a*(b-c))))))))))
+}
+
+func main() {
+	show(id(1) << 40 >> 38)
+	show(id(1) << 62 >> 61)
+	show(-id(7) / 2)
+	show(deep(id(7), id(-1), id(4)))
+	show(strconv.Atoi("-12345") * 3)
+	show(strconv.Atoi("-9223372036854775808") / id(-1))
+	show(strconv.Atoi("-9223372036854775808") % id(-1))
+	show(strings.Index("hello world", "wor"))
+	println(strings.Repeat("ab", 3))
+	println(os.Args[0])
+	syscall.Syscall(syscall.SYS_EXIT, 3, 0, 0)
+	show(99)
+}
+
+// Flags: -arch=amd64 -O1
+// It really is 64-bit code, with registers the i386 doesn't have.
+// Asm: syscall
+// Asm: %r8
+// NoAsm: %e[a-d]x
+// Flags: -arch=amd64 -O0
+// Flags: -arch=amd64 --regabi
+// Exit: 3
+// Stderr:
+// 4
+// 2
+// -3
+// 749
+// -37035
+// -9223372036854775808
+// 0
+// 6
+// ababab
+// ./amd64
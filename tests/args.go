@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"strconv"
+)
+
+var greeting string
+
+func init() {
+	greeting = "hello from init"
+}
+
+// arg is small enough to be inlined, index check and all.
+func arg(i int) string {
+	return os.Args[i]
+}
+
+func second(a, b string) string {
+	a = b
+	return a
+}
+
+func main() {
+	println(greeting)
+	println(strconv.Itoa(len(os.Args)))
+	println(arg(1))
+	println(second(os.Args[1], os.Args[2]))
+	println(strconv.Itoa(len(os.Args[2])))
+	println(os.Args[len(os.Args)])
+}
+
+// Flags: -O1
+// Flags: -O0
+// Flags: --inline-budget 0
+// Flags: --regabi
+// Flags: -arch=amd64
+// Args: one three
+// Exit: 2
+// Stderr:
+// hello from init
+// 3
+// one
+// three
+// 5
+// panic: runtime error: index out of range [3] with length 3
+//
+// main.main()
+//	args.go:30
+
+// Args: a bb ccc
+// Exit: 2
+// Stderr:
+// hello from init
+// 4
+// a
+// bb
+// 2
+// panic: runtime error: index out of range [4] with length 4
+//
+// main.main()
+//	args.go:30
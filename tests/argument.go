@@ -7,3 +7,6 @@ func sayhi(arg string) {
 func main() {
 	sayhi("Hello world!")
 }
+
+// Stderr:
+// Hello world!
@@ -14,3 +14,6 @@ func main() {
 	sayforward("Hel", "lo ")
 	saybackwards("!\n", "world")
 }
+
+// Stderr:
+// Hello world!
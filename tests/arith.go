@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strconv"
+	"syscall"
+)
+
+func show(x int) {
+	println(strconv.Itoa(x))
+}
+
+func id(x int) int {
+	return x
+}
+
+// deep keeps more temporaries alive at once than we have registers.
+func deep(a, b, c int) int {
+	return a*(b+(c*(a+(b*(c+(a-(b*(c+(a*(b-c))))))))))
+}
+
+// none is always zero, even when x and x are in the same register.
+func none(x int) int {
+	return x &^ x
+}
+
+func main() {
+	show(7 + id(5)*3)
+	show(id(-7) / 2)
+	show(id(-7) % 2)
+	show(100 / id(7) % id(4))
+	show(id(1) << 10 >> 3)
+	show(-id(64) >> id(2))
+	show(id(5) << id(3) - id(1))
+	show(id(12) &^ 10 | id(1) ^ 3)
+	show(^id(0) & 255)
+	show(id(6) &^ id(3))
+	show(id(1) << 40)
+	show(id(1) << id(40))
+	show(-id(64) >> id(33))
+	show(id(-64) >> id(31))
+	show(id(3) << id(31))
+	show(deep(2, 3, 5))
+	show(deep(id(7), id(-1), id(4)))
+	show(none(strconv.Atoi("-3")))
+	show(id(-2147483648) / id(-1))
+	show(id(-2147483648) % id(-1))
+	show(id(-2147483648) / -1)
+	show(id(-2147483648) % -1)
+	show(id(7) / id(-2))
+	syscall.Syscall(1, id(6)*id(7)-id(39), 0, 0)
+	show(99)
+}
+
+// Flags: -O1
+// Flags: -O0
+// Exit: 3
+// Stderr:
+// 22
+// -3
+// -1
+// 2
+// 128
+// -16
+// 39
+// 6
+// 255
+// 4
+// 0
+// 0
+// -1
+// -1
+// -2147483648
+// 146
+// 749
+// 0
+// -2147483648
+// 0
+// -2147483648
+// 0
+// -3
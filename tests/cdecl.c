@@ -0,0 +1,11 @@
+/* C functions for cdecl.go to call, one of which calls back into go. */
+
+int gogo_twice(int x);
+
+int add3(int a, int b, int c) {
+	return a + b + c;
+}
+
+int c_twice_plus_one(int x) {
+	return gogo_twice(x) + 1;
+}
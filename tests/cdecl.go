@@ -0,0 +1,41 @@
+package main
+
+import "strconv"
+
+//gogo:cdecl
+func add3(a, b, c int) int
+
+// C calls back into twice, which it knows as gogo_twice.
+//gogo:cdecl c_twice_plus_one
+func twicePlusOne(x int) int
+
+//gogo:export gogo_twice
+func twice(x int) int {
+	return x + x
+}
+
+func show(x int) {
+	println(strconv.Itoa(x))
+}
+
+// mixed keeps some temporaries alive across calls to C.
+func mixed(a, b int) int {
+	return a*b + add3(a, b, twicePlusOne(a*b))
+}
+
+func main() {
+	show(add3(1, 20, 300))
+	show(mixed(5, 7))
+}
+
+// The go function gets a wrapper so C can call it.
+// Asm: ^gogo_twice:
+// Asm: call c_twice_plus_one
+// Flags: -O1
+// Flags: -O0
+// With -c we write an object file ourselves, which the system linker
+// can link with the C.
+// Flags: -c
+// Stderr:
+// 321
+// 118
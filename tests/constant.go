@@ -0,0 +1,20 @@
+package main
+
+// We keep integer constants in an int64, which isn't nearly enough for
+// an untyped constant, but is for any typed one.
+
+const huge = 1 << 62 * 4
+const back = 1 << 63 >> 63
+const most int64 = 1<<62 - 1 + 1<<62
+const least = -most - 1
+
+func main() {
+	println(most + 1, -least, least / -1, least % -1)
+}
+
+// Errors:
+// constant.go:6:14: unsupported: I can't handle constants as big as 1 << 62 * 4
+// constant.go:7:14: unsupported: I can't handle constants as big as 1 << 63
+// constant.go:12:10: constant most + 1 overflows int64
+// constant.go:12:20: constant -least overflows int64
+// constant.go:12:28: constant least / -1 overflows int64
@@ -0,0 +1,45 @@
+package main
+
+import "strconv"
+
+const debug = false
+
+func unused() {
+	println("Nobody calls me")
+}
+
+func main() {
+	if debug {
+		println("Debugging is on")
+	}
+	if !debug {
+		println("Debugging is off")
+	} else {
+		println("Debugging is off")
+	}
+	const greeting = "Hello" + ", " + "world!"
+	println(greeting)
+	println(strconv.Itoa(1<<4 + 6*7))
+	if len(greeting) == 13 && greeting != "" {
+		panic("the end")
+	}
+	println("This never happens")
+}
+
+// Nothing we can't reach should have made it into the binary.
+// NoAsm: Nobody calls me
+// NoAsm: Debugging is on
+// NoAsm: This never happens
+// NoAsm: ^main_unused:
+// Flags:
+// Flags: -v
+// Log: Removed unused function main_unused
+// Exit: 2
+// Stderr:
+// Debugging is off
+// Hello, world!
+// 58
+// panic: the end
+//
+// main.main()
+//	deadcode.go:24
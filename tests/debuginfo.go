@@ -0,0 +1,28 @@
+package main
+
+import "os"
+
+// describe has parameters and a result of each kind that we tell gdb
+// about.
+func describe(n int, name string, loud bool) (size int) {
+	println(name)
+	return n * 4
+}
+
+func main() {
+	os.Exit(describe(2, "gopher", true))
+}
+
+// Each statement marks where its line starts.
+// Flags: -arch=386 -O0 -g
+// Asm: \.file [0-9]* "debuginfo.go"
+// Asm: \.loc [0-9]* 9$
+// Flags: -arch=amd64 -O0 -g
+// Asm: \.file [0-9]* "debuginfo.go"
+// Asm: \.loc [0-9]* 9$
+// Without -g there's none of it.
+// Flags:
+// NoAsm: \.loc|debug_info
+// Exit: 8
+// Stderr:
+// gopher
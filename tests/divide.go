@@ -0,0 +1,31 @@
+package main
+
+import (
+	"os"
+	"strconv"
+)
+
+// Only dividing by zero panics: the most negative int divided by -1
+// is itself, though the x86 won't do it.
+func divide(x, y int) int {
+	return x / y
+}
+
+func main() {
+	println(strconv.Itoa(divide(-2147483647 - 1, strconv.Atoi(os.Args[1]))))
+	println(strconv.Itoa(divide(100, strconv.Atoi(os.Args[2]))))
+}
+
+// Flags: --inline-budget 0 -O1
+// Flags: --inline-budget 0 -O0
+// Flags: --inline-budget 0 --regabi
+// Args: -1 0
+// Exit: 2
+// Stderr:
+// -2147483648
+// panic: runtime error: integer divide by zero
+//
+// main.divide()
+//	divide.go:11
+// main.main()
+//	divide.go:16
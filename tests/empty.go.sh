@@ -1,5 +0,0 @@
-#!/bin/bash
-
-set -ev
-
-./empty
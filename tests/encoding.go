@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+func show(x int) {
+	println(strconv.Itoa(x))
+}
+
+func id(x int) int {
+	return x
+}
+
+// mix uses as many different instructions as I could think of, with
+// both small and large constants, so that our encoder gets a workout.
+func mix(a, b, c int) int {
+	return (a*100000 + b*3 - c/7) ^ (a << 3) | (b >> 2) % 1000 - 200000 - ^c &^ 255
+}
+
+func main() {
+	show(mix(id(5), id(-9), id(3)))
+	show(id(7) / id(-2) + id(7) % id(-2))
+	show(strconv.Atoi("4096") * -130)
+	println(strings.Repeat("xy", 2))
+	os.Exit(mix(1, 2, 3) & 7)
+}
+
+// Flags: -arch=386 -O1 --check-encoding
+// Flags: -arch=386 -O0 --check-encoding
+// Flags: -arch=386 --regabi --check-encoding
+// Flags: -arch=amd64 -O1 --check-encoding
+// Flags: -arch=amd64 -O0 --check-encoding
+// Flags: -arch=amd64 --regabi --check-encoding
+// Exit: 6
+// Stderr:
+// -199747
+// -2
+// -532480
+// xyxy
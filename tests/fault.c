@@ -0,0 +1,5 @@
+/* A C function for fault.go, which reads whatever address it's given. */
+
+int peek(long address) {
+	return *(int *)address;
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"strconv"
+)
+
+// peek is in C, since we have no pointers of our own to get wrong.
+//gogo:cdecl
+func peek(address int) int
+
+func divide(n int) int {
+	println("dividing")
+	return 100 / n
+}
+
+func main() {
+	println(strconv.Itoa(divide(strconv.Atoi(os.Args[1]))))
+	println(strconv.Itoa(peek(strconv.Atoi(os.Args[2]))))
+}
+
+// Flags: -O1 --inline-budget 0
+// Flags: -O0 --inline-budget 0
+// Flags: --regabi --inline-budget 0
+// Flags: -arch=amd64 --inline-budget 0
+// Dividing by zero is a panic, not a SIGFPE.
+// Args: 0 0
+// Exit: 2
+// Stderr:
+// dividing
+// panic: runtime error: integer divide by zero
+//
+// main.divide()
+//	fault.go:14
+// main.main()
+//	fault.go:18
+
+// Nor is reading address zero a SIGSEGV, even in C.
+// Args: 5 0
+// Exit: 2
+// Stderr:
+// dividing
+// 20
+// panic: runtime error: invalid memory address or nil pointer dereference
+//
+// main.main()
+//	fault.go:19
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os"
+	"strconv"
+)
+
+// show uses its arguments both before and after calling into the
+// library, which had better leave our frame pointer alone.
+func show(a, b int, name string) int {
+	println(name)
+	return a*100 + b + os.Close(os.Open(name, 0, 0))
+}
+
+func main() {
+	println(strconv.Itoa(show(4, 2, "/dev/null")))
+	println(strconv.Itoa(show(show(1, 2, "frame.go"), 3, "/")))
+}
+
+// Everything is addressed relative to the frame pointer.
+// Asm in main_show: movl %esp, %ebp
+// NoAsm in main_show: \(%esp\)
+// Flags: -O1
+// Flags: -O0
+// Stderr:
+// /dev/null
+// 402
+// frame.go
+// /
+// 10203
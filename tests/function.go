@@ -7,3 +7,6 @@ func sayhi() {
 func main() {
 	sayhi()
 }
+
+// Stderr:
+// Hello world!
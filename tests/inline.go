@@ -0,0 +1,38 @@
+package main
+
+import "strconv"
+
+func echo(s string) string {
+	return s
+}
+
+func twice(x int) int {
+	return x + x
+}
+
+func zero() (n int) {
+	return n
+}
+
+func shout(s string) {
+	println(s)
+}
+
+func main() {
+	println(echo("Hello world!"))
+	println(strconv.Itoa(twice(twice(5)) + zero()))
+	shout(echo(echo("Goodbye")))
+}
+
+// Flags:
+// NoAsm: call main_echo
+// NoAsm: call main_twice
+// Flags: -v
+// Log: Inlined main_echo into main_main
+// Flags: --inline-budget 0
+// Asm: call main_echo
+// Asm: call main_twice
+// Stderr:
+// Hello world!
+// 20
+// Goodbye
@@ -0,0 +1,51 @@
+package main
+
+import "strconv"
+
+func pair(name string) (string, int) {
+	return name, 42
+}
+
+func second(s string, i int) string {
+	return strconv.Itoa(i)
+}
+
+func main() {
+	println(second(pair("Hello world!")))
+	return
+	println("unreachable")
+}
+
+// Flags:
+// Flags: -O0 --dump-ir
+// Dump:
+// func strconv_Itoa(i int) (r0 string) (external)
+//
+// func strconv_Atoi(s string) (r0 int) (external)
+//
+// func main_pair(name string) (r0 string, r1 int):
+// b0:
+// 	t0 = name[0]
+// 	t1 = name[1]
+// 	r0[0] = t0
+// 	r0[1] = t1
+// 	r1[0] = 42
+// 	return
+//
+// func main_second(s string, i int) (r0 string):
+// b0:
+// 	t0 = i[0]
+// 	t1 t2 = call strconv_Itoa(t0)
+// 	r0[0] = t1
+// 	r0[1] = t2
+// 	return
+//
+// func main_main():
+// b0:
+// 	t0 t1 t2 = call main_pair(12 &string_Helloworld)
+// 	t3 t4 = call main_second(t0 t1, t2)
+// 	call println(t3 t4)
+// 	return
+//
+// Stderr:
+// 42
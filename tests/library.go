@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	println(os.Args[1])
+	println(strconv.Itoa(len(os.Args)))
+	println(strconv.Itoa(strconv.Atoi("-42")))
+	println(strings.Repeat("ab", 3))
+	println(strconv.Itoa(strings.Index("Hello world", "world")))
+	os.Write(os.Stdout(), "Goodbye world!\n")
+	os.Exit(3)
+}
+
+// Args: hello
+// Exit: 3
+// Stderr:
+// hello
+// 2
+// -42
+// ababab
+// 6
+// Output:
+// Goodbye world!
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+func twice(x int) int {
+	return x + x
+}
+
+func main() {
+	println(strconv.Itoa(twice(-21)))
+	println(strings.Repeat("na", 4))
+	println(os.Args[1])
+	os.Exit(twice(2))
+}
+
+// We can build a program without binutils, for either machine.
+// NoFile: native.o
+// Flags: -arch=386 --native
+// Flags: -arch=amd64 --native
+// Args: batman
+// Exit: 4
+// Stderr:
+// -42
+// nananana
+// batman
@@ -3,3 +3,6 @@ package main
 func main() {
 	println("Hello world!")
 }
+
+// Stderr:
+// Hello world!
@@ -0,0 +1,52 @@
+package main
+
+import "strconv"
+
+func show(x int) {
+	println(strconv.Itoa(x))
+}
+
+func sub(a, b int) int {
+	return a - b
+}
+
+// mix has more arguments than there are registers for.
+func mix(a, b, c, d, e int) int {
+	return sub(a, b)*100 + sub(c, d)*10 + e
+}
+
+// greet has a string argument that won't fit in the registers the
+// first two leave.
+func greet(x, y int, hello string) string {
+	show(sub(y, x))
+	return hello
+}
+
+// calls makes enough calls that the arguments pass each other in
+// registers.
+func calls(a, b int) int {
+	return sub(sub(b, a), sub(a, b)) + mix(b, a, sub(a, b), b, a)
+}
+
+func main() {
+	show(sub(10, 3))
+	show(mix(9, 2, 7, 3, 5))
+	println(greet(1, 5, "hello"))
+	show(calls(4, 6))
+}
+
+// Flags: --stackabi -O1 --inline-budget 0
+// Flags: --stackabi -O0 --inline-budget 0
+// Flags: --regabi -O1 --inline-budget 0
+// Flags: --regabi -O0 --inline-budget 0
+// sub gets its arguments in registers, so it has nothing to pop, but
+// main is called from the runtime, so it sticks to the stack.
+// Asm in main_sub: Saving a from a register
+// NoAsm in main_sub: jmp \*%eax
+// Asm in main_main: jmp \*%eax
+// Stderr:
+// 7
+// 745
+// 4
+// hello
+// 128
@@ -11,3 +11,6 @@ func echo(x string) string {
 func main() {
 	println(echo(sayhi()))
 }
+
+// Stderr:
+// Hello world!
@@ -0,0 +1,25 @@
+package main
+
+import "strconv"
+
+func shift(x, n int) int {
+	return x << n
+}
+
+func main() {
+	println(strconv.Itoa(shift(1, 3)))
+	println(strconv.Itoa(shift(1, strconv.Atoi("-1"))))
+}
+
+// Flags: --inline-budget 0 -O1
+// Flags: --inline-budget 0 -O0
+// Flags: --inline-budget 0 -arch=amd64
+// Exit: 2
+// Stderr:
+// 8
+// panic: runtime error: negative shift amount
+//
+// main.shift()
+//	shift.go:6
+// main.main()
+//	shift.go:11
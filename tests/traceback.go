@@ -0,0 +1,39 @@
+package main
+
+import "strconv"
+
+func inner(n int) int {
+	println(strconv.Itoa(n))
+	panic(strconv.Itoa(n + 1))
+	return n
+}
+
+func outer(n int) int {
+	println("outer")
+	return inner(n * 2) + 1
+}
+
+func main() {
+	println("main")
+	println(strconv.Itoa(outer(21)))
+}
+
+// Flags: --inline-budget 0 -O1
+// Flags: --inline-budget 0 -O0
+// Flags: --inline-budget 0 --regabi
+// Flags: --inline-budget 0 --native
+// Flags: --inline-budget 0 -arch=amd64
+// Flags: --inline-budget 0 -arch=amd64 --regabi --native
+// Exit: 2
+// Stderr:
+// main
+// outer
+// 42
+// panic: 43
+//
+// main.inner()
+//	traceback.go:7
+// main.outer()
+//	traceback.go:13
+// main.main()
+//	traceback.go:18
@@ -0,0 +1,29 @@
+package main
+
+type Stringer interface {
+	String() string
+}
+
+type T int
+
+func (t *T) String() string {
+	return "T"
+}
+
+func pair() (int, string) {
+	return 1, "one"
+}
+
+func main() {
+	var s Stringer = T(1)
+	var small uint8 = 256
+	x := "a" + 1
+	n := pair()
+	println(s, small, x, n)
+}
+
+// Errors:
+// typecheck.go:18:19: cannot use T(...) (type T) as type Stringer in variable declaration:
+// typecheck.go:19:20: constant 256 overflows uint8
+// typecheck.go:20:7: invalid operation: "a" + 1 (mismatched types untyped string and untyped int)
+// typecheck.go:21:7: multiple-value pair() in single-value context (2 values)
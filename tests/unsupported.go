@@ -0,0 +1,15 @@
+package main
+
+var x int = 3
+
+func main() {
+	println(5)
+	if x == 0 {
+	}
+	println("still compiling")
+}
+
+// Errors:
+// unsupported.go:3:5: unsupported: I can't yet initialize package-level variables such as x
+// unsupported.go:6:10: unsupported: Argument to println has type int but should have type string!
+// unsupported.go:7:5: unsupported: I can only handle if statements with constant conditions
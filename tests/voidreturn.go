@@ -9,3 +9,8 @@ func sayhi() {
 func main() {
 	sayhi()
 }
+
+// What comes after the return is never compiled.
+// NoAsm: Die evil creatures
+// Stderr:
+// Hello world!
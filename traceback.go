@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"github.com/droundy/go/ir"
+	"github.com/droundy/go/x86"
+)
+
+// When a program panics, the runtime says where it was, and which
+// calls got it there, much as go does:
+//
+//	panic: the end
+//
+//	main.main()
+//		deadcode.go:24
+//
+// For that it needs to know which function any address is in, and on
+// which line, so we give it two tables in the data section, which are
+// a far simpler version of gc's pclntab.  goc.functab has an entry for
+// each function, with where its code starts and ends and its name.
+// goc.linetab has an entry for each statement, with where its code
+// starts and its file and line, in the order they come in the text.
+// Every entry is four words.  The runtime (goc.traceback) walks up the
+// stack using the frame pointers, which all our functions keep in %ebp,
+// and stops once it reaches an address that isn't in any of our
+// functions.
+
+// A tableLine is an entry of goc.linetab.
+
+type tableLine struct {
+	label, file string
+	line int
+}
+
+var lineTable []tableLine
+
+// lineLabel returns a label marking where the code for l starts, and
+// puts it in goc.linetab.
+func lineLabel(l *ir.Line) x86.X86 {
+	label := fmt.Sprint(".Lline.", len(lineTable))
+	lineTable = append(lineTable, tableLine{label, l.File, l.Line})
+	return x86.Symbol(label)
+}
+
+// goName is the name of a function as go would print it, such as
+// main.main for main_main.
+func goName(f *ir.Func) string {
+	if f.CDecl {
+		return f.Name
+	}
+	return strings.Replace(f.Name, "_", ".", 1)
+}
+
+// Tables returns goc.functab and goc.linetab for the functions of p,
+// which must come after their code.
+func Tables(p *ir.Program) []x86.X86 {
+	word := ".long"
+	if x86.WordSize == 8 {
+		word = ".quad"
+	}
+	var out, strs []string
+	labels := make(map[string]string)
+	str := func(s string) string {
+		l,ok := labels[s]
+		if !ok {
+			l = fmt.Sprint(".Ltable_string.", len(labels))
+			labels[s] = l
+			strs = append(strs, fmt.Sprintf("%s:\n\t.ascii %q", l, s))
+		}
+		return fmt.Sprint(len(s), ", ", l)
+	}
+	out = append(out, "\t.data", "goc.functab:")
+	for _,f := range p.Funcs {
+		if !f.External {
+			out = append(out, fmt.Sprintf("\t%s %s, .Lend_%s, %s", word, f.Name, f.Name, str(goName(f))))
+		}
+	}
+	out = append(out, "goc.functab_end:", "goc.linetab:")
+	for _,l := range lineTable {
+		out = append(out, fmt.Sprintf("\t%s %s, %d, %s", word, l.label, l.line, str(l.file)))
+	}
+	out = append(out, "goc.linetab_end:")
+	out = append(out, strs...)
+	out = append(out, "\t.text")
+	return []x86.X86{x86.RawAssembly(strings.Join(out, "\n"))}
+}
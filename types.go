@@ -1,66 +1,61 @@
 package main
 
 import (
-	"fmt"
+	"os"
+	"path"
+	"sort"
 	"go/ast"
+	"github.com/droundy/go/types"
 )
 
-func TypeToSize(t *ast.Type) (out int) {
-	switch t.Form {
-	case ast.Tuple:
-		for _,o := range t.Params.Objects {
-			out += TypeToSize(o.Type)
+// Info holds what the type checker has worked out about every
+// package we are compiling.
+var Info = types.NewInfo()
+
+// CheckPackages type checks pkgs, which must be in dependency order
+// (as ImportedPackages gives them to us), recording any errors.
+func CheckPackages(pkgs []*ast.Package) {
+	checked := make(map[string]*types.Package)
+	importer := func(ip string) (*types.Package, os.Error) {
+		_,name := path.Split(ip)
+		if p,ok := checked[name]; ok {
+			return p, nil
+		}
+		return nil, os.NewError("package " + ip + " hasn't been checked")
+	}
+	for _,p := range pkgs {
+		var names []string
+		for fn := range p.Files {
+			names = append(names, fn)
 		}
-		return out
-	case ast.Basic:
-		switch t.N {
-		case ast.String:
-			return 8
-		case ast.Int:
-			return 4
-		default:
-			panic(fmt.Sprintf("I don't know size of basic type %s", t))
+		sort.SortStrings(names) // so we always see the files in the same order
+		files := make([]*ast.File, len(names))
+		for i,fn := range names {
+			files[i] = p.Files[fn]
+		}
+		pkg,errs := types.Check(p.Name, files, importer, Info)
+		checked[p.Name] = pkg
+		for _,e := range errs {
+			kind := InvalidProgram
+			if e.Unsupported {
+				kind = UnsupportedFeature
+			}
+			Errors = append(Errors, &CompileError{ e.Pos, kind, e.Msg })
 		}
-	default:
-		panic(fmt.Sprintf("I don't know how to pop type %s", t.Form))
 	}
-	return
 }
 
-func SizeOnStack(t *ast.Type) (out int) {
-	out = TypeToSize(t)
-	if out & 3 != 0 {
-		return 4*(out/4 + 1)
+func TypeToSize(t types.Type) int {
+	size := types.Target.Sizeof(t)
+	if size < 0 {
+		Unsupported(nil, "I don't know the size of type %s", t)
 	}
-	return
-}
-
-var IntType *ast.Type = ast.NewType(ast.Basic)
-var StringType *ast.Type = ast.NewType(ast.Basic)
-
-func init() {
-	IntType.N = ast.Int
-	StringType.N = ast.String
+	return size
 }
 
-func PrettyType(t *ast.Type) string {
-	switch t.Form {
-	case ast.Tuple:
-		out := "("
-		for _,o := range t.Params.Objects {
-			if out != "(" {
-				out += ", "
-			}
-			out += PrettyType(o.Type)
-		}
-		return out + ")"
-	case ast.Basic:
-		switch t.N {
-		case ast.String:
-			return "string"
-		case ast.Int:
-			return "int"
-		}
-	}
-	return fmt.Sprint("weird type: ",t)
+// SizeOnStack is the size of t rounded up to a whole number of words,
+// since that's how much room it takes when we push it.
+func SizeOnStack(t types.Type) (out int) {
+	w := types.Target.WordSize
+	return (TypeToSize(t) + w - 1) / w * w
 }
@@ -0,0 +1,542 @@
+package types
+
+import (
+	"os"
+	"fmt"
+	"strconv"
+	"go/ast"
+	"go/token"
+)
+
+// An Error describes something wrong with the program being
+// checked.  Unsupported is set when the program may be fine, but
+// uses a part of go that the checker doesn't understand.
+
+type Error struct {
+	Pos token.Pos
+	Msg string
+	Unsupported bool
+}
+func (e *Error) String() string {
+	return e.Msg
+}
+
+// TypeAndValue describes an expression: its type, its value if it is
+// a constant, and whether it is a type rather than a value.
+
+type TypeAndValue struct {
+	Type Type
+	Value interface{}
+	IsType bool
+}
+
+// Info holds the results of checking a package, which is everything
+// the compiler needs to know about its expressions and identifiers.
+
+type Info struct {
+	Types map[ast.Expr]TypeAndValue
+	Defs map[*ast.Ident]Object // what each declared identifier declares
+	Uses map[*ast.Ident]Object // what each other identifier refers to
+}
+func NewInfo() *Info {
+	return &Info{make(map[ast.Expr]TypeAndValue),
+		make(map[*ast.Ident]Object), make(map[*ast.Ident]Object)}
+}
+
+// An Importer returns the (already checked) package with the given
+// import path.
+type Importer func(path string) (*Package, os.Error)
+
+type declInfo struct {
+	scope *Scope // the scope in which the declaration appears
+	typ ast.Expr
+	init ast.Expr
+	index int // which of the results of init we want, or -1 for all of them
+	fdecl *ast.FuncDecl
+	iota int64
+}
+
+type funcBody struct {
+	sig *Signature
+	body *ast.BlockStmt
+	scope *Scope
+}
+
+type checker struct {
+	pkg *Package
+	info *Info
+	importer Importer
+	errors []*Error
+
+	decls map[Object]*declInfo
+	order []Object // the package-level objects, in source order
+	resolving map[Object]bool
+	funcs []funcBody
+
+	// These describe where we are.
+	sig *Signature // the function whose body we are checking
+	iota interface{} // nil outside of constant declarations
+	locals []*Var // the local variables of the current function
+	used map[Object]bool
+}
+
+// Check type checks a package, recording the type of every
+// expression in info.  It returns the package, along with any errors
+// found.
+func Check(path string, files []*ast.File, importer Importer, info *Info) (*Package, []*Error) {
+	c := &checker{
+		info: info,
+		importer: importer,
+		decls: make(map[Object]*declInfo),
+		resolving: make(map[Object]bool),
+		used: make(map[Object]bool),
+	}
+	c.pkg = &Package{path, files[0].Name.Name, NewScope(Universe)}
+	var methods []*ast.FuncDecl
+	methodscopes := make(map[*ast.FuncDecl]*Scope)
+	var imports []*PkgName
+	for _,f := range files {
+		if f.Name.Name != c.pkg.Name {
+			c.errorf(f.Name.Pos(), "package %s; expected %s", f.Name.Name, c.pkg.Name)
+		}
+		fscope := NewScope(c.pkg.Scope)
+		for _,d := range f.Decls {
+			switch d := d.(type) {
+			case *ast.GenDecl:
+				if d.Tok == token.IMPORT {
+					for _,s := range d.Specs {
+						if p := c.importSpec(s.(*ast.ImportSpec), fscope); p != nil {
+							imports = append(imports, p)
+						}
+					}
+				} else {
+					c.genDecl(d, fscope, c.pkg.Scope, false)
+				}
+			case *ast.FuncDecl:
+				if d.Recv != nil {
+					methods = append(methods, d)
+					methodscopes[d] = fscope
+					continue
+				}
+				obj := NewFunc(d.Name.Pos(), c.pkg, d.Name.Name, nil)
+				di := &declInfo{scope: fscope, fdecl: d}
+				if d.Name.Name == "init" {
+					// init functions can't be referred to, so they don't go in
+					// the package scope.
+					c.info.Defs[d.Name] = obj
+					c.decls[obj] = di
+					c.order = append(c.order, obj)
+					continue
+				}
+				c.declare(c.pkg.Scope, d.Name, obj, di)
+			}
+		}
+	}
+	for _,m := range methods {
+		c.methodDecl(m, methodscopes[m])
+	}
+	for _,obj := range c.order {
+		c.objDecl(obj)
+	}
+	if c.pkg.Name == "main" {
+		if f,ok := c.pkg.Scope.Lookup("main").(*Func); !ok || f.Pkg != c.pkg {
+			c.errorf(files[0].Name.Pos(), "function main is undeclared in the main package")
+		} else if s := f.Signature(); s != nil && (s.Params.Len() > 0 || s.Results.Len() > 0) {
+			c.errorf(f.Pos(), "func main must have no arguments and no return values")
+		}
+	}
+	for i := 0; i < len(c.funcs); i++ {
+		// More functions may be added as we go, from function literals.
+		f := c.funcs[i]
+		c.funcBody(f.sig, f.body, f.scope)
+	}
+	for _,p := range imports {
+		if !c.used[p] {
+			c.errorf(p.Pos(), "imported and not used: %s", strconv.Quote(p.Imported.Path))
+		}
+	}
+	return c.pkg, c.errors
+}
+
+func (c *checker) errorf(pos token.Pos, format string, args ...interface{}) {
+	c.errors = append(c.errors, &Error{pos, fmt.Sprintf(format, args...), false})
+}
+
+func (c *checker) unsupported(pos token.Pos, format string, args ...interface{}) {
+	c.errors = append(c.errors, &Error{pos, fmt.Sprintf(format, args...), true})
+}
+
+// declare adds obj to scope s, complaining if there is already
+// something of the same name.  A non-nil d means that we will work
+// out the type of obj later, when we call objDecl.
+func (c *checker) declare(s *Scope, id *ast.Ident, obj Object, d *declInfo) {
+	c.info.Defs[id] = obj
+	if d != nil {
+		c.decls[obj] = d
+		if s == c.pkg.Scope {
+			c.order = append(c.order, obj)
+		}
+	}
+	if id.Name == "_" {
+		return
+	}
+	if old := s.Insert(obj); old != nil {
+		c.errorf(id.Pos(), "%s redeclared in this block", id.Name)
+	}
+}
+
+func (c *checker) importSpec(s *ast.ImportSpec, fscope *Scope) *PkgName {
+	path,err := strconv.Unquote(string(s.Path.Value))
+	if err != nil {
+		c.errorf(s.Path.Pos(), "invalid import path %s", string(s.Path.Value))
+		return nil
+	}
+	if c.importer == nil {
+		c.unsupported(s.Path.Pos(), "can't import %s without an importer", path)
+		return nil
+	}
+	pkg,err := c.importer(path)
+	if err != nil {
+		c.errorf(s.Path.Pos(), "can't find import %s: %s", path, fmt.Sprint(err))
+		return nil
+	}
+	name := pkg.Name
+	if s.Name != nil {
+		name = s.Name.Name
+	}
+	switch name {
+	case "_":
+		return nil
+	case ".":
+		c.unsupported(s.Name.Pos(), "I don't handle dot imports")
+		return nil
+	}
+	p := &PkgName{object{name, Typ[Invalid], s.Path.Pos()}, pkg}
+	if s.Name != nil {
+		c.info.Defs[s.Name] = p
+	}
+	if old := fscope.Insert(p); old != nil {
+		c.errorf(s.Path.Pos(), "%s redeclared in this block", name)
+	}
+	return p
+}
+
+// genDecl declares the constants, variables and types of d in scope
+// s.  At the package level their types are worked out later, but
+// local declarations are dealt with immediately, so that they can only
+// refer to what came before them.
+func (c *checker) genDecl(d *ast.GenDecl, fscope, s *Scope, local bool) {
+	var lastTyp ast.Expr
+	var lastValues []ast.Expr
+	for iota,spec := range d.Specs {
+		switch spec := spec.(type) {
+		case *ast.TypeSpec:
+			obj := NewTypeName(spec.Name.Pos(), c.pkg, spec.Name.Name, nil)
+			di := &declInfo{scope: fscope, typ: spec.Type}
+			c.declare(s, spec.Name, obj, di)
+			if local {
+				c.objDecl(obj)
+			}
+		case *ast.ValueSpec:
+			if d.Tok == token.CONST && (spec.Type != nil || len(spec.Values) > 0) {
+				lastTyp, lastValues = spec.Type, spec.Values
+			} else if d.Tok == token.VAR {
+				lastTyp, lastValues = spec.Type, spec.Values
+			}
+			var objs []Object
+			for i,n := range spec.Names {
+				di := &declInfo{scope: fscope, typ: lastTyp, index: -1, iota: int64(iota)}
+				switch {
+				case len(lastValues) == len(spec.Names):
+					di.init = lastValues[i]
+				case len(lastValues) == 1 && d.Tok == token.VAR:
+					// As in var a, b = f()
+					di.init = lastValues[0]
+					di.index = i
+				case len(lastValues) > 0 || d.Tok == token.CONST:
+					c.errorf(n.Pos(), "wrong number of initializers for %s", n.Name)
+					continue
+				}
+				var obj Object
+				if d.Tok == token.CONST {
+					obj = NewConst(n.Pos(), n.Name, nil, nil)
+				} else {
+					v := NewVar(n.Pos(), n.Name, nil)
+					if local {
+						c.locals = append(c.locals, v)
+					}
+					obj = v
+				}
+				if local {
+					// The new names aren't visible until after the
+					// declaration.
+					c.info.Defs[n] = obj
+					c.decls[obj] = di
+					c.objDecl(obj)
+					objs = append(objs, obj)
+				} else {
+					c.declare(s, n, obj, di)
+				}
+			}
+			for _,obj := range objs {
+				if obj.Name() != "_" {
+					if old := s.Insert(obj); old != nil {
+						c.errorf(obj.Pos(), "%s redeclared in this block", obj.Name())
+					}
+				}
+			}
+		}
+	}
+}
+
+// objDecl works out the type (and value, for constants) of an object
+// declared at the package level, if we haven't already done so.
+func (c *checker) objDecl(obj Object) {
+	d,ok := c.decls[obj]
+	if !ok || obj.Type() != nil {
+		return
+	}
+	if c.resolving[obj] {
+		c.errorf(obj.Pos(), "initialization loop involving %s", obj.Name())
+		c.setType(obj, Typ[Invalid])
+		return
+	}
+	c.resolving[obj] = true
+	defer func() { c.resolving[obj] = false }()
+
+	// The declaration may be some way from where we are, so we start
+	// with a clean slate.
+	oldsig, oldiota := c.sig, c.iota
+	defer func() { c.sig, c.iota = oldsig, oldiota }()
+	c.iota = nil
+
+	switch o := obj.(type) {
+	case *Const:
+		c.iota = d.iota
+		var t Type
+		if d.typ != nil {
+			t = c.typExpr(d.typ, d.scope)
+		}
+		if d.init == nil {
+			o.typ = Typ[Invalid]
+			return
+		}
+		x := c.expr(d.init, d.scope)
+		if x.mode != constant {
+			if x.mode != invalid {
+				c.errorf(d.init.Pos(), "%s is not constant", exprString(d.init))
+			}
+			o.typ = Typ[Invalid]
+			return
+		}
+		if t != nil && !c.assignment(x, t, "constant declaration") {
+			o.typ = Typ[Invalid]
+			return
+		}
+		o.typ, o.Val = x.typ, x.val
+	case *Var:
+		if d.typ != nil {
+			o.typ = c.typExpr(d.typ, d.scope)
+		}
+		if d.init == nil {
+			if o.typ == nil {
+				o.typ = Typ[Invalid]
+			}
+			return
+		}
+		x := c.expr(d.init, d.scope)
+		if d.index >= 0 {
+			tup,ok := x.typ.(*Tuple)
+			if x.mode == invalid {
+				o.typ = Typ[Invalid]
+				return
+			} else if !ok || d.index >= tup.Len() {
+				c.errorf(d.init.Pos(), "assignment count mismatch")
+				o.typ = Typ[Invalid]
+				return
+			}
+			x = &operand{value, d.init, tup.Vars[d.index].Type(), nil, nil}
+		}
+		if o.typ == nil {
+			if c.assignment(x, nil, "variable declaration") {
+				o.typ = x.typ
+			} else {
+				o.typ = Typ[Invalid]
+			}
+		} else {
+			c.assignment(x, o.typ, "variable declaration")
+		}
+	case *TypeName:
+		named := NewNamed(o, nil) // so that recursive types can refer to it
+		t := c.typExpr(d.typ, d.scope)
+		if n,ok := t.(*Named); ok && n.underlying == nil {
+			c.errorf(o.Pos(), "invalid recursive type %s", o.Name())
+			t = Typ[Invalid]
+		}
+		named.SetUnderlying(t)
+	case *Func:
+		sig := c.funcType(nil, d.fdecl.Type, d.scope)
+		o.typ = sig
+		if d.fdecl.Body != nil {
+			c.funcs = append(c.funcs, funcBody{sig, d.fdecl.Body, d.scope})
+		}
+	}
+}
+
+func (c *checker) setType(obj Object, t Type) {
+	switch o := obj.(type) {
+	case *Const:
+		o.typ = t
+	case *Var:
+		o.typ = t
+	case *TypeName:
+		o.typ = t
+	case *Func:
+		o.typ = t
+	}
+}
+
+// methodDecl attaches a method to its receiver's type.
+func (c *checker) methodDecl(d *ast.FuncDecl, fscope *Scope) {
+	obj := NewFunc(d.Name.Pos(), c.pkg, d.Name.Name, nil)
+	c.info.Defs[d.Name] = obj
+	if len(d.Recv.List) != 1 {
+		c.errorf(d.Recv.Pos(), "method %s must have exactly one receiver", d.Name.Name)
+		return
+	}
+	rtype := d.Recv.List[0].Type
+	if s,ok := rtype.(*ast.StarExpr); ok {
+		rtype = s.X
+	}
+	id,ok := rtype.(*ast.Ident)
+	if !ok {
+		c.errorf(rtype.Pos(), "invalid receiver type")
+		return
+	}
+	tn,ok := c.pkg.Scope.Lookup(id.Name).(*TypeName)
+	if !ok || tn.Pkg != c.pkg {
+		c.errorf(id.Pos(), "undefined receiver type %s", id.Name)
+		return
+	}
+	c.objDecl(tn)
+	named,ok := tn.Type().(*Named)
+	if !ok {
+		c.errorf(id.Pos(), "invalid receiver type %s", id.Name)
+		return
+	}
+	switch named.Underlying().(type) {
+	case *Pointer, *Interface:
+		c.errorf(id.Pos(), "invalid receiver type %s (pointer or interface type)", id.Name)
+		return
+	}
+	if d.Name.Name != "_" {
+		if findMethod(named.Methods, d.Name.Name) != nil {
+			c.errorf(d.Name.Pos(), "method %s.%s redeclared", id.Name, d.Name.Name)
+			return
+		}
+		if s,ok := named.Underlying().(*Struct); ok {
+			for _,f := range s.Fields {
+				if f.Name() == d.Name.Name {
+					c.errorf(d.Name.Pos(), "type %s has both field and method named %s",
+						id.Name, d.Name.Name)
+					return
+				}
+			}
+		}
+	}
+	sig := c.funcType(d.Recv, d.Type, fscope)
+	obj.typ = sig
+	named.Methods = append(named.Methods, obj)
+	if d.Body != nil {
+		c.funcs = append(c.funcs, funcBody{sig, d.Body, fscope})
+	}
+}
+
+// funcType works out the signature of a function (or method, if recv
+// is non-nil).
+func (c *checker) funcType(recv *ast.FieldList, ft *ast.FuncType, scope *Scope) *Signature {
+	sig := &Signature{}
+	if recv != nil {
+		r,_ := c.collectParams(recv, scope, false)
+		if len(r) == 1 {
+			sig.Recv = r[0]
+		}
+	}
+	params,variadic := c.collectParams(ft.Params, scope, true)
+	results,_ := c.collectParams(ft.Results, scope, false)
+	sig.Params = &Tuple{params}
+	sig.Results = &Tuple{results}
+	sig.Variadic = variadic
+	return sig
+}
+
+func (c *checker) collectParams(fl *ast.FieldList, scope *Scope, variadicOk bool) (out []*Var, variadic bool) {
+	if fl == nil {
+		return
+	}
+	for i,f := range fl.List {
+		ftype := f.Type
+		if e,ok := ftype.(*ast.Ellipsis); ok {
+			if !variadicOk || i != len(fl.List)-1 || len(f.Names) > 1 {
+				c.errorf(e.Pos(), "can only use ... as the type of the final parameter")
+			}
+			variadic = true
+			var elem Type = &Interface{}
+			if e.Elt != nil {
+				elem = c.typExpr(e.Elt, scope)
+			}
+			for _,n := range f.Names {
+				out = append(out, NewVar(n.Pos(), n.Name, &Slice{elem}))
+			}
+			if len(f.Names) == 0 {
+				out = append(out, NewVar(e.Pos(), "", &Slice{elem}))
+			}
+			continue
+		}
+		t := c.typExpr(ftype, scope)
+		if len(f.Names) == 0 {
+			out = append(out, NewVar(ftype.Pos(), "", t))
+		}
+		for _,n := range f.Names {
+			v := NewVar(n.Pos(), n.Name, t)
+			c.info.Defs[n] = v
+			out = append(out, v)
+		}
+	}
+	return
+}
+
+// funcBody checks the body of a function.
+func (c *checker) funcBody(sig *Signature, body *ast.BlockStmt, scope *Scope) {
+	oldsig, oldlocals := c.sig, c.locals
+	defer func() { c.sig, c.locals = oldsig, oldlocals }()
+	c.sig, c.locals = sig, nil
+
+	s := NewScope(scope)
+	if sig.Recv != nil && sig.Recv.Name() != "" && sig.Recv.Name() != "_" {
+		s.Insert(sig.Recv)
+	}
+	for _,v := range sig.Params.Vars {
+		if v.Name() != "" && v.Name() != "_" {
+			if old := s.Insert(v); old != nil {
+				c.errorf(v.Pos(), "duplicate argument %s", v.Name())
+			}
+		}
+	}
+	for _,v := range sig.Results.Vars {
+		if v.Name() != "" && v.Name() != "_" {
+			if old := s.Insert(v); old != nil {
+				c.errorf(v.Pos(), "duplicate argument %s", v.Name())
+			}
+		}
+	}
+	c.stmtList(body.List, s)
+	if sig.Results.Len() > 0 && !isTerminating(body) {
+		c.errorf(body.Rbrace, "missing return at end of function")
+	}
+	for _,v := range c.locals {
+		if !c.used[v] && v.Name() != "_" {
+			c.errorf(v.Pos(), "%s declared and not used", v.Name())
+		}
+	}
+}
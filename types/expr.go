@@ -0,0 +1,1285 @@
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"go/ast"
+	"go/token"
+)
+
+// An operand is the result of checking an expression.
+
+type operandMode int
+const (
+	invalid operandMode = iota // something went wrong, and we've said so
+	novalue // a call of a function with no results
+	builtin // a builtin function, which must be called
+	typexpr // a type
+	constant // a constant, whose value is in val
+	variable // an addressable value
+	value // any other value
+)
+
+type operand struct {
+	mode operandMode
+	expr ast.Expr
+	typ Type
+	val interface{}
+	obj Object // the builtin, for builtins
+}
+
+// expr checks e, recording its type.
+func (c *checker) expr(e ast.Expr, scope *Scope) *operand {
+	x := &operand{invalid, e, nil, nil, nil}
+	c.exprInternal(x, e, scope)
+	x.expr = e
+	if x.mode == invalid || x.typ == nil {
+		x.mode, x.typ = invalid, Typ[Invalid]
+	}
+	c.record(x)
+	return x
+}
+
+func (c *checker) record(x *operand) {
+	switch x.mode {
+	case invalid, builtin:
+		return
+	case typexpr:
+		c.info.Types[x.expr] = TypeAndValue{x.typ, nil, true}
+	default:
+		c.info.Types[x.expr] = TypeAndValue{x.typ, x.val, false}
+	}
+}
+
+func (c *checker) exprInternal(x *operand, e ast.Expr, scope *Scope) {
+	switch e := e.(type) {
+	case *ast.Ident:
+		if e.Name == "_" {
+			c.errorf(e.Pos(), "cannot use _ as value")
+			return
+		}
+		obj := scope.Lookup(e.Name)
+		if obj == nil {
+			c.errorf(e.Pos(), "undefined: %s", e.Name)
+			return
+		}
+		c.info.Uses[e] = obj
+		if p,ok := obj.(*PkgName); ok {
+			c.errorf(e.Pos(), "use of package %s without selector", p.Name())
+			return
+		}
+		c.object(x, obj)
+	case *ast.BasicLit:
+		c.basicLit(x, e)
+	case *ast.FuncLit:
+		sig := c.funcType(nil, e.Type, scope)
+		c.funcBody(sig, e.Body, scope)
+		x.mode, x.typ = value, sig
+	case *ast.CompositeLit:
+		c.compositeLit(x, e, nil, scope)
+	case *ast.ParenExpr:
+		*x = *c.expr(e.X, scope)
+	case *ast.SelectorExpr:
+		c.selector(x, e, scope)
+	case *ast.IndexExpr:
+		c.index(x, e, scope)
+	case *ast.StarExpr:
+		y := c.expr(e.X, scope)
+		switch y.mode {
+		case invalid:
+			return
+		case typexpr:
+			x.mode, x.typ = typexpr, &Pointer{y.typ}
+			return
+		}
+		p,ok := y.typ.Underlying().(*Pointer)
+		if !ok {
+			c.errorf(e.Pos(), "invalid indirect of %s (type %s)", exprString(e.X), y.typ)
+			return
+		}
+		x.mode, x.typ = variable, p.Elem
+	case *ast.UnaryExpr:
+		if e.Op == token.AND {
+			y := c.expr(e.X, scope)
+			if y.mode == invalid {
+				return
+			}
+			if _,ok := unparen(e.X).(*ast.CompositeLit); !ok && y.mode != variable {
+				c.errorf(e.Pos(), "cannot take the address of %s", exprString(e.X))
+				return
+			}
+			x.mode, x.typ = value, &Pointer{y.typ}
+			return
+		}
+		*x = *c.expr(e.X, scope)
+		x.expr = e
+		c.unary(x, e.Op)
+	case *ast.BinaryExpr:
+		*x = *c.expr(e.X, scope)
+		y := c.expr(e.Y, scope)
+		x.expr = e
+		c.binary(x, y, e.Op, e.Y)
+	case *ast.CallExpr:
+		c.call(x, e, scope)
+	case *ast.ArrayType, *ast.MapType, *ast.FuncType, *ast.StructType, *ast.InterfaceType:
+		x.mode, x.typ = typexpr, c.typInternal(e, scope)
+	case *ast.KeyValueExpr:
+		c.errorf(e.Pos(), "unexpected key:value expression")
+	default:
+		c.unsupported(e.Pos(), "I can't check expressions such as %T", e)
+	}
+}
+
+// object makes x refer to obj.
+func (c *checker) object(x *operand, obj Object) {
+	c.used[obj] = true
+	c.objDecl(obj)
+	switch o := obj.(type) {
+	case *Const:
+		if o == universeIota {
+			if c.iota == nil {
+				c.errorf(x.expr.Pos(), "cannot use iota outside constant declaration")
+				return
+			}
+			x.mode, x.typ, x.val = constant, Typ[UntypedInt], c.iota
+			return
+		}
+		if o.Type() == Typ[Invalid] {
+			return // we've already complained
+		}
+		x.mode, x.typ, x.val = constant, o.Type(), o.Val
+	case *Var:
+		x.mode, x.typ = variable, o.Type()
+	case *TypeName:
+		x.mode, x.typ = typexpr, o.Type()
+	case *Func:
+		x.mode, x.typ = value, o.Type()
+	case *Builtin:
+		x.mode, x.obj = builtin, o
+		x.typ = Typ[Invalid]
+	case *Nil:
+		x.mode, x.typ = value, Typ[UntypedNil]
+	}
+}
+
+func (c *checker) basicLit(x *operand, e *ast.BasicLit) {
+	lit := string(e.Value)
+	switch e.Kind {
+	case token.INT:
+		v,err := strconv.Btoi64(lit, 0)
+		if err != nil {
+			c.unsupported(e.Pos(), "I can't handle the integer %s", lit)
+			return
+		}
+		x.mode, x.typ, x.val = constant, Typ[UntypedInt], v
+	case token.CHAR:
+		s,err := strconv.Unquote(lit)
+		if err != nil {
+			c.errorf(e.Pos(), "invalid character literal %s", lit)
+			return
+		}
+		for _,r := range s {
+			x.val = int64(r)
+			break
+		}
+		x.mode, x.typ = constant, Typ[UntypedInt]
+	case token.FLOAT:
+		x.mode, x.typ = constant, Typ[UntypedFloat]
+	case token.STRING:
+		s,err := strconv.Unquote(lit)
+		if err != nil {
+			c.errorf(e.Pos(), "invalid string literal %s", lit)
+			return
+		}
+		x.mode, x.typ, x.val = constant, Typ[UntypedString], s
+	default:
+		c.unsupported(e.Pos(), "I don't handle literals such as %s", lit)
+	}
+}
+
+func (c *checker) selector(x *operand, e *ast.SelectorExpr, scope *Scope) {
+	name := e.Sel.Name
+	if id,ok := e.X.(*ast.Ident); ok {
+		if p,ok := scope.Lookup(id.Name).(*PkgName); ok {
+			c.info.Uses[id] = p
+			c.used[p] = true
+			obj,ok := p.Imported.Scope.Objects[name]
+			if !ok {
+				c.errorf(e.Sel.Pos(), "undefined: %s.%s", id.Name, name)
+				return
+			}
+			if !ast.IsExported(name) {
+				c.errorf(e.Sel.Pos(), "cannot refer to unexported name %s.%s", id.Name, name)
+				return
+			}
+			c.info.Uses[e.Sel] = obj
+			c.object(x, obj)
+			return
+		}
+	}
+	y := c.expr(e.X, scope)
+	if y.mode == invalid {
+		return
+	}
+	if y.mode == typexpr {
+		// A method expression, such as T.String, which is a function
+		// taking the receiver as its first argument.
+		m,ok := LookupFieldOrMethod(y.typ, name).(*Func)
+		if !ok || findMethod(MethodSet(y.typ), name) == nil {
+			c.errorf(e.Sel.Pos(), "%s undefined (type %s has no method %s)", exprString(e), y.typ, name)
+			return
+		}
+		c.info.Uses[e.Sel] = m
+		sig := m.Signature()
+		params := append([]*Var{NewVar(token.NoPos, "", y.typ)}, sig.Params.Vars...)
+		x.mode, x.typ = value, &Signature{nil, &Tuple{params}, sig.Results, sig.Variadic}
+		return
+	}
+	obj := LookupFieldOrMethod(y.typ, name)
+	if obj == nil {
+		c.errorf(e.Sel.Pos(), "%s undefined (type %s has no field or method %s)",
+			exprString(e), y.typ, name)
+		return
+	}
+	c.info.Uses[e.Sel] = obj
+	_,isptr := y.typ.Underlying().(*Pointer)
+	switch o := obj.(type) {
+	case *Var:
+		x.mode, x.typ = value, o.Type()
+		if y.mode == variable || isptr {
+			x.mode = variable
+		}
+	case *Func:
+		sig := o.Signature()
+		if sig.Recv != nil {
+			if _,ptrrecv := sig.Recv.Type().(*Pointer); ptrrecv && !isptr && y.mode != variable {
+				c.errorf(e.Pos(), "cannot call pointer method %s on %s", name, y.typ)
+				return
+			}
+		}
+		x.mode, x.typ = value, &Signature{nil, sig.Params, sig.Results, sig.Variadic}
+	}
+}
+
+func (c *checker) index(x *operand, e *ast.IndexExpr, scope *Scope) {
+	y := c.expr(e.X, scope)
+	if y.mode == invalid {
+		c.expr(e.Index, scope)
+		return
+	}
+	length := int64(-1)
+	switch t := y.typ.Underlying().(type) {
+	case *Basic:
+		if !IsString(t) {
+			break
+		}
+		x.mode, x.typ = value, Typ[Uint8]
+		if s,ok := y.val.(string); ok {
+			length = int64(len(s))
+		}
+	case *Array:
+		x.mode, x.typ = value, t.Elem
+		if y.mode == variable {
+			x.mode = variable
+		}
+		length = t.Len
+	case *Pointer:
+		if a,ok := t.Elem.Underlying().(*Array); ok {
+			x.mode, x.typ = variable, a.Elem
+			length = a.Len
+		}
+	case *Slice:
+		x.mode, x.typ = variable, t.Elem
+	case *Map:
+		k := c.expr(e.Index, scope)
+		c.assignment(k, t.Key, "map index")
+		x.mode, x.typ = value, t.Elem
+		return
+	}
+	if x.mode == invalid {
+		c.errorf(e.Pos(), "invalid operation: %s (index of type %s)", exprString(e), y.typ)
+		c.expr(e.Index, scope)
+		return
+	}
+	i := c.expr(e.Index, scope)
+	if i.mode == invalid {
+		return
+	}
+	if !IsInteger(i.typ) {
+		c.errorf(e.Index.Pos(), "non-integer index %s", exprString(e.Index))
+		return
+	}
+	c.assignment(i, nil, "index")
+	if v,ok := i.val.(int64); ok {
+		if v < 0 {
+			c.errorf(e.Index.Pos(), "invalid index %s (index must be non-negative)", exprString(e.Index))
+		} else if length >= 0 && v >= length {
+			c.errorf(e.Index.Pos(), "invalid index %s (out of bounds for %d-element array)",
+				exprString(e.Index), length)
+		}
+	}
+}
+
+// compositeLit checks a composite literal, whose type is hint if it
+// was left out, as it may be within another composite literal.
+func (c *checker) compositeLit(x *operand, e *ast.CompositeLit, hint Type, scope *Scope) {
+	t := hint
+	openarray := false
+	switch {
+	case e.Type != nil:
+		if a,ok := e.Type.(*ast.ArrayType); ok && a.Len != nil {
+			if _,ok := a.Len.(*ast.Ellipsis); ok {
+				// We'll work out the length once we've seen the elements.
+				t = &Array{0, c.typExpr(a.Elt, scope)}
+				openarray = true
+				break
+			}
+		}
+		t = c.typExpr(e.Type, scope)
+	case hint == nil:
+		c.errorf(e.Pos(), "missing type in composite literal")
+		return
+	}
+	switch u := t.Underlying().(type) {
+	case *Struct:
+		if len(e.Elts) > 0 {
+			if _,keyed := e.Elts[0].(*ast.KeyValueExpr); keyed {
+				for _,elt := range e.Elts {
+					kv,ok := elt.(*ast.KeyValueExpr)
+					if !ok {
+						c.errorf(elt.Pos(), "mixture of field:value and value initializers")
+						continue
+					}
+					key,ok := kv.Key.(*ast.Ident)
+					var field *Var
+					if ok {
+						for _,f := range u.Fields {
+							if f.Name() == key.Name {
+								field = f
+							}
+						}
+					}
+					if field == nil {
+						c.errorf(kv.Key.Pos(), "unknown field %s in struct literal", exprString(kv.Key))
+						continue
+					}
+					c.info.Uses[key] = field
+					c.element(kv.Value, field.Type(), "struct literal", scope)
+				}
+				break
+			}
+			for i,elt := range e.Elts {
+				if i >= len(u.Fields) {
+					c.errorf(elt.Pos(), "too many values in struct initializer")
+					break
+				}
+				c.element(elt, u.Fields[i].Type(), "struct literal", scope)
+			}
+			if len(e.Elts) < len(u.Fields) {
+				c.errorf(e.Rbrace, "too few values in struct initializer")
+			}
+		}
+	case *Array, *Slice:
+		var elem Type
+		if a,ok := u.(*Array); ok {
+			elem = a.Elem
+		} else {
+			elem = u.(*Slice).Elem
+		}
+		max, i := int64(0), int64(0)
+		for _,elt := range e.Elts {
+			if kv,ok := elt.(*ast.KeyValueExpr); ok {
+				k := c.expr(kv.Key, scope)
+				if v,ok := k.val.(int64); ok && k.mode == constant && v >= 0 {
+					i = v
+				} else if k.mode != invalid {
+					c.errorf(kv.Key.Pos(), "index %s must be a non-negative integer constant",
+						exprString(kv.Key))
+				}
+				elt = kv.Value
+			}
+			if a,ok := u.(*Array); ok && !openarray && i >= a.Len {
+				c.errorf(elt.Pos(), "array index %d out of bounds [0:%d]", i, a.Len)
+			}
+			c.element(elt, elem, "array or slice literal", scope)
+			i++
+			if i > max {
+				max = i
+			}
+		}
+		if openarray {
+			t.(*Array).Len = max
+			c.info.Types[e.Type] = TypeAndValue{t, nil, true}
+		}
+	case *Map:
+		for _,elt := range e.Elts {
+			kv,ok := elt.(*ast.KeyValueExpr)
+			if !ok {
+				c.errorf(elt.Pos(), "missing key in map literal")
+				continue
+			}
+			c.element(kv.Key, u.Key, "map literal", scope)
+			c.element(kv.Value, u.Elem, "map literal", scope)
+		}
+	default:
+		if u != Typ[Invalid] {
+			c.errorf(e.Pos(), "invalid type for composite literal: %s", t)
+		}
+		return
+	}
+	x.mode, x.typ = value, t
+}
+
+func (c *checker) element(e ast.Expr, t Type, context string, scope *Scope) {
+	if cl,ok := e.(*ast.CompositeLit); ok && cl.Type == nil {
+		x := &operand{invalid, e, nil, nil, nil}
+		c.compositeLit(x, cl, t, scope)
+		c.record(x)
+		return
+	}
+	c.assignment(c.expr(e, scope), t, context)
+}
+
+func (c *checker) unary(x *operand, op token.Token) {
+	if x.mode == invalid || !c.singleValue(x) {
+		return
+	}
+	ok := false
+	switch op {
+	case token.ADD, token.SUB:
+		ok = IsNumeric(x.typ)
+	case token.XOR:
+		ok = IsInteger(x.typ)
+	case token.NOT:
+		ok = IsBoolean(x.typ)
+	case token.ARROW:
+		c.unsupported(x.expr.Pos(), "I don't handle channels")
+		x.mode = invalid
+		return
+	}
+	if !ok {
+		c.errorf(x.expr.Pos(), "invalid operation: %s%s (operator %s not defined on %s)",
+			op, exprString(x.expr), op, x.typ)
+		x.mode = invalid
+		return
+	}
+	if x.mode != constant {
+		x.mode = value
+		return
+	}
+	switch v := x.val.(type) {
+	case int64:
+		switch op {
+		case token.SUB:
+			if v == minInt64 {
+				c.tooBig(x)
+				return
+			}
+			x.val = -v
+		case token.XOR:
+			x.val = ^v
+			if IsUnsigned(x.typ) {
+				bits := uint(8*Target.Sizeof(x.typ))
+				x.val = ^v & (1<<bits - 1)
+			}
+		}
+	case bool:
+		x.val = !v
+	}
+	c.overflow(x)
+}
+
+func isComparison(op token.Token) bool {
+	switch op {
+	case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+		return true
+	}
+	return false
+}
+
+// binary checks x op y, leaving the result in x.
+func (c *checker) binary(x, y *operand, op token.Token, ye ast.Expr) {
+	if x.mode == invalid || y.mode == invalid || !c.singleValue(x) || !c.singleValue(y) {
+		x.mode = invalid
+		return
+	}
+	if op == token.SHL || op == token.SHR {
+		c.shift(x, y, op)
+		return
+	}
+	if isComparison(op) {
+		c.comparison(x, y, op, ye)
+		return
+	}
+	c.matchTypes(x, y)
+	if x.mode == invalid || y.mode == invalid {
+		x.mode = invalid
+		return
+	}
+	if !Identical(x.typ, y.typ) {
+		c.errorf(x.expr.Pos(), "invalid operation: %s (mismatched types %s and %s)",
+			exprString(x.expr), x.typ, y.typ)
+		x.mode = invalid
+		return
+	}
+	ok := false
+	switch op {
+	case token.ADD:
+		ok = IsNumeric(x.typ) || IsString(x.typ)
+	case token.SUB, token.MUL, token.QUO:
+		ok = IsNumeric(x.typ)
+	case token.REM, token.AND, token.OR, token.XOR, token.AND_NOT:
+		ok = IsInteger(x.typ)
+	case token.LAND, token.LOR:
+		ok = IsBoolean(x.typ)
+	}
+	if !ok {
+		c.errorf(x.expr.Pos(), "invalid operation: operator %s not defined on %s (type %s)",
+			op, exprString(x.expr), x.typ)
+		x.mode = invalid
+		return
+	}
+	if (op == token.QUO || op == token.REM) && y.mode == constant {
+		if v,ok := y.val.(int64); ok && v == 0 {
+			c.errorf(ye.Pos(), "division by zero")
+			x.mode = invalid
+			return
+		}
+	}
+	if x.mode == constant && y.mode == constant {
+		v,ok := constBinary(op, x.val, y.val)
+		if !ok {
+			c.tooBig(x)
+			return
+		}
+		x.val = v
+		c.overflow(x)
+		return
+	}
+	x.mode, x.val = value, nil
+}
+
+// matchTypes converts an untyped operand to the type of the other
+// operand.  If both are untyped numbers, the result is the "larger"
+// of the two kinds.
+func (c *checker) matchTypes(x, y *operand) {
+	xu, yu := IsUntyped(x.typ), IsUntyped(y.typ)
+	switch {
+	case xu && !yu:
+		c.convertUntyped(x, y.typ)
+	case yu && !xu:
+		c.convertUntyped(y, x.typ)
+	case xu && yu && IsNumeric(x.typ) && IsNumeric(y.typ):
+		if x.typ.(*Basic).Kind < y.typ.(*Basic).Kind {
+			x.typ = y.typ
+		} else {
+			y.typ = x.typ
+		}
+	}
+}
+
+// convertUntyped gives the untyped operand x the type t, if it can
+// have that type.
+func (c *checker) convertUntyped(x *operand, t Type) {
+	if x.mode == invalid || !IsUntyped(x.typ) || t == Typ[Invalid] {
+		return
+	}
+	if x.typ.(*Basic).Kind == UntypedNil {
+		switch t.Underlying().(type) {
+		case *Pointer, *Signature, *Slice, *Map, *Interface:
+			x.typ = t
+			c.record(x)
+			return
+		}
+		if IsUntyped(t) {
+			return // as in nil == nil, which the comparison will catch
+		}
+		c.errorf(x.expr.Pos(), "cannot convert nil to type %s", t)
+		x.mode = invalid
+		return
+	}
+	if IsInterface(t) {
+		x.typ = Default(x.typ)
+		c.record(x)
+		return
+	}
+	compatible := IsNumeric(x.typ) && IsNumeric(t) ||
+		IsString(x.typ) && IsString(t) ||
+		IsBoolean(x.typ) && IsBoolean(t)
+	if !compatible {
+		c.errorf(x.expr.Pos(), "cannot convert %s (type %s) to type %s", exprString(x.expr), x.typ, t)
+		x.mode = invalid
+		return
+	}
+	if x.mode == constant && !Representable(x.val, t) {
+		if IsInteger(t) && x.val == nil {
+			c.errorf(x.expr.Pos(), "constant %s truncated to integer", exprString(x.expr))
+		} else {
+			c.errorf(x.expr.Pos(), "constant %v overflows %s", x.val, t)
+		}
+		x.mode = invalid
+		return
+	}
+	x.typ = t
+	c.record(x)
+}
+
+func (c *checker) comparison(x, y *operand, op token.Token, ye ast.Expr) {
+	c.matchTypes(x, y)
+	if x.mode == invalid || y.mode == invalid {
+		x.mode = invalid
+		return
+	}
+	if !AssignableTo(x.typ, x.val, y.typ) && !AssignableTo(y.typ, y.val, x.typ) {
+		c.errorf(x.expr.Pos(), "invalid operation: %s %s %s (mismatched types %s and %s)",
+			exprString(x.expr), op, exprString(ye), x.typ, y.typ)
+		x.mode = invalid
+		return
+	}
+	ok := true
+	switch op {
+	case token.EQL, token.NEQ:
+		isnil := func(o *operand) bool {
+			b,ok := o.typ.(*Basic)
+			return ok && b.Kind == UntypedNil || o.val == nil && o.mode == value && isNilExpr(o.expr)
+		}
+		if !isnil(x) && !isnil(y) {
+			switch x.typ.Underlying().(type) {
+			case *Slice, *Map, *Signature:
+				ok = false
+			}
+		}
+	default:
+		ok = IsNumeric(x.typ) || IsString(x.typ)
+	}
+	if !ok {
+		c.errorf(x.expr.Pos(), "invalid operation: %s %s %s (operator %s not defined on %s)",
+			exprString(x.expr), op, exprString(ye), op, x.typ)
+		x.mode = invalid
+		return
+	}
+	if x.mode == constant && y.mode == constant && x.val != nil && y.val != nil {
+		x.val = constCompare(op, x.val, y.val)
+	} else {
+		x.mode, x.val = value, nil
+	}
+	x.typ = Typ[UntypedBool]
+}
+
+func isNilExpr(e ast.Expr) bool {
+	id,ok := unparen(e).(*ast.Ident)
+	return ok && id.Name == "nil"
+}
+
+func (c *checker) shift(x, y *operand, op token.Token) {
+	if !IsInteger(y.typ) {
+		c.errorf(y.expr.Pos(), "invalid shift count %s (type %s)", exprString(y.expr), y.typ)
+		x.mode = invalid
+		return
+	}
+	if v,ok := y.val.(int64); ok && y.mode == constant && v < 0 {
+		c.errorf(y.expr.Pos(), "invalid negative shift count %s", exprString(y.expr))
+		x.mode = invalid
+		return
+	}
+	c.assignment(y, nil, "shift count")
+	if !IsInteger(x.typ) {
+		c.errorf(x.expr.Pos(), "invalid operation: shift of %s (type %s)", exprString(x.expr), x.typ)
+		x.mode = invalid
+		return
+	}
+	if x.mode == constant && y.mode == constant {
+		s := y.val.(int64)
+		if s >= 64 {
+			c.unsupported(y.expr.Pos(), "I can't handle shift counts as large as %d", s)
+			x.mode = invalid
+			return
+		}
+		if op == token.SHL {
+			v := x.val.(int64)
+			if v << uint(s) >> uint(s) != v {
+				c.tooBig(x)
+				return
+			}
+			x.val = v << uint(s)
+		} else {
+			x.val = x.val.(int64) >> uint(s)
+		}
+		c.overflow(x)
+		return
+	}
+	if x.mode == constant && IsUntyped(x.typ) {
+		// The shifted value isn't constant, so it had better be an int.
+		c.convertUntyped(x, Typ[Int])
+	}
+	x.mode, x.val = value, nil
+}
+
+// overflow checks that a typed constant fits in its type.
+func (c *checker) overflow(x *operand) {
+	if x.mode == constant && !IsUntyped(x.typ) && x.val != nil && !Representable(x.val, x.typ) {
+		c.errorf(x.expr.Pos(), "constant %v overflows %s", x.val, x.typ)
+		x.mode = invalid
+	}
+}
+
+const minInt64 = -1 << 63
+
+// tooBig complains about a constant that doesn't fit in the int64 we
+// keep integer constants in.  A typed constant that big overflows its
+// type, but go allows untyped constants far bigger than we can handle.
+func (c *checker) tooBig(x *operand) {
+	if IsUntyped(x.typ) {
+		c.unsupported(x.expr.Pos(), "I can't handle constants as big as %s", exprString(x.expr))
+	} else {
+		c.errorf(x.expr.Pos(), "constant %s overflows %s", exprString(x.expr), x.typ)
+	}
+	x.mode = invalid
+}
+
+// constBinary works out a op b, and whether the answer fits in an
+// int64, if they are integers.
+func constBinary(op token.Token, a, b interface{}) (interface{}, bool) {
+	if a == nil || b == nil {
+		return nil, true // a float, which we don't track
+	}
+	switch a := a.(type) {
+	case int64:
+		b := b.(int64)
+		switch op {
+		case token.ADD:
+			return a + b, (a + b < a) == (b < 0)
+		case token.SUB:
+			return a - b, (a - b > a) == (b < 0)
+		case token.MUL:
+			if (a == -1 && b == minInt64) || (b == -1 && a == minInt64) {
+				return nil, false
+			}
+			return a * b, a == 0 || a * b / a == b
+		case token.QUO:
+			return a / b, a != minInt64 || b != -1
+		case token.REM:
+			if b == -1 {
+				return int64(0), true
+			}
+			return a % b, true
+		case token.AND:
+			return a & b, true
+		case token.OR:
+			return a | b, true
+		case token.XOR:
+			return a ^ b, true
+		case token.AND_NOT:
+			return a &^ b, true
+		}
+	case string:
+		return a + b.(string), true
+	case bool:
+		if op == token.LAND {
+			return a && b.(bool), true
+		}
+		return a || b.(bool), true
+	}
+	panic(fmt.Sprint("bad constant operation ", op))
+}
+
+func constCompare(op token.Token, a, b interface{}) bool {
+	switch a := a.(type) {
+	case int64:
+		b := b.(int64)
+		switch op {
+		case token.EQL:
+			return a == b
+		case token.NEQ:
+			return a != b
+		case token.LSS:
+			return a < b
+		case token.LEQ:
+			return a <= b
+		case token.GTR:
+			return a > b
+		case token.GEQ:
+			return a >= b
+		}
+	case string:
+		b := b.(string)
+		switch op {
+		case token.EQL:
+			return a == b
+		case token.NEQ:
+			return a != b
+		case token.LSS:
+			return a < b
+		case token.LEQ:
+			return a <= b
+		case token.GTR:
+			return a > b
+		case token.GEQ:
+			return a >= b
+		}
+	case bool:
+		if op == token.EQL {
+			return a == b.(bool)
+		}
+		return a != b.(bool)
+	}
+	panic(fmt.Sprint("bad constant comparison ", op))
+}
+
+func (c *checker) singleValue(x *operand) bool {
+	switch x.mode {
+	case novalue:
+		c.errorf(x.expr.Pos(), "%s used as value", exprString(x.expr))
+	case builtin:
+		c.errorf(x.expr.Pos(), "%s must be called", exprString(x.expr))
+	case typexpr:
+		c.errorf(x.expr.Pos(), "type %s is not an expression", x.typ)
+	default:
+		if t,ok := x.typ.(*Tuple); ok && x.mode != invalid {
+			c.errorf(x.expr.Pos(), "multiple-value %s in single-value context (%d values)",
+				exprString(x.expr), t.Len())
+			break
+		}
+		return true
+	}
+	x.mode = invalid
+	return false
+}
+
+// assignment checks that x can be assigned to a variable of type t
+// (which is nil if the variable gets its type from x, as in x := 1).
+// If x is untyped, it is given its final type.
+func (c *checker) assignment(x *operand, t Type, context string) bool {
+	if x.mode == invalid || !c.singleValue(x) {
+		return false
+	}
+	if t == nil {
+		if b,ok := x.typ.(*Basic); ok && b.Kind == UntypedNil {
+			c.errorf(x.expr.Pos(), "use of untyped nil in %s", context)
+			return false
+		}
+		t = Default(x.typ)
+	}
+	if t == Typ[Invalid] || x.typ == Typ[Invalid] {
+		return false // we've already complained
+	}
+	if !AssignableTo(x.typ, x.val, t) {
+		if IsUntyped(x.typ) && x.val != nil && IsNumeric(x.typ) && IsNumeric(t) {
+			c.errorf(x.expr.Pos(), "constant %v overflows %s", x.val, t)
+		} else {
+			c.errorf(x.expr.Pos(), "cannot use %s (type %s) as type %s in %s%s",
+				exprString(x.expr), x.typ, t, context, missingMethod(x.typ, t))
+		}
+		return false
+	}
+	if IsUntyped(x.typ) {
+		if IsInterface(t) {
+			x.typ = Default(x.typ)
+		} else {
+			x.typ = t
+		}
+		c.record(x)
+	}
+	return true
+}
+
+// missingMethod explains why v doesn't implement the interface t, if
+// that's the trouble.
+func missingMethod(v, t Type) string {
+	iface,ok := t.Underlying().(*Interface)
+	if !ok || IsInterface(v) {
+		return ""
+	}
+	mset := MethodSet(v)
+	for _,m := range iface.Methods {
+		if findMethod(mset, m.Name()) != nil {
+			continue
+		}
+		if findMethod(MethodSet(&Pointer{v}), m.Name()) != nil {
+			return ":\n\t" + v.String() + " does not implement " + t.String() +
+				" (method " + m.Name() + " has pointer receiver)"
+		}
+		return ":\n\t" + v.String() + " does not implement " + t.String() +
+			" (missing method " + m.Name() + ")"
+	}
+	return ""
+}
+
+func (c *checker) call(x *operand, e *ast.CallExpr, scope *Scope) {
+	f := c.expr(e.Fun, scope)
+	switch f.mode {
+	case invalid:
+		for _,a := range e.Args {
+			c.expr(a, scope)
+		}
+		return
+	case builtin:
+		c.builtin(x, e, f.obj.Name(), scope)
+		return
+	case typexpr:
+		if len(e.Args) != 1 {
+			c.errorf(e.Pos(), "wrong number of arguments in conversion to %s", f.typ)
+			return
+		}
+		*x = *c.expr(e.Args[0], scope)
+		x.expr = e
+		c.conversion(x, f.typ)
+		return
+	}
+	sig,ok := f.typ.Underlying().(*Signature)
+	if !ok {
+		c.errorf(e.Pos(), "cannot call non-function %s (type %s)", exprString(e.Fun), f.typ)
+		return
+	}
+	c.arguments(e, sig, scope)
+	switch sig.Results.Len() {
+	case 0:
+		x.mode, x.typ = novalue, &Tuple{}
+	case 1:
+		x.mode, x.typ = value, sig.Results.Vars[0].Type()
+	default:
+		x.mode, x.typ = value, sig.Results
+	}
+}
+
+func (c *checker) arguments(e *ast.CallExpr, sig *Signature, scope *Scope) {
+	var args []*operand
+	if len(e.Args) == 1 {
+		a := c.expr(e.Args[0], scope)
+		if t,ok := a.typ.(*Tuple); ok && a.mode == value {
+			// As in f(g()), where g has several results.
+			for _,v := range t.Vars {
+				args = append(args, &operand{value, e.Args[0], v.Type(), nil, nil})
+			}
+		} else {
+			args = append(args, a)
+		}
+	} else {
+		for _,a := range e.Args {
+			args = append(args, c.expr(a, scope))
+		}
+	}
+	params := sig.Params.Vars
+	fname := exprString(e.Fun)
+	ellipsis := e.Ellipsis != token.NoPos
+	if ellipsis && !sig.Variadic {
+		c.errorf(e.Ellipsis, "can only use ... with a variadic function")
+		return
+	}
+	nparams := len(params)
+	if sig.Variadic && !ellipsis {
+		nparams-- // the variadic parameter may get any number of arguments
+	}
+	if len(args) < nparams {
+		c.errorf(e.Rparen, "not enough arguments in call to %s", fname)
+		return
+	}
+	if len(args) > nparams && !(sig.Variadic && !ellipsis) {
+		c.errorf(args[nparams].expr.Pos(), "too many arguments in call to %s", fname)
+		return
+	}
+	for i,a := range args {
+		var t Type
+		if i < nparams {
+			t = params[i].Type()
+		} else {
+			t = params[len(params)-1].Type().(*Slice).Elem
+		}
+		c.assignment(a, t, "argument to "+fname)
+	}
+}
+
+func (c *checker) builtin(x *operand, e *ast.CallExpr, name string, scope *Scope) {
+	nargs := 1
+	switch name {
+	case "print", "println":
+		for _,a := range e.Args {
+			c.assignment(c.expr(a, scope), nil, "argument to "+name)
+		}
+		x.mode, x.typ = novalue, &Tuple{}
+		return
+	}
+	if len(e.Args) < nargs {
+		c.errorf(e.Rparen, "not enough arguments for %s", name)
+		return
+	}
+	if len(e.Args) > nargs {
+		c.errorf(e.Args[nargs].Pos(), "too many arguments for %s", name)
+		return
+	}
+	switch name {
+	case "len":
+		a := c.expr(e.Args[0], scope)
+		if a.mode == invalid || !c.singleValue(a) {
+			return
+		}
+		x.mode, x.typ = value, Typ[Int]
+		switch t := a.typ.Underlying().(type) {
+		case *Basic:
+			if !IsString(t) {
+				break
+			}
+			if s,ok := a.val.(string); ok && a.mode == constant {
+				x.mode, x.val = constant, int64(len(s))
+			}
+			c.assignment(a, nil, "argument to len")
+			return
+		case *Pointer:
+			if _,ok := t.Elem.Underlying().(*Array); ok {
+				return
+			}
+		case *Array, *Slice, *Map:
+			return
+		}
+		c.errorf(a.expr.Pos(), "invalid argument %s (type %s) for len", exprString(a.expr), a.typ)
+		x.mode = invalid
+	case "panic":
+		c.assignment(c.expr(e.Args[0], scope), &Interface{}, "argument to panic")
+		x.mode, x.typ = novalue, &Tuple{}
+	case "new":
+		t := c.typExpr(e.Args[0], scope)
+		x.mode, x.typ = value, &Pointer{t}
+	}
+}
+
+func (c *checker) conversion(x *operand, t Type) {
+	if x.mode == invalid || !c.singleValue(x) {
+		return
+	}
+	if x.mode == constant && IsUntyped(x.typ) && !IsInterface(t) {
+		if v,ok := x.val.(int64); ok && IsString(t) {
+			// string(65) is "A"
+			x.val, x.typ = string([]int{int(v)}), t
+			return
+		}
+		c.convertUntyped(x, t)
+		if x.mode != invalid {
+			x.typ = t
+		}
+		return
+	}
+	xu, tu := x.typ.Underlying(), t.Underlying()
+	ok := AssignableTo(x.typ, x.val, t) || Identical(xu, tu) ||
+		IsNumeric(x.typ) && IsNumeric(t) ||
+		IsInteger(x.typ) && IsString(t) ||
+		isBytes(xu) && IsString(t) || IsString(x.typ) && isBytes(tu)
+	if xp,isptr := xu.(*Pointer); isptr {
+		if tp,isptr := tu.(*Pointer); isptr {
+			ok = ok || Identical(xp.Elem.Underlying(), tp.Elem.Underlying())
+		}
+	}
+	if !ok {
+		c.errorf(x.expr.Pos(), "cannot convert %s (type %s) to type %s", exprString(x.expr), x.typ, t)
+		x.mode = invalid
+		return
+	}
+	if x.mode == constant && IsInteger(x.typ) && IsInteger(t) {
+		x.typ = t
+		c.overflow(x)
+		return
+	}
+	if x.mode == constant && IsString(x.typ) && IsString(t) {
+		x.typ = t
+		return
+	}
+	x.mode, x.typ, x.val = value, t, nil
+}
+
+func isBytes(t Type) bool {
+	s,ok := t.(*Slice)
+	return ok && isKind(s.Elem, Uint8, Uint8)
+}
+
+// typExpr checks a type expression, returning the type it denotes.
+func (c *checker) typExpr(e ast.Expr, scope *Scope) Type {
+	t := c.typInternal(e, scope)
+	c.info.Types[e] = TypeAndValue{t, nil, true}
+	return t
+}
+
+func (c *checker) typInternal(e ast.Expr, scope *Scope) Type {
+	switch e := e.(type) {
+	case *ast.Ident, *ast.SelectorExpr:
+		x := c.expr(e, scope)
+		switch x.mode {
+		case typexpr:
+			return x.typ
+		case invalid:
+		default:
+			c.errorf(e.Pos(), "%s is not a type", exprString(e))
+		}
+	case *ast.ParenExpr:
+		return c.typExpr(e.X, scope)
+	case *ast.StarExpr:
+		return &Pointer{c.typExpr(e.X, scope)}
+	case *ast.ArrayType:
+		elem := c.typExpr(e.Elt, scope)
+		if e.Len == nil {
+			return &Slice{elem}
+		}
+		if _,ok := e.Len.(*ast.Ellipsis); ok {
+			c.errorf(e.Len.Pos(), "invalid use of [...] array (outside a composite literal)")
+			break
+		}
+		n := c.expr(e.Len, scope)
+		if v,ok := n.val.(int64); ok && n.mode == constant && IsInteger(n.typ) && v >= 0 {
+			return &Array{v, elem}
+		}
+		if n.mode != invalid {
+			c.errorf(e.Len.Pos(), "invalid array bound %s", exprString(e.Len))
+		}
+	case *ast.MapType:
+		key := c.typExpr(e.Key, scope)
+		switch key.Underlying().(type) {
+		case *Slice, *Map, *Signature:
+			c.errorf(e.Key.Pos(), "invalid map key type %s", key)
+		}
+		return &Map{key, c.typExpr(e.Value, scope)}
+	case *ast.FuncType:
+		return c.funcType(nil, e, scope)
+	case *ast.StructType:
+		return c.structType(e, scope)
+	case *ast.InterfaceType:
+		return c.interfaceType(e, scope)
+	case *ast.ChanType:
+		c.unsupported(e.Pos(), "I don't handle channels")
+	default:
+		c.errorf(e.Pos(), "%s is not a type", exprString(e))
+	}
+	return Typ[Invalid]
+}
+
+func (c *checker) structType(e *ast.StructType, scope *Scope) *Struct {
+	s := &Struct{}
+	seen := make(map[string]bool)
+	add := func(v *Var) {
+		if v.Name() != "_" && seen[v.Name()] {
+			c.errorf(v.Pos(), "duplicate field %s", v.Name())
+		}
+		seen[v.Name()] = true
+		s.Fields = append(s.Fields, v)
+	}
+	for _,f := range e.Fields.List {
+		t := c.typExpr(f.Type, scope)
+		if len(f.Names) == 0 {
+			// An embedded field is named after its type.
+			te := f.Type
+			if star,ok := te.(*ast.StarExpr); ok {
+				te = star.X
+			}
+			name := "?"
+			switch te := te.(type) {
+			case *ast.Ident:
+				name = te.Name
+			case *ast.SelectorExpr:
+				name = te.Sel.Name
+			default:
+				c.errorf(f.Type.Pos(), "invalid embedded field type %s", exprString(f.Type))
+			}
+			v := NewVar(f.Type.Pos(), name, t)
+			v.Anonymous = true
+			add(v)
+			continue
+		}
+		for _,n := range f.Names {
+			v := NewVar(n.Pos(), n.Name, t)
+			c.info.Defs[n] = v
+			add(v)
+		}
+	}
+	return s
+}
+
+func (c *checker) interfaceType(e *ast.InterfaceType, scope *Scope) *Interface {
+	iface := &Interface{}
+	add := func(m *Func) {
+		if findMethod(iface.Methods, m.Name()) != nil {
+			c.errorf(m.Pos(), "duplicate method %s", m.Name())
+			return
+		}
+		iface.Methods = append(iface.Methods, m)
+	}
+	for _,f := range e.Methods.List {
+		if len(f.Names) == 0 {
+			t := c.typExpr(f.Type, scope)
+			if t == Typ[Invalid] {
+				continue
+			}
+			embedded,ok := t.Underlying().(*Interface)
+			if !ok {
+				c.errorf(f.Type.Pos(), "interface contains embedded non-interface %s", t)
+				continue
+			}
+			for _,m := range embedded.Methods {
+				add(m)
+			}
+			continue
+		}
+		ft,ok := f.Type.(*ast.FuncType)
+		if !ok {
+			c.errorf(f.Type.Pos(), "expected a method signature")
+			continue
+		}
+		sig := c.funcType(nil, ft, scope)
+		for _,n := range f.Names {
+			m := NewFunc(n.Pos(), c.pkg, n.Name, sig)
+			c.info.Defs[n] = m
+			add(m)
+		}
+	}
+	return iface
+}
+
+func unparen(e ast.Expr) ast.Expr {
+	for {
+		p,ok := e.(*ast.ParenExpr)
+		if !ok {
+			return e
+		}
+		e = p.X
+	}
+	panic("unreachable")
+}
+
+// exprString gives a short description of e for use in error
+// messages.
+func exprString(e ast.Expr) string {
+	switch e := e.(type) {
+	case *ast.Ident:
+		return e.Name
+	case *ast.BasicLit:
+		return string(e.Value)
+	case *ast.SelectorExpr:
+		return exprString(e.X) + "." + e.Sel.Name
+	case *ast.CallExpr:
+		if len(e.Args) == 0 {
+			return exprString(e.Fun) + "()"
+		}
+		return exprString(e.Fun) + "(...)"
+	case *ast.ParenExpr:
+		return "(" + exprString(e.X) + ")"
+	case *ast.UnaryExpr:
+		return e.Op.String() + exprString(e.X)
+	case *ast.BinaryExpr:
+		return exprString(e.X) + " " + e.Op.String() + " " + exprString(e.Y)
+	case *ast.StarExpr:
+		return "*" + exprString(e.X)
+	case *ast.IndexExpr:
+		return exprString(e.X) + "[" + exprString(e.Index) + "]"
+	case *ast.CompositeLit:
+		if e.Type != nil {
+			return exprString(e.Type) + "{...}"
+		}
+		return "{...}"
+	case *ast.FuncLit:
+		return "func literal"
+	case *ast.ArrayType:
+		if e.Len == nil {
+			return "[]" + exprString(e.Elt)
+		}
+		return "[" + exprString(e.Len) + "]" + exprString(e.Elt)
+	case *ast.MapType:
+		return "map[" + exprString(e.Key) + "]" + exprString(e.Value)
+	}
+	return fmt.Sprintf("(%T)", e)
+}
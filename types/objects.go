@@ -0,0 +1,175 @@
+package types
+
+import (
+	"go/token"
+)
+
+// An Object is anything that can be named: a constant, type,
+// variable, function or imported package.
+
+type Object interface {
+	Name() string
+	Type() Type
+	Pos() token.Pos
+}
+
+type object struct {
+	name string
+	typ Type
+	pos token.Pos
+}
+func (o *object) Name() string {
+	return o.name
+}
+func (o *object) Type() Type {
+	return o.typ
+}
+func (o *object) Pos() token.Pos {
+	return o.pos
+}
+
+type Var struct {
+	object
+	Anonymous bool // true for an embedded struct field
+}
+func NewVar(pos token.Pos, name string, t Type) *Var {
+	return &Var{object{name, t, pos}, false}
+}
+
+// A Const holds the value of a constant, which is either a bool, an
+// int64 or a string.  Constants of float type don't yet have values.
+
+type Const struct {
+	object
+	Val interface{}
+}
+func NewConst(pos token.Pos, name string, t Type, val interface{}) *Const {
+	return &Const{object{name, t, pos}, val}
+}
+
+type TypeName struct {
+	object
+	Pkg *Package
+}
+func NewTypeName(pos token.Pos, pkg *Package, name string, t Type) *TypeName {
+	return &TypeName{object{name, t, pos}, pkg}
+}
+
+// A Func is a function or a method.  Its type is always a
+// *Signature.
+
+type Func struct {
+	object
+	Pkg *Package
+}
+func NewFunc(pos token.Pos, pkg *Package, name string, sig *Signature) *Func {
+	f := &Func{object{name, nil, pos}, pkg}
+	if sig != nil {
+		// We don't want a nil *Signature masquerading as a Type.
+		f.typ = sig
+	}
+	return f
+}
+func (f *Func) Signature() *Signature {
+	s,_ := f.typ.(*Signature)
+	return s
+}
+
+// FullName is the package-qualified name of a function, such as
+// os.Exit.  Methods are qualified by their receiver type instead, as
+// in main.T.String.
+func (f *Func) FullName() string {
+	pkg := ""
+	if f.Pkg != nil {
+		pkg = f.Pkg.Name + "."
+	}
+	if s := f.Signature(); s != nil && s.Recv != nil {
+		t := s.Recv.Type()
+		if p,ok := t.(*Pointer); ok {
+			t = p.Elem
+		}
+		if n,ok := t.(*Named); ok {
+			return pkg + n.Obj.Name() + "." + f.name
+		}
+	}
+	return pkg + f.name
+}
+
+// A PkgName is the name under which a package has been imported.
+
+type PkgName struct {
+	object
+	Imported *Package
+}
+
+// A Builtin is one of the predeclared functions, such as println.
+
+type Builtin struct {
+	object
+}
+
+// A Nil is the predeclared nil.
+
+type Nil struct {
+	object
+}
+
+type Package struct {
+	Path, Name string
+	Scope *Scope
+}
+
+// A Scope maps names to objects, and may be nested within a parent
+// scope.
+
+type Scope struct {
+	Parent *Scope
+	Objects map[string]Object
+}
+func NewScope(parent *Scope) *Scope {
+	return &Scope{parent, make(map[string]Object)}
+}
+
+// Lookup finds the object with the given name in s or any of its
+// parents, or returns nil if there is no such object.
+func (s *Scope) Lookup(name string) Object {
+	for ; s != nil; s = s.Parent {
+		if o,ok := s.Objects[name]; ok {
+			return o
+		}
+	}
+	return nil
+}
+
+// Insert adds obj to s, unless there is already an object of that
+// name in s (not counting its parents), in which case it returns that
+// object instead.
+func (s *Scope) Insert(obj Object) Object {
+	if old,ok := s.Objects[obj.Name()]; ok {
+		return old
+	}
+	s.Objects[obj.Name()] = obj
+	return nil
+}
+
+// Universe holds the predeclared identifiers.
+var Universe = NewScope(nil)
+
+// iota is a constant whose value depends on where it is used.
+var universeIota = NewConst(token.NoPos, "iota", Typ[UntypedInt], nil)
+
+func init() {
+	for _,t := range Typ {
+		if t.Kind < UntypedBool && t.Kind != Invalid {
+			Universe.Insert(NewTypeName(token.NoPos, nil, t.Name, t))
+		}
+	}
+	Universe.Objects["byte"] = NewTypeName(token.NoPos, nil, "byte", Typ[Uint8])
+	Universe.Insert(NewConst(token.NoPos, "true", Typ[UntypedBool], true))
+	Universe.Insert(NewConst(token.NoPos, "false", Typ[UntypedBool], false))
+	Universe.Insert(&Nil{object{"nil", Typ[UntypedNil], token.NoPos}})
+	Universe.Insert(universeIota)
+	for _,b := range []string{"print", "println", "len", "panic", "new"} {
+		Universe.Insert(&Builtin{object{b, Typ[Invalid], token.NoPos}})
+	}
+}
@@ -0,0 +1,377 @@
+package types
+
+// IsUntyped tells whether t is the type of an untyped constant (or of
+// nil).
+func IsUntyped(t Type) bool {
+	b,ok := t.(*Basic)
+	return ok && b.Kind >= UntypedBool
+}
+
+func isKind(t Type, lo, hi BasicKind) bool {
+	b,ok := t.Underlying().(*Basic)
+	return ok && b.Kind >= lo && b.Kind <= hi
+}
+
+func IsBoolean(t Type) bool {
+	return isKind(t, Bool, Bool) || isKind(t, UntypedBool, UntypedBool)
+}
+
+func IsInteger(t Type) bool {
+	return isKind(t, Int, Uintptr) || isKind(t, UntypedInt, UntypedInt)
+}
+
+func IsUnsigned(t Type) bool {
+	return isKind(t, Uint, Uintptr)
+}
+
+func IsNumeric(t Type) bool {
+	return isKind(t, Int, Float64) || isKind(t, UntypedInt, UntypedFloat)
+}
+
+func IsString(t Type) bool {
+	return isKind(t, String, String) || isKind(t, UntypedString, UntypedString)
+}
+
+func IsInterface(t Type) bool {
+	_,ok := t.Underlying().(*Interface)
+	return ok
+}
+
+// Default returns the type that an untyped constant of type t takes
+// on when there's nothing else to decide it, as in x := 1.
+func Default(t Type) Type {
+	if b,ok := t.(*Basic); ok {
+		switch b.Kind {
+		case UntypedBool:
+			return Typ[Bool]
+		case UntypedInt:
+			return Typ[Int]
+		case UntypedFloat:
+			return Typ[Float64]
+		case UntypedString:
+			return Typ[String]
+		}
+	}
+	return t
+}
+
+// Identical tells whether x and y are the same type.
+func Identical(x, y Type) bool {
+	if x == y {
+		return true
+	}
+	switch x := x.(type) {
+	case *Basic:
+		if y,ok := y.(*Basic); ok {
+			return x.Kind == y.Kind
+		}
+	case *Pointer:
+		if y,ok := y.(*Pointer); ok {
+			return Identical(x.Elem, y.Elem)
+		}
+	case *Slice:
+		if y,ok := y.(*Slice); ok {
+			return Identical(x.Elem, y.Elem)
+		}
+	case *Array:
+		if y,ok := y.(*Array); ok {
+			return x.Len == y.Len && Identical(x.Elem, y.Elem)
+		}
+	case *Map:
+		if y,ok := y.(*Map); ok {
+			return Identical(x.Key, y.Key) && Identical(x.Elem, y.Elem)
+		}
+	case *Tuple:
+		if y,ok := y.(*Tuple); ok {
+			return identicalVars(x.Vars, y.Vars, false)
+		}
+	case *Signature:
+		if y,ok := y.(*Signature); ok {
+			return x.Variadic == y.Variadic &&
+				identicalVars(x.Params.Vars, y.Params.Vars, false) &&
+				identicalVars(x.Results.Vars, y.Results.Vars, false)
+		}
+	case *Struct:
+		if y,ok := y.(*Struct); ok {
+			return identicalVars(x.Fields, y.Fields, true)
+		}
+	case *Interface:
+		if y,ok := y.(*Interface); ok {
+			if len(x.Methods) != len(y.Methods) {
+				return false
+			}
+			for _,m := range x.Methods {
+				if o := findMethod(y.Methods, m.Name()); o == nil || !Identical(m.Type(), o.Type()) {
+					return false
+				}
+			}
+			return true
+		}
+	case *Named:
+		// Named types are only identical to themselves, which we've
+		// already checked.
+	}
+	return false
+}
+
+func identicalVars(x, y []*Var, names bool) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i := range x {
+		if names && (x[i].Name() != y[i].Name() || x[i].Anonymous != y[i].Anonymous) {
+			return false
+		}
+		if !Identical(x[i].Type(), y[i].Type()) {
+			return false
+		}
+	}
+	return true
+}
+
+// Representable tells whether the constant val can be given type t,
+// and returns the value it would have.
+func Representable(val interface{}, t Type) bool {
+	b,ok := t.Underlying().(*Basic)
+	if !ok {
+		return false
+	}
+	switch v := val.(type) {
+	case bool:
+		return b.Kind == Bool || b.Kind == UntypedBool
+	case string:
+		return b.Kind == String || b.Kind == UntypedString
+	case int64:
+		switch b.Kind {
+		case Int:
+			if Target.WordSize == 8 {
+				return true
+			}
+			return v >= -1<<31 && v < 1<<31
+		case Int32:
+			return v >= -1<<31 && v < 1<<31
+		case Int8:
+			return v >= -1<<7 && v < 1<<7
+		case Int16:
+			return v >= -1<<15 && v < 1<<15
+		case Int64, UntypedInt:
+			return true
+		case Uint, Uintptr:
+			if Target.WordSize == 8 {
+				return v >= 0
+			}
+			return v >= 0 && v < 1<<32
+		case Uint32:
+			return v >= 0 && v < 1<<32
+		case Uint8:
+			return v >= 0 && v < 1<<8
+		case Uint16:
+			return v >= 0 && v < 1<<16
+		case Uint64:
+			return v >= 0
+		case Float32, Float64, UntypedFloat:
+			return true
+		}
+		return false
+	case nil:
+		// A constant whose value we don't track, which is a float.
+		return IsNumeric(b) && !IsInteger(b)
+	}
+	return false
+}
+
+// AssignableTo tells whether a value of type v (which is the
+// constant val, if val is non-nil) can be assigned to a variable of
+// type t.
+func AssignableTo(v Type, val interface{}, t Type) bool {
+	if Identical(v, t) {
+		return true
+	}
+	vu, tu := v.Underlying(), t.Underlying()
+	if IsUntyped(v) {
+		if b,ok := v.(*Basic); ok && b.Kind == UntypedNil {
+			switch tu.(type) {
+			case *Pointer, *Signature, *Slice, *Map, *Interface:
+				return true
+			}
+			return false
+		}
+		if IsInterface(t) {
+			return len(tu.(*Interface).Methods) == 0
+		}
+		if val != nil || isKind(v, UntypedFloat, UntypedFloat) {
+			return Representable(val, t)
+		}
+		// An untyped (but not constant) bool, from a comparison.
+		return IsBoolean(v) && IsBoolean(t)
+	}
+	// Values whose underlying types are identical can be assigned if at
+	// least one of them isn't a named type.
+	_,vnamed := v.(*Named)
+	_,tnamed := t.(*Named)
+	if Identical(vu, tu) && (!vnamed || !tnamed) {
+		return true
+	}
+	if i,ok := tu.(*Interface); ok {
+		return Implements(v, i)
+	}
+	return false
+}
+
+// Implements tells whether every method of iface is in the method set
+// of t.
+func Implements(t Type, iface *Interface) bool {
+	if i,ok := t.Underlying().(*Interface); ok {
+		for _,m := range iface.Methods {
+			if o := findMethod(i.Methods, m.Name()); o == nil || !Identical(m.Type(), o.Type()) {
+				return false
+			}
+		}
+		return true
+	}
+	mset := MethodSet(t)
+	for _,m := range iface.Methods {
+		o := findMethod(mset, m.Name())
+		if o == nil || !identicalMethods(m.Signature(), o.Signature()) {
+			return false
+		}
+	}
+	return true
+}
+
+// identicalMethods compares two methods, ignoring their receivers.
+func identicalMethods(x, y *Signature) bool {
+	return x.Variadic == y.Variadic &&
+		identicalVars(x.Params.Vars, y.Params.Vars, false) &&
+		identicalVars(x.Results.Vars, y.Results.Vars, false)
+}
+
+func findMethod(ms []*Func, name string) *Func {
+	for _,m := range ms {
+		if m.Name() == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// MethodSet returns the methods that can be called on a value of
+// type t.  The method set of a named type T holds the methods with
+// receiver T, while that of *T also holds those with receiver *T.
+// Methods of embedded fields are promoted, following the same rule,
+// unless they are hidden by a shallower method or field.
+func MethodSet(t Type) (out []*Func) {
+	if i,ok := t.Underlying().(*Interface); ok {
+		return i.Methods
+	}
+	seen := make(map[string]bool)
+	methodSet(t, seen, &out, make(map[*Named]bool))
+	return
+}
+
+func methodSet(t Type, seen map[string]bool, out *[]*Func, visited map[*Named]bool) {
+	pointer := false
+	if p,ok := t.(*Pointer); ok {
+		t = p.Elem
+		pointer = true
+	}
+	n,isnamed := t.(*Named)
+	if isnamed {
+		if visited[n] {
+			return
+		}
+		visited[n] = true
+		for _,m := range n.Methods {
+			if seen[m.Name()] {
+				continue
+			}
+			_,ptrrecv := m.Signature().Recv.Type().(*Pointer)
+			if pointer || !ptrrecv {
+				*out = append(*out, m)
+			}
+		}
+	}
+	s,ok := t.Underlying().(*Struct)
+	if !ok {
+		return
+	}
+	// Record what we have at this depth before promoting anything, so
+	// that shallower names hide deeper ones.
+	for _,m := range *out {
+		seen[m.Name()] = true
+	}
+	for _,f := range s.Fields {
+		seen[f.Name()] = true
+	}
+	for _,f := range s.Fields {
+		if f.Anonymous {
+			ft := f.Type()
+			if pointer {
+				// Through a pointer, we can also reach the pointer methods
+				// of embedded values.
+				if _,isptr := ft.(*Pointer); !isptr {
+					ft = &Pointer{ft}
+				}
+			}
+			if itf,ok := ft.Underlying().(*Interface); ok {
+				for _,m := range itf.Methods {
+					if !seen[m.Name()] {
+						*out = append(*out, m)
+					}
+				}
+				continue
+			}
+			methodSet(ft, seen, out, visited)
+		}
+	}
+}
+
+// LookupFieldOrMethod finds the field or method called name of a
+// value of type t, including any promoted from embedded fields.  For
+// methods with a pointer receiver, the value must be addressable,
+// which the caller must check.
+func LookupFieldOrMethod(t Type, name string) Object {
+	if p,ok := t.(*Pointer); ok {
+		if _,isnamed := p.Elem.(*Named); isnamed {
+			t = p.Elem
+		} else if _,isstruct := p.Elem.(*Struct); isstruct {
+			t = p.Elem
+		}
+	}
+	return lookup(t, name, make(map[*Named]bool))
+}
+
+func lookup(t Type, name string, visited map[*Named]bool) Object {
+	if p,ok := t.(*Pointer); ok {
+		t = p.Elem
+	}
+	if n,ok := t.(*Named); ok {
+		if visited[n] {
+			return nil
+		}
+		visited[n] = true
+		if m := findMethod(n.Methods, name); m != nil {
+			return m
+		}
+	}
+	switch u := t.Underlying().(type) {
+	case *Interface:
+		if m := findMethod(u.Methods, name); m != nil {
+			return m
+		}
+	case *Struct:
+		for _,f := range u.Fields {
+			if f.Name() == name {
+				return f
+			}
+		}
+		for _,f := range u.Fields {
+			if f.Anonymous {
+				if o := lookup(f.Type(), name, visited); o != nil {
+					return o
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package types
+
+// Sizes describes the layout of values on the machine we are
+// compiling for.
+
+type Sizes struct {
+	WordSize int // the size of int, uint, uintptr and pointers
+}
+
+// These are the machines we can generate code for.
+var I386 = &Sizes{4}
+var AMD64 = &Sizes{8}
+
+// Target is the machine we are generating code for, which decides how
+// big an int is.
+var Target = I386
+
+// Sizeof returns the number of bytes a value of type t occupies.
+func (s *Sizes) Sizeof(t Type) int {
+	switch t := t.Underlying().(type) {
+	case *Basic:
+		switch t.Kind {
+		case Bool, Int8, Uint8:
+			return 1
+		case Int16, Uint16:
+			return 2
+		case Int32, Uint32, Float32:
+			return 4
+		case Int64, Uint64, Float64:
+			return 8
+		case Int, Uint, Uintptr:
+			return s.WordSize
+		case String:
+			return 2*s.WordSize // a length and a pointer
+		}
+	case *Pointer, *Map, *Signature:
+		return s.WordSize
+	case *Slice:
+		return 3*s.WordSize // a pointer, a length and a capacity
+	case *Interface:
+		return 2*s.WordSize // a type and a value
+	case *Array:
+		return int(t.Len)*s.Sizeof(t.Elem)
+	case *Struct:
+		size := 0
+		for _,f := range t.Fields {
+			size = s.align(size, s.Sizeof(f.Type()))
+			size += s.Sizeof(f.Type())
+		}
+		return size
+	case *Tuple:
+		size := 0
+		for _,v := range t.Vars {
+			size += s.Sizeof(v.Type())
+		}
+		return size
+	}
+	return -1 // we don't know the size of untyped values
+}
+
+// align rounds off upwards to the alignment appropriate for a field
+// of the given size.
+func (s *Sizes) align(off, size int) int {
+	a := size
+	if a > s.WordSize {
+		a = s.WordSize
+	}
+	if a <= 1 {
+		return off
+	}
+	return (off + a - 1) / a * a
+}
@@ -0,0 +1,350 @@
+package types
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+func (c *checker) stmtList(list []ast.Stmt, scope *Scope) {
+	for _,s := range list {
+		c.stmt(s, scope)
+	}
+}
+
+func (c *checker) stmt(s ast.Stmt, scope *Scope) {
+	switch s := s.(type) {
+	case *ast.EmptyStmt, *ast.BranchStmt:
+		// Nothing to check here.
+	case *ast.ExprStmt:
+		x := c.expr(s.X, scope)
+		if x.mode == invalid {
+			return
+		}
+		if call,ok := unparen(s.X).(*ast.CallExpr); ok {
+			// Calls are fine, unless they are conversions or calls to
+			// builtins that have no effect.
+			unused := c.info.Types[call.Fun].IsType
+			if id,ok := unparen(call.Fun).(*ast.Ident); ok {
+				if b,ok := c.info.Uses[id].(*Builtin); ok {
+					unused = b.Name() == "len" || b.Name() == "new"
+				}
+			}
+			if unused {
+				c.errorf(s.Pos(), "%s is not used", exprString(call))
+			}
+			return
+		}
+		c.errorf(s.Pos(), "%s is not used", exprString(s.X))
+	case *ast.DeclStmt:
+		if d,ok := s.Decl.(*ast.GenDecl); ok {
+			c.genDecl(d, scope, scope, true)
+		}
+	case *ast.AssignStmt:
+		c.assignStmt(s, scope)
+	case *ast.IncDecStmt:
+		x := c.expr(s.X, scope)
+		if x.mode == invalid {
+			return
+		}
+		if !IsNumeric(x.typ) {
+			c.errorf(s.X.Pos(), "invalid operation: %s%s (non-numeric type %s)",
+				exprString(s.X), s.Tok, x.typ)
+			return
+		}
+		c.assignable(x)
+	case *ast.ReturnStmt:
+		c.returnStmt(s, scope)
+	case *ast.BlockStmt:
+		c.stmtList(s.List, NewScope(scope))
+	case *ast.LabeledStmt:
+		c.stmt(s.Stmt, scope)
+	case *ast.GoStmt:
+		c.expr(s.Call, scope)
+	case *ast.DeferStmt:
+		c.expr(s.Call, scope)
+	case *ast.IfStmt:
+		inner := NewScope(scope)
+		if s.Init != nil {
+			c.stmt(s.Init, inner)
+		}
+		c.condition(s.Cond, inner)
+		c.stmt(s.Body, inner)
+		if s.Else != nil {
+			c.stmt(s.Else, inner)
+		}
+	case *ast.ForStmt:
+		inner := NewScope(scope)
+		if s.Init != nil {
+			c.stmt(s.Init, inner)
+		}
+		if s.Cond != nil {
+			c.condition(s.Cond, inner)
+		}
+		if s.Post != nil {
+			c.stmt(s.Post, inner)
+		}
+		c.stmt(s.Body, inner)
+	case *ast.SwitchStmt:
+		inner := NewScope(scope)
+		if s.Init != nil {
+			c.stmt(s.Init, inner)
+		}
+		var tag *operand
+		if s.Tag != nil {
+			tag = c.expr(s.Tag, inner)
+			c.assignment(tag, nil, "switch expression")
+		}
+		for _,cc := range s.Body.List {
+			clause := cc.(*ast.CaseClause)
+			for _,e := range clause.List {
+				if tag == nil {
+					c.condition(e, inner)
+					continue
+				}
+				x := c.expr(e, inner)
+				if tag.mode != invalid && x.mode != invalid {
+					c.comparison(x, tag, token.EQL, e)
+				}
+			}
+			c.stmtList(clause.Body, NewScope(inner))
+		}
+	default:
+		c.unsupported(s.Pos(), "I can't check statements such as %T", s)
+	}
+}
+
+// condition checks that e is a boolean expression.
+func (c *checker) condition(e ast.Expr, scope *Scope) {
+	x := c.expr(e, scope)
+	if x.mode != invalid && !IsBoolean(x.typ) {
+		c.errorf(e.Pos(), "non-bool %s (type %s) used as condition", exprString(e), x.typ)
+	}
+	c.assignment(x, nil, "condition")
+}
+
+// assignable checks that x is something we may assign to.
+func (c *checker) assignable(x *operand) bool {
+	if x.mode == variable {
+		return true
+	}
+	if ix,ok := unparen(x.expr).(*ast.IndexExpr); ok {
+		if t,ok := c.info.Types[ix.X]; ok {
+			if _,ismap := t.Type.Underlying().(*Map); ismap {
+				return true
+			}
+		}
+	}
+	c.errorf(x.expr.Pos(), "cannot assign to %s", exprString(x.expr))
+	return false
+}
+
+// lhsExpr checks the left hand side of an assignment, which doesn't
+// count as using a variable.
+func (c *checker) lhsExpr(e ast.Expr, scope *Scope) *operand {
+	if id,ok := e.(*ast.Ident); ok {
+		if id.Name == "_" {
+			c.info.Defs[id] = nil
+			return &operand{mode: variable, expr: e}
+		}
+		if v,ok := scope.Lookup(id.Name).(*Var); ok {
+			used := c.used[v]
+			x := c.expr(e, scope)
+			c.used[v] = used
+			return x
+		}
+	}
+	return c.expr(e, scope)
+}
+
+// rhsExprs evaluates the right hand side of an assignment of n
+// values, which may be a single call returning n results.
+func (c *checker) rhsExprs(rhs []ast.Expr, n int, scope *Scope) []*operand {
+	if len(rhs) == 1 && n > 1 {
+		x := c.expr(rhs[0], scope)
+		if x.mode == invalid {
+			return nil
+		}
+		if t,ok := x.typ.(*Tuple); ok && t.Len() == n {
+			out := make([]*operand, n)
+			for i,v := range t.Vars {
+				out[i] = &operand{value, rhs[0], v.Type(), nil, nil}
+			}
+			return out
+		}
+		c.errorf(rhs[0].Pos(), "assignment count mismatch: %d = 1", n)
+		return nil
+	}
+	out := make([]*operand, len(rhs))
+	for i,e := range rhs {
+		out[i] = c.expr(e, scope)
+	}
+	if len(rhs) != n {
+		c.errorf(rhs[0].Pos(), "assignment count mismatch: %d = %d", n, len(rhs))
+		return nil
+	}
+	return out
+}
+
+func (c *checker) assignStmt(s *ast.AssignStmt, scope *Scope) {
+	switch s.Tok {
+	case token.DEFINE:
+		rhs := c.rhsExprs(s.Rhs, len(s.Lhs), scope)
+		var newvars []*Var
+		for i,e := range s.Lhs {
+			id,ok := e.(*ast.Ident)
+			if !ok {
+				c.errorf(e.Pos(), "non-name %s on left side of :=", exprString(e))
+				continue
+			}
+			var x *operand
+			if rhs != nil {
+				x = rhs[i]
+			}
+			if old,ok := scope.Objects[id.Name].(*Var); ok {
+				// An existing variable is simply assigned to.
+				c.info.Uses[id] = old
+				if x != nil {
+					c.assignment(x, old.Type(), "assignment")
+				}
+				continue
+			}
+			v := NewVar(id.Pos(), id.Name, Typ[Invalid])
+			if x != nil && c.assignment(x, nil, "assignment") {
+				v.typ = x.typ
+			}
+			c.info.Defs[id] = v
+			c.locals = append(c.locals, v)
+			if id.Name != "_" {
+				newvars = append(newvars, v)
+			}
+		}
+		if len(newvars) == 0 {
+			c.errorf(s.Pos(), "no new variables on left side of :=")
+		}
+		for _,v := range newvars {
+			if old := scope.Insert(v); old != nil {
+				c.errorf(v.Pos(), "%s repeated on left side of :=", v.Name())
+			}
+		}
+	case token.ASSIGN:
+		rhs := c.rhsExprs(s.Rhs, len(s.Lhs), scope)
+		for i,e := range s.Lhs {
+			l := c.lhsExpr(e, scope)
+			if rhs == nil || l.mode == invalid {
+				continue
+			}
+			if l.typ == nil {
+				// Assigning to _ is fine, so long as it has a type.
+				c.assignment(rhs[i], nil, "assignment")
+				continue
+			}
+			if c.assignable(l) {
+				c.assignment(rhs[i], l.typ, "assignment")
+			}
+		}
+	default:
+		// An assignment operation, such as +=
+		if len(s.Lhs) != 1 || len(s.Rhs) != 1 {
+			c.errorf(s.Pos(), "assignment operation %s requires single-valued expressions", s.Tok)
+			return
+		}
+		l := c.lhsExpr(s.Lhs[0], scope)
+		r := c.expr(s.Rhs[0], scope)
+		if l.mode == invalid || r.mode == invalid || !c.assignable(l) {
+			return
+		}
+		op := assignOps[s.Tok]
+		x := *l
+		c.binary(&x, r, op, s.Rhs[0])
+		if x.mode != invalid {
+			c.assignment(&x, l.typ, "assignment")
+		}
+	}
+}
+
+var assignOps = map[token.Token]token.Token{
+	token.ADD_ASSIGN: token.ADD,
+	token.SUB_ASSIGN: token.SUB,
+	token.MUL_ASSIGN: token.MUL,
+	token.QUO_ASSIGN: token.QUO,
+	token.REM_ASSIGN: token.REM,
+	token.AND_ASSIGN: token.AND,
+	token.OR_ASSIGN: token.OR,
+	token.XOR_ASSIGN: token.XOR,
+	token.SHL_ASSIGN: token.SHL,
+	token.SHR_ASSIGN: token.SHR,
+	token.AND_NOT_ASSIGN: token.AND_NOT,
+}
+
+func (c *checker) returnStmt(s *ast.ReturnStmt, scope *Scope) {
+	results := c.sig.Results
+	if len(s.Results) == 0 {
+		if results.Len() > 0 && results.Vars[0].Name() == "" {
+			c.errorf(s.Pos(), "not enough arguments to return")
+		}
+		return
+	}
+	if results.Len() == 0 {
+		c.errorf(s.Pos(), "too many arguments to return")
+		for _,e := range s.Results {
+			c.expr(e, scope)
+		}
+		return
+	}
+	xs := c.rhsExprs(s.Results, results.Len(), scope)
+	for i,x := range xs {
+		c.assignment(x, results.Vars[i].Type(), "return argument")
+	}
+}
+
+// isTerminating tells whether s is certain to never fall through to
+// whatever follows it.
+func isTerminating(s ast.Stmt) bool {
+	switch s := s.(type) {
+	case *ast.ReturnStmt:
+		return true
+	case *ast.BranchStmt:
+		return s.Tok == token.GOTO
+	case *ast.ExprStmt:
+		if call,ok := unparen(s.X).(*ast.CallExpr); ok {
+			if id,ok := call.Fun.(*ast.Ident); ok && id.Name == "panic" {
+				return true
+			}
+		}
+	case *ast.BlockStmt:
+		return len(s.List) > 0 && isTerminating(s.List[len(s.List)-1])
+	case *ast.IfStmt:
+		return s.Else != nil && isTerminating(s.Body) && isTerminating(s.Else)
+	case *ast.ForStmt:
+		return s.Cond == nil && !hasBreak(s.Body)
+	case *ast.LabeledStmt:
+		return isTerminating(s.Stmt)
+	}
+	return false
+}
+
+// hasBreak tells whether there's a break within s that refers to the
+// statement enclosing s.
+func hasBreak(s ast.Stmt) bool {
+	b := &breakFinder{false}
+	ast.Walk(b, s)
+	return b.found
+}
+
+type breakFinder struct {
+	found bool
+}
+func (b *breakFinder) Visit(n ast.Node) ast.Visitor {
+	switch n := n.(type) {
+	case *ast.BranchStmt:
+		if n.Tok == token.BREAK && n.Label == nil {
+			b.found = true
+		}
+	case *ast.ForStmt, *ast.SwitchStmt, *ast.FuncLit:
+		return nil // a break in here is someone else's
+	}
+	if b.found {
+		return nil
+	}
+	return b
+}
@@ -0,0 +1,254 @@
+// Package types holds gogo's own representation of go types, along
+// with a checker (in check.go) that works out the type of every
+// expression in a package.
+package types
+
+import (
+	"fmt"
+)
+
+// A Type is a go type.  Every type knows its underlying type, which
+// for anything but a Named type is itself.
+
+type Type interface {
+	String() string
+	Underlying() Type
+}
+
+// BasicKind describes the predeclared types, including the types of
+// untyped constants.
+
+type BasicKind int
+const (
+	Invalid BasicKind = iota
+	Bool
+	Int
+	Int8
+	Int16
+	Int32
+	Int64
+	Uint
+	Uint8
+	Uint16
+	Uint32
+	Uint64
+	Uintptr
+	Float32
+	Float64
+	String
+
+	UntypedBool
+	UntypedInt
+	UntypedFloat
+	UntypedString
+	UntypedNil
+)
+
+type Basic struct {
+	Kind BasicKind
+	Name string
+}
+func (b *Basic) String() string {
+	return b.Name
+}
+func (b *Basic) Underlying() Type {
+	return b
+}
+
+// Typ holds the predeclared types, indexed by their kind.
+var Typ = []*Basic{
+	Invalid: &Basic{Invalid, "invalid type"},
+	Bool: &Basic{Bool, "bool"},
+	Int: &Basic{Int, "int"},
+	Int8: &Basic{Int8, "int8"},
+	Int16: &Basic{Int16, "int16"},
+	Int32: &Basic{Int32, "int32"},
+	Int64: &Basic{Int64, "int64"},
+	Uint: &Basic{Uint, "uint"},
+	Uint8: &Basic{Uint8, "uint8"},
+	Uint16: &Basic{Uint16, "uint16"},
+	Uint32: &Basic{Uint32, "uint32"},
+	Uint64: &Basic{Uint64, "uint64"},
+	Uintptr: &Basic{Uintptr, "uintptr"},
+	Float32: &Basic{Float32, "float32"},
+	Float64: &Basic{Float64, "float64"},
+	String: &Basic{String, "string"},
+
+	UntypedBool: &Basic{UntypedBool, "untyped bool"},
+	UntypedInt: &Basic{UntypedInt, "untyped int"},
+	UntypedFloat: &Basic{UntypedFloat, "untyped float"},
+	UntypedString: &Basic{UntypedString, "untyped string"},
+	UntypedNil: &Basic{UntypedNil, "untyped nil"},
+}
+
+type Pointer struct {
+	Elem Type
+}
+func (p *Pointer) String() string {
+	return "*" + p.Elem.String()
+}
+func (p *Pointer) Underlying() Type {
+	return p
+}
+
+type Slice struct {
+	Elem Type
+}
+func (s *Slice) String() string {
+	return "[]" + s.Elem.String()
+}
+func (s *Slice) Underlying() Type {
+	return s
+}
+
+type Array struct {
+	Len int64
+	Elem Type
+}
+func (a *Array) String() string {
+	return fmt.Sprint("[", a.Len, "]", a.Elem)
+}
+func (a *Array) Underlying() Type {
+	return a
+}
+
+type Map struct {
+	Key, Elem Type
+}
+func (m *Map) String() string {
+	return "map[" + m.Key.String() + "]" + m.Elem.String()
+}
+func (m *Map) Underlying() Type {
+	return m
+}
+
+// A Tuple is a list of variables, such as the parameters or results
+// of a function.  It is also the type of a call to a function with
+// anything other than exactly one result.
+
+type Tuple struct {
+	Vars []*Var
+}
+func (t *Tuple) Len() int {
+	if t == nil {
+		return 0
+	}
+	return len(t.Vars)
+}
+func (t *Tuple) String() string {
+	out := "("
+	for i,v := range t.Vars {
+		if i > 0 {
+			out += ", "
+		}
+		out += v.Type().String()
+	}
+	return out + ")"
+}
+func (t *Tuple) Underlying() Type {
+	return t
+}
+
+type Signature struct {
+	Recv *Var // nil unless this is a method
+	Params, Results *Tuple
+	Variadic bool
+}
+func (s *Signature) String() string {
+	return "func" + s.signature()
+}
+func (s *Signature) signature() string {
+	out := "("
+	for i,v := range s.Params.Vars {
+		if i > 0 {
+			out += ", "
+		}
+		if s.Variadic && i == len(s.Params.Vars)-1 {
+			out += "..." + v.Type().(*Slice).Elem.String()
+		} else {
+			out += v.Type().String()
+		}
+	}
+	out += ")"
+	switch s.Results.Len() {
+	case 0:
+	case 1:
+		out += " " + s.Results.Vars[0].Type().String()
+	default:
+		out += " " + s.Results.String()
+	}
+	return out
+}
+func (s *Signature) Underlying() Type {
+	return s
+}
+
+type Struct struct {
+	Fields []*Var
+}
+func (s *Struct) String() string {
+	out := "struct{"
+	for i,f := range s.Fields {
+		if i > 0 {
+			out += "; "
+		}
+		if !f.Anonymous {
+			out += f.Name() + " "
+		}
+		out += f.Type().String()
+	}
+	return out + "}"
+}
+func (s *Struct) Underlying() Type {
+	return s
+}
+
+type Interface struct {
+	Methods []*Func
+}
+func (i *Interface) String() string {
+	out := "interface{"
+	for n,m := range i.Methods {
+		if n > 0 {
+			out += "; "
+		}
+		out += m.Name() + m.Type().(*Signature).signature()
+	}
+	return out + "}"
+}
+func (i *Interface) Underlying() Type {
+	return i
+}
+
+// A Named type is one declared with a type declaration.  Its
+// underlying type is filled in once we've worked it out, which may be
+// after the type has been referred to (as in recursive types).
+
+type Named struct {
+	Obj *TypeName
+	underlying Type
+	Methods []*Func // the methods declared with this type as receiver
+}
+func NewNamed(obj *TypeName, underlying Type) *Named {
+	n := &Named{obj, nil, nil}
+	if underlying != nil {
+		n.SetUnderlying(underlying)
+	}
+	obj.typ = n
+	return n
+}
+func (n *Named) String() string {
+	if n.Obj.Pkg != nil && n.Obj.Pkg.Name != "main" {
+		return n.Obj.Pkg.Name + "." + n.Obj.Name()
+	}
+	return n.Obj.Name()
+}
+func (n *Named) Underlying() Type {
+	if n.underlying == nil {
+		return Typ[Invalid] // we haven't worked it out yet
+	}
+	return n.underlying
+}
+func (n *Named) SetUnderlying(t Type) {
+	n.underlying = t.Underlying()
+}
@@ -2,26 +2,30 @@ package main
 
 import (
 	"fmt"
-	"go/ast"
+	"github.com/droundy/go/types"
 	"github.com/droundy/go/x86"
 )
 
 type Variable interface {
 	InMemory() x86.Memory
-	Type() *ast.Type
+	Type() types.Type
 	Name() string
 }
 
+// A StackVariable lives in the frame of a function.  Once Lookup has
+// found it, its Offset is relative to the frame pointer, %ebp, which
+// doesn't budge however much we push and pop.
+
 type StackVariable struct {
-	T *ast.Type
+	T types.Type
 	N string
 	Offset int
 }
 
 func (v *StackVariable) InMemory() x86.Memory {
-	return x86.Memory{ x86.Imm32(v.Offset), x86.ESP, nil, nil }
+	return x86.Memory{ x86.Imm32(v.Offset), x86.EBP, nil, nil }
 }
-func (v *StackVariable) Type() *ast.Type {
+func (v *StackVariable) Type() types.Type {
 	return v.T
 }
 func (v *StackVariable) Name() string {
@@ -29,14 +33,14 @@ func (v *StackVariable) Name() string {
 }
 
 type GlobalVariable struct {
-	T *ast.Type
+	T types.Type
 	N string
 }
 
 func (g *GlobalVariable) InMemory() x86.Memory {
 	return x86.Memory{x86.Symbol(g.N),nil,nil,nil}
 }
-func (v *GlobalVariable) Type() *ast.Type {
+func (v *GlobalVariable) Type() types.Type {
 	return v.T
 }
 func (v *GlobalVariable) Name() string {
@@ -46,7 +50,7 @@ func (v *GlobalVariable) Name() string {
 // All global variables are accessible via Globals.
 var Globals = make(map[string]GlobalVariable)
 
-func DefineGlobal(name string, t *ast.Type) {
+func DefineGlobal(name string, t types.Type) {
 	Globals[name] = GlobalVariable{ t, name }
 }
 
@@ -62,9 +66,9 @@ type Stack struct {
 
 // DefineVariable returns the offset to be subtracted from the stack
 // pointer
-func (s *Stack) DefineVariable(name string, t *ast.Type, synonymns ...string) int {
+func (s *Stack) DefineVariable(name string, t types.Type, synonymns ...string) int {
 	if _,ok := s.Vars[name]; ok && name != "_" {
-		panic(fmt.Sprintf("Cannot define already existing variable %s", name))
+		Invalid(nil, "Cannot define already existing variable %s", name)
 	}
 	off := SizeOnStack(t)
 	s.Size += off
@@ -76,81 +80,83 @@ func (s *Stack) DefineVariable(name string, t *ast.Type, synonymns ...string) in
 }
 
 // Pop returns the offset to be added to the stack pointer
-func (s *Stack) Pop(t *ast.Type) int {
+func (s *Stack) Pop(t types.Type) int {
 	off := SizeOnStack(t)
 	s.Size -= off
 	return off
 }
 
 // Pop returns the offset to be added to the stack pointer
-func (s *Stack) Push(t *ast.Type) int {
+func (s *Stack) Push(t types.Type) int {
 	off := SizeOnStack(t)
 	s.Size += off
 	return off
 }
 
-// PopTo returns code to save data from the stack into the variable.
+// PopTo returns code to pop the top of the stack into the variable.
 // It also changes the stack size accordingly.
 func (s *Stack) PopTo(name string) x86.X86 {
 	v := s.Lookup(name)
 	off := SizeOnStack(v.Type())
 	if TypeToSize(v.Type()) != off {
-		panic("I can't yet handle types with sizes that aren't a multiple of 4")
-	}
-	comment := "Popping to variable "+v.Name()
-	if v.Name() == "_" {
-		comment = fmt.Sprint("Popping to ",name," of type ",PrettyType(v.Type()), " at ", v.InMemory())
+		Unsupported(nil, "I can't yet handle types with sizes that aren't a multiple of a word")
 	}
 	code := []x86.X86{x86.Comment(s.PrettyComments())}
-	switch off {
-	case 4:
-		s.Size -= off
-		vnew := s.Lookup(name) // Its location relative to stack may have changed!
-		return x86.RawAssembly(x86.Assembly([]x86.X86{
-			x86.PopL(x86.EAX),
-			x86.Commented(x86.MovL(x86.EAX, vnew.InMemory()), comment),
-		}))
-	case 8:
-		s.Size -= 4
-		vnew := s.Lookup(name) // Its location relative to stack may have changed!
-		code = append(code,
-			x86.PopL(x86.EAX),
-			x86.Commented(x86.MovL(x86.EAX, vnew.InMemory()), comment))
-		s.Size -= 4
-		vnew = s.Lookup(name) // Its location relative to stack may have changed again!
-		code = append(code,
-			x86.PopL(x86.EAX),
-			x86.Commented(x86.MovL(x86.EAX, vnew.InMemory().Add(4)), comment))
-		return x86.RawAssembly(x86.Assembly(code))
-	default:
-		panic(fmt.Sprintf("I don't pop variables with length %s", SizeOnStack(v.Type())))
+	for w:=0; w<off; w+=x86.WordSize {
+		s.Size -= x86.WordSize
+		code = append(code, x86.Commented(x86.PopL(v.InMemory().Add(w)),
+			"Popping to variable "+v.Name()))
 	}
-	panic("This can't happen")
+	return x86.RawAssembly(x86.Assembly(code))
 }
 
+// Lookup finds a variable, working out where it is relative to %ebp.
+// The outermost Stack holds the arguments and results, which the
+// caller put above the return address (and the %ebp we saved just
+// below it), so they are at positive offsets.  Anything else is in
+// our own frame, below %ebp.
 func (s *Stack) Lookup(name string) (out Variable) {
-	offtotal := 0
-	for {
-		if s == nil {
+	for t := s; ; t = t.Parent {
+		if t == nil {
 			if v,ok := Globals[name]; ok {
 				return &v
 			}
-			panic("There is no variable named "+name)
+			// Globals are stored under their package-qualified names,
+			// but with no Stack at all we don't know our package.
+			if s == nil {
+				Invalid(nil, "There is no variable named %s", name)
+			}
+			if v,ok := Globals[s.Package() + "." + name]; ok {
+				return &v
+			}
+			Invalid(nil, "There is no variable named %s", name)
 		}
-		offtotal += s.Size
-		if v,ok := s.Vars[name]; ok {
-			fmt.Println("For", name, "offtotal is", offtotal, "and v.Offset is", v.Offset)
-			v.Offset = offtotal - v.Offset
-			// Because we do this just here, we can't save this Variable for
-			// later, when the stack pointer might have changed.
+		if v,ok := t.Vars[name]; ok {
+			if t.Parent == nil {
+				v.Offset = t.Size - v.Offset + x86.WordSize
+				return &v
+			}
+			// Everything between us and the arguments comes first.
+			for u := t.Parent; u.Parent != nil; u = u.Parent {
+				v.Offset += u.Size
+			}
+			v.Offset = -v.Offset
 			return &v
 		}
-		s = s.Parent
 	}
 	panic("This can never happen")
 	return
 }
 
+// Package returns the name of the package whose code we are
+// compiling, which is the name of the outermost Stack.
+func (s *Stack) Package() string {
+	for s.Parent != nil {
+		s = s.Parent
+	}
+	return s.Name
+}
+
 func (s *Stack) New(name string) *Stack {
 	n := Stack{ s, make(map[string]StackVariable), 0, 0, name }
 	return &n
@@ -163,7 +169,7 @@ func (s *Stack) PrettyComments() string {
 	out := "\n# Stack: " + s.Name
 	for vn,v := range s.Vars {
 		out += fmt.Sprint("\n#   ", v.Name(), " (", vn, ") size: ", SizeOnStack(v.Type()),
-			" type: ", PrettyType(v.Type()), " offset: ", v.Offset)
+			" type: ", v.Type(), " offset: ", v.Offset)
 	}
 	out += s.Parent.PrettyComments()
 	return out
@@ -0,0 +1,362 @@
+package x86
+
+import (
+	"os"
+)
+
+// We generate code for either the i386 or the amd64, which look much
+// the same from here: the amd64 has bigger words and more registers,
+// and calls the kernel differently.  Rather than a second set of
+// instructions, each instruction that works on a word prints itself
+// for whichever we are targetting, so movl becomes movq on the amd64,
+// and %eax becomes %rax.
+
+// WordSize is the size in bytes of a word, and hence of a register,
+// on the machine we are generating code for.
+var WordSize = 4
+
+// SetArch chooses the machine to generate code for, which is either
+// "386" or "amd64".  It must be called before any code is printed.
+func SetArch(arch string) os.Error {
+	switch arch {
+	case "386":
+		WordSize = 4
+	case "amd64":
+		WordSize = 8
+		StartText = startText64
+		Debugging = debugging64
+		Runtime = runtime64
+	default:
+		return os.NewError("I can't generate code for " + arch)
+	}
+	return nil
+}
+
+// wordOp turns the name of a 32-bit instruction into the name of the
+// one working on a whole word.
+func wordOp(name string) string {
+	if WordSize == 4 {
+		return name
+	}
+	switch name {
+	case "cltd":
+		return "cqto"
+	case "movl", "addl", "subl", "sbbl", "andl", "orl", "xorl", "imull", "idivl",
+		"shll", "shrl", "sarl", "cmpl", "popl", "pushl", "negl", "notl":
+		return name[:len(name)-1] + "q"
+	}
+	return name
+}
+
+var startText64 = []X86{
+	Section("text"),
+	Commented(GlobalSymbol("_start"), "this says where to start execution"),
+	Commented(MovL(Imm32(0), EBP), "this is the outermost frame, as far as backtraces go"),
+	Commented(MovL(Memory{nil, ESP, nil, nil}, EAX), "the kernel leaves argc on the stack"),
+	MovL(EAX, Symbol("goc.args")),
+	Commented(MovL(ESP, EAX), "followed by the argv pointers"),
+	AddL(Imm32(8), EAX),
+	MovL(EAX, Symbol("goc.argsptr")),
+	Commented(Call(Symbol("goc.signals")), "so that faults become panics"),
+	Call(Symbol("main_main")),
+	Comment("And exit..."),
+	Commented(MovL(Imm32(0), EDI), "first argument: exit code"),
+	Commented(MovL(Imm32(60), EAX), "system call number (sys_exit)"),
+	Syscall(),
+}
+
+var debugging64 = []X86{
+	RawAssembly(`
+#  Debug utility routines!
+
+print:
+	movq 8(%rsp), %rdx	# read the length
+	movq 16(%rsp), %rsi	# the pointer to the string
+	movq $2, %rdi	# first argument: file handle (stderr)
+	movq $1, %rax	# system call number (sys_write)
+	syscall
+	popq %rax	# store the return address
+	addq $16, %rsp	# get rid of the two arguments
+	jmp *%rax	# return from print
+
+println:
+	movq 8(%rsp), %rdx	# read the length
+	movq 16(%rsp), %rsi	# the pointer to the string
+	movq $2, %rdi	# first argument: file handle (stderr)
+	movq $1, %rax	# system call number (sys_write)
+	syscall
+	movq $10, 8(%rsp)	# a newline
+	movq $1, %rdx	# the length
+	movq %rsp, %rsi	# the pointer
+	addq $8, %rsi
+	movq $2, %rdi	# first argument: file handle (stderr)
+	movq $1, %rax	# system call number (sys_write)
+	syscall
+	popq %rax	# store the return address
+	addq $16, %rsp	# get rid of the two arguments
+	jmp *%rax	# return from println
+
+panic:	# prints "panic: " and its string argument, then exits with 2, as go does
+	movq $7, %rdx	# the length of "panic: "
+	movq $goc.panicmsg, %rsi
+	movq $2, %rdi	# first argument: file handle (stderr)
+	movq $1, %rax	# system call number (sys_write)
+	syscall
+	movq 8(%rsp), %rdx	# read the length
+	movq 16(%rsp), %rsi	# the pointer to the string
+	movq $2, %rdi	# first argument: file handle (stderr)
+	movq $1, %rax	# system call number (sys_write)
+	syscall
+	movq $10, 8(%rsp)	# a newline
+	movq $1, %rdx	# the length
+	movq %rsp, %rsi	# the pointer
+	addq $8, %rsi
+	movq $2, %rdi	# first argument: file handle (stderr)
+	movq $1, %rax	# system call number (sys_write)
+	syscall
+	movq (%rsp), %rax	# where we were called from...
+	subq $1, %rax	# ...which is in the call instruction
+	call goc.traceback
+	movq $2, %rdi	# first argument: exit code
+	movq $60, %rax	# system call number (sys_exit)
+	syscall
+		`),
+}
+
+var runtime64 = []X86{
+	RawAssembly(`
+#  Runtime routines!
+
+goc.alloc:	# allocates %rax bytes, returning a pointer to them in %rax
+	pushq %rdi	# Save registers, including the two syscall clobbers...
+	pushq %rsi
+	pushq %rdx
+	pushq %rcx
+	pushq %r11
+	movq %rax, %rdx	# the number of bytes wanted
+	movq goc.brk, %rsi
+	cmpq $0, %rsi
+	jne 1f
+	movq $0, %rdi	# brk(0) tells us where the heap starts
+	movq $12, %rax	# system call number (sys_brk)
+	syscall
+	movq %rax, %rsi
+1:	leaq 7(%rsi,%rdx), %rdi	# the new end of the heap...
+	andq $-8, %rdi	# ...kept word-aligned
+	movq $12, %rax	# system call number (sys_brk)
+	syscall
+	movq %rax, goc.brk	# FIXME: we don't notice if we're out of memory
+	movq %rsi, %rax	# our memory starts at the old end of the heap
+	popq %r11	# Restore saved registers...
+	popq %rcx
+	popq %rdx
+	popq %rsi
+	popq %rdi
+	ret	# from goc.alloc
+
+goc.traceback:	# prints the function and line of the code at %rax, and then of each call that led there
+	pushq %rax	# Save registers...
+	pushq %rbx
+	pushq %rdx
+	pushq %rsi
+	pushq %r8
+	pushq %r9
+	pushq %r10
+	pushq %rbp
+	movq %rax, %r8	# the address we're looking for
+	movq $goc.newline, %rsi
+	movq $1, %rdx
+	call goc.write
+	pushq $1	# we're in the innermost frame
+1:	movq $goc.functab, %r9	# which of our functions is it in?
+2:	cmpq $goc.functab_end, %r9
+	jae 8f
+	cmpq (%r9), %r8
+	jb 3f
+	cmpq 8(%r9), %r8
+	jb 4f
+3:	addq $32, %r9
+	jmp 2b
+4:	movq 24(%r9), %rsi	# its name
+	movq 16(%r9), %rdx
+	call goc.write
+	movq $goc.parens, %rsi
+	movq $4, %rdx
+	call goc.write
+	movq (%r9), %r9	# where the function starts
+	movq $0, %r10	# the last of its lines that starts before our address
+	movq $goc.linetab, %rbx
+5:	cmpq $goc.linetab_end, %rbx
+	jae 7f
+	cmpq (%rbx), %r8
+	jb 7f	# the lines are in order, so none of the rest can be it
+	cmpq (%rbx), %r9
+	ja 6f	# this line is in an earlier function
+	movq %rbx, %r10
+6:	addq $32, %rbx
+	jmp 5b
+7:	cmpq $0, %r10
+	je 10f
+	movq 24(%r10), %rsi	# the file
+	movq 16(%r10), %rdx
+	call goc.write
+	movq $goc.colon, %rsi
+	movq $1, %rdx
+	call goc.write
+	movq 8(%r10), %rax	# and the line
+	call goc.printint
+10:	movq $goc.newline, %rsi
+	movq $1, %rdx
+	call goc.write
+	jmp 9f
+8:	cmpq $0, (%rsp)	# it isn't one of ours, but if it's where we faulted it could be assembly or C that we called
+	je 11f	# otherwise we've come as far as we can
+9:	movq $0, (%rsp)	# we're past the innermost frame
+	cmpq $0, %rbp	# is this the outermost frame?
+	je 11f
+	movq 8(%rbp), %r8	# the return address is in the call that got us here...
+	subq $1, %r8
+	movq (%rbp), %rbp	# ...and we move on to the frame of whoever made it
+	jmp 1b
+11:	addq $8, %rsp
+	popq %rbp	# Restore saved registers...
+	popq %r10
+	popq %r9
+	popq %r8
+	popq %rsi
+	popq %rdx
+	popq %rbx
+	popq %rax
+	ret	# from goc.traceback
+
+goc.signals:	# asks the kernel to tell goc.sigpanic about faults
+	subq $32, %rsp	# a struct sigaction
+	movq $goc.sigpanic, (%rsp)	# the handler
+	movq $0x4000004, 8(%rsp)	# its flags: SA_SIGINFO | SA_RESTORER
+	movq $goc.sigreturn, 16(%rsp)	# the restorer, which the amd64 insists upon
+	movq $0, 24(%rsp)	# the signals to block while handling one
+	movq $11, %rdi	# first argument: the signal (SIGSEGV)
+	call 1f
+	movq $8, %rdi	# SIGFPE
+	call 1f
+	movq $7, %rdi	# SIGBUS
+	call 1f
+	addq $32, %rsp
+	ret	# from goc.signals
+1:	leaq 8(%rsp), %rsi	# second argument: the action
+	movq $0, %rdx	# third argument: where to put the old one, which we don't want
+	movq $8, %r10	# fourth argument: the size of the signal mask
+	movq $13, %rax	# system call number (sys_rt_sigaction)
+	syscall
+	ret
+
+goc.sigreturn:
+	movq $15, %rax	# system call number (sys_rt_sigreturn)
+	syscall
+
+goc.sigpanic:	# turns the signal %rdi into a panic, with %rsi its siginfo and %rdx pointing to where it happened
+	movq %rdx, %rbx
+	movq %rsi, %rcx
+	movq $goc.faultmsg, %rsi
+	movq $goc.faultmsg_len, %rdx
+	cmpq $8, %rdi	# SIGFPE
+	jne 1f
+	movq $goc.floatmsg, %rsi	# as go says, unless it was an idivq...
+	movq $goc.floatmsg_len, %rdx
+	cmpl $1, 8(%rcx)	# ...when the code is FPE_INTDIV
+	jne 1f
+	movq $goc.dividemsg, %rsi	# which our code can only do by dividing by zero
+	movq $goc.dividemsg_len, %rdx
+1:	call goc.write
+	movq 168(%rbx), %rax	# the %rip that faulted...
+	movq 120(%rbx), %rbp	# ...and its frame pointer
+	call goc.traceback
+	movq $2, %rdi	# first argument: exit code
+	movq $60, %rax	# system call number (sys_exit)
+	syscall
+
+goc.shiftpanic:	# panics because the code that called us shifted by a negative count
+	movq $goc.shiftmsg, %rsi
+	movq $goc.shiftmsg_len, %rdx
+	call goc.write
+	movq (%rsp), %rax	# where we were called from...
+	subq $1, %rax	# ...which is in the call instruction
+	call goc.traceback
+	movq $2, %rdi	# first argument: exit code
+	movq $60, %rax	# system call number (sys_exit)
+	syscall
+
+goc.indexpanic:	# panics because the code that called us used an index that was out of range, having pushed the length and then the index
+	movq $goc.indexmsg, %rsi
+	movq $goc.indexmsg_len, %rdx
+	call goc.write
+	movq 8(%rsp), %rax	# the index, which go prints signed
+	cmpq $0, %rax
+	jge 1f
+	movq $goc.minus, %rsi
+	movq $1, %rdx
+	call goc.write
+	negq %rax
+1:	call goc.printint
+	movq $goc.lengthmsg, %rsi
+	cmpq $0, 8(%rsp)
+	jl 2f
+	movq $goc.lengthmsg_len, %rdx
+	call goc.write
+	movq 16(%rsp), %rax	# the length
+	call goc.printint
+	jmp 3f
+2:	movq $1, %rdx	# go doesn't give the length with a negative index, just the ]
+	call goc.write
+3:	movq $goc.newline, %rsi
+	movq $1, %rdx
+	call goc.write
+	movq (%rsp), %rax	# where we were called from...
+	subq $1, %rax	# ...which is in the call instruction
+	call goc.traceback
+	movq $2, %rdi	# first argument: exit code
+	movq $60, %rax	# system call number (sys_exit)
+	syscall
+
+goc.printint:	# prints %rax in decimal, to stderr
+	pushq %rax	# Save registers...
+	pushq %rbx
+	pushq %rdx
+	pushq %rsi
+	subq $24, %rsp	# room for the digits
+	movq %rsp, %rsi
+	addq $24, %rsi	# which we write from the end backwards
+	movq $10, %rbx
+1:	movq $0, %rdx
+	divq %rbx
+	addq $48, %rdx	# the digit in ascii
+	subq $1, %rsi
+	movb %dl, (%rsi)
+	cmpq $0, %rax
+	jne 1b
+	movq %rsp, %rdx
+	addq $24, %rdx
+	subq %rsi, %rdx	# the number of digits
+	call goc.write
+	addq $24, %rsp
+	popq %rsi	# Restore saved registers...
+	popq %rdx
+	popq %rbx
+	popq %rax
+	ret	# from goc.printint
+
+goc.write:	# writes the %rdx bytes at %rsi to stderr
+	pushq %rax	# Save registers, including the two syscall clobbers...
+	pushq %rdi
+	pushq %rcx
+	pushq %r11
+	movq $2, %rdi	# first argument: file handle (stderr)
+	movq $1, %rax	# system call number (sys_write)
+	syscall
+	popq %r11	# Restore saved registers...
+	popq %rcx
+	popq %rdi
+	popq %rax
+	ret	# from goc.write
+		`),
+}
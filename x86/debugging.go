@@ -11,6 +11,37 @@ var StartData = []X86{
 	Symbol("goc.argsptr"),
 	Commented(GlobalInt(0),
 		"This is a pointer to the actual args"),
+	Symbol("goc.brk"),
+	Commented(GlobalInt(0),
+		"This is the end of the heap, once we've asked for one"),
+	Symbol("goc.panicmsg"),
+	Commented(Ascii("panic: "), "what a panic message starts with"),
+	Symbol("goc.newline"),
+	Ascii("\n"),
+	Symbol("goc.parens"),
+	Commented(Ascii("()\n\t"), "what comes after a function's name in a traceback"),
+	Symbol("goc.colon"),
+	Ascii(":"),
+	Symbol("goc.faultmsg"),
+	Ascii("panic: runtime error: invalid memory address or nil pointer dereference\n"),
+	SymbolicConstant(Symbol("goc.faultmsg_len"), ". - goc.faultmsg"),
+	Symbol("goc.dividemsg"),
+	Ascii("panic: runtime error: integer divide by zero\n"),
+	SymbolicConstant(Symbol("goc.dividemsg_len"), ". - goc.dividemsg"),
+	Symbol("goc.floatmsg"),
+	Ascii("panic: runtime error: floating point error\n"),
+	SymbolicConstant(Symbol("goc.floatmsg_len"), ". - goc.floatmsg"),
+	Symbol("goc.shiftmsg"),
+	Ascii("panic: runtime error: negative shift amount\n"),
+	SymbolicConstant(Symbol("goc.shiftmsg_len"), ". - goc.shiftmsg"),
+	Symbol("goc.indexmsg"),
+	Ascii("panic: runtime error: index out of range ["),
+	SymbolicConstant(Symbol("goc.indexmsg_len"), ". - goc.indexmsg"),
+	Symbol("goc.lengthmsg"),
+	Ascii("] with length "),
+	SymbolicConstant(Symbol("goc.lengthmsg_len"), ". - goc.lengthmsg"),
+	Symbol("goc.minus"),
+	Ascii("-"),
 
 	Symbol("msg"),
 	Commented(Ascii("Hello, world!\n"), "a non-null-terminated string"),
@@ -20,6 +51,13 @@ var StartData = []X86{
 var StartText = []X86{
 	Section("text"),
 	Commented(GlobalSymbol("_start"), "this says where to start execution"),
+	Commented(MovL(Imm32(0), EBP), "this is the outermost frame, as far as backtraces go"),
+	Commented(MovL(Memory{nil, ESP, nil, nil}, EAX), "the kernel leaves argc on the stack"),
+	MovL(EAX, Symbol("goc.args")),
+	Commented(MovL(ESP, EAX), "followed by the argv pointers"),
+	AddL(Imm32(4), EAX),
+	MovL(EAX, Symbol("goc.argsptr")),
+	Commented(Call(Symbol("goc.signals")), "so that faults become panics"),
 	Call(Symbol("main_main")),
 	Comment("And exit..."),
 	Commented(MovL(Imm32(0), EBX), "first argument: exit code"),
@@ -59,6 +97,31 @@ println:
   addl $8, %esp # get rid of the two arguments
 	jmp *%eax # return from println
 
+panic:	# prints "panic: " and its string argument, then exits with 2, as go does
+	movl $7, %edx	# the length of "panic: "
+	movl $goc.panicmsg, %ecx
+	movl $2, %ebx	# first argument: file handle (stderr)
+	movl $4, %eax	# system call number (sys_write)
+	int $128
+	movl 4(%esp), %edx # read the length
+	movl 8(%esp), %ecx # the pointer to the string
+	movl $2, %ebx	# first argument: file handle (stderr)
+	movl $4, %eax	# system call number (sys_write)
+	int $128
+	movl $10, 4(%esp) # a newline
+	movl $1, %edx # the length
+	movl %esp, %ecx # the pointer
+	addl $4, %ecx
+	movl $2, %ebx	# first argument: file handle (stderr)
+	movl $4, %eax	# system call number (sys_write)
+	int $128
+	movl (%esp), %eax	# where we were called from...
+	subl $1, %eax	# ...which is in the call instruction
+	call goc.traceback
+	movl $2, %ebx	# first argument: exit code
+	movl $1, %eax	# system call number (sys_exit)
+	int $128
+
 debug.print_eax:
 	pushl %edx	# Save registers...
 	pushl %ecx
@@ -0,0 +1,1116 @@
+package x86
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Encode turns assembly into machine code, just as GNU as would, so
+// that we don't need binutils to build a program.  The instructions we
+// generate ourselves are encoded straight from their fields, but the
+// assembly that comes as text (the runtime and the .S files of the
+// standard library) has to be parsed first, so Encode understands the
+// subset of GNU as syntax that we write by hand: labels (including
+// numbered local labels such as 1f and 2b), .global, .text, .data,
+// .ascii, .int and .quad, "name = . - label", and the instructions
+// listed in encodeInstr.  We leave out the debugging information
+// (.file, .loc and the .debug sections) altogether.
+//
+// We make the same choices as GNU as about which encoding to use, so
+// that the two can be compared byte for byte: short jumps where they
+// reach, 8-bit immediates where they fit, and so on.
+
+// These are the sections an Object has.
+const (
+	TextSection = iota
+	DataSection
+	NumSections
+	AbsoluteSection = -1 // for a symbol that is just a number
+	ignoredSection = -2 // such as .debug_info, which we leave out
+)
+
+// An Object is the machine code for a program, as Encode produces it.
+// Any field that refers to the address of a symbol is left as zero,
+// with a Reloc saying how to fill it in, since we don't know where
+// the sections will end up until we link.
+
+type Object struct {
+	Sections [NumSections][]byte
+	Symbols map[string]*SymbolDef
+	SymbolOrder []string // the names of Symbols, in the order they were defined
+	Relocs []Reloc
+	Lines []Line
+}
+
+// A SymbolDef says where a symbol is, as an offset into its section.
+
+type SymbolDef struct {
+	Section int
+	Value int64
+	Global bool
+}
+
+// A Reloc is a field that holds the address of Symbol plus Addend,
+// less the address of the field itself if PCRel is set.
+
+type Reloc struct {
+	Section, Offset int // where the field is
+	Size int // how many bytes the field takes up
+	Symbol string
+	Addend int64
+	PCRel bool
+}
+
+// A Line records where the code for a line of assembly went, which
+// is handy for telling which instruction is at a given address.
+
+type Line struct {
+	Section, Offset int
+	Text string
+}
+
+// Link fills in every field that refers to a symbol, given the
+// addresses at which the sections will be loaded, and how to find
+// the address of any symbol that isn't defined in o.
+func (o *Object) Link(addrs [NumSections]int64, lookup func(string) (int64, bool)) os.Error {
+	for _,r := range o.Relocs {
+		s,ok := o.Address(r.Symbol, addrs)
+		if !ok {
+			if s,ok = lookup(r.Symbol); !ok {
+				return os.NewError("undefined symbol " + r.Symbol)
+			}
+		}
+		v := s + r.Addend
+		if r.PCRel {
+			v -= addrs[r.Section] + int64(r.Offset)
+		}
+		putInt(o.Sections[r.Section][r.Offset:r.Offset+r.Size], v)
+	}
+	return nil
+}
+
+// Address returns the address of a symbol defined in o, given the
+// addresses of the sections.
+func (o *Object) Address(name string, addrs [NumSections]int64) (int64, bool) {
+	d,ok := o.Symbols[name]
+	if !ok {
+		return 0, false
+	}
+	if d.Section == AbsoluteSection {
+		return d.Value, true
+	}
+	return addrs[d.Section] + d.Value, true
+}
+
+// References returns the symbols that o refers to without defining
+// them, which something else will have to.
+func (o *Object) References() map[string]bool {
+	refs := make(map[string]bool)
+	for _,r := range o.Relocs {
+		if _,ok := o.Symbols[r.Symbol]; !ok {
+			refs[r.Symbol] = true
+		}
+	}
+	return refs
+}
+
+// Where returns the line of assembly whose code is at offset in
+// section.
+func (o *Object) Where(section, offset int) string {
+	where := ""
+	for _,l := range o.Lines {
+		if l.Section == section && l.Offset <= offset {
+			where = l.Text
+		}
+	}
+	return where
+}
+
+func putInt(b []byte, v int64) {
+	for i := range b {
+		b[i] = byte(v >> uint(8*i))
+	}
+}
+
+// An operand is what we make of one argument of an instruction,
+// whether it came from one of our types or from text.
+
+type operand struct {
+	kind int
+	reg int // the hardware number of a register operand
+	size int // the size of a register operand
+	indirect bool // as in jmp *%eax
+	imm int64 // the value of an immediate, or the displacement
+	sym string // a symbol whose address is added to imm
+	base, index int // registers of a memory operand, or -1
+	scale int
+}
+
+const (
+	regOperand = iota
+	immOperand
+	memOperand
+)
+
+// An instr is an instruction, ready to encode.
+
+type instr struct {
+	op string
+	args []operand
+	rep bool
+}
+
+// An item is a line of assembly: a label, a directive or an
+// instruction.
+
+type item struct {
+	section int
+	text string
+	label string
+	global string
+	equ string // the name of a symbolic constant, whose value is text
+	instr *instr
+	data []byte
+	word *operand // a .int or .quad of a symbol
+	enc *encoding
+	branch bool // a jump whose size depends on how far it goes
+	short bool
+}
+
+// An encoding is the bytes of an instruction, along with any fields
+// that refer to symbols.
+
+type encoding struct {
+	b []byte
+	fields []field
+}
+
+type field struct {
+	at, size int
+	sym string
+	addend int64
+	pcrel bool
+}
+
+type assembler struct {
+	items []*item
+	section int
+}
+
+// emit adds items to the section we're in.
+func (a *assembler) emit(its ...*item) {
+	if a.section == ignoredSection {
+		return
+	}
+	for _,it := range its {
+		it.section = a.section
+		a.items = append(a.items, it)
+	}
+}
+
+// Encode assembles code into an Object.
+func Encode(code []X86) (*Object, os.Error) {
+	a := &assembler{}
+	for _,x := range code {
+		if err := a.add(x); err != nil {
+			return nil, err
+		}
+	}
+	return a.assemble()
+}
+
+func (a *assembler) add(x X86) os.Error {
+	x = Uncommented(x)
+	if x == nil {
+		return nil
+	}
+	text := x.X86()
+	var in *instr
+	var err os.Error
+	switch x := x.(type) {
+	case Symbol:
+		a.emit(&item{label: string(x), text: text})
+		return nil
+	case GlobalSymbol:
+		a.emit(&item{global: string(x), text: text},
+			&item{label: string(x), text: text})
+		return nil
+	case Section:
+		a.section = sectionNumber(string(x))
+		return nil
+	case Ascii:
+		a.emit(&item{data: []byte(string(x)), text: text})
+		return nil
+	case GlobalInt:
+		b := make([]byte, WordSize)
+		putInt(b, int64(x))
+		a.emit(&item{data: b, text: text})
+		return nil
+	case symbolicConstant:
+		a.emit(&item{equ: string(x.name), text: x.value})
+		return nil
+	case OpL2:
+		in,err = newInstr(wordOp(x.Name), x.Src.W32(), x.Dest.Ptr())
+	case OpBL:
+		in,err = newInstr(wordOp(x.Name), x.Src.W8(), x.Dest.Ptr())
+	case OpLL:
+		in,err = newInstr(wordOp(x.Name), x.Src1.W32(), x.Src2.W32())
+	case OpL1:
+		in,err = newInstr(wordOp(x.Name), x.Arg.W32())
+	case Op0:
+		in,err = newInstr(wordOp(x.Name))
+	case OpP1:
+		in,err = newInstr(x.Name, x.Arg.Ptr())
+	default:
+		// Anything else is text that we have to parse.
+		for _,l := range strings.Split(text, "\n", -1) {
+			if err := a.parseLine(l); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err != nil {
+		return os.NewError(err.String() + " in: " + text)
+	}
+	a.emit(&item{instr: in, text: text})
+	return nil
+}
+
+func sectionNumber(name string) int {
+	switch {
+	case strings.HasPrefix(name, "data"):
+		return DataSection
+	case strings.HasPrefix(name, "debug"):
+		// We don't make any debugging information yet.
+		return ignoredSection
+	}
+	return TextSection
+}
+
+// parseLine parses a line of GNU as syntax.
+func (a *assembler) parseLine(line string) os.Error {
+	text := strings.TrimSpace(line)
+	line = stripComment(line)
+	if a.section == ignoredSection {
+		// All we care about is where the section ends.
+		words := strings.Fields(line)
+		if len(words) == 0 || (words[0] != ".text" && words[0] != ".data" && words[0] != ".section") {
+			return nil
+		}
+	}
+	for {
+		line = strings.TrimSpace(line)
+		colon := strings.Index(line, ":")
+		if colon < 0 || !isSymbol(line[:colon]) {
+			break
+		}
+		a.emit(&item{label: line[:colon], text: text})
+		line = line[colon+1:]
+	}
+	if line == "" {
+		return nil
+	}
+	fail := func(why string) os.Error {
+		return os.NewError(why + " in: " + text)
+	}
+	words := strings.Fields(line)
+	switch words[0] {
+	case ".global", ".globl":
+		if len(words) != 2 {
+			return fail("I expected one symbol")
+		}
+		a.emit(&item{global: words[1], text: text})
+		return nil
+	case ".text", ".data":
+		a.section = sectionNumber(words[0][1:])
+		return nil
+	case ".file", ".loc":
+		// These are only for the debugging information.
+		return nil
+	case ".section":
+		a.section = sectionNumber(strings.TrimLeft(words[1], "."))
+		return nil
+	case ".ascii":
+		s,err := strconv.Unquote(strings.TrimSpace(line[len(".ascii"):]))
+		if err != nil {
+			return fail("bad string")
+		}
+		a.emit(&item{data: []byte(s), text: text})
+		return nil
+	case ".int", ".long", ".quad":
+		size := 4
+		if words[0] == ".quad" {
+			size = 8
+		}
+		for _,v := range splitArgs(line[len(words[0]):]) {
+			o,err := parseOperand("$" + v)
+			if err != nil {
+				return fail(err.String())
+			}
+			b := make([]byte, size)
+			putInt(b, o.imm)
+			it := &item{data: b, text: text}
+			if o.sym != "" {
+				it.word = &o
+			}
+			a.emit(it)
+		}
+		return nil
+	}
+	if eq := strings.Index(line, "="); eq > 0 && isSymbol(strings.TrimSpace(line[:eq])) {
+		a.emit(&item{equ: strings.TrimSpace(line[:eq]),
+			text: strings.TrimSpace(line[eq+1:])})
+		return nil
+	}
+	rep := false
+	if words[0] == "rep" {
+		rep = true
+		line = strings.TrimSpace(line[3:])
+		words = words[1:]
+	}
+	in,err := newInstr(words[0], splitArgs(line[len(words[0]):])...)
+	if err != nil {
+		return fail(err.String())
+	}
+	in.rep = rep
+	a.emit(&item{instr: in, text: text})
+	return nil
+}
+
+// stripComment removes anything after a # that isn't in a string.
+func stripComment(line string) string {
+	quoted := false
+	for i:=0; i<len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case '#':
+			if !quoted {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+func isSymbol(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _,c := range s {
+		if !(c == '_' || c == '.' || c == '$' || c >= 'a' && c <= 'z' ||
+			c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// splitArgs splits the arguments of an instruction at the commas
+// that aren't inside parentheses.
+func splitArgs(s string) (out []string) {
+	depth, start := 0, 0
+	for i,c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				out = append(out, strings.TrimSpace(s[start:i]))
+				start = i+1
+			}
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" || len(out) > 0 {
+		out = append(out, last)
+	}
+	return
+}
+
+func newInstr(op string, args ...string) (*instr, os.Error) {
+	in := &instr{op: op}
+	for _,s := range args {
+		o,err := parseOperand(s)
+		if err != nil {
+			return nil, err
+		}
+		in.args = append(in.args, o)
+	}
+	return in, nil
+}
+
+var registerNames = map[string]operand{}
+
+func init() {
+	// These are in the order of their hardware numbers.
+	names := [][]string{
+		{"al", "ax", "eax", "rax"}, {"cl", "cx", "ecx", "rcx"},
+		{"dl", "dx", "edx", "rdx"}, {"bl", "bx", "ebx", "rbx"},
+		{"spl", "sp", "esp", "rsp"}, {"bpl", "bp", "ebp", "rbp"},
+		{"sil", "si", "esi", "rsi"}, {"dil", "di", "edi", "rdi"},
+	}
+	for n,ns := range names {
+		for k,name := range ns {
+			registerNames[name] = operand{kind: regOperand, reg: n, size: 1 << uint(k)}
+		}
+	}
+	for n:=8; n<16; n++ {
+		r := "r" + strconv.Itoa(n)
+		registerNames[r+"b"] = operand{kind: regOperand, reg: n, size: 1}
+		registerNames[r+"w"] = operand{kind: regOperand, reg: n, size: 2}
+		registerNames[r+"d"] = operand{kind: regOperand, reg: n, size: 4}
+		registerNames[r] = operand{kind: regOperand, reg: n, size: 8}
+	}
+}
+
+// parseOperand parses an argument in AT&T syntax, such as %eax, $12,
+// $msg, 8(%ebp), (%ecx,%eax,4), goc.args or *%eax.
+func parseOperand(s string) (o operand, err os.Error) {
+	o.base, o.index = -1, -1
+	if strings.HasPrefix(s, "*") {
+		o,err = parseOperand(s[1:])
+		o.indirect = true
+		return
+	}
+	if strings.HasPrefix(s, "%") {
+		r,ok := registerNames[s[1:]]
+		if !ok {
+			return o, os.NewError("unknown register " + s)
+		}
+		r.base, r.index = -1, -1
+		return r, nil
+	}
+	if strings.HasPrefix(s, "$") {
+		o.kind = immOperand
+		o.imm, o.sym, err = parseExpr(s[1:])
+		return
+	}
+	o.kind = memOperand
+	paren := strings.Index(s, "(")
+	if paren < 0 {
+		o.imm, o.sym, err = parseExpr(s)
+		return
+	}
+	if paren > 0 {
+		if o.imm, o.sym, err = parseExpr(s[:paren]); err != nil {
+			return
+		}
+	}
+	if !strings.HasSuffix(s, ")") {
+		return o, os.NewError("bad memory reference " + s)
+	}
+	regs := strings.Split(s[paren+1:len(s)-1], ",", -1)
+	reg := func(name string) (int, os.Error) {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return -1, nil
+		}
+		r,ok := registerNames[strings.TrimLeft(name, "%")]
+		if !ok || r.size < 4 {
+			return -1, os.NewError("bad register in " + s)
+		}
+		return r.reg, nil
+	}
+	if o.base,err = reg(regs[0]); err != nil {
+		return
+	}
+	o.scale = 1
+	if len(regs) > 1 {
+		if o.index,err = reg(regs[1]); err != nil {
+			return
+		}
+	}
+	if len(regs) > 2 {
+		o.scale,err = strconv.Atoi(strings.TrimSpace(regs[2]))
+	}
+	return
+}
+
+// parseExpr parses a number, a symbol, or a symbol plus or minus a
+// number.
+func parseExpr(s string) (n int64, sym string, err os.Error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, "", os.NewError("missing expression")
+	}
+	if s[0] == '-' || s[0] >= '0' && s[0] <= '9' {
+		if len(s) > 1 && (s[len(s)-1] == 'f' || s[len(s)-1] == 'b') && !strings.HasPrefix(s, "0x") {
+			return 0, s, nil // a numbered local label
+		}
+		n,err = strconv.Btoi64(s, 0)
+		return
+	}
+	split := 0
+	for split < len(s) && s[split] != '+' && s[split] != '-' {
+		split++
+	}
+	if split == len(s) {
+		return 0, s, nil
+	}
+	n,err = strconv.Btoi64(strings.TrimSpace(s[split+1:]), 0)
+	if s[split] == '-' {
+		n = -n
+	}
+	return n, strings.TrimSpace(s[:split]), err
+}
+
+// assemble lays out the items, deciding how big each jump has to be,
+// and then produces the Object.
+func (a *assembler) assemble() (*Object, os.Error) {
+	a.numberedLabels()
+	sections := make(map[string]int)
+	for _,it := range a.items {
+		if it.label != "" {
+			sections[it.label] = it.section
+		}
+		if it.equ != "" {
+			sections[it.equ] = AbsoluteSection
+		}
+	}
+	for _,it := range a.items {
+		if it.instr != nil && isBranch(it.instr) {
+			// A jump can be short if it's to somewhere in the same
+			// section, which we assume it is until we find out that
+			// it's too far.
+			s,ok := sections[it.instr.args[0].sym]
+			it.branch = true
+			it.short = ok && s == it.section
+		} else if it.instr != nil {
+			enc,err := encodeInstr(it.instr, false)
+			if err != nil {
+				return nil, os.NewError(err.String() + " in: " + it.text)
+			}
+			it.enc = enc
+		}
+	}
+	// Making one jump long can push another out of reach, so we keep
+	// going until they all reach.
+	for {
+		labels, at := a.layout()
+		changed := false
+		for i,it := range a.items {
+			if it.branch && it.short {
+				if !fits8(int64(labels[it.instr.args[0].sym] - (at[i] + 2))) {
+					it.short = false
+					changed = true
+				}
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+	for _,it := range a.items {
+		if it.branch {
+			enc,err := encodeInstr(it.instr, it.short)
+			if err != nil {
+				return nil, os.NewError(err.String() + " in: " + it.text)
+			}
+			it.enc = enc
+		}
+	}
+	return a.object(sections)
+}
+
+// layout works out the offset of every label, and of every item,
+// within its section.
+func (a *assembler) layout() (labels map[string]int, at []int) {
+	labels = make(map[string]int)
+	var here [NumSections]int
+	for _,it := range a.items {
+		at = append(at, here[it.section])
+		if it.label != "" {
+			labels[it.label] = here[it.section]
+		}
+		here[it.section] += it.size()
+	}
+	return
+}
+
+func (it *item) size() int {
+	switch {
+	case it.branch && it.short:
+		return 2
+	case it.branch && it.instr.op == "jmp":
+		return 5
+	case it.branch:
+		return 6
+	case it.enc != nil:
+		return len(it.enc.b)
+	}
+	return len(it.data)
+}
+
+// numberedLabels gives each numbered local label a name of its own,
+// and points each reference to one (such as 1f, meaning the next label
+// 1, or 1b, meaning the last) at the right one.
+func (a *assembler) numberedLabels() {
+	isNumber := func(s string) bool {
+		_,err := strconv.Atoi(s)
+		return err == nil
+	}
+	count := make(map[string]int)
+	for _,it := range a.items {
+		if isNumber(it.label) {
+			count[it.label]++
+			it.label = fmt.Sprint(".Lnumbered.", it.label, ".", count[it.label])
+		} else if it.instr != nil {
+			for k,o := range it.instr.args {
+				n := len(o.sym) - 1
+				if n > 0 && isNumber(o.sym[:n]) {
+					if o.sym[n] == 'b' {
+						it.instr.args[k].sym = fmt.Sprint(".Lnumbered.", o.sym[:n], ".", count[o.sym[:n]])
+					} else {
+						it.instr.args[k].sym = fmt.Sprint(".Lnumbered.", o.sym[:n], ".", count[o.sym[:n]]+1)
+					}
+				}
+			}
+		}
+	}
+}
+
+// object puts together the bytes of the sections, filling in the
+// fields we can (jumps within a section) and recording a Reloc for
+// the rest.
+func (a *assembler) object(sections map[string]int) (*Object, os.Error) {
+	o := &Object{Symbols: make(map[string]*SymbolDef)}
+	offsets,_ := a.layout()
+	globals := make(map[string]bool)
+	for _,it := range a.items {
+		if it.global != "" {
+			globals[it.global] = true
+		}
+	}
+	for _,it := range a.items {
+		here := len(o.Sections[it.section])
+		switch {
+		case it.label != "":
+			if _,ok := o.Symbols[it.label]; ok {
+				return nil, os.NewError("symbol " + it.label + " is defined twice")
+			}
+			o.Symbols[it.label] = &SymbolDef{it.section, int64(here), globals[it.label]}
+			o.SymbolOrder = append(o.SymbolOrder, it.label)
+		case it.equ != "":
+			// All we handle is the length of something, as in ". - msg".
+			fs := strings.Fields(it.text)
+			if len(fs) != 3 || fs[0] != "." || fs[1] != "-" {
+				return nil, os.NewError("I can't work out " + it.equ + " = " + it.text)
+			}
+			start,ok := offsets[fs[2]]
+			if !ok || sections[fs[2]] != it.section {
+				return nil, os.NewError("I can't work out " + it.equ + " = " + it.text)
+			}
+			o.Symbols[it.equ] = &SymbolDef{AbsoluteSection, int64(here - start), globals[it.equ]}
+			o.SymbolOrder = append(o.SymbolOrder, it.equ)
+		case it.enc != nil:
+			o.Lines = append(o.Lines, Line{it.section, here, it.text})
+			o.Sections[it.section] = append(o.Sections[it.section], it.enc.b...)
+			for _,f := range it.enc.fields {
+				at := here + f.at
+				if s,ok := sections[f.sym]; ok && f.pcrel && s == it.section {
+					v := int64(offsets[f.sym]) + f.addend - int64(at)
+					if f.size == 1 && (v < -128 || v > 127) {
+						return nil, os.NewError("jump out of range in: " + it.text)
+					}
+					putInt(o.Sections[it.section][at:at+f.size], v)
+					continue
+				}
+				o.Relocs = append(o.Relocs, Reloc{it.section, at, f.size, f.sym, f.addend, f.pcrel})
+			}
+		case it.data != nil:
+			o.Sections[it.section] = append(o.Sections[it.section], it.data...)
+			if it.word != nil {
+				o.Relocs = append(o.Relocs, Reloc{it.section, here, len(it.data),
+					it.word.sym, it.word.imm, false})
+				putInt(o.Sections[it.section][here:], 0)
+			}
+		}
+	}
+	return o, nil
+}
+
+func isBranch(in *instr) bool {
+	_,jcc := conditions[in.op]
+	return (jcc || in.op == "jmp") && len(in.args) == 1 &&
+		in.args[0].kind == memOperand && !in.args[0].indirect && in.args[0].sym != ""
+}
+
+// These are the condition codes of the conditional jumps.
+var conditions = map[string]byte{
+	"jo": 0, "jno": 1, "jb": 2, "jc": 2, "jnae": 2, "jae": 3, "jnb": 3, "jnc": 3,
+	"je": 4, "jz": 4, "jne": 5, "jnz": 5, "jbe": 6, "jna": 6, "ja": 7, "jnbe": 7,
+	"js": 8, "jns": 9, "jp": 10, "jnp": 11, "jl": 12, "jnge": 12, "jge": 13, "jnl": 13,
+	"jle": 14, "jng": 14, "jg": 15, "jnle": 15,
+}
+
+// These are the arithmetic instructions, with the number that goes in
+// the reg field of their ModRM byte when they take an immediate.
+var arithmeticOps = map[string]byte{"add": 0, "or": 1, "adc": 2, "sbb": 3, "and": 4, "sub": 5, "xor": 6, "cmp": 7}
+var unaryOps = map[string]byte{"not": 2, "neg": 3, "mul": 4, "div": 6, "idiv": 7}
+var shiftOps = map[string]byte{"shl": 4, "sal": 4, "shr": 5, "sar": 7}
+
+// opSize splits an instruction name into the instruction and the
+// size of its operands.
+func opSize(op string) (string, int) {
+	switch op {
+	case "movzbl":
+		return "movzb", 4
+	case "movzbq":
+		return "movzb", 8
+	}
+	if len(op) > 1 {
+		switch op[len(op)-1] {
+		case 'b':
+			return op[:len(op)-1], 1
+		case 'w':
+			return op[:len(op)-1], 2
+		case 'l':
+			return op[:len(op)-1], 4
+		case 'q':
+			return op[:len(op)-1], 8
+		}
+	}
+	return op, 0
+}
+
+func fits8(v int64) bool {
+	return v >= -128 && v <= 127
+}
+
+// An encoder builds up a single instruction.
+
+type encoder struct {
+	b []byte
+	fields []field
+}
+
+func (e *encoder) bytes(bs ...byte) {
+	e.b = append(e.b, bs...)
+}
+
+// imm appends an immediate of the given size.
+func (e *encoder) imm(o operand, size int) {
+	if o.sym != "" {
+		e.fields = append(e.fields, field{len(e.b), size, o.sym, o.imm, false})
+		e.b = append(e.b, make([]byte, size)...)
+		return
+	}
+	b := make([]byte, size)
+	putInt(b, o.imm)
+	e.bytes(b...)
+}
+
+// rel appends a jump's displacement to sym, which is relative to the
+// end of the instruction.
+func (e *encoder) rel(sym string, size int) {
+	e.fields = append(e.fields, field{len(e.b), size, sym, int64(-size), true})
+	e.b = append(e.b, make([]byte, size)...)
+}
+
+// op appends an instruction with a ModRM byte, whose reg field is reg
+// and which refers to rm, preceded by whichever prefixes it needs.
+func (e *encoder) op(size int, opcode []byte, reg int, rm operand) {
+	rex := byte(0)
+	if size == 8 {
+		rex |= 8 // REX.W
+	}
+	if size == 2 {
+		e.bytes(0x66)
+	}
+	if reg >= 8 {
+		rex |= 4 // REX.R
+	}
+	if rm.kind == regOperand && rm.reg >= 8 || rm.kind == memOperand && rm.base >= 8 {
+		rex |= 1 // REX.B
+	}
+	if rm.kind == memOperand && rm.index >= 8 {
+		rex |= 2 // REX.X
+	}
+	if rex != 0 {
+		e.bytes(0x40 | rex)
+	}
+	e.bytes(opcode...)
+	e.modRM(reg & 7, rm)
+}
+
+// modRM appends the ModRM byte, and any SIB byte and displacement.
+func (e *encoder) modRM(reg int, o operand) {
+	r := byte(reg << 3)
+	if o.kind == regOperand {
+		e.bytes(0xC0 | r | byte(o.reg & 7))
+		return
+	}
+	disp := operand{kind: immOperand, imm: o.imm, sym: o.sym}
+	if o.base < 0 {
+		// There's no base, so the displacement is 32 bits.  On the
+		// amd64, the usual way of saying that means relative to %rip,
+		// so we have to go by way of a SIB byte.
+		switch {
+		case o.index >= 0:
+			e.bytes(0x04 | r, scaleBits(o.scale) | byte(o.index & 7) << 3 | 5)
+		case WordSize == 8:
+			e.bytes(0x04 | r, 0x25)
+		default:
+			e.bytes(0x05 | r)
+		}
+		e.imm(disp, 4)
+		return
+	}
+	var mod byte
+	switch {
+	case o.sym != "":
+		mod = 2
+	case o.imm == 0 && o.base & 7 != 5:
+		mod = 0 // %ebp as a base always needs a displacement
+	case fits8(o.imm):
+		mod = 1
+	default:
+		mod = 2
+	}
+	if o.index >= 0 || o.base & 7 == 4 {
+		index := byte(4) // meaning none, so %esp can't be an index
+		if o.index >= 0 {
+			index = byte(o.index & 7)
+		}
+		e.bytes(mod << 6 | r | 4, scaleBits(o.scale) | index << 3 | byte(o.base & 7))
+	} else {
+		e.bytes(mod << 6 | r | byte(o.base & 7))
+	}
+	switch mod {
+	case 1:
+		e.imm(disp, 1)
+	case 2:
+		e.imm(disp, 4)
+	}
+}
+
+func scaleBits(scale int) byte {
+	switch scale {
+	case 2:
+		return 1 << 6
+	case 4:
+		return 2 << 6
+	case 8:
+		return 3 << 6
+	}
+	return 0
+}
+
+// encodeInstr encodes a single instruction.  A jump to a label is
+// encoded short (with an 8-bit displacement) if short is set.
+func encodeInstr(in *instr, short bool) (*encoding, os.Error) {
+	e := &encoder{}
+	args := in.args
+	bad := func() (*encoding, os.Error) {
+		return nil, os.NewError("I don't know how to encode " + in.op)
+	}
+	need := func(n int) bool {
+		return len(args) == n
+	}
+	if in.rep {
+		e.bytes(0xF3)
+	}
+	base,size := opSize(in.op)
+	cc,jcc := conditions[in.op]
+	arith,isArith := arithmeticOps[base]
+	unary,isUnary := unaryOps[base]
+	shift,isShift := shiftOps[base]
+	switch {
+	case in.op == "ret" && need(0):
+		e.bytes(0xC3)
+	case in.op == "cld" && need(0):
+		e.bytes(0xFC)
+	case in.op == "cltd" && need(0):
+		e.bytes(0x99)
+	case in.op == "cqto" && need(0):
+		e.bytes(0x48, 0x99)
+	case in.op == "syscall" && need(0):
+		e.bytes(0x0F, 0x05)
+	case in.op == "movsb" && need(0):
+		e.bytes(0xA4)
+	case in.op == "int" && need(1) && args[0].kind == immOperand:
+		e.bytes(0xCD)
+		e.imm(args[0], 1)
+	case (in.op == "call" || in.op == "jmp") && need(1) && args[0].indirect:
+		digit := 4
+		if in.op == "call" {
+			digit = 2
+		}
+		e.op(0, []byte{0xFF}, digit, args[0])
+	case in.op == "call" && need(1):
+		e.bytes(0xE8)
+		e.rel(args[0].sym, 4)
+	case in.op == "jmp" && need(1) && short:
+		e.bytes(0xEB)
+		e.rel(args[0].sym, 1)
+	case in.op == "jmp" && need(1):
+		e.bytes(0xE9)
+		e.rel(args[0].sym, 4)
+	case jcc && need(1) && short:
+		e.bytes(0x70 + cc)
+		e.rel(args[0].sym, 1)
+	case jcc && need(1):
+		e.bytes(0x0F, 0x80 + cc)
+		e.rel(args[0].sym, 4)
+	case size == 0:
+		return bad()
+	case base == "push" && need(1):
+		switch a := args[0]; a.kind {
+		case regOperand:
+			if a.reg >= 8 {
+				e.bytes(0x41)
+			}
+			e.bytes(0x50 + byte(a.reg & 7))
+		case immOperand:
+			if a.sym == "" && fits8(a.imm) {
+				e.bytes(0x6A)
+				e.imm(a, 1)
+			} else {
+				e.bytes(0x68)
+				e.imm(a, 4)
+			}
+		default:
+			e.op(0, []byte{0xFF}, 6, a)
+		}
+	case base == "pop" && need(1):
+		switch a := args[0]; a.kind {
+		case regOperand:
+			if a.reg >= 8 {
+				e.bytes(0x41)
+			}
+			e.bytes(0x58 + byte(a.reg & 7))
+		case memOperand:
+			e.op(0, []byte{0x8F}, 0, a)
+		default:
+			return bad()
+		}
+	case base == "mov" && need(2):
+		return e.mov(size, args[0], args[1])
+	case base == "movzb" && need(2) && args[1].kind == regOperand:
+		e.op(size, []byte{0x0F, 0xB6}, args[1].reg, args[0])
+	case base == "lea" && need(2) && args[0].kind == memOperand && args[1].kind == regOperand:
+		e.op(size, []byte{0x8D}, args[1].reg, args[0])
+	case base == "imul" && need(2) && args[1].kind == regOperand:
+		src, dst := args[0], args[1]
+		switch {
+		case src.kind == immOperand && src.sym == "" && fits8(src.imm):
+			e.op(size, []byte{0x6B}, dst.reg, dst)
+			e.imm(src, 1)
+		case src.kind == immOperand:
+			e.op(size, []byte{0x69}, dst.reg, dst)
+			e.imm(src, 4)
+		default:
+			e.op(size, []byte{0x0F, 0xAF}, dst.reg, src)
+		}
+	case isArith && need(2):
+		return e.arithmetic(arith, size, args[0], args[1])
+	case isUnary && need(1) && args[0].kind != immOperand:
+		opcode := byte(0xF7)
+		if size == 1 {
+			opcode = 0xF6
+		}
+		e.op(size, []byte{opcode}, int(unary), args[0])
+	case isShift && need(2) && args[1].kind != immOperand:
+		digit := int(shift)
+		count, dst := args[0], args[1]
+		b := byte(0)
+		if size == 1 {
+			b = 1 // the byte versions are one less
+		}
+		switch {
+		case count.kind == regOperand && count.reg == 1 && count.size == 1:
+			e.op(size, []byte{0xD3 - b}, digit, dst)
+		case count.kind == immOperand && count.sym == "" && count.imm == 1:
+			e.op(size, []byte{0xD1 - b}, digit, dst)
+		case count.kind == immOperand:
+			e.op(size, []byte{0xC1 - b}, digit, dst)
+			e.imm(count, 1)
+		default:
+			return bad()
+		}
+	default:
+		return bad()
+	}
+	return &encoding{e.b, e.fields}, nil
+}
+
+func (e *encoder) mov(size int, src, dst operand) (*encoding, os.Error) {
+	b := byte(1)
+	if size == 1 {
+		b = 0 // the byte versions are one less
+	}
+	absolute := func(o operand) bool {
+		return o.kind == memOperand && o.base < 0 && o.index < 0 && WordSize == 4
+	}
+	switch {
+	case src.kind == immOperand && dst.kind == regOperand && size == 8:
+		// This is sign-extended from 32 bits, as GNU as prefers.
+		e.op(size, []byte{0xC7}, 0, dst)
+		e.imm(src, 4)
+	case src.kind == immOperand && dst.kind == regOperand:
+		if dst.reg >= 8 {
+			e.bytes(0x41)
+		}
+		e.bytes(0xB0 + 8*b + byte(dst.reg & 7))
+		e.imm(src, size)
+	case src.kind == immOperand:
+		e.op(size, []byte{0xC6 + b}, 0, dst)
+		if size == 8 {
+			size = 4
+		}
+		e.imm(src, size)
+	case src.kind == regOperand && src.reg == 0 && absolute(dst):
+		// There's a short form for moving to or from %eax.
+		e.bytes(0xA2 + b)
+		e.imm(operand{kind: immOperand, imm: dst.imm, sym: dst.sym}, 4)
+	case dst.kind == regOperand && dst.reg == 0 && absolute(src):
+		e.bytes(0xA0 + b)
+		e.imm(operand{kind: immOperand, imm: src.imm, sym: src.sym}, 4)
+	case src.kind == regOperand:
+		e.op(size, []byte{0x88 + b}, src.reg, dst)
+	case dst.kind == regOperand:
+		e.op(size, []byte{0x8A + b}, dst.reg, src)
+	default:
+		return nil, os.NewError("I can't move from memory to memory")
+	}
+	return &encoding{e.b, e.fields}, nil
+}
+
+func (e *encoder) arithmetic(digit byte, size int, src, dst operand) (*encoding, os.Error) {
+	b := byte(1)
+	if size == 1 {
+		b = 0 // the byte versions are one less
+	}
+	switch {
+	case src.kind == immOperand && size == 1:
+		e.op(size, []byte{0x80}, int(digit), dst)
+		e.imm(src, 1)
+	case src.kind == immOperand && src.sym == "" && fits8(src.imm):
+		e.op(size, []byte{0x83}, int(digit), dst)
+		e.imm(src, 1)
+	case src.kind == immOperand && dst.kind == regOperand && dst.reg == 0:
+		// There's a short form for %eax.
+		if size == 8 {
+			e.bytes(0x48)
+		}
+		e.bytes(digit << 3 | 5)
+		e.imm(src, 4)
+	case src.kind == immOperand:
+		e.op(size, []byte{0x81}, int(digit), dst)
+		e.imm(src, 4)
+	case src.kind == regOperand:
+		e.op(size, []byte{digit << 3 | b}, src.reg, dst)
+	case dst.kind == regOperand:
+		e.op(size, []byte{digit << 3 | b + 2}, dst.reg, src)
+	default:
+		return nil, os.NewError("I can't do arithmetic on two memory operands")
+	}
+	return &encoding{e.b, e.fields}, nil
+}
@@ -0,0 +1,208 @@
+package x86
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// An encodeTest is some assembly, and the bytes GNU as makes of it,
+// written as objdump would show them.
+
+type encodeTest struct {
+	asm string
+	bytes string
+}
+
+var encode386 = []encodeTest{
+	// ModRM, with a register or memory
+	{"movl %eax, %ebx", "89 c3"},
+	{"movl %eax, (%ecx)", "89 01"},
+	{"xorl (%ecx), %eax", "33 01"},
+	{"movb %al, (%ecx)", "88 01"},
+	{"movzbl (%ecx), %eax", "0f b6 01"},
+	{"negl %eax", "f7 d8"},
+	{"idivl %ecx", "f7 f9"},
+	{"call *%eax", "ff d0"},
+	{"jmp *%eax", "ff e0"},
+	// displacements: none, 8 bits and 32 bits, and %ebp, which
+	// always needs one
+	{"movl 8(%ebp), %eax", "8b 45 08"},
+	{"movl -4(%ebp), %ecx", "8b 4d fc"},
+	{"movl 200(%ebp), %eax", "8b 85 c8 00 00 00"},
+	{"movl (%ebp), %eax", "8b 45 00"},
+	{"pushl 8(%ebp)", "ff 75 08"},
+	// SIB, which %esp always needs
+	{"movl (%esp), %eax", "8b 04 24"},
+	{"movl 4(%esp), %eax", "8b 44 24 04"},
+	{"leal 4(%esp), %ecx", "8d 4c 24 04"},
+	{"movl (%ecx,%eax,4), %edx", "8b 14 81"},
+	{"movl 12(%ebx,%esi,2), %edx", "8b 54 73 0c"},
+	{"movl 1000(%eax,%ecx,8), %edx", "8b 94 c8 e8 03 00 00"},
+	// immediates, which are 8 bits where they fit
+	{"movl $5, %eax", "b8 05 00 00 00"},
+	{"movl $5, 4(%esp)", "c7 44 24 04 05 00 00 00"},
+	{"addl $4, %esp", "83 c4 04"},
+	{"addl $1000, %esp", "81 c4 e8 03 00 00"},
+	{"addl $1000, %eax", "05 e8 03 00 00"},
+	{"subl $-128, %ecx", "83 e9 80"},
+	{"subl $128, %ecx", "81 e9 80 00 00 00"},
+	{"cmpb $0, (%eax)", "80 38 00"},
+	{"andl %ecx, %eax", "21 c8"},
+	{"imull $3, %eax", "6b c0 03"},
+	{"imull $300, %eax", "69 c0 2c 01 00 00"},
+	{"imull %ecx, %eax", "0f af c1"},
+	{"pushl $1", "6a 01"},
+	{"pushl $1000", "68 e8 03 00 00"},
+	{"pushl %ebp", "55"},
+	{"popl %ebp", "5d"},
+	{"shll %cl, %eax", "d3 e0"},
+	{"sarl $1, %eax", "d1 f8"},
+	{"shrl $3, %eax", "c1 e8 03"},
+	{"int $128", "cd 80"},
+	{"ret", "c3"},
+	// branches, which are short where they reach: these just reach...
+	{"1:\tjmp 1b", "eb fe"},
+	{"jne 1f\n\tret\n1:\tret", "75 01 c3 c3"},
+	{"call 1f\n1:\tret", "e8 00 00 00 00 c3"},
+	// ...and these don't
+	{"jl 1f\n" + padding + "1:\tret", "0f 8c 80 00 00 00" + paddingBytes + " c3"},
+	{"jmp 1f\n" + padding + "1:\tret", "e9 80 00 00 00" + paddingBytes + " c3"},
+	{"1:\t" + padding + "\tjmp 1b", paddingBytes[1:] + " e9 7b ff ff ff"},
+}
+
+var encodeAmd64 = []encodeTest{
+	{"movq %rax, %rbx", "48 89 c3"},
+	{"movq 16(%rbp), %rax", "48 8b 45 10"},
+	{"movq -8(%rbp), %r8", "4c 8b 45 f8"},
+	{"movq (%rsp), %rax", "48 8b 04 24"},
+	{"movq 8(%rsp), %r12", "4c 8b 64 24 08"},
+	{"movq (%r13), %rax", "49 8b 45 00"},
+	{"movq (%rcx,%r9,8), %rdx", "4a 8b 14 c9"},
+	{"movq 1000(%rax,%rcx,8), %r10", "4c 8b 94 c8 e8 03 00 00"},
+	{"movq %r11, (%rcx)", "4c 89 19"},
+	{"movq $5, %rax", "48 c7 c0 05 00 00 00"},
+	{"movl $5, %r9d", "41 b9 05 00 00 00"},
+	{"movq $-1, 8(%rsp)", "48 c7 44 24 08 ff ff ff ff"},
+	{"movb %al, (%rcx)", "88 01"},
+	{"movzbq (%rcx), %rax", "48 0f b6 01"},
+	{"addq $8, %rsp", "48 83 c4 08"},
+	{"addq $1000, %rax", "48 05 e8 03 00 00"},
+	{"subq $1000, %r8", "49 81 e8 e8 03 00 00"},
+	{"cmpq %rax, %r15", "49 39 c7"},
+	{"imulq $3, %rax", "48 6b c0 03"},
+	{"imulq %r8, %rax", "49 0f af c0"},
+	{"pushq %rbp", "55"},
+	{"pushq %r12", "41 54"},
+	{"popq %r12", "41 5c"},
+	{"negq %rax", "48 f7 d8"},
+	{"idivq %rcx", "48 f7 f9"},
+	{"shlq %cl, %rax", "48 d3 e0"},
+	{"sarq $1, %rax", "48 d1 f8"},
+	{"cqto", "48 99"},
+	{"syscall", "0f 05"},
+	{"call *%rax", "ff d0"},
+	{"1:\tjmp 1b", "eb fe"},
+	{"jne 1f\n\tret\n1:\tret", "75 01 c3 c3"},
+	{"jmp 1f\n" + padding + "1:\tret", "e9 80 00 00 00" + paddingBytes + " c3"},
+}
+
+// padding is just too much to jump over with a short jump.
+var padding = "\t.ascii \"" + strings.Repeat("x", 128) + "\"\n"
+var paddingBytes = strings.Repeat(" 78", 128)
+
+func testEncode(t *testing.T, wordsize int, tests []encodeTest) {
+	// Only the encoding depends on WordSize, so we don't need all
+	// that SetArch does, which can't be undone.
+	defer func(w int) { WordSize = w }(WordSize)
+	WordSize = wordsize
+	for _,test := range tests {
+		o,err := Encode([]X86{RawAssembly("\t" + test.asm)})
+		if err != nil {
+			t.Errorf("%s: %s", test.asm, err)
+			continue
+		}
+		if len(o.Relocs) != 0 {
+			t.Errorf("%s: there's a reloc for %s", test.asm, o.Relocs[0].Symbol)
+		}
+		if b := fmt.Sprintf("% x", o.Sections[TextSection]); b != test.bytes {
+			t.Errorf("%s:\n\tgot  %s\n\twant %s", test.asm, b, test.bytes)
+		}
+	}
+}
+
+func TestEncode386(t *testing.T) {
+	testEncode(t, 4, encode386)
+}
+
+func TestEncodeAmd64(t *testing.T) {
+	testEncode(t, 8, encodeAmd64)
+}
+
+// A relocTest is an instruction that refers to a symbol we don't
+// define, and so needs a reloc, which comes after the given bytes.
+
+type relocTest struct {
+	wordsize int
+	asm string
+	bytes string
+	reloc Reloc
+}
+
+var relocTests = []relocTest{
+	{4, "call elsewhere", "e8 00 00 00 00", Reloc{TextSection, 1, 4, "elsewhere", -4, true}},
+	{4, "movl goc.args, %eax", "a1 00 00 00 00", Reloc{TextSection, 1, 4, "goc.args", 0, false}},
+	{4, "movl %eax, goc.args", "a3 00 00 00 00", Reloc{TextSection, 1, 4, "goc.args", 0, false}},
+	{4, "movl goc.args, %ecx", "8b 0d 00 00 00 00", Reloc{TextSection, 2, 4, "goc.args", 0, false}},
+	{4, "movl $msg, %ecx", "b9 00 00 00 00", Reloc{TextSection, 1, 4, "msg", 0, false}},
+	{4, "movl goc.args+4, %eax", "a1 00 00 00 00", Reloc{TextSection, 1, 4, "goc.args", 4, false}},
+	{8, "call elsewhere", "e8 00 00 00 00", Reloc{TextSection, 1, 4, "elsewhere", -4, true}},
+	{8, "movq goc.args, %rax", "48 8b 04 25 00 00 00 00", Reloc{TextSection, 4, 4, "goc.args", 0, false}},
+	{8, "movq %rax, goc.args", "48 89 04 25 00 00 00 00", Reloc{TextSection, 4, 4, "goc.args", 0, false}},
+	{8, "movq $msg, %rsi", "48 c7 c6 00 00 00 00", Reloc{TextSection, 3, 4, "msg", 0, false}},
+}
+
+func TestEncodeRelocs(t *testing.T) {
+	defer func(w int) { WordSize = w }(WordSize)
+	for _,test := range relocTests {
+		WordSize = test.wordsize
+		o,err := Encode([]X86{RawAssembly("\t" + test.asm)})
+		if err != nil {
+			t.Errorf("%s: %s", test.asm, err)
+			continue
+		}
+		if b := fmt.Sprintf("% x", o.Sections[TextSection]); b != test.bytes {
+			t.Errorf("%s:\n\tgot  %s\n\twant %s", test.asm, b, test.bytes)
+		}
+		if len(o.Relocs) != 1 || fmt.Sprint(o.Relocs[0]) != fmt.Sprint(test.reloc) {
+			t.Errorf("%s: got relocs %v, want %v", test.asm, o.Relocs, test.reloc)
+		}
+	}
+}
+
+func TestReferences(t *testing.T) {
+	o,err := Encode([]X86{RawAssembly(`
+main_helper:
+	call main_helper
+	call main_foobar
+	jmp c_twice
+	movl $string_hello, %eax
+	.data
+	.int main_table`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs := o.References()
+	for _,name := range []string{"main_foobar", "c_twice", "string_hello", "main_table"} {
+		if !refs[name] {
+			t.Errorf("there's no reference to %s", name)
+		}
+	}
+	// A symbol we define isn't a reference, and nor is a prefix of
+	// one that is.
+	for _,name := range []string{"main_helper", "main_foo", "main_"} {
+		if refs[name] {
+			t.Errorf("there's a reference to %s", name)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package x86
+
+// Peephole tidies up code by looking at an instruction or two at a
+// time and replacing them with something simpler, over and over until
+// there's nothing left to tidy.  Comments are skipped over, but labels
+// aren't, since something could be jumping to them.
+func Peephole(code []X86) []X86 {
+	for {
+		out, changed := peephole(code)
+		if !changed {
+			return out
+		}
+		code = out
+	}
+	panic("unreachable")
+}
+
+func peephole(code []X86) (out []X86, changed bool) {
+	for i:=0; i<len(code); i++ {
+		a := Uncommented(code[i])
+		if a == nil {
+			out = append(out, code[i]) // it's just a comment
+			continue
+		}
+		if useless(a) {
+			changed = true
+			continue
+		}
+		j := i+1
+		for j < len(code) && Uncommented(code[j]) == nil {
+			j++
+		}
+		if j < len(code) && jumpsTo(a, Uncommented(code[j])) {
+			changed = true // there's no need to jump to the very next instruction
+			continue
+		}
+		if j < len(code) {
+			if better,ok := pair(a, Uncommented(code[j])); ok {
+				out = append(out, code[i+1:j]...) // the comments in between
+				out = append(out, better...)
+				i = j
+				changed = true
+				continue
+			}
+		}
+		out = append(out, code[i])
+	}
+	return
+}
+
+// useless tells whether a does nothing at all.
+func useless(a X86) bool {
+	if a,ok := a.(OpL2); ok && (a.Name == "addl" || a.Name == "subl") {
+		return a.Src == Imm32(0)
+	}
+	return false
+}
+
+// pair looks at two neighbouring instructions, and returns what to
+// replace them with if it can do better.
+func pair(a, b X86) ([]X86, bool) {
+	switch a := a.(type) {
+	case OpL1:
+		b,ok := b.(OpL1)
+		if !ok || a.Name != "pushl" || b.Name != "popl" {
+			break
+		}
+		if a.Arg == b.Arg {
+			return nil, true
+		}
+		// popl works out its address after it has popped, so the two
+		// memory references mean the same thing in a movl.
+		_,amem := a.Arg.(Memory)
+		_,bmem := b.Arg.(Memory)
+		if dest,ok := b.Arg.(Ptr); ok && !(amem && bmem) && b.Arg != W32(ESP) {
+			return []X86{MovL(a.Arg, dest)}, true
+		}
+	case OpL2:
+		b,ok := b.(OpL2)
+		if !ok || a.Name != "addl" || b.Name != "addl" || a.Dest != Ptr(ESP) || b.Dest != Ptr(ESP) {
+			break
+		}
+		x,xok := a.Src.(Imm32)
+		y,yok := b.Src.(Imm32)
+		if xok && yok {
+			return []X86{AddL(x+y, ESP)}, true
+		}
+	}
+	return nil, false
+}
+
+// jumpsTo tells whether a is a jump to the label b.
+func jumpsTo(a, b X86) bool {
+	j,ok := a.(OpP1)
+	return ok && j.Name == "jmp" && label(b) != "" && j.Arg == Ptr(Symbol(label(b)))
+}
+
+// label returns the name of the label a, or "" if it isn't one.
+func label(a X86) string {
+	switch a := a.(type) {
+	case Symbol:
+		return string(a)
+	case GlobalSymbol:
+		return string(a)
+	}
+	return ""
+}
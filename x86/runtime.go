@@ -0,0 +1,235 @@
+package x86
+
+// Runtime holds the routines that compiled code (and the assembly in
+// the standard library) relies upon.  Unlike the functions it
+// implements for go code, these use the ordinary call/ret convention
+// and pass their arguments in registers.
+
+var Runtime = []X86{
+	RawAssembly(`
+#  Runtime routines!
+
+goc.alloc:	# allocates %eax bytes, returning a pointer to them in %eax
+	pushl %ebx	# Save registers...
+	pushl %ecx
+	pushl %edx
+	movl %eax, %edx	# the number of bytes wanted
+	movl goc.brk, %ecx
+	cmpl $0, %ecx
+	jne 1f
+	movl $0, %ebx	# brk(0) tells us where the heap starts
+	movl $45, %eax	# system call number (sys_brk)
+	int $128
+	movl %eax, %ecx
+1:	leal 3(%ecx,%edx), %ebx	# the new end of the heap...
+	andl $-4, %ebx	# ...kept word-aligned
+	movl $45, %eax	# system call number (sys_brk)
+	int $128
+	movl %eax, goc.brk	# FIXME: we don't notice if we're out of memory
+	movl %ecx, %eax	# our memory starts at the old end of the heap
+	popl %edx	# Restore saved registers...
+	popl %ecx
+	popl %ebx
+	ret	# from goc.alloc
+
+goc.traceback:	# prints the function and line of the code at %eax, and then of each call that led there
+	pushl %eax	# Save registers...
+	pushl %ebx
+	pushl %ecx
+	pushl %edx
+	pushl %esi
+	pushl %edi
+	pushl %ebp
+	movl %eax, %edi	# the address we're looking for
+	movl $goc.newline, %ecx
+	movl $1, %edx
+	call goc.write
+	pushl $1	# we're in the innermost frame
+1:	movl $goc.functab, %esi	# which of our functions is it in?
+2:	cmpl $goc.functab_end, %esi
+	jae 8f
+	cmpl (%esi), %edi
+	jb 3f
+	cmpl 4(%esi), %edi
+	jb 4f
+3:	addl $16, %esi
+	jmp 2b
+4:	movl 12(%esi), %ecx	# its name
+	movl 8(%esi), %edx
+	call goc.write
+	movl $goc.parens, %ecx
+	movl $4, %edx
+	call goc.write
+	movl (%esi), %esi	# where the function starts
+	movl $0, %ebx	# the last of its lines that starts before our address
+	movl $goc.linetab, %ecx
+5:	cmpl $goc.linetab_end, %ecx
+	jae 7f
+	cmpl (%ecx), %edi
+	jb 7f	# the lines are in order, so none of the rest can be it
+	cmpl (%ecx), %esi
+	ja 6f	# this line is in an earlier function
+	movl %ecx, %ebx
+6:	addl $16, %ecx
+	jmp 5b
+7:	cmpl $0, %ebx
+	je 10f
+	movl 12(%ebx), %ecx	# the file
+	movl 8(%ebx), %edx
+	call goc.write
+	movl $goc.colon, %ecx
+	movl $1, %edx
+	call goc.write
+	movl 4(%ebx), %eax	# and the line
+	call goc.printint
+10:	movl $goc.newline, %ecx
+	movl $1, %edx
+	call goc.write
+	jmp 9f
+8:	cmpl $0, (%esp)	# it isn't one of ours, but if it's where we faulted it could be assembly or C that we called
+	je 11f	# otherwise we've come as far as we can
+9:	movl $0, (%esp)	# we're past the innermost frame
+	cmpl $0, %ebp	# is this the outermost frame?
+	je 11f
+	movl 4(%ebp), %edi	# the return address is in the call that got us here...
+	subl $1, %edi
+	movl (%ebp), %ebp	# ...and we move on to the frame of whoever made it
+	jmp 1b
+11:	addl $4, %esp
+	popl %ebp	# Restore saved registers...
+	popl %edi
+	popl %esi
+	popl %edx
+	popl %ecx
+	popl %ebx
+	popl %eax
+	ret	# from goc.traceback
+
+goc.signals:	# asks the kernel to tell goc.sigpanic about faults
+	subl $20, %esp	# a struct sigaction
+	movl $goc.sigpanic, (%esp)	# the handler
+	movl $0x4000004, 4(%esp)	# its flags: SA_SIGINFO | SA_RESTORER
+	movl $goc.sigreturn, 8(%esp)	# the restorer
+	movl $0, 12(%esp)	# the signals to block while handling one
+	movl $0, 16(%esp)
+	movl $11, %ebx	# first argument: the signal (SIGSEGV)
+	call 1f
+	movl $8, %ebx	# SIGFPE
+	call 1f
+	movl $7, %ebx	# SIGBUS
+	call 1f
+	addl $20, %esp
+	ret	# from goc.signals
+1:	leal 4(%esp), %ecx	# second argument: the action
+	movl $0, %edx	# third argument: where to put the old one, which we don't want
+	movl $8, %esi	# fourth argument: the size of the signal mask
+	movl $174, %eax	# system call number (sys_rt_sigaction)
+	int $128
+	ret
+
+goc.sigreturn:
+	movl $173, %eax	# system call number (sys_rt_sigreturn)
+	int $128
+
+goc.sigpanic:	# turns a signal into a panic, given the signal, its siginfo and where it happened
+	movl $goc.faultmsg, %ecx
+	movl $goc.faultmsg_len, %edx
+	cmpl $8, 4(%esp)	# SIGFPE
+	jne 1f
+	movl $goc.floatmsg, %ecx	# as go says, unless it was an idivl...
+	movl $goc.floatmsg_len, %edx
+	movl 8(%esp), %ebx
+	cmpl $1, 8(%ebx)	# ...when the code is FPE_INTDIV
+	jne 1f
+	movl $goc.dividemsg, %ecx	# which our code can only do by dividing by zero
+	movl $goc.dividemsg_len, %edx
+1:	call goc.write
+	movl 12(%esp), %ebx	# the context we were interrupted in
+	movl 76(%ebx), %eax	# the %eip that faulted...
+	movl 44(%ebx), %ebp	# ...and its frame pointer
+	call goc.traceback
+	movl $2, %ebx	# first argument: exit code
+	movl $1, %eax	# system call number (sys_exit)
+	int $128
+
+goc.shiftpanic:	# panics because the code that called us shifted by a negative count
+	movl $goc.shiftmsg, %ecx
+	movl $goc.shiftmsg_len, %edx
+	call goc.write
+	movl (%esp), %eax	# where we were called from...
+	subl $1, %eax	# ...which is in the call instruction
+	call goc.traceback
+	movl $2, %ebx	# first argument: exit code
+	movl $1, %eax	# system call number (sys_exit)
+	int $128
+
+goc.indexpanic:	# panics because the code that called us used an index that was out of range, having pushed the length and then the index
+	movl $goc.indexmsg, %ecx
+	movl $goc.indexmsg_len, %edx
+	call goc.write
+	movl 4(%esp), %eax	# the index, which go prints signed
+	cmpl $0, %eax
+	jge 1f
+	movl $goc.minus, %ecx
+	movl $1, %edx
+	call goc.write
+	negl %eax
+1:	call goc.printint
+	movl $goc.lengthmsg, %ecx
+	cmpl $0, 4(%esp)
+	jl 2f
+	movl $goc.lengthmsg_len, %edx
+	call goc.write
+	movl 8(%esp), %eax	# the length
+	call goc.printint
+	jmp 3f
+2:	movl $1, %edx	# go doesn't give the length with a negative index, just the ]
+	call goc.write
+3:	movl $goc.newline, %ecx
+	movl $1, %edx
+	call goc.write
+	movl (%esp), %eax	# where we were called from...
+	subl $1, %eax	# ...which is in the call instruction
+	call goc.traceback
+	movl $2, %ebx	# first argument: exit code
+	movl $1, %eax	# system call number (sys_exit)
+	int $128
+
+goc.printint:	# prints %eax in decimal, to stderr
+	pushl %eax	# Save registers...
+	pushl %ebx
+	pushl %ecx
+	pushl %edx
+	subl $12, %esp	# room for the digits
+	movl %esp, %ecx
+	addl $12, %ecx	# which we write from the end backwards
+	movl $10, %ebx
+1:	movl $0, %edx
+	divl %ebx
+	addl $48, %edx	# the digit in ascii
+	subl $1, %ecx
+	movb %dl, (%ecx)
+	cmpl $0, %eax
+	jne 1b
+	movl %esp, %edx
+	addl $12, %edx
+	subl %ecx, %edx	# the number of digits
+	call goc.write
+	addl $12, %esp
+	popl %edx	# Restore saved registers...
+	popl %ecx
+	popl %ebx
+	popl %eax
+	ret	# from goc.printint
+
+goc.write:	# writes the %edx bytes at %ecx to stderr
+	pushl %eax	# Save registers...
+	pushl %ebx
+	movl $2, %ebx	# first argument: file handle (stderr)
+	movl $4, %eax	# system call number (sys_write)
+	int $128
+	popl %ebx	# Restore saved registers...
+	popl %eax
+	ret	# from goc.write
+		`),
+}
@@ -21,7 +21,8 @@ type X86 interface {
 
 // a W32 is a source (or sink) for a double-word (which I think of as
 // a word, but x86 assembly doesn't), which could either be a register
-// or a memory location.
+// or a memory location.  On amd64 it's a quad-word instead, since
+// that's what a word is there.
 
 type W32 interface {
 	W32() string
@@ -68,6 +69,18 @@ func Commented(instr X86, x string) X86 {
 	return commentType{instr, x}
 }
 
+// Uncommented returns x without its comment, or nil if x is nothing
+// but a comment.
+func Uncommented(x X86) X86 {
+	if c,ok := x.(commentType); ok {
+		if _,ok := c.instr.(commentType); ok {
+			return nil
+		}
+		return c.instr
+	}
+	return x
+}
+
 // A Symbol marks a location in the binary source file, which could be
 // a function or a global variable or even a global constant.
 
@@ -102,6 +115,9 @@ func (m Memory) W32() string {
 	if m.Disp != nil {
 		offstr = m.Disp.Ptr()
 	}
+	if m.Base == nil && m.Index == nil {
+		return offstr // an absolute address, such as a global variable
+	}
 	bstr := ""
 	if m.Base != nil {
 		bstr = m.Base.W32()
@@ -127,6 +143,12 @@ func (m Memory) Add(off int) Memory {
 		m.Disp = d + Imm32(off)
 		return m
 	}
+	if s,ok := m.Disp.(Symbol); ok && m.Base == nil && m.Index == nil {
+		if off != 0 {
+			m.Disp = Symbol(fmt.Sprint(s, "+", off))
+		}
+		return m
+	}
 	if m.Scale == nil {
 		if m.Index == nil {
 			m.Index = Imm32(off)
@@ -147,6 +169,8 @@ func (m Memory) Ptr() string {
 
 // A Register refers to a general-purpose register, of which the x86
 // has only eight, two of which are pretty much devoted to the stack.
+// The amd64 has eight more, R8 to R15, and calls the first eight %rax
+// and so on rather than %eax.
 
 type Register byte
 const (
@@ -158,6 +182,14 @@ const (
   ESI
   EBP
   ESP
+	R8
+	R9
+	R10
+	R11
+	R12
+	R13
+	R14
+	R15
 )
 
 func (r Register) String() string {
@@ -171,6 +203,9 @@ func (r Register) String() string {
 	case EBP: return "bp"
 	case ESP: return "sp"
 	}
+	if r >= R8 && r <= R15 && WordSize == 8 {
+		return fmt.Sprint(int(r - R8) + 8)
+	}
 	panic(fmt.Sprint("Bad register value: ", r))
 }
 func (r Register) W8() string {
@@ -183,16 +218,25 @@ func (r Register) W8() string {
 	case ECX: return "%cl"
 	case EDX: return "%dl"
 	}
+	if r >= R8 {
+		return "%r" + r.String() + "b"
+	}
 	panic(fmt.Sprint("Bad 8-bit register value: ", r))
 }
 func (r Register) W16() string {
+	if r >= R8 {
+		return "%r" + r.String() + "w"
+	}
 	return "%" + r.String()
 }
 func (r Register) W32() string {
+	if WordSize == 8 {
+		return "%r" + r.String()
+	}
 	return "%e" + r.String()
 }
 func (r Register) Ptr() string {
-	return "%e" + r.String()
+	return r.W32()
 }
 
 // Imm32 represents an immediate 32-bit value
@@ -220,16 +264,16 @@ func (i Imm8) W8() string {
 }
 
 // OpL2 holds any two-argument instructions involving 32-bit arguments
-// of with the latter is an "output" argument.  It shouldn't need to
-// be exported, but it could also come in handy at some stage...
+// of with the latter is an "output" argument.  Its fields are exported
+// so that the peephole optimizer can see what it's looking at.
 
 type OpL2 struct {
-	name string
-	src W32
-	dest Ptr
+	Name string
+	Src W32
+	Dest Ptr
 }
 func (o OpL2) X86() string {
-	return "\t" + o.name + " " + o.src.W32() + ", " + o.dest.Ptr()
+	return "\t" + wordOp(o.Name) + " " + o.Src.W32() + ", " + o.Dest.Ptr()
 }
 
 func MovL(src W32, dest Ptr) X86 {
@@ -240,48 +284,83 @@ func AddL(src W32, dest Ptr) X86 {
 	return OpL2{"addl", src, dest}
 }
 
+func SubL(src W32, dest Ptr) X86 {
+	return OpL2{"subl", src, dest}
+}
+
+// SbbL subtracts src and the carry flag from dest, which is how we turn
+// a comparison into a mask: sbbl %eax, %eax leaves -1 in %eax if the
+// carry was set, and 0 if not.
+func SbbL(src W32, dest Ptr) X86 {
+	return OpL2{"sbbl", src, dest}
+}
+
 func AndL(src W32, dest Ptr) X86 {
 	return OpL2{"andl", src, dest}
 }
 
-func ShiftLeftL(src W32, dest Ptr) X86 {
-	return OpL2{"shll", src, dest}
+func OrL(src W32, dest Ptr) X86 {
+	return OpL2{"orl", src, dest}
 }
 
-func ShiftRightL(src W32, dest Ptr) X86 {
-	return OpL2{"shrl", src, dest}
+func XorL(src W32, dest Ptr) X86 {
+	return OpL2{"xorl", src, dest}
 }
 
 func IMulL(src W32, dest Ptr) X86 {
 	return OpL2{"imull", src, dest}
 }
 
+// OpBL holds the shifts, whose count is a byte (either immediate or
+// in %cl) while what they shift is 32 bits.
+
+type OpBL struct {
+	Name string
+	Src W8
+	Dest Ptr
+}
+func (o OpBL) X86() string {
+	return "\t" + wordOp(o.Name) + " " + o.Src.W8() + ", " + o.Dest.Ptr()
+}
+
+func ShiftLeftL(src W8, dest Ptr) X86 {
+	return OpBL{"shll", src, dest}
+}
+
+// ShiftRightL is the unsigned (logical) right shift.
+func ShiftRightL(src W8, dest Ptr) X86 {
+	return OpBL{"shrl", src, dest}
+}
+
+// ShiftRightArithL is the signed (arithmetic) right shift.
+func ShiftRightArithL(src W8, dest Ptr) X86 {
+	return OpBL{"sarl", src, dest}
+}
+
 // OpLL holds any two-argument instructions involving 32-bit arguments
-// in which either could be immediate.  It shouldn't need to be
-// exported, but it could also come in handy at some stage...
+// in which either could be immediate.
 
 type OpLL struct {
-	name string
-	src1, src2 W32
+	Name string
+	Src1, Src2 W32
 }
 func (o OpLL) X86() string {
-	return "\t" + o.name + " " + o.src1.W32() + ", " + o.src2.W32()
+	return "\t" + wordOp(o.Name) + " " + o.Src1.W32() + ", " + o.Src2.W32()
 }
 
 func CmpL(src W32, dest W32) X86 {
 	return OpLL{"cmpl", src, dest}
 }
 
-// OpL1 holds any instruction involving a single 32-bit argument.  It
-// shouldn't need to be exported, but it could also come in handy at
-// some stage...
+// OpL1 holds any instruction involving a single 32-bit argument, such
+// as pushl and popl.
 
 type OpL1 struct {
-	name string
-	arg W32
+	Name string
+	Arg W32
 }
 func (o OpL1) X86() string {
-	return "\t" + o.name + " " + o.arg.W32()
+	return "\t" + wordOp(o.Name) + " " + o.Arg.W32()
 }
 
 func Int(val W32) X86 {
@@ -296,33 +375,69 @@ func PushL(src W32) X86 {
 	return OpL1{"pushl", src}
 }
 
+func NegL(dest W32) X86 {
+	return OpL1{"negl", dest}
+}
+
+func NotL(dest W32) X86 {
+	return OpL1{"notl", dest}
+}
+
+// IDivL divides %edx:%eax by its argument, leaving the quotient in
+// %eax and the remainder in %edx.
+func IDivL(src W32) X86 {
+	return OpL1{"idivl", src}
+}
+
 type Op0 struct {
-	name, comment string
+	Name, Comment string
 }
 func (o Op0) X86() string {
-	return "\t" + o.name + "\t# " + o.comment
+	return "\t" + wordOp(o.Name) + "\t# " + o.Comment
 }
 
 func Return(com string) X86 {
 	return Op0{ "ret", com }
 }
 
-// OpL1 holds any instruction involving a single argument that must be
-// an address.  It shouldn't need to be exported, but it could also
-// come in handy at some stage...
+// Cltd sign-extends %eax into %edx, ready for IDivL.
+func Cltd() X86 {
+	return Op0{ "cltd", "sign-extend %eax into %edx" }
+}
+
+// Syscall is how the amd64 calls the kernel, rather than Int.
+func Syscall() X86 {
+	return Op0{ "syscall", "call the kernel" }
+}
+
+// OpP1 holds any instruction involving a single argument that must be
+// an address, such as the jumps.
 
 type OpP1 struct {
-	name string
-	arg Ptr
+	Name string
+	Arg Ptr
 }
 func (o OpP1) X86() string {
-	return "\t" + o.name + " " + o.arg.Ptr()
+	return "\t" + o.Name + " " + o.Arg.Ptr()
 }
 
 func Jne(src Ptr) X86 {
 	return OpP1{"jne", src}
 }
 
+func Je(src Ptr) X86 {
+	return OpP1{"je", src}
+}
+
+func Jge(src Ptr) X86 {
+	return OpP1{"jge", src}
+}
+
+// Jb jumps if the comparison was below, as unsigned numbers.
+func Jb(src Ptr) X86 {
+	return OpP1{"jb", src}
+}
+
 func Call(src Ptr) X86 {
 	return OpP1{"call", src}
 }
@@ -355,10 +470,13 @@ func (a Ascii) X86() (out string) {
 	return
 }
 
-// Int is just raw 32-bit number...
+// Int is just raw number, which takes up a word.
 
 type GlobalInt int32
 func (a GlobalInt) X86() string {
+	if WordSize == 8 {
+		return "\t.quad\t" + fmt.Sprint(a)
+	}
 	return "\t.int\t" + fmt.Sprint(a)
 }
 
@@ -382,6 +500,10 @@ func (r RawAssembly) X86() string {
 	return string(r)
 }
 
-func GlobalSymbol(name string) X86 {
-	return RawAssembly(".global " + name + "\n" + name + ":")
+// A GlobalSymbol is a Symbol that the linker can see, such as a
+// function.
+
+type GlobalSymbol string
+func (s GlobalSymbol) X86() string {
+	return ".global " + string(s) + "\n" + string(s) + ":"
 }